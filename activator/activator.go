@@ -0,0 +1,285 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package activator는 scale-to-zero 된 Demo로 들어온 요청을 붙잡아 두었다가
+// pod가 준비되면 전달하는 작은 HTTP 서버입니다.
+// Demo가 idle 상태인 동안 Service의 Endpoints는 operator pod의 액티베이터를 가리킵니다.
+package activator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// 요청을 붙잡아 둔 동안 Endpoints를 확인하는 주기
+const backendPollInterval = 500 * time.Millisecond
+
+// Options는 액티베이터 설정입니다.
+type Options struct {
+	// BindAddress는 액티베이터가 listen 할 주소입니다. (예: ":8082")
+	BindAddress string
+	// PodIP는 idle 상태의 Service Endpoints에 등록할 operator pod의 IP입니다.
+	PodIP string
+	// PodNamespace와 PodLabels는 operator pod의 namespace와 label입니다.
+	// Demo의 NetworkPolicy는 바뀌는 pod IP 대신 이 값으로 액티베이터를 허용합니다.
+	PodNamespace string
+	PodLabels    map[string]string
+	// Timeout은 pod가 준비될 때까지 요청을 붙잡아 두는 최대 시간입니다.
+	Timeout time.Duration
+}
+
+// Activator는 idle 상태인 Demo로 온 요청을 받아 reconcile을 깨우고,
+// pod가 준비되면 요청을 pod로 전달합니다.
+type Activator struct {
+	client client.Client
+	opts   Options
+	port   int32
+	events chan event.GenericEvent
+
+	mu    sync.Mutex
+	demos map[types.NamespacedName]*route // 액티베이터로 라우팅 중인 Demo
+}
+
+// route는 액티베이터로 라우팅 중인 Demo의 Service입니다.
+// 긴 Demo 이름은 Service 이름에서 잘리므로 요청은 Demo 이름이 아닌 Service 이름으로 찾습니다.
+type route struct {
+	service string
+	woken   bool // wake 요청 여부
+}
+
+// New는 액티베이터를 생성합니다. c는 Endpoints 조회에 사용합니다.
+func New(c client.Client, opts Options) (*Activator, error) {
+	_, p, err := net.SplitHostPort(opts.BindAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid activator bind address %q: %w", opts.BindAddress, err)
+	}
+	port, err := strconv.ParseInt(p, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid activator port %q: %w", p, err)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 2 * time.Minute
+	}
+
+	return &Activator{
+		client: c,
+		opts:   opts,
+		port:   int32(port),
+		events: make(chan event.GenericEvent, 100),
+		demos:  map[types.NamespacedName]*route{},
+	}, nil
+}
+
+// Address는 Service Endpoints에 등록할 주소를 반환합니다.
+// pod IP를 모르면 Service를 액티베이터로 돌릴 수 없으므로 ok가 false입니다.
+func (a *Activator) Address() (ip string, port int32, ok bool) {
+	return a.opts.PodIP, a.port, a.opts.PodIP != ""
+}
+
+// Pod는 NetworkPolicy에서 operator pod를 고를 namespace와 label을 반환합니다.
+func (a *Activator) Pod() (namespace string, labels map[string]string) {
+	return a.opts.PodNamespace, a.opts.PodLabels
+}
+
+// Source는 wake 요청이 오면 해당 Demo를 reconcile 하도록 이벤트를 보내는 source입니다.
+func (a *Activator) Source() source.Source {
+	return &source.Channel{Source: a.events}
+}
+
+// Register는 Service가 액티베이터로 라우팅 중인 Demo를 등록합니다. service는 같은 namespace의 Service 이름입니다.
+func (a *Activator) Register(nn types.NamespacedName, service string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if rt, ok := a.demos[nn]; ok {
+		rt.service = service
+		return
+	}
+	a.demos[nn] = &route{service: service}
+}
+
+// Unregister는 Service가 다시 pod를 가리키게 된 Demo를 제거합니다.
+func (a *Activator) Unregister(nn types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.demos, nn)
+}
+
+// WakeRequested는 idle 상태인 Demo에 요청이 들어왔는지 확인합니다.
+func (a *Activator) WakeRequested(nn types.NamespacedName) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rt, ok := a.demos[nn]
+	return ok && rt.woken
+}
+
+// Start는 manager.Runnable 구현입니다. ctx가 끝나면 서버를 종료합니다.
+func (a *Activator) Start(ctx context.Context) error {
+	srv := &http.Server{Addr: a.opts.BindAddress, Handler: a}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-errCh:
+		return err
+	}
+}
+
+// ServeHTTP는 Host 헤더로 Demo를 찾아 깨우고, pod가 준비되면 요청을 전달합니다.
+func (a *Activator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	logger := log.FromContext(req.Context()).WithName("activator")
+
+	nn, svc, ok := a.lookup(req.Host)
+	if !ok {
+		http.Error(w, "no idle Demo found for host", http.StatusNotFound)
+		return
+	}
+	a.wake(req.Context(), nn)
+
+	ctx, cancel := context.WithTimeout(req.Context(), a.opts.Timeout)
+	defer cancel()
+
+	target, err := a.waitForBackend(ctx, svc)
+	if err != nil {
+		logger.Info("Demo did not become ready in time", "demo", nn.String())
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Demo is waking up, retry later", http.StatusServiceUnavailable)
+		return
+	}
+
+	httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, req)
+}
+
+// wake는 처음 들어온 요청일 때만 reconcile 이벤트를 보냅니다.
+func (a *Activator) wake(ctx context.Context, nn types.NamespacedName) {
+	a.mu.Lock()
+	rt, ok := a.demos[nn]
+	woken := ok && rt.woken
+	if ok {
+		rt.woken = true
+	}
+	a.mu.Unlock()
+
+	if !ok || woken {
+		return
+	}
+
+	evt := event.GenericEvent{Object: &metav1.PartialObjectMetadata{
+		ObjectMeta: metav1.ObjectMeta{Name: nn.Name, Namespace: nn.Namespace},
+	}}
+	select {
+	case a.events <- evt:
+	case <-ctx.Done():
+	}
+}
+
+// lookup은 Host 헤더(<service>.<namespace>.svc... 또는 <service>)로 등록된 Demo와 그 Service를 찾습니다.
+func (a *Activator) lookup(host string) (demo, svc types.NamespacedName, ok bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	parts := strings.Split(host, ".")
+	name := parts[0]
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// namespace가 없으면 같은 namespace에서 Service 이름만으로 호출한 경우이고, 이름이 유일할 때만 사용합니다.
+	var found []types.NamespacedName
+	for nn, rt := range a.demos {
+		if rt.service != name || (len(parts) > 1 && nn.Namespace != parts[1]) {
+			continue
+		}
+		found = append(found, nn)
+	}
+	if len(found) != 1 {
+		return types.NamespacedName{}, types.NamespacedName{}, false
+	}
+	return found[0], types.NamespacedName{Namespace: found[0].Namespace, Name: name}, true
+}
+
+// waitForBackend는 Service svc의 Endpoints에 액티베이터가 아닌 pod 주소가 생길 때까지 기다립니다.
+func (a *Activator) waitForBackend(ctx context.Context, svc types.NamespacedName) (*url.URL, error) {
+	ticker := time.NewTicker(backendPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ep := &corev1.Endpoints{}
+		if err := a.client.Get(ctx, svc, ep); err == nil {
+			if target := a.backend(ep); target != nil {
+				return target, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// backend는 Endpoints에서 요청을 보낼 pod 주소를 고릅니다.
+func (a *Activator) backend(ep *corev1.Endpoints) *url.URL {
+	for _, subset := range ep.Subsets {
+		port, ok := httpPort(subset.Ports)
+		if !ok {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			if addr.IP == a.opts.PodIP {
+				continue
+			}
+			return &url.URL{Scheme: "http", Host: net.JoinHostPort(addr.IP, strconv.Itoa(int(port)))}
+		}
+	}
+	return nil
+}
+
+// httpPort는 이름이 http인 포트를, 없으면 하나뿐인 포트를 사용합니다.
+func httpPort(ports []corev1.EndpointPort) (int32, bool) {
+	for _, p := range ports {
+		if p.Name == "http" {
+			return p.Port, true
+		}
+	}
+	if len(ports) == 1 {
+		return ports[0].Port, true
+	}
+	return 0, false
+}
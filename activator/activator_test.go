@@ -0,0 +1,180 @@
+package activator
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestLookup(t *testing.T) {
+	a, err := New(fake.NewClientBuilder().Build(), Options{BindAddress: ":8082", PodIP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Register(types.NamespacedName{Namespace: "default", Name: "web"}, "web")
+	a.Register(types.NamespacedName{Namespace: "team-a", Name: "docs"}, "docs")
+	a.Register(types.NamespacedName{Namespace: "team-b", Name: "docs"}, "docs")
+
+	tests := []struct {
+		host string
+		want string
+		ok   bool
+	}{
+		{host: "web.default.svc.cluster.local", want: "default/web", ok: true},
+		{host: "web.default:80", want: "default/web", ok: true},
+		{host: "web", want: "default/web", ok: true},
+		{host: "docs.team-b.svc", want: "team-b/docs", ok: true},
+		{host: "docs", ok: false}, // 이름만으로는 구분할 수 없음
+		{host: "10.96.0.12", ok: false},
+	}
+
+	for _, tt := range tests {
+		nn, _, ok := a.lookup(tt.host)
+		if ok != tt.ok || (ok && nn.String() != tt.want) {
+			t.Errorf("lookup(%q) = %v, %v; want %v, %v", tt.host, nn, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestServeHTTPWakesAndProxies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello from pod"))
+	}))
+	defer backend.Close()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	// Service가 다시 pod를 가리키게 된 상태의 Endpoints
+	ep := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: host}},
+			Ports:     []corev1.EndpointPort{{Port: int32(p)}},
+		}},
+	}
+
+	a, err := New(fake.NewClientBuilder().WithObjects(ep).Build(), Options{
+		BindAddress: ":8082",
+		PodIP:       "10.0.0.1",
+		Timeout:     5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nn := types.NamespacedName{Namespace: "default", Name: "web"}
+	a.Register(nn, "web")
+
+	req := httptest.NewRequest(http.MethodGet, "http://web.default.svc/", nil)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	body, _ := io.ReadAll(rec.Result().Body)
+	if rec.Code != http.StatusOK || string(body) != "hello from pod" {
+		t.Fatalf("got %d %q, want proxied response", rec.Code, body)
+	}
+	if !a.WakeRequested(nn) {
+		t.Error("expected wake to be requested")
+	}
+
+	select {
+	case evt := <-a.events:
+		if evt.Object.GetName() != "web" || evt.Object.GetNamespace() != "default" {
+			t.Errorf("unexpected wake event for %s/%s", evt.Object.GetNamespace(), evt.Object.GetName())
+		}
+	default:
+		t.Error("expected a wake event")
+	}
+}
+
+func TestServeHTTPTimesOut(t *testing.T) {
+	// 아직 액티베이터만 가리키는 Endpoints
+	ep := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+			Ports:     []corev1.EndpointPort{{Port: 8082}},
+		}},
+	}
+
+	a, err := New(fake.NewClientBuilder().WithObjects(ep).Build(), Options{
+		BindAddress: ":8082",
+		PodIP:       "10.0.0.1",
+		Timeout:     time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Register(types.NamespacedName{Namespace: "default", Name: "web"}, "web")
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://web.default/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want 503", rec.Code)
+	}
+}
+
+// 긴 Demo 이름은 Service 이름에서 잘리므로 Host와 Endpoints는 Demo 이름이 아닌 Service 이름을 씁니다.
+func TestServeHTTPLongName(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello from pod"))
+	}))
+	defer backend.Close()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	demo := types.NamespacedName{Namespace: "default", Name: strings.Repeat("a", 70)}
+	service := strings.Repeat("a", 54) + "-1a2b3c4d"
+	ep := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: service, Namespace: "default"},
+		Subsets: []corev1.EndpointSubset{{
+			Addresses: []corev1.EndpointAddress{{IP: host}},
+			Ports:     []corev1.EndpointPort{{Port: int32(p)}},
+		}},
+	}
+
+	a, err := New(fake.NewClientBuilder().WithObjects(ep).Build(), Options{
+		BindAddress: ":8082",
+		PodIP:       "10.0.0.1",
+		Timeout:     5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Register(demo, service)
+
+	if _, _, ok := a.lookup(demo.Name + ".default.svc"); ok {
+		t.Error("expected no match for the Demo name")
+	}
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+service+".default.svc/", nil))
+
+	body, _ := io.ReadAll(rec.Result().Body)
+	if rec.Code != http.StatusOK || string(body) != "hello from pod" {
+		t.Fatalf("got %d %q, want proxied response", rec.Code, body)
+	}
+	if !a.WakeRequested(demo) {
+		t.Error("expected wake to be requested for the Demo")
+	}
+	select {
+	case evt := <-a.events:
+		if evt.Object.GetName() != demo.Name {
+			t.Errorf("wake event for %s, want the Demo %s", evt.Object.GetName(), demo.Name)
+		}
+	default:
+		t.Error("expected a wake event")
+	}
+}
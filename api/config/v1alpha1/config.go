@@ -0,0 +1,245 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	configv1alpha1 "k8s.io/component-base/config/v1alpha1"
+	"k8s.io/utils/pointer"
+)
+
+// Defaults of the manager options, the same as the flag defaults of the manager
+const (
+	DefaultHealthProbeBindAddress = ":8081"
+	DefaultMetricsBindAddress     = ":8080"
+	DefaultWebhookPort            = 9443
+	DefaultLeaderElectionID       = "a3788769.my.domain"
+	DefaultImage                  = "nginx:latest"
+	DefaultActivatorBindAddress   = ":8082"
+	DefaultActivatorTimeout       = 2 * time.Minute
+	DefaultBaseDelay              = 5 * time.Millisecond
+	DefaultMaxDelay               = 5 * time.Minute
+	DefaultWriteQPS               = 20
+	DefaultWriteBurst             = 50
+	DefaultNameTemplate           = "{{.Name}}"
+)
+
+// Load reads the config file at path, sets defaults and validates it.
+// An empty path returns the defaults.
+func Load(path string) (*DemoOperatorConfig, error) {
+	c := &DemoOperatorConfig{}
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := Decode(content, c); err != nil {
+			return nil, fmt.Errorf("decoding config file %s: %w", path, err)
+		}
+	}
+	c.Default()
+	return c, nil
+}
+
+// Decode decodes a DemoOperatorConfig, rejecting unknown fields
+func Decode(content []byte, c *DemoOperatorConfig) error {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		return err
+	}
+	// 오타가 있는 필드를 조용히 무시하지 않도록 strict 모드로 읽습니다.
+	codecs := serializer.NewCodecFactory(scheme, serializer.EnableStrict)
+	return runtime.DecodeInto(codecs.UniversalDecoder(GroupVersion), content, c)
+}
+
+// Default sets the defaults of unset fields
+func (c *DemoOperatorConfig) Default() {
+	if c.Health.HealthProbeBindAddress == "" {
+		c.Health.HealthProbeBindAddress = DefaultHealthProbeBindAddress
+	}
+	if c.Metrics.BindAddress == "" {
+		c.Metrics.BindAddress = DefaultMetricsBindAddress
+	}
+	if c.Webhook.Port == nil {
+		c.Webhook.Port = pointer.Int(DefaultWebhookPort)
+	}
+	if c.LeaderElection == nil {
+		c.LeaderElection = &configv1alpha1.LeaderElectionConfiguration{}
+	}
+	if c.LeaderElection.LeaderElect == nil {
+		c.LeaderElection.LeaderElect = pointer.Bool(false)
+	}
+	if c.LeaderElection.ResourceName == "" {
+		c.LeaderElection.ResourceName = DefaultLeaderElectionID
+	}
+	if c.DefaultImage == "" {
+		c.DefaultImage = DefaultImage
+	}
+	if c.NameTemplate == "" {
+		c.NameTemplate = DefaultNameTemplate
+	}
+	if c.MaxConcurrentReconciles == 0 {
+		c.MaxConcurrentReconciles = 1
+	}
+	if c.RateLimits.BaseDelay.Duration == 0 {
+		c.RateLimits.BaseDelay = metav1.Duration{Duration: DefaultBaseDelay}
+	}
+	if c.RateLimits.MaxDelay.Duration == 0 {
+		c.RateLimits.MaxDelay = metav1.Duration{Duration: DefaultMaxDelay}
+	}
+	if c.RateLimits.WriteQPS == 0 {
+		c.RateLimits.WriteQPS = DefaultWriteQPS
+	}
+	if c.RateLimits.WriteBurst == 0 {
+		c.RateLimits.WriteBurst = DefaultWriteBurst
+	}
+	if c.Activator.BindAddress == "" {
+		c.Activator.BindAddress = DefaultActivatorBindAddress
+	}
+	if c.Activator.Timeout.Duration == 0 {
+		c.Activator.Timeout = metav1.Duration{Duration: DefaultActivatorTimeout}
+	}
+}
+
+// Validate returns all invalid fields of the config
+func (c *DemoOperatorConfig) Validate() error {
+	var errs field.ErrorList
+
+	if port := c.Webhook.Port; port != nil && (*port < 1 || *port > 65535) {
+		errs = append(errs, field.Invalid(field.NewPath("webhook", "port"), *port, "must be between 1 and 65535"))
+	}
+	if err := validateNameTemplate(c.NameTemplate); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("nameTemplate"), c.NameTemplate, err.Error()))
+	}
+	if c.MaxConcurrentReconciles < 1 {
+		errs = append(errs, field.Invalid(field.NewPath("maxConcurrentReconciles"), c.MaxConcurrentReconciles, "must be at least 1"))
+	}
+	limits := field.NewPath("rateLimits")
+	if c.RateLimits.BaseDelay.Duration < 0 {
+		errs = append(errs, field.Invalid(limits.Child("baseDelay"), c.RateLimits.BaseDelay.Duration.String(), "must not be negative"))
+	}
+	if c.RateLimits.MaxDelay.Duration < c.RateLimits.BaseDelay.Duration {
+		errs = append(errs, field.Invalid(limits.Child("maxDelay"), c.RateLimits.MaxDelay.Duration.String(), "must not be less than baseDelay"))
+	}
+	if c.RateLimits.WriteQPS < 0 {
+		errs = append(errs, field.Invalid(limits.Child("writeQPS"), c.RateLimits.WriteQPS, "must not be negative"))
+	}
+	if c.RateLimits.WriteBurst < 1 {
+		errs = append(errs, field.Invalid(limits.Child("writeBurst"), c.RateLimits.WriteBurst, "must be at least 1"))
+	}
+	if c.Activator.Timeout.Duration < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("activator", "timeout"), c.Activator.Timeout.Duration.String(), "must not be negative"))
+	}
+	if c.DryRun.PlanAnnotation && !c.DryRun.Enabled {
+		errs = append(errs, field.Invalid(field.NewPath("dryRun", "planAnnotation"), true, "requires dryRun.enabled"))
+	}
+
+	profiles := field.NewPath("resourceProfiles")
+	for name, r := range c.ResourceProfiles {
+		for resource, request := range r.Requests {
+			if limit, ok := r.Limits[resource]; ok && request.Cmp(limit) > 0 {
+				errs = append(errs, field.Invalid(profiles.Key(name).Child("requests").Key(string(resource)),
+					request.String(), fmt.Sprintf("must not exceed the limit %s", limit.String())))
+			}
+		}
+	}
+	if p := c.DefaultResourceProfile; p != "" {
+		if _, ok := c.ResourceProfiles[p]; !ok {
+			errs = append(errs, field.NotFound(field.NewPath("defaultResourceProfile"), p))
+		}
+	}
+
+	if c.NamespaceSelector != "" {
+		path := field.NewPath("namespaceSelector")
+		if len(c.WatchNamespaces) > 0 {
+			errs = append(errs, field.Forbidden(path, "cannot be used together with watchNamespaces"))
+		} else if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+			errs = append(errs, field.Invalid(path, c.NamespaceSelector, err.Error()))
+		}
+	}
+
+	gates := field.NewPath("featureGates")
+	for name := range c.FeatureGates {
+		if _, ok := defaultFeatureGates[name]; !ok {
+			errs = append(errs, field.NotSupported(gates.Key(name), name, knownFeatureGates()))
+		}
+	}
+
+	return errs.ToAggregate()
+}
+
+// NameTemplateData is the data NameTemplate is rendered with
+// +kubebuilder:object:generate=false
+type NameTemplateData struct {
+	// Name of the Demo
+	Name string
+	// Namespace of the Demo
+	Namespace string
+}
+
+// ParseNameTemplate parses NameTemplate. An empty template renders the Demo name.
+func ParseNameTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultNameTemplate
+	}
+	return template.New("nameTemplate").Option("missingkey=error").Parse(text)
+}
+
+// validateNameTemplate는 template이 Demo마다 다른 DNS label을 만드는지 두 Demo 이름으로 확인합니다.
+// 긴 이름은 operator가 자르므로 길이는 확인하지 않습니다.
+func validateNameTemplate(text string) error {
+	tmpl, err := ParseNameTemplate(text)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, name := range []string{"a", "b"} {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, NameTemplateData{Name: name, Namespace: "default"}); err != nil {
+			return err
+		}
+		if msgs := validation.IsDNS1123Label(b.String()); len(msgs) > 0 {
+			return fmt.Errorf("renders %q: %s", b.String(), strings.Join(msgs, ", "))
+		}
+		names = append(names, b.String())
+	}
+	if names[0] == names[1] {
+		return fmt.Errorf("must use {{.Name}} so that each Demo gets its own objects")
+	}
+	return nil
+}
+
+func knownFeatureGates() []string {
+	names := make([]string, 0, len(defaultFeatureGates))
+	for name := range defaultFeatureGates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
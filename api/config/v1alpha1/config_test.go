@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestLoad(t *testing.T) {
+	c, err := Load("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 파일에 있는 값
+	if c.Metrics.BindAddress != "127.0.0.1:8080" || !*c.LeaderElection.LeaderElect || c.DefaultImage != "nginx:1.21" ||
+		c.MaxConcurrentReconciles != 4 || c.DefaultResourceProfile != "small" || len(c.WatchNamespaces) != 1 {
+		t.Errorf("file values not loaded: %+v", c)
+	}
+	// 파일에 없는 값은 기본값
+	if c.Health.HealthProbeBindAddress != DefaultHealthProbeBindAddress || *c.Webhook.Port != DefaultWebhookPort ||
+		c.LeaderElection.ResourceName != DefaultLeaderElectionID || c.Activator.Timeout.Duration != 2*time.Minute {
+		t.Errorf("defaults not set: %+v", c)
+	}
+	if c.Enabled(IdleScaling) || !c.Enabled(StorageVersionMigration) {
+		t.Errorf("unexpected feature gates %v", c.FeatureGates)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Metrics.BindAddress != DefaultMetricsBindAddress || c.DefaultImage != DefaultImage || c.MaxConcurrentReconciles != 1 {
+		t.Errorf("unexpected defaults %+v", c)
+	}
+}
+
+func TestDecodeRejectsUnknownFields(t *testing.T) {
+	content := []byte(`apiVersion: config.demoapp.my.domain/v1alpha1
+kind: DemoOperatorConfig
+defaultImag: nginx:1.21
+`)
+	if err := Decode(content, &DemoOperatorConfig{}); err == nil {
+		t.Error("expected an error for a misspelled field")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := &DemoOperatorConfig{
+		ResourceProfiles: map[string]corev1.ResourceRequirements{
+			"bad": {
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+			},
+		},
+		DefaultResourceProfile: "missing",
+		WatchNamespaces:        []string{"team-a"},
+		NamespaceSelector:      "demo=enabled",
+		FeatureGates:           map[string]bool{"Unknown": true},
+		DryRun:                 DryRunConfig{PlanAnnotation: true},
+		NameTemplate:           "{{.Namespace}}",
+	}
+	c.Default()
+	c.MaxConcurrentReconciles = -1
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		"resourceProfiles[bad].requests[memory]",
+		"defaultResourceProfile",
+		"namespaceSelector",
+		"featureGates[Unknown]",
+		"maxConcurrentReconciles",
+		"dryRun.planAnnotation",
+		"nameTemplate",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestValidateNameTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{template: "{{.Name}}", valid: true},
+		{template: "demo-{{.Name}}", valid: true},
+		{template: "{{.Namespace}}-{{.Name}}", valid: true},
+		{template: "{{.Namespace}}"}, // 모든 Demo가 같은 이름
+		{template: "Demo_{{.Name}}"}, // DNS label이 아님
+		{template: "{{.Name}}-"},     // '-'로 끝남
+		{template: "{{.Owner}}"},     // 없는 필드
+		{template: "{{.Name"},        // 문법 오류
+	}
+	for _, tt := range tests {
+		err := validateNameTemplate(tt.template)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.template, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", tt.template)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+// Feature gates of the operator
+const (
+	// IdleScaling enables idle scale-to-zero through the activator
+	IdleScaling = "IdleScaling"
+	// StorageVersionMigration rewrites Demos stored in an older API version at startup
+	StorageVersionMigration = "StorageVersionMigration"
+)
+
+// defaultFeatureGates lists every known feature gate and whether it is enabled by default
+var defaultFeatureGates = map[string]bool{
+	IdleScaling:             true,
+	StorageVersionMigration: true,
+}
+
+//+kubebuilder:object:root=true
+
+// DemoOperatorConfig is the configuration file of the demo operator manager
+type DemoOperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec holds the manager options:
+	// health probes, metrics, webhook port and leader election
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// DefaultImage of the nginx container when a Demo does not set spec.image.
+	// Defaults to nginx:latest.
+	// +optional
+	DefaultImage string `json:"defaultImage,omitempty"`
+
+	// ResourceProfiles are named resource requirements a Demo selects with
+	// spec.pod.resourceProfile for its nginx container
+	// +optional
+	ResourceProfiles map[string]corev1.ResourceRequirements `json:"resourceProfiles,omitempty"`
+
+	// DefaultResourceProfile is used when a Demo does not select a resource profile.
+	// The nginx container has no resource requirements when empty.
+	// +optional
+	DefaultResourceProfile string `json:"defaultResourceProfile,omitempty"`
+
+	// NameTemplate is a Go template of the name the objects of a Demo start with, e.g.
+	// "demo-{{.Name}}". It gets the Name and Namespace of the Demo and must render a
+	// different DNS label for each Demo name. The operator appends the suffix of each object,
+	// e.g. -headless, and shortens long names with a hash. Defaults to {{.Name}}.
+	// Changing it renames the Services, ConfigMaps and Secrets of existing Demos;
+	// their Deployments and StatefulSets keep their names.
+	// +optional
+	NameTemplate string `json:"nameTemplate,omitempty"`
+
+	// MaxConcurrentReconciles is the number of Demos reconciled in parallel. Defaults to 1.
+	// +optional
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// RateLimits bound the retries of failed reconciles and the API writes of the controller
+	// +optional
+	RateLimits RateLimitsConfig `json:"rateLimits,omitempty"`
+
+	// WatchNamespaces restricts the operator to these namespaces.
+	// All namespaces are watched when both this and NamespaceSelector are empty.
+	// +optional
+	WatchNamespaces []string `json:"watchNamespaces,omitempty"`
+
+	// NamespaceSelector restricts the operator to the namespaces matching this label selector
+	// +optional
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+
+	// Activator configures the activator receiving requests for idle Demos
+	// +optional
+	Activator ActivatorConfig `json:"activator,omitempty"`
+
+	// FeatureGates enables or disables operator features by name
+	// +optional
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+
+	// DryRun runs the controller without changing the cluster, e.g. to preview an upgrade
+	// side by side with the operator that manages the Demos
+	// +optional
+	DryRun DryRunConfig `json:"dryRun,omitempty"`
+
+	// Tracing exports a trace of each reconcile over OTLP. Off by default.
+	// +optional
+	Tracing TracingConfig `json:"tracing,omitempty"`
+}
+
+// RateLimitsConfig bounds how fast the controller retries and writes
+type RateLimitsConfig struct {
+	// BaseDelay is the first retry delay of a failed Demo, doubled on each further failure.
+	// Defaults to 5ms.
+	// +optional
+	BaseDelay metav1.Duration `json:"baseDelay,omitempty"`
+
+	// MaxDelay caps the retry delay of a failed Demo. Defaults to 5m.
+	// +optional
+	MaxDelay metav1.Duration `json:"maxDelay,omitempty"`
+
+	// WriteQPS is the number of create, update, patch and delete requests per second
+	// shared by all workers. Defaults to 20.
+	// +optional
+	WriteQPS float32 `json:"writeQPS,omitempty"`
+
+	// WriteBurst is the number of writes allowed at once above WriteQPS. Defaults to 50.
+	// +optional
+	WriteBurst int `json:"writeBurst,omitempty"`
+}
+
+// ActivatorConfig configures the activator
+type ActivatorConfig struct {
+	// BindAddress the activator listens on. Defaults to :8082.
+	// +optional
+	BindAddress string `json:"bindAddress,omitempty"`
+
+	// Timeout a request is held while an idle Demo wakes up. Defaults to 2m.
+	// +optional
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// DryRunConfig configures the dry-run mode
+type DryRunConfig struct {
+	// Enabled logs the changes the controller would make to each Demo as diffs
+	// and reports their number per Demo instead of writing them
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// PlanAnnotation writes the planned changes of each Demo to its
+	// demoapp.my.domain/plan annotation. This is the only write of a dry-run operator.
+	// +optional
+	PlanAnnotation bool `json:"planAnnotation,omitempty"`
+}
+
+// TracingConfig configures the OpenTelemetry traces of the controller
+type TracingConfig struct {
+	// Endpoint (host:port) of the OTLP/HTTP collector receiving the traces, e.g.
+	// otel-collector.observability:4318. Tracing is disabled when empty.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Insecure sends the traces over plain HTTP instead of HTTPS
+	// +optional
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// Enabled reports whether the feature gate is enabled
+func (c *DemoOperatorConfig) Enabled(gate string) bool {
+	if enabled, ok := c.FeatureGates[gate]; ok {
+		return enabled
+	}
+	return defaultFeatureGates[gate]
+}
+
+func init() {
+	SchemeBuilder.Register(&DemoOperatorConfig{})
+}
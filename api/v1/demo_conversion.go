@@ -0,0 +1,235 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	demoappv2 "demo-operator/api/v2"
+)
+
+// ConversionDataAnnotation keeps the v2 spec fields that v1 cannot represent,
+// so that a v2 Demo read and written back through v1 loses nothing.
+const ConversionDataAnnotation = "demoapp.my.domain/conversion-data"
+
+// v1에 없는 v2 spec 필드
+type conversionData struct {
+	Image   string                `json:"image,omitempty"`
+	Service demoappv2.ServiceSpec `json:"service,omitempty"`
+	TLS     *demoappv2.TLSSpec    `json:"tls,omitempty"`
+
+	ResourceProfile              string                    `json:"resourceProfile,omitempty"`
+	SecurityProfile              demoappv2.SecurityProfile `json:"securityProfile,omitempty"`
+	SecurityContext              *corev1.SecurityContext   `json:"securityContext,omitempty"`
+	AutomountServiceAccountToken *bool                     `json:"automountServiceAccountToken,omitempty"`
+	Adoption                     demoappv2.AdoptionPolicy  `json:"adoption,omitempty"`
+
+	CommonLabels      map[string]string `json:"commonLabels,omitempty"`
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+
+	NetworkPolicy  *demoappv2.NetworkPolicySpec  `json:"networkPolicy,omitempty"`
+	ServiceAccount *demoappv2.ServiceAccountSpec `json:"serviceAccount,omitempty"`
+}
+
+// ConvertTo converts this Demo to the Hub version (v2).
+func (src *Demo) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*demoappv2.Demo)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.Scaling = demoappv2.ScalingSpec{Replicas: src.Spec.Size}
+	for _, s := range src.Spec.Schedules {
+		dst.Spec.Scaling.Schedules = append(dst.Spec.Scaling.Schedules, demoappv2.ScalingSchedule{
+			Name:     s.Name,
+			Schedule: s.Schedule,
+			Replicas: s.Size,
+			TimeZone: s.TimeZone,
+		})
+	}
+	if src.Spec.Idle != nil {
+		dst.Spec.Scaling.Idle = &demoappv2.IdleSpec{AfterMinutes: src.Spec.Idle.AfterMinutes}
+	}
+	if s := src.Spec.Storage; s != nil {
+		dst.Spec.Storage = &demoappv2.StorageSpec{
+			Size:             s.Size.DeepCopy(),
+			StorageClassName: s.StorageClassName,
+			AccessModes:      s.AccessModes,
+			MountPath:        s.MountPath,
+			RetentionPolicy:  demoappv2.VolumeRetentionPolicy(s.RetentionPolicy),
+		}
+	}
+	dst.Spec.Pod = demoappv2.PodSpec{
+		Containers:     src.Spec.Containers,
+		InitContainers: src.Spec.InitContainers,
+	}
+	for _, s := range src.Spec.Sidecars {
+		dst.Spec.Pod.Sidecars = append(dst.Spec.Pod.Sidecars, demoappv2.BuiltinSidecar(s))
+	}
+	if c := src.Spec.Content; c != nil {
+		dst.Spec.Content = &demoappv2.ContentSpec{Inline: c.Inline, ConfigMap: c.ConfigMap}
+		if c.Git != nil {
+			dst.Spec.Content.Git = &demoappv2.GitSource{Repository: c.Git.Repository, Revision: c.Git.Revision}
+		}
+	}
+
+	// v1으로 변환할 때 보관한 v2 전용 필드를 복원합니다.
+	if raw, ok := dst.Annotations[ConversionDataAnnotation]; ok {
+		data := conversionData{}
+		if err := json.Unmarshal([]byte(raw), &data); err != nil {
+			return err
+		}
+		dst.Spec.Image = data.Image
+		dst.Spec.Service = data.Service
+		dst.Spec.TLS = data.TLS
+		dst.Spec.Pod.ResourceProfile = data.ResourceProfile
+		dst.Spec.Pod.SecurityProfile = data.SecurityProfile
+		dst.Spec.Pod.SecurityContext = data.SecurityContext
+		dst.Spec.Pod.AutomountServiceAccountToken = data.AutomountServiceAccountToken
+		dst.Spec.Adoption = data.Adoption
+		dst.Spec.CommonLabels = data.CommonLabels
+		dst.Spec.CommonAnnotations = data.CommonAnnotations
+		dst.Spec.NetworkPolicy = data.NetworkPolicy
+		dst.Spec.ServiceAccount = data.ServiceAccount
+
+		delete(dst.Annotations, ConversionDataAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	// status.nodes는 v2에 없습니다. v1으로 변환할 때 status.pods의 이름으로 다시 채웁니다.
+	dst.Status = demoappv2.DemoStatus{
+		ActiveSchedule:    src.Status.ActiveSchedule,
+		NextTransition:    src.Status.NextTransition,
+		Activity:          demoappv2.ActivityState(src.Status.Activity),
+		LastActivityTime:  src.Status.LastActivityTime,
+		ContentRevision:   src.Status.ContentRevision,
+		CertificateExpiry: src.Status.CertificateExpiry,
+		Conditions:        src.Status.Conditions,
+	}
+	for _, p := range src.Status.Pods {
+		dst.Status.Pods = append(dst.Status.Pods, demoappv2.PodSummary{Name: p.Name, NodeName: p.NodeName, Phase: p.Phase, Ready: p.Ready})
+	}
+	for _, v := range src.Status.Volumes {
+		dst.Status.Volumes = append(dst.Status.Volumes, demoappv2.VolumeStatus{Name: v.Name, Phase: v.Phase})
+	}
+	for _, c := range src.Status.Containers {
+		dst.Status.Containers = append(dst.Status.Containers, demoappv2.ContainerReadiness{Name: c.Name, Ready: c.Ready, Total: c.Total})
+	}
+	if r := src.Status.LastRestart; r != nil {
+		dst.Status.LastRestart = &demoappv2.RestartStatus{Time: r.Time, Cause: demoappv2.RestartCause(r.Cause), Message: r.Message}
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v2) to this version.
+func (dst *Demo) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*demoappv2.Demo)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.Size = src.Spec.Scaling.Replicas
+	for _, s := range src.Spec.Scaling.Schedules {
+		dst.Spec.Schedules = append(dst.Spec.Schedules, ScalingSchedule{
+			Name:     s.Name,
+			Schedule: s.Schedule,
+			Size:     s.Replicas,
+			TimeZone: s.TimeZone,
+		})
+	}
+	if src.Spec.Scaling.Idle != nil {
+		dst.Spec.Idle = &IdleSpec{AfterMinutes: src.Spec.Scaling.Idle.AfterMinutes}
+	}
+	if s := src.Spec.Storage; s != nil {
+		dst.Spec.Storage = &StorageSpec{
+			Size:             s.Size.DeepCopy(),
+			StorageClassName: s.StorageClassName,
+			AccessModes:      s.AccessModes,
+			MountPath:        s.MountPath,
+			RetentionPolicy:  VolumeRetentionPolicy(s.RetentionPolicy),
+		}
+	}
+	dst.Spec.Containers = src.Spec.Pod.Containers
+	dst.Spec.InitContainers = src.Spec.Pod.InitContainers
+	for _, s := range src.Spec.Pod.Sidecars {
+		dst.Spec.Sidecars = append(dst.Spec.Sidecars, BuiltinSidecar(s))
+	}
+	if c := src.Spec.Content; c != nil {
+		dst.Spec.Content = &ContentSpec{Inline: c.Inline, ConfigMap: c.ConfigMap}
+		if c.Git != nil {
+			dst.Spec.Content.Git = &GitSource{Repository: c.Git.Repository, Revision: c.Git.Revision}
+		}
+	}
+
+	// v1에 없는 필드는 annotation에 보관해 다시 v2로 변환할 때 잃지 않도록 합니다.
+	data := conversionData{
+		Image:                        src.Spec.Image,
+		Service:                      src.Spec.Service,
+		TLS:                          src.Spec.TLS,
+		ResourceProfile:              src.Spec.Pod.ResourceProfile,
+		SecurityProfile:              src.Spec.Pod.SecurityProfile,
+		SecurityContext:              src.Spec.Pod.SecurityContext,
+		AutomountServiceAccountToken: src.Spec.Pod.AutomountServiceAccountToken,
+		Adoption:                     src.Spec.Adoption,
+
+		CommonLabels:      src.Spec.CommonLabels,
+		CommonAnnotations: src.Spec.CommonAnnotations,
+
+		NetworkPolicy:  src.Spec.NetworkPolicy,
+		ServiceAccount: src.Spec.ServiceAccount,
+	}
+	if !reflect.DeepEqual(data, conversionData{}) {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[ConversionDataAnnotation] = string(raw)
+	}
+
+	dst.Status = DemoStatus{
+		ActiveSchedule:    src.Status.ActiveSchedule,
+		NextTransition:    src.Status.NextTransition,
+		Activity:          ActivityState(src.Status.Activity),
+		LastActivityTime:  src.Status.LastActivityTime,
+		ContentRevision:   src.Status.ContentRevision,
+		CertificateExpiry: src.Status.CertificateExpiry,
+		Conditions:        src.Status.Conditions,
+	}
+	// status는 subresource로 쓰므로 metadata의 conversion-data annotation에 보관할 수 없습니다.
+	// v1의 status.nodes는 항상 pod 이름이었으므로 status.pods에서 만듭니다.
+	for _, p := range src.Status.Pods {
+		dst.Status.Nodes = append(dst.Status.Nodes, p.Name)
+		dst.Status.Pods = append(dst.Status.Pods, PodSummary{Name: p.Name, NodeName: p.NodeName, Phase: p.Phase, Ready: p.Ready})
+	}
+	for _, v := range src.Status.Volumes {
+		dst.Status.Volumes = append(dst.Status.Volumes, VolumeStatus{Name: v.Name, Phase: v.Phase})
+	}
+	for _, c := range src.Status.Containers {
+		dst.Status.Containers = append(dst.Status.Containers, ContainerReadiness{Name: c.Name, Ready: c.Ready, Total: c.Total})
+	}
+	if r := src.Status.LastRestart; r != nil {
+		dst.Status.LastRestart = &RestartStatus{Time: r.Time, Cause: RestartCause(r.Cause), Message: r.Message}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"math/rand"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	demoappv2 "demo-operator/api/v2"
+)
+
+const fuzzIterations = 1000
+
+func newFuzzer(t *testing.T) *fuzz.Fuzzer {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := demoappv2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fuzzer.FuzzerFor(metafuzzer.Funcs, rand.NewSource(rand.Int63()), serializer.NewCodecFactory(scheme))
+}
+
+// v1 -> v2 -> v1 변환에서 v1 데이터가 사라지지 않아야 합니다.
+func TestFuzzyConversionFromSpoke(t *testing.T) {
+	f := newFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		src := &Demo{}
+		f.Fuzz(src)
+		// v2에는 status.nodes가 없어 status.pods의 이름으로 다시 만듭니다. operator는 항상 둘을 같게 씁니다.
+		src.Status.Nodes = nil
+		for _, p := range src.Status.Pods {
+			src.Status.Nodes = append(src.Status.Nodes, p.Name)
+		}
+
+		hub := &demoappv2.Demo{}
+		if err := src.ConvertTo(hub); err != nil {
+			t.Fatal(err)
+		}
+		dst := &Demo{}
+		if err := dst.ConvertFrom(hub); err != nil {
+			t.Fatal(err)
+		}
+
+		if !apiequality.Semantic.DeepEqual(src, dst) {
+			t.Fatalf("v1 data lost in round trip:\n%s", diff.ObjectReflectDiff(src, dst))
+		}
+	}
+}
+
+// v2 -> v1 -> v2 변환에서 v1에 없는 필드도 annotation을 통해 복원되어야 합니다.
+func TestFuzzyConversionFromHub(t *testing.T) {
+	f := newFuzzer(t)
+	for i := 0; i < fuzzIterations; i++ {
+		src := &demoappv2.Demo{}
+		f.Fuzz(src)
+
+		spoke := &Demo{}
+		if err := spoke.ConvertFrom(src); err != nil {
+			t.Fatal(err)
+		}
+		dst := &demoappv2.Demo{}
+		if err := spoke.ConvertTo(dst); err != nil {
+			t.Fatal(err)
+		}
+
+		if !apiequality.Semantic.DeepEqual(src, dst) {
+			t.Fatalf("v2 data lost in round trip:\n%s", diff.ObjectReflectDiff(src, dst))
+		}
+	}
+}
+
+func TestConvertTo(t *testing.T) {
+	src := &Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: DemoSpec{
+			Size:      3,
+			Schedules: []ScalingSchedule{{Name: "night", Schedule: "0 20 * * *", Size: 1}},
+			Idle:      &IdleSpec{AfterMinutes: 10},
+			Storage:   &StorageSpec{Size: resource.MustParse("1Gi"), MountPath: "/data"},
+			Sidecars:  []BuiltinSidecar{NginxPrometheusExporter},
+		},
+		Status: DemoStatus{
+			Nodes:    []string{"web-0"},
+			Pods:     []PodSummary{{Name: "web-0", NodeName: "node-a"}},
+			Activity: ActivityActive,
+		},
+	}
+
+	dst := &demoappv2.Demo{}
+	if err := src.ConvertTo(dst); err != nil {
+		t.Fatal(err)
+	}
+
+	want := demoappv2.DemoSpec{
+		Scaling: demoappv2.ScalingSpec{
+			Replicas:  3,
+			Schedules: []demoappv2.ScalingSchedule{{Name: "night", Schedule: "0 20 * * *", Replicas: 1}},
+			Idle:      &demoappv2.IdleSpec{AfterMinutes: 10},
+		},
+		Storage: &demoappv2.StorageSpec{Size: resource.MustParse("1Gi"), MountPath: "/data"},
+		Pod:     demoappv2.PodSpec{Sidecars: []demoappv2.BuiltinSidecar{demoappv2.NginxPrometheusExporter}},
+	}
+	if !apiequality.Semantic.DeepEqual(dst.Spec, want) {
+		t.Errorf("unexpected spec:\n%s", diff.ObjectReflectDiff(want, dst.Spec))
+	}
+	if dst.Status.Activity != demoappv2.ActivityActive || len(dst.Status.Pods) != 1 {
+		t.Errorf("unexpected status %+v", dst.Status)
+	}
+}
+
+// v2에 없는 status.nodes는 status.pods의 이름으로 채웁니다.
+func TestConvertFromFillsNodes(t *testing.T) {
+	src := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Status: demoappv2.DemoStatus{Pods: []demoappv2.PodSummary{
+			{Name: "web-0", NodeName: "node-a"}, {Name: "web-1", NodeName: "node-b"},
+		}},
+	}
+	dst := &Demo{}
+	if err := dst.ConvertFrom(src); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"web-0", "web-1"}; !apiequality.Semantic.DeepEqual(dst.Status.Nodes, want) {
+		t.Errorf("got nodes %v, want %v", dst.Status.Nodes, want)
+	}
+}
+
+func TestConvertFromKeepsV2OnlyFields(t *testing.T) {
+	src := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: demoappv2.DemoSpec{
+			Image:   "nginx:1.21",
+			Service: demoappv2.ServiceSpec{Type: "NodePort", Port: 8080},
+			Scaling: demoappv2.ScalingSpec{Replicas: 2},
+		},
+	}
+
+	dst := &Demo{}
+	if err := dst.ConvertFrom(src); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Spec.Size != 2 {
+		t.Errorf("got size %d, want 2", dst.Spec.Size)
+	}
+	want := `{"image":"nginx:1.21","service":{"type":"NodePort","port":8080}}`
+	if got := dst.Annotations[ConversionDataAnnotation]; got != want {
+		t.Errorf("got conversion data %s, want %s", got, want)
+	}
+
+	// v2 전용 필드가 없으면 annotation을 남기지 않습니다.
+	src.Spec.Image, src.Spec.Service = "", demoappv2.ServiceSpec{}
+	dst = &Demo{}
+	if err := dst.ConvertFrom(src); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dst.Annotations[ConversionDataAnnotation]; ok {
+		t.Errorf("unexpected conversion data %v", dst.Annotations)
+	}
+}
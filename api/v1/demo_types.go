@@ -17,6 +17,8 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -33,19 +35,282 @@ type DemoSpec struct {
 
 	// Size of Demo
 	Size int32 `json:"size"`
+
+	// Schedules override Size while one of them is active.
+	// The most recently fired schedule wins until another one fires.
+	// +optional
+	Schedules []ScalingSchedule `json:"schedules,omitempty"`
+
+	// Idle scales the Demo to zero after a period without requests and
+	// wakes it up again on the first request.
+	// +optional
+	Idle *IdleSpec `json:"idle,omitempty"`
+
+	// Storage runs the Demo as a StatefulSet with a PersistentVolumeClaim per replica
+	// instead of a Deployment.
+	// +optional
+	Storage *StorageSpec `json:"storage,omitempty"`
+
+	// Containers are added to the pod next to the nginx container
+	// +optional
+	Containers []corev1.Container `json:"containers,omitempty"`
+
+	// InitContainers run before the nginx container starts
+	// +optional
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+
+	// Sidecars are built-in containers the operator injects and wires up,
+	// including their Service ports
+	// +optional
+	Sidecars []BuiltinSidecar `json:"sidecars,omitempty"`
+
+	// Content is the static site served by nginx. It is copied into a shared
+	// volume by an init container, so changing it rolls the pods.
+	// +optional
+	Content *ContentSpec `json:"content,omitempty"`
+}
+
+// ContentSpec selects where the served files come from. Exactly one source must be set.
+type ContentSpec struct {
+	// Inline maps file names to their content
+	// +optional
+	Inline map[string]string `json:"inline,omitempty"`
+
+	// ConfigMap whose keys are served as files
+	// +optional
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+
+	// Git repository cloned into the served directory
+	// +optional
+	Git *GitSource `json:"git,omitempty"`
+}
+
+// GitSource is a Git repository at a revision
+type GitSource struct {
+	// Repository URL, e.g. https://github.com/example/site.git
+	Repository string `json:"repository"`
+
+	// Revision is a branch, tag or full commit SHA. The default branch is used when empty.
+	// Branches and tags are resolved to a commit every 5 minutes over HTTP(S); a new commit is rolled out.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+}
+
+// BuiltinSidecar names a sidecar container provided by the operator
+// +kubebuilder:validation:Enum=nginx-prometheus-exporter
+type BuiltinSidecar string
+
+const (
+	// NginxPrometheusExporter exports nginx stub_status as Prometheus metrics on port 9113
+	NginxPrometheusExporter BuiltinSidecar = "nginx-prometheus-exporter"
+)
+
+// IdleSpec configures idle scale-to-zero
+type IdleSpec struct {
+	// AfterMinutes without requests before the Demo is scaled to zero.
+	// Requests are counted from nginx stub_status. Scrapes through the
+	// nginx-prometheus-exporter sidecar are not counted, other clients
+	// reading stub_status directly are.
+	// +kubebuilder:validation:Minimum=1
+	AfterMinutes int32 `json:"afterMinutes"`
 }
 
+// ScalingSchedule sets the size of a Demo from the time its cron expression fires
+type ScalingSchedule struct {
+	// Name identifies the schedule in status
+	Name string `json:"name"`
+
+	// Schedule is a standard 5-field cron expression, e.g. "0 20 * * 1-5"
+	Schedule string `json:"schedule"`
+
+	// Size to scale to when the schedule fires
+	// +kubebuilder:validation:Minimum=0
+	Size int32 `json:"size"`
+
+	// TimeZone is an IANA time zone name used to evaluate Schedule. Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// StorageSpec configures the volume of each Demo replica.
+// A larger Size expands the existing volumes when their StorageClass allows it.
+// A smaller Size, another StorageClassName or other AccessModes cannot be applied to
+// existing volumes and are reported by the StorageSynced condition.
+type StorageSpec struct {
+	// Size of each volume
+	Size resource.Quantity `json:"size"`
+
+	// StorageClassName of the volumes. The cluster default is used when empty.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// AccessModes of the volumes. Defaults to ReadWriteOnce.
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// MountPath of the volume in the nginx container. Defaults to /usr/share/nginx/html.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+
+	// RetentionPolicy decides whether the volumes are deleted with the Demo,
+	// or when storage is removed from the spec. Defaults to Retain.
+	// +optional
+	RetentionPolicy VolumeRetentionPolicy `json:"retentionPolicy,omitempty"`
+}
+
+// VolumeRetentionPolicy decides what happens to Demo volumes that are no longer used
+// +kubebuilder:validation:Enum=Retain;Delete
+type VolumeRetentionPolicy string
+
+const (
+	// RetainVolumes keeps the PersistentVolumeClaims
+	RetainVolumes VolumeRetentionPolicy = "Retain"
+	// DeleteVolumes deletes the PersistentVolumeClaims
+	DeleteVolumes VolumeRetentionPolicy = "Delete"
+)
+
 // DemoStatus defines the observed state of Demo
 type DemoStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// pod status
-	Nodes []string `json:"nodes"`
+	// Nodes are the names of the Demo pods.
+	// Deprecated: despite its name this holds pod names, use Pods instead.
+	// v2 does not have it; it is filled from the names in Pods when a Demo is read through v1.
+	// +optional
+	Nodes []string `json:"nodes,omitempty"`
+
+	// Pods are the Demo pods and the nodes they run on, sorted by name
+	// +optional
+	Pods []PodSummary `json:"pods,omitempty"`
+
+	// ActiveSchedule is the name of the schedule currently deciding the size
+	// +optional
+	ActiveSchedule string `json:"activeSchedule,omitempty"`
+
+	// NextTransition is the time the next schedule fires
+	// +optional
+	NextTransition *metav1.Time `json:"nextTransition,omitempty"`
+
+	// Activity is the idle state of the Demo when spec.idle is set
+	// +optional
+	Activity ActivityState `json:"activity,omitempty"`
+
+	// LastActivityTime is the last time requests were observed
+	// +optional
+	LastActivityTime *metav1.Time `json:"lastActivityTime,omitempty"`
+
+	// Volumes lists the PersistentVolumeClaim of each replica when spec.storage is set
+	// +optional
+	Volumes []VolumeStatus `json:"volumes,omitempty"`
+
+	// Containers reports the readiness of each container across the Demo pods
+	// +optional
+	Containers []ContainerReadiness `json:"containers,omitempty"`
+
+	// ContentRevision is the revision of spec.content rolled out to the pods, the commit SHA for Git
+	// +optional
+	ContentRevision string `json:"contentRevision,omitempty"`
+
+	// CertificateExpiry is when the serving certificate of spec.tls expires
+	// +optional
+	CertificateExpiry *metav1.Time `json:"certificateExpiry,omitempty"`
+
+	// LastRestart is the last rolling restart of the pods that was not caused by a change of the pod spec
+	// +optional
+	LastRestart *RestartStatus `json:"lastRestart,omitempty"`
+
+	// Conditions describe the state of the Demo. ResourcesOwned is false while
+	// a resource the Demo needs exists but is not owned by it. NetworkPolicyActive
+	// tells whether the pods are selected by the NetworkPolicy of spec.networkPolicy.
+	// ServiceAccountReady tells whether the ServiceAccount and Role of spec.serviceAccount exist.
+	// Paused is true while the Demo is paused with the paused annotation.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PodSummary describes one Demo pod
+type PodSummary struct {
+	// Name of the pod
+	Name string `json:"name"`
+
+	// NodeName is the node the pod is scheduled on, empty while pending
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+
+	// Phase of the pod
+	// +optional
+	Phase corev1.PodPhase `json:"phase,omitempty"`
+
+	// Ready is true when the pod's Ready condition is true
+	Ready bool `json:"ready"`
 }
 
+// RestartCause tells why the Demo pods were restarted
+// +kubebuilder:validation:Enum=Requested;ContentChanged;CertificateRotated
+type RestartCause string
+
+const (
+	// RestartRequested means the restartedAt annotation of the Demo changed
+	RestartRequested RestartCause = "Requested"
+	// RestartContentChanged means a new revision of spec.content was rolled out
+	RestartContentChanged RestartCause = "ContentChanged"
+	// RestartCertificateRotated means the serving certificate of spec.tls was renewed
+	RestartCertificateRotated RestartCause = "CertificateRotated"
+)
+
+// RestartStatus describes a rolling restart of the Demo pods
+type RestartStatus struct {
+	// Time is when the operator started the restart
+	Time metav1.Time `json:"time"`
+
+	// Cause of the restart
+	Cause RestartCause `json:"cause"`
+
+	// Message describes what changed, e.g. the requested restart time
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ContainerReadiness is the readiness of one container across the Demo pods
+type ContainerReadiness struct {
+	// Name of the container
+	Name string `json:"name"`
+
+	// Ready is the number of pods in which the container is ready
+	Ready int32 `json:"ready"`
+
+	// Total is the number of pods running the container
+	Total int32 `json:"total"`
+}
+
+// VolumeStatus is the binding state of one replica's PersistentVolumeClaim
+type VolumeStatus struct {
+	// Name of the PersistentVolumeClaim
+	Name string `json:"name"`
+
+	// Phase of the PersistentVolumeClaim
+	Phase corev1.PersistentVolumeClaimPhase `json:"phase"`
+}
+
+// ActivityState describes whether a Demo with spec.idle is serving traffic
+// +kubebuilder:validation:Enum=Active;Idle;Waking
+type ActivityState string
+
+const (
+	// ActivityActive means pods are running and the Service selects them
+	ActivityActive ActivityState = "Active"
+	// ActivityIdle means the Demo is scaled to zero and the Service points at the activator
+	ActivityIdle ActivityState = "Idle"
+	// ActivityWaking means a request arrived while idle and pods are starting
+	ActivityWaking ActivityState = "Waking"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:deprecatedversion:warning="demoapp.my.domain/v1 Demo is deprecated; use demoapp.my.domain/v2, and status.pods instead of status.nodes"
 // 추가
 // +kubebuilder:printcolumn:name="size",type=string,JSONPath=`.spec.size`
 // +kubebuilder:printcolumn:name="created at",type=string,JSONPath=`.metadata.creationTimestamp`
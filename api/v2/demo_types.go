@@ -0,0 +1,535 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DemoSpec defines the desired state of Demo
+type DemoSpec struct {
+	// Image of the nginx container. Defaults to nginx:latest.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Service exposing the Demo pods
+	// +optional
+	Service ServiceSpec `json:"service,omitempty"`
+
+	// TLS serves HTTPS next to HTTP
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// Scaling decides how many replicas the Demo runs
+	Scaling ScalingSpec `json:"scaling"`
+
+	// Storage runs the Demo as a StatefulSet with a PersistentVolumeClaim per replica
+	// instead of a Deployment.
+	// +optional
+	Storage *StorageSpec `json:"storage,omitempty"`
+
+	// Pod adds containers to the Demo pods
+	// +optional
+	Pod PodSpec `json:"pod,omitempty"`
+
+	// Adoption decides whether the Demo takes over existing resources with the names it
+	// needs that have no controller. Never reports them as a conflict, IfLabeled adopts
+	// them when they carry the demoapp.my.domain/adopt label set to the Demo name, and
+	// Always adopts them. Resources controlled by something else are never adopted.
+	// Defaults to Never.
+	// +kubebuilder:validation:Enum=Never;IfLabeled;Always
+	// +optional
+	Adoption AdoptionPolicy `json:"adoption,omitempty"`
+
+	// NetworkPolicy creates a NetworkPolicy selecting the Demo pods, for clusters
+	// that deny traffic by default
+	// +optional
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// ServiceAccount runs the Demo pods as a dedicated ServiceAccount instead of
+	// the default ServiceAccount of the namespace
+	// +optional
+	ServiceAccount *ServiceAccountSpec `json:"serviceAccount,omitempty"`
+
+	// CommonLabels are added to every object the Demo creates, including its pods.
+	// Keys under app.kubernetes.io/ are set by the operator and cannot be used.
+	// Keys removed from the map are not removed from existing objects.
+	// +optional
+	CommonLabels map[string]string `json:"commonLabels,omitempty"`
+
+	// CommonAnnotations are added to every object the Demo creates, including its pods.
+	// Keys removed from the map are not removed from existing objects.
+	// +optional
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+
+	// Content is the static site served by nginx. It is copied into a shared
+	// volume by an init container, so changing it rolls the pods.
+	// +optional
+	Content *ContentSpec `json:"content,omitempty"`
+}
+
+// AdoptionPolicy decides whether a Demo takes over existing resources
+type AdoptionPolicy string
+
+const (
+	// AdoptNever never adopts existing resources
+	AdoptNever AdoptionPolicy = "Never"
+	// AdoptIfLabeled adopts existing resources labeled with AdoptLabel set to the Demo name
+	AdoptIfLabeled AdoptionPolicy = "IfLabeled"
+	// AdoptAlways adopts existing resources without a controller
+	AdoptAlways AdoptionPolicy = "Always"
+)
+
+// AdoptLabel marks an existing resource for adoption by the Demo named in its value
+const AdoptLabel = "demoapp.my.domain/adopt"
+
+// ConditionResourcesOwned is true when the Demo controls all the resources it needs
+const ConditionResourcesOwned = "ResourcesOwned"
+
+// ConditionNetworkPolicyActive is true when the NetworkPolicy of spec.networkPolicy
+// exists and selects the Demo pods. Whether it is enforced depends on the network plugin.
+// It is only set for Demos with spec.networkPolicy.
+const ConditionNetworkPolicyActive = "NetworkPolicyActive"
+
+// ConditionServiceAccountReady is true when the ServiceAccount of spec.serviceAccount
+// and the Role granting its rules exist
+const ConditionServiceAccountReady = "ServiceAccountReady"
+
+// ConditionTLSReady is true when the serving certificate of spec.tls is valid
+const ConditionTLSReady = "TLSReady"
+
+// ConditionSchedulesValid is false when spec.scaling.schedules cannot be parsed.
+// The Demo runs spec.scaling.replicas until the schedules are fixed.
+const ConditionSchedulesValid = "SchedulesValid"
+
+// ConditionValid is false when the spec cannot be applied. The reason names the invalid part,
+// and the operator does not retry until the spec changes.
+const ConditionValid = "Valid"
+
+// ConditionStorageSynced is false when spec.storage asks for a volume change the operator cannot
+// apply, such as a smaller size or another storage class, or while the volumes are expanded.
+// It is only set for Demos with spec.storage.
+const ConditionStorageSynced = "StorageSynced"
+
+// ConditionPaused is true while the Demo has PausedAnnotation set to "true"
+const ConditionPaused = "Paused"
+
+// PausedAnnotation set to "true" stops the operator from changing the resources of the Demo.
+// Only the status is updated while the Demo is paused.
+const PausedAnnotation = "demoapp.my.domain/paused"
+
+// RestartedAtAnnotation restarts the Demo pods when its value changes.
+// The operator copies it into the pod template, which rolls the pods like `kubectl rollout restart`.
+const RestartedAtAnnotation = "demoapp.my.domain/restartedAt"
+
+// PlanAnnotation is written by an operator running in dry-run mode with the plan annotation enabled.
+// It holds a JSON list of the changes that operator would make to the resources of the Demo.
+const PlanAnnotation = "demoapp.my.domain/plan"
+
+// ServiceSpec configures the Service in front of the Demo pods
+type ServiceSpec struct {
+	// Type of the Service. Defaults to ClusterIP.
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Port the Service serves HTTP on. Defaults to 80.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+// TLSSpec configures HTTPS on the Demo
+type TLSSpec struct {
+	// SecretName of an existing kubernetes.io/tls Secret with the serving certificate.
+	// When empty the operator generates a self-signed CA and a certificate for the
+	// Service DNS names into a Secret named <name>-tls and rotates it before expiry.
+	// The CA certificate is stored under ca.crt for clients.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Port the Service serves HTTPS on. Defaults to 443.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+// ScalingSpec decides the number of replicas
+type ScalingSpec struct {
+	// Replicas of the Demo when no schedule is active
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+
+	// Schedules override Replicas while one of them is active.
+	// The most recently fired schedule wins until another one fires.
+	// +optional
+	Schedules []ScalingSchedule `json:"schedules,omitempty"`
+
+	// Idle scales the Demo to zero after a period without requests and
+	// wakes it up again on the first request.
+	// +optional
+	Idle *IdleSpec `json:"idle,omitempty"`
+}
+
+// PodSpec adds containers to the Demo pods
+type PodSpec struct {
+	// Containers are added to the pod next to the nginx container
+	// +optional
+	Containers []corev1.Container `json:"containers,omitempty"`
+
+	// InitContainers run before the nginx container starts
+	// +optional
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+
+	// Sidecars are built-in containers the operator injects and wires up,
+	// including their Service ports
+	// +optional
+	Sidecars []BuiltinSidecar `json:"sidecars,omitempty"`
+
+	// ResourceProfile names a resource profile of the operator config whose
+	// resource requirements apply to the nginx container. The operator's default
+	// profile is used when empty.
+	// +optional
+	ResourceProfile string `json:"resourceProfile,omitempty"`
+
+	// SecurityProfile of the Demo pods. Defaults to Restricted.
+	// +optional
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+
+	// SecurityContext overrides fields of the nginx container security context set by
+	// the security profile. Under Restricted it cannot run nginx as root or privileged,
+	// make the root filesystem writable or turn off seccomp, and added capabilities
+	// are kept on top of dropping ALL.
+	// +optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// AutomountServiceAccountToken overrides the security profile, which does not
+	// mount a service account token under Restricted unless spec.serviceAccount.rules are set.
+	// +optional
+	AutomountServiceAccountToken *bool `json:"automountServiceAccountToken,omitempty"`
+}
+
+// SecurityProfile decides the security context of the Demo pods
+// +kubebuilder:validation:Enum=Restricted;Unrestricted
+type SecurityProfile string
+
+const (
+	// SecurityRestricted runs nginx as a non-root user on port 8080 with a read-only root
+	// filesystem, no capabilities, the RuntimeDefault seccomp profile and no service
+	// account token without spec.serviceAccount.rules. Containers in spec.pod run as the user of
+	// their image unless they set a securityContext; set runAsNonRoot on them in namespaces
+	// enforcing the restricted Pod Security Standard.
+	SecurityRestricted SecurityProfile = "Restricted"
+	// SecurityUnrestricted keeps the defaults of the images and runs nginx on port 80
+	SecurityUnrestricted SecurityProfile = "Unrestricted"
+)
+
+// ServiceAccountSpec configures the ServiceAccount of the Demo pods
+type ServiceAccountSpec struct {
+	// Name of an existing ServiceAccount the pods run as. When empty the operator
+	// creates a ServiceAccount named after the Demo.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Rules of a Role the operator creates and binds to the ServiceAccount.
+	// The operator can only grant permissions it has itself. Only the get, list and
+	// watch verbs are allowed, so the pods cannot create workloads. Rules on secrets,
+	// serviceaccounts, roles, rolebindings, pods/exec, pods/attach or pods/portforward,
+	// other verbs and wildcards are rejected and no Role is created. The service account token is mounted into the pods by default when rules are set.
+	// +optional
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+}
+
+// NetworkPolicySpec configures the NetworkPolicy of the Demo pods
+type NetworkPolicySpec struct {
+	// Ingress lists the sources allowed to reach the ports of the Service.
+	// An empty list does not deny any traffic: every pod and address may reach those
+	// ports, and only the other ports of the pods are closed. Set at least one source,
+	// e.g. a namespaceSelector, to restrict who reaches the Service ports.
+	// The operator is always allowed when spec.scaling.idle is set.
+	// +optional
+	Ingress []networkingv1.NetworkPolicyPeer `json:"ingress,omitempty"`
+
+	// Egress rules of the Demo pods. Egress is not restricted when empty.
+	// Remember to allow DNS and, for spec.content.git, the Git server.
+	// +optional
+	Egress []networkingv1.NetworkPolicyEgressRule `json:"egress,omitempty"`
+}
+
+// ContentSpec selects where the served files come from. Exactly one source must be set.
+type ContentSpec struct {
+	// Inline maps file names to their content
+	// +optional
+	Inline map[string]string `json:"inline,omitempty"`
+
+	// ConfigMap whose keys are served as files
+	// +optional
+	ConfigMap *corev1.LocalObjectReference `json:"configMap,omitempty"`
+
+	// Git repository cloned into the served directory
+	// +optional
+	Git *GitSource `json:"git,omitempty"`
+}
+
+// GitSource is a Git repository at a revision
+type GitSource struct {
+	// Repository URL, e.g. https://github.com/example/site.git
+	Repository string `json:"repository"`
+
+	// Revision is a branch, tag or full commit SHA. The default branch is used when empty.
+	// Branches and tags are resolved to a commit every 5 minutes over HTTP(S); a new commit is rolled out.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+}
+
+// BuiltinSidecar names a sidecar container provided by the operator
+// +kubebuilder:validation:Enum=nginx-prometheus-exporter
+type BuiltinSidecar string
+
+const (
+	// NginxPrometheusExporter exports nginx stub_status as Prometheus metrics on port 9113
+	NginxPrometheusExporter BuiltinSidecar = "nginx-prometheus-exporter"
+)
+
+// IdleSpec configures idle scale-to-zero
+type IdleSpec struct {
+	// AfterMinutes without requests before the Demo is scaled to zero.
+	// Requests are counted from nginx stub_status. Scrapes through the
+	// nginx-prometheus-exporter sidecar are not counted, other clients
+	// reading stub_status directly are.
+	// +kubebuilder:validation:Minimum=1
+	AfterMinutes int32 `json:"afterMinutes"`
+}
+
+// ScalingSchedule sets the replicas of a Demo from the time its cron expression fires
+type ScalingSchedule struct {
+	// Name identifies the schedule in status
+	Name string `json:"name"`
+
+	// Schedule is a standard 5-field cron expression, e.g. "0 20 * * 1-5"
+	Schedule string `json:"schedule"`
+
+	// Replicas to scale to when the schedule fires
+	// +kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+
+	// TimeZone is an IANA time zone name used to evaluate Schedule. Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// StorageSpec configures the volume of each Demo replica.
+// A larger Size expands the existing volumes when their StorageClass allows it.
+// A smaller Size, another StorageClassName or other AccessModes cannot be applied to
+// existing volumes and are reported by the StorageSynced condition.
+type StorageSpec struct {
+	// Size of each volume
+	Size resource.Quantity `json:"size"`
+
+	// StorageClassName of the volumes. The cluster default is used when empty.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// AccessModes of the volumes. Defaults to ReadWriteOnce.
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// MountPath of the volume in the nginx container. Defaults to /usr/share/nginx/html.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+
+	// RetentionPolicy decides whether the volumes are deleted with the Demo,
+	// or when storage is removed from the spec. Defaults to Retain.
+	// +optional
+	RetentionPolicy VolumeRetentionPolicy `json:"retentionPolicy,omitempty"`
+}
+
+// VolumeRetentionPolicy decides what happens to Demo volumes that are no longer used
+// +kubebuilder:validation:Enum=Retain;Delete
+type VolumeRetentionPolicy string
+
+const (
+	// RetainVolumes keeps the PersistentVolumeClaims
+	RetainVolumes VolumeRetentionPolicy = "Retain"
+	// DeleteVolumes deletes the PersistentVolumeClaims
+	DeleteVolumes VolumeRetentionPolicy = "Delete"
+)
+
+// DemoStatus defines the observed state of Demo
+type DemoStatus struct {
+	// Pods are the Demo pods and the nodes they run on, sorted by name
+	// +optional
+	Pods []PodSummary `json:"pods,omitempty"`
+
+	// ActiveSchedule is the name of the schedule currently deciding the replicas
+	// +optional
+	ActiveSchedule string `json:"activeSchedule,omitempty"`
+
+	// NextTransition is the time the next schedule fires
+	// +optional
+	NextTransition *metav1.Time `json:"nextTransition,omitempty"`
+
+	// Activity is the idle state of the Demo when spec.scaling.idle is set
+	// +optional
+	Activity ActivityState `json:"activity,omitempty"`
+
+	// LastActivityTime is the last time requests were observed
+	// +optional
+	LastActivityTime *metav1.Time `json:"lastActivityTime,omitempty"`
+
+	// Volumes lists the PersistentVolumeClaim of each replica when spec.storage is set
+	// +optional
+	Volumes []VolumeStatus `json:"volumes,omitempty"`
+
+	// Containers reports the readiness of each container across the Demo pods
+	// +optional
+	Containers []ContainerReadiness `json:"containers,omitempty"`
+
+	// ContentRevision is the revision of spec.content rolled out to the pods, the commit SHA for Git
+	// +optional
+	ContentRevision string `json:"contentRevision,omitempty"`
+
+	// CertificateExpiry is when the serving certificate of spec.tls expires
+	// +optional
+	CertificateExpiry *metav1.Time `json:"certificateExpiry,omitempty"`
+
+	// LastRestart is the last rolling restart of the pods that was not caused by a change of the pod spec
+	// +optional
+	LastRestart *RestartStatus `json:"lastRestart,omitempty"`
+
+	// Conditions describe the state of the Demo. ResourcesOwned is false while
+	// a resource the Demo needs exists but is not owned by it. NetworkPolicyActive
+	// tells whether the pods are selected by the NetworkPolicy of spec.networkPolicy.
+	// ServiceAccountReady tells whether the ServiceAccount and Role of spec.serviceAccount exist.
+	// Paused is true while the Demo is paused with the paused annotation.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// PodSummary describes one Demo pod
+type PodSummary struct {
+	// Name of the pod
+	Name string `json:"name"`
+
+	// NodeName is the node the pod is scheduled on, empty while pending
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+
+	// Phase of the pod
+	// +optional
+	Phase corev1.PodPhase `json:"phase,omitempty"`
+
+	// Ready is true when the pod's Ready condition is true
+	Ready bool `json:"ready"`
+}
+
+// RestartCause tells why the Demo pods were restarted
+// +kubebuilder:validation:Enum=Requested;ContentChanged;CertificateRotated
+type RestartCause string
+
+const (
+	// RestartRequested means the restartedAt annotation of the Demo changed
+	RestartRequested RestartCause = "Requested"
+	// RestartContentChanged means a new revision of spec.content was rolled out
+	RestartContentChanged RestartCause = "ContentChanged"
+	// RestartCertificateRotated means the serving certificate of spec.tls was renewed
+	RestartCertificateRotated RestartCause = "CertificateRotated"
+)
+
+// RestartStatus describes a rolling restart of the Demo pods
+type RestartStatus struct {
+	// Time is when the operator started the restart
+	Time metav1.Time `json:"time"`
+
+	// Cause of the restart
+	Cause RestartCause `json:"cause"`
+
+	// Message describes what changed, e.g. the requested restart time
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ContainerReadiness is the readiness of one container across the Demo pods
+type ContainerReadiness struct {
+	// Name of the container
+	Name string `json:"name"`
+
+	// Ready is the number of pods in which the container is ready
+	Ready int32 `json:"ready"`
+
+	// Total is the number of pods running the container
+	Total int32 `json:"total"`
+}
+
+// VolumeStatus is the binding state of one replica's PersistentVolumeClaim
+type VolumeStatus struct {
+	// Name of the PersistentVolumeClaim
+	Name string `json:"name"`
+
+	// Phase of the PersistentVolumeClaim
+	Phase corev1.PersistentVolumeClaimPhase `json:"phase"`
+}
+
+// ActivityState describes whether a Demo with spec.scaling.idle is serving traffic
+// +kubebuilder:validation:Enum=Active;Idle;Waking
+type ActivityState string
+
+const (
+	// ActivityActive means pods are running and the Service selects them
+	ActivityActive ActivityState = "Active"
+	// ActivityIdle means the Demo is scaled to zero and the Service points at the activator
+	ActivityIdle ActivityState = "Idle"
+	// ActivityWaking means a request arrived while idle and pods are starting
+	ActivityWaking ActivityState = "Waking"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="replicas",type=integer,JSONPath=`.spec.scaling.replicas`
+//+kubebuilder:printcolumn:name="image",type=string,JSONPath=`.spec.image`
+//+kubebuilder:printcolumn:name="created at",type=string,JSONPath=`.metadata.creationTimestamp`
+
+// Demo is the Schema for the demoes API
+type Demo struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DemoSpec   `json:"spec,omitempty"`
+	Status DemoStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DemoList contains a list of Demo
+type DemoList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Demo `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Demo{}, &DemoList{})
+}
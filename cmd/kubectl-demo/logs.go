@@ -0,0 +1,132 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+)
+
+func logsCommand(fs *flag.FlagSet) func(ctx context.Context, o *options, args []string) error {
+	container := fs.String("c", nginxContainer, "The container to print the logs of.")
+	follow := fs.Bool("f", false, "Stream the logs until interrupted.")
+	tail := fs.Int64("tail", -1, "Lines of recent logs to print from each pod. All lines when negative.")
+	since := fs.Duration("since", 0, "Only print logs newer than this duration, e.g. 1h.")
+	return func(ctx context.Context, o *options, args []string) error {
+		d, err := o.getDemo(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		pods, err := o.demoPods(ctx, d)
+		if err != nil {
+			return err
+		}
+		if len(pods) == 0 {
+			return fmt.Errorf("demo/%s has no pods", d.Name)
+		}
+
+		opts := &corev1.PodLogOptions{Container: *container, Follow: *follow}
+		if *tail >= 0 {
+			opts.TailLines = tail
+		}
+		if *since > 0 {
+			seconds := int64(since.Round(time.Second) / time.Second)
+			opts.SinceSeconds = &seconds
+		}
+		return o.streamLogs(ctx, pods, opts)
+	}
+}
+
+// demoPods는 Demo의 pod를 이름 순서로 찾습니다.
+func (o *options) demoPods(ctx context.Context, d *demoappv2.Demo) ([]corev1.Pod, error) {
+	list := &corev1.PodList{}
+	err := o.client.List(ctx, list, client.InNamespace(d.Namespace), client.MatchingLabels(controllers.PodSelector(d)))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	return list.Items, nil
+}
+
+// streamLogs는 모든 pod의 로그를 동시에 읽어 줄마다 [pod/container] prefix를 붙여 출력합니다.
+// 한 pod의 로그를 읽지 못해도 나머지 pod의 로그는 계속 출력합니다.
+func (o *options) streamLogs(ctx context.Context, pods []corev1.Pod, opts *corev1.PodLogOptions) error {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for i := range pods {
+		pod := pods[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			prefix := fmt.Sprintf("[%s/%s] ", pod.Name, opts.Container)
+			err := o.podLogs(ctx, pod, opts, func(line string) {
+				mu.Lock()
+				defer mu.Unlock()
+				fmt.Fprint(o.out, prefix+line)
+			})
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("pod %s: %w", pod.Name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return kerrors.NewAggregate(errs)
+}
+
+func (o *options) podLogs(ctx context.Context, pod corev1.Pod, opts *corev1.PodLogOptions, emit func(line string)) error {
+	stream, err := o.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if line[len(line)-1] != '\n' {
+				line += "\n"
+			}
+			emit(line)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+)
+
+func TestLogs(t *testing.T) {
+	p := newPluginTest(t)
+	d := p.createDemo("web", demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2}})
+	other := &demoappv2.Demo{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+	pod := func(name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: nginxContainer, Image: "nginx"}}},
+		}
+	}
+	for _, obj := range []*corev1.Pod{
+		pod("web-b", controllers.PodSelector(d)),
+		pod("web-a", controllers.PodSelector(d)),
+		pod("other-a", controllers.PodSelector(other)),
+	} {
+		p.create(obj)
+		// fake clientset은 pod가 있어야 로그를 돌려줍니다.
+		if _, err := p.clientset.CoreV1().Pods(p.namespace).Create(p.ctx, obj, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := p.mustRun("logs", "web", "--tail=10")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	sort.Strings(lines)
+	want := []string{"[web-a/nginx] fake logs", "[web-b/nginx] fake logs"}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got logs\n%s\nwant\n%s", out, strings.Join(want, "\n"))
+	}
+
+	if out := p.mustRun("logs", "web", "-c", "exporter"); !strings.Contains(out, "[web-a/exporter] ") {
+		t.Errorf("got logs %q without the container prefix", out)
+	}
+
+	p.createDemo("empty", demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}})
+	if _, _, err := p.run("logs", "empty"); err == nil || !strings.Contains(err.Error(), "no pods") {
+		t.Errorf("got %v, want an error for a Demo without pods", err)
+	}
+}
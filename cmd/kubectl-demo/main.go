@@ -0,0 +1,234 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-demo는 Demo를 확인하고 조작하는 kubectl plugin입니다.
+// PATH에 두면 `kubectl demo status web`처럼 실행할 수 있습니다.
+//
+// Demo는 api/v1 타입으로 읽고 patch합니다. 변환 webhook이 v2 전용 필드를
+// conversion-data annotation에 보관하므로 v1으로 써도 잃는 필드가 없습니다.
+// operator와 함께 쓰는 코드(controllers, render)는 hub 버전인 v2를 받으므로
+// 읽은 Demo는 프로세스 안에서 v2로 변환해 넘깁니다.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	demoappv1 "demo-operator/api/v1"
+	demoappv2 "demo-operator/api/v2"
+)
+
+// Demo pod에서 nginx가 실행되는 컨테이너 이름
+const nginxContainer = "nginx"
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(demoappv1.AddToScheme(scheme))
+	utilruntime.Must(demoappv2.AddToScheme(scheme))
+}
+
+// command는 kubectl demo의 하위 명령입니다.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	// flags는 공통 flag 외의 flag를 등록하고, run은 parse한 뒤 위치 인자로 실행합니다.
+	flags func(fs *flag.FlagSet) func(ctx context.Context, o *options, args []string) error
+}
+
+var commands = []command{
+	{"status", "status NAME", "Show the conditions, pods and drift of a Demo", statusCommand},
+	{"scale", "scale NAME --replicas=N", "Set spec.scaling.replicas", scaleCommand},
+	{"pause", "pause NAME", "Stop the operator from changing the resources of a Demo", pauseCommand},
+	{"resume", "resume NAME", "Let the operator reconcile a paused Demo again", resumeCommand},
+	{"restart", "restart NAME", "Restart the pods of a Demo one by one", restartCommand},
+	{"rollback", "rollback NAME [--to-revision=N]", "Restore the nginx image of a previous rollout; other spec fields are kept", rollbackCommand},
+	{"logs", "logs NAME [-c CONTAINER] [-f]", "Print the logs of all the pods of a Demo", logsCommand},
+}
+
+// options는 모든 하위 명령이 함께 쓰는 flag와 client입니다.
+type options struct {
+	kubeconfig  string
+	kubeContext string
+	namespace   string
+
+	// client와 clientset이 nil이면 kubeconfig로 만듭니다. 테스트에서는 미리 채웁니다.
+	client    client.Client
+	clientset kubernetes.Interface
+
+	out    io.Writer
+	errOut io.Writer
+}
+
+func (o *options) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	fs.StringVar(&o.kubeContext, "context", "", "The kubeconfig context to use.")
+	fs.StringVar(&o.namespace, "namespace", o.namespace, "The namespace of the Demo. Defaults to the namespace of the context.")
+	fs.StringVar(&o.namespace, "n", o.namespace, "Shorthand for --namespace.")
+}
+
+// complete는 kubeconfig로 client를 만들고, namespace가 없으면 context의 namespace를 사용합니다.
+func (o *options) complete() error {
+	if o.client != nil {
+		if o.namespace == "" {
+			o.namespace = "default"
+		}
+		return nil
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: o.kubeContext})
+	cfg, err := kubeConfig.ClientConfig()
+	if err != nil {
+		return err
+	}
+	// v1 Demo를 쓸 때마다 API 서버가 보내는 deprecation 경고는 사용자가 고칠 수 없으므로 출력하지 않습니다.
+	cfg.WarningHandler = rest.NoWarnings{}
+	if o.namespace == "" {
+		if o.namespace, _, err = kubeConfig.Namespace(); err != nil {
+			return err
+		}
+	}
+	if o.client, err = client.New(cfg, client.Options{Scheme: scheme}); err != nil {
+		return err
+	}
+	o.clientset, err = kubernetes.NewForConfig(cfg)
+	return err
+}
+
+// run은 하위 명령을 찾아 실행합니다.
+func (o *options) run(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		o.usage()
+		return nil
+	}
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		fs := flag.NewFlagSet("kubectl demo "+c.name, flag.ContinueOnError)
+		fs.SetOutput(o.errOut)
+		o.bindFlags(fs)
+		run := c.flags(fs)
+		fs.Usage = func() {
+			fmt.Fprintf(o.errOut, "%s\n\nUsage: kubectl demo %s\n\nFlags:\n", c.summary, c.usage)
+			fs.PrintDefaults()
+		}
+		positional, err := parseInterspersed(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			fs.Usage()
+			return errors.New("exactly one Demo name is required")
+		}
+		if err := o.complete(); err != nil {
+			return err
+		}
+		return run(ctx, o, positional)
+	}
+	o.usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func (o *options) usage() {
+	fmt.Fprintln(o.errOut, "kubectl demo inspects and operates Demos.\n\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(o.errOut, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(o.errOut, "\nUse \"kubectl demo COMMAND -h\" for the flags of a command.")
+}
+
+// parseInterspersed는 `status web -n prod`처럼 위치 인자 뒤에 오는 flag도 읽습니다.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// demoKey는 options의 namespace에 있는 Demo의 key입니다.
+func (o *options) demoKey(name string) client.ObjectKey {
+	return client.ObjectKey{Namespace: o.namespace, Name: name}
+}
+
+// getDemo는 v1 Demo를 읽어 v2로 변환합니다. 없으면 kubectl처럼 알아보기 쉬운 에러를 돌려줍니다.
+func (o *options) getDemo(ctx context.Context, name string) (*demoappv2.Demo, error) {
+	v1 := &demoappv1.Demo{}
+	if err := o.client.Get(ctx, o.demoKey(name), v1); err != nil {
+		return nil, fmt.Errorf("getting Demo %s/%s: %w", o.namespace, name, err)
+	}
+	d := &demoappv2.Demo{}
+	if err := v1.ConvertTo(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// patchDemo는 mutate로 바꾼 부분만 v1 Demo의 merge patch로 보내고, 결과를 d에 다시 채웁니다.
+func (o *options) patchDemo(ctx context.Context, d *demoappv2.Demo, mutate func(d *demoappv2.Demo)) error {
+	original := &demoappv1.Demo{}
+	if err := original.ConvertFrom(d); err != nil {
+		return err
+	}
+	mutate(d)
+	modified := &demoappv1.Demo{}
+	if err := modified.ConvertFrom(d); err != nil {
+		return err
+	}
+	if err := o.client.Patch(ctx, modified, client.MergeFrom(original)); err != nil {
+		return err
+	}
+	return modified.ConvertTo(d)
+}
+
+func main() {
+	o := &options{out: os.Stdout, errOut: os.Stderr}
+	if err := o.run(context.Background(), os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "error:", strings.TrimSpace(err.Error()))
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/yaml"
+
+	demoappv2 "demo-operator/api/v2"
+)
+
+// 모든 하위 명령은 envtest API 서버에 대해 실행합니다. operator는 실행하지 않으므로
+// operator가 만들 객체는 테스트가 직접 만듭니다. plugin은 v1 Demo를 읽으므로
+// 변환 webhook은 테스트 안에서 실행합니다.
+var k8sClient client.Client
+
+func TestMain(m *testing.M) {
+	crd, err := demoCRD()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	testEnv := &envtest.Environment{
+		CRDInstallOptions:     envtest.CRDInstallOptions{CRDs: []apiextensionsv1.CustomResourceDefinition{*crd}, Scheme: scheme},
+		WebhookInstallOptions: envtest.WebhookInstallOptions{},
+		Scheme:                scheme,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "starting envtest:", err)
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := startConversionWebhook(ctx, testEnv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	cancel()
+	_ = testEnv.Stop()
+	os.Exit(code)
+}
+
+// demoCRD는 config/crd/bases의 Demo CRD에 webhook 변환을 켭니다.
+// envtest는 webhook 변환이 설정된 CRD의 주소만 테스트 webhook 서버로 바꿉니다.
+func demoCRD() (*apiextensionsv1.CustomResourceDefinition, error) {
+	content, err := ioutil.ReadFile(filepath.Join("..", "..", "config", "crd", "bases", "demoapp.my.domain_demoes.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := yaml.Unmarshal(content, crd); err != nil {
+		return nil, err
+	}
+	crd.Spec.Conversion = &apiextensionsv1.CustomResourceConversion{
+		Strategy: apiextensionsv1.WebhookConverter,
+		Webhook: &apiextensionsv1.WebhookConversion{
+			ClientConfig:             &apiextensionsv1.WebhookClientConfig{},
+			ConversionReviewVersions: []string{"v1"},
+		},
+	}
+	return crd, nil
+}
+
+// startConversionWebhook은 operator와 같은 /convert webhook을 envtest가 정한 주소에서 실행합니다.
+func startConversionWebhook(ctx context.Context, testEnv *envtest.Environment) error {
+	webhookOptions := &testEnv.WebhookInstallOptions
+	mgr, err := ctrl.NewManager(testEnv.Config, ctrl.Options{
+		Scheme:             scheme,
+		Host:               webhookOptions.LocalServingHost,
+		Port:               webhookOptions.LocalServingPort,
+		CertDir:            webhookOptions.LocalServingCertDir,
+		MetricsBindAddress: "0",
+	})
+	if err != nil {
+		return err
+	}
+	if err := (&demoappv2.Demo{}).SetupWebhookWithManager(mgr); err != nil {
+		return err
+	}
+	go func() {
+		if err := mgr.Start(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "conversion webhook:", err)
+		}
+	}()
+
+	started := mgr.GetWebhookServer().StartedChecker()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		err := started(nil)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// pluginTest는 새 namespace에서 kubectl demo를 실행합니다.
+type pluginTest struct {
+	t         *testing.T
+	ctx       context.Context
+	namespace string
+	// logs가 사용하는 clientset. envtest에는 kubelet이 없어 로그를 돌려주지 못하므로 fake를 사용합니다.
+	clientset *fake.Clientset
+}
+
+func newPluginTest(t *testing.T) *pluginTest {
+	t.Helper()
+	ctx := context.Background()
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "kubectl-demo-"}}
+	if err := k8sClient.Create(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+	return &pluginTest{t: t, ctx: ctx, namespace: ns.Name, clientset: fake.NewSimpleClientset()}
+}
+
+// run은 `kubectl demo args... -n namespace`를 실행하고 stdout과 stderr를 돌려줍니다.
+func (p *pluginTest) run(args ...string) (string, string, error) {
+	var out, errOut bytes.Buffer
+	o := &options{client: k8sClient, clientset: p.clientset, out: &out, errOut: &errOut}
+	err := o.run(p.ctx, append(args, "-n", p.namespace))
+	return out.String(), errOut.String(), err
+}
+
+// mustRun은 실패하면 테스트를 멈춥니다.
+func (p *pluginTest) mustRun(args ...string) string {
+	p.t.Helper()
+	out, errOut, err := p.run(args...)
+	if err != nil {
+		p.t.Fatalf("kubectl demo %s: %v\n%s", strings.Join(args, " "), err, errOut)
+	}
+	return out
+}
+
+func (p *pluginTest) create(obj client.Object) {
+	p.t.Helper()
+	obj.SetNamespace(p.namespace)
+	if err := k8sClient.Create(p.ctx, obj); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+func (p *pluginTest) createDemo(name string, spec demoappv2.DemoSpec) *demoappv2.Demo {
+	p.t.Helper()
+	d := &demoappv2.Demo{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: spec}
+	p.create(d)
+	return d
+}
+
+func (p *pluginTest) getDemo(name string) *demoappv2.Demo {
+	p.t.Helper()
+	d := &demoappv2.Demo{}
+	if err := k8sClient.Get(p.ctx, client.ObjectKey{Namespace: p.namespace, Name: name}, d); err != nil {
+		p.t.Fatal(err)
+	}
+	return d
+}
+
+func TestParseInterspersed(t *testing.T) {
+	o := &options{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.bindFlags(fs)
+	args, err := parseInterspersed(fs, []string{"web", "-n", "prod", "--", "-x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(args, " ") != "web -x" || o.namespace != "prod" {
+		t.Errorf("got args %q and namespace %q, want [web -x] and prod", args, o.namespace)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	p := newPluginTest(t)
+	if _, _, err := p.run("explode", "web"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("got %v, want an unknown command error", err)
+	}
+	if _, _, err := p.run("status"); err == nil {
+		t.Error("got no error without a Demo name")
+	}
+	if _, _, err := p.run("status", "missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got %v, want a not found error", err)
+	}
+}
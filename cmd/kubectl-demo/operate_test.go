@@ -0,0 +1,95 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+)
+
+func TestScale(t *testing.T) {
+	p := newPluginTest(t)
+	p.createDemo("web", demoappv2.DemoSpec{Image: "nginx:1.21", Scaling: demoappv2.ScalingSpec{Replicas: 1}})
+
+	out := p.mustRun("scale", "web", "--replicas=3")
+	if !strings.Contains(out, "demo/web scaled to 3") {
+		t.Errorf("got output %q", out)
+	}
+	// spec.image는 v1에 없으므로 v1 Demo로 patch해도 남는지 확인합니다.
+	d := p.getDemo("web")
+	if d.Spec.Scaling.Replicas != 3 || d.Spec.Image != "nginx:1.21" {
+		t.Errorf("got replicas %d and image %q, want 3 and the image unchanged", d.Spec.Scaling.Replicas, d.Spec.Image)
+	}
+
+	p.mustRun("scale", "web", "--replicas", "0")
+	if got := p.getDemo("web").Spec.Scaling.Replicas; got != 0 {
+		t.Errorf("got replicas %d after scaling to zero", got)
+	}
+	for _, args := range [][]string{{"scale", "web"}, {"scale", "web", "--replicas=-1"}, {"scale", "missing", "--replicas=1"}} {
+		if _, _, err := p.run(args...); err == nil {
+			t.Errorf("kubectl demo %s: got no error", strings.Join(args, " "))
+		}
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	p := newPluginTest(t)
+	p.createDemo("web", demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}})
+
+	if out := p.mustRun("pause", "web"); !strings.Contains(out, "demo/web paused") {
+		t.Errorf("got output %q", out)
+	}
+	if got := p.getDemo("web").Annotations[demoappv2.PausedAnnotation]; got != "true" {
+		t.Errorf("got paused annotation %q, want true", got)
+	}
+	if out := p.mustRun("pause", "web"); !strings.Contains(out, "already paused") {
+		t.Errorf("got output %q for a paused Demo", out)
+	}
+
+	if out := p.mustRun("resume", "web"); !strings.Contains(out, "demo/web resumed") {
+		t.Errorf("got output %q", out)
+	}
+	if _, ok := p.getDemo("web").Annotations[demoappv2.PausedAnnotation]; ok {
+		t.Error("paused annotation is still set after resume")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	p := newPluginTest(t)
+	p.createDemo("web", demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}})
+
+	before := time.Now().Add(-time.Second)
+	if out := p.mustRun("restart", "web"); !strings.Contains(out, "demo/web restarted") {
+		t.Errorf("got output %q", out)
+	}
+	restartedAt, err := time.Parse(time.RFC3339, p.getDemo("web").Annotations[demoappv2.RestartedAtAnnotation])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restartedAt.Before(before) {
+		t.Errorf("got restartedAt %s, want the current time", restartedAt)
+	}
+
+	// 멈춘 Demo는 다시 시작할 때 pod가 재시작된다고 알려 줍니다.
+	p.mustRun("pause", "web")
+	if _, errOut, err := p.run("restart", "web"); err != nil || !strings.Contains(errOut, "is paused") {
+		t.Errorf("got error %v and stderr %q, want a warning about the paused Demo", err, errOut)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	demoappv2 "demo-operator/api/v2"
+)
+
+func pauseCommand(*flag.FlagSet) func(ctx context.Context, o *options, args []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		return o.setPaused(ctx, args[0], true)
+	}
+}
+
+func resumeCommand(*flag.FlagSet) func(ctx context.Context, o *options, args []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		return o.setPaused(ctx, args[0], false)
+	}
+}
+
+// setPaused는 paused annotation을 붙이거나 지웁니다.
+func (o *options) setPaused(ctx context.Context, name string, paused bool) error {
+	d, err := o.getDemo(ctx, name)
+	if err != nil {
+		return err
+	}
+	if (d.Annotations[demoappv2.PausedAnnotation] == "true") == paused {
+		fmt.Fprintf(o.out, "demo/%s is already %s\n", d.Name, pausedState(paused))
+		return nil
+	}
+	err = o.patchDemo(ctx, d, func(d *demoappv2.Demo) {
+		if paused {
+			if d.Annotations == nil {
+				d.Annotations = map[string]string{}
+			}
+			d.Annotations[demoappv2.PausedAnnotation] = "true"
+		} else {
+			delete(d.Annotations, demoappv2.PausedAnnotation)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.out, "demo/%s %s\n", d.Name, pausedState(paused))
+	return nil
+}
+
+func pausedState(paused bool) string {
+	if paused {
+		return "paused"
+	}
+	return "resumed"
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+)
+
+func restartCommand(*flag.FlagSet) func(ctx context.Context, o *options, args []string) error {
+	return func(ctx context.Context, o *options, args []string) error {
+		d, err := o.getDemo(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		// kubectl rollout restart처럼 현재 시각을 기록하면 operator가 pod template에 복사해 pod를 다시 만듭니다.
+		err = o.patchDemo(ctx, d, func(d *demoappv2.Demo) {
+			if d.Annotations == nil {
+				d.Annotations = map[string]string{}
+			}
+			d.Annotations[demoappv2.RestartedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(o.out, "demo/%s restarted\n", d.Name)
+		if d.Annotations[demoappv2.PausedAnnotation] == "true" {
+			fmt.Fprintf(o.errOut, "warning: demo/%s is paused, the pods restart when it is resumed\n", d.Name)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+)
+
+// Deployment controller가 ReplicaSet과 Deployment에 붙이는 revision annotation
+const deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// rollout은 workload가 기록한 이전 pod template 하나입니다.
+type rollout struct {
+	revision int64
+	image    string
+}
+
+// rollback은 Demo의 spec.image를 이전 rollout의 nginx 이미지로 되돌립니다.
+// workload의 template을 직접 되돌리면 operator가 다시 spec대로 고치므로 Demo spec을 바꿉니다.
+// ReplicaSet과 ControllerRevision에는 Demo spec이 남지 않으므로 content나 resources 같은
+// 다른 spec 필드는 되돌리지 않습니다.
+func rollbackCommand(fs *flag.FlagSet) func(ctx context.Context, o *options, args []string) error {
+	toRevision := fs.Int64("to-revision", 0, "The revision to roll back to. Defaults to the one before the current revision.")
+	return func(ctx context.Context, o *options, args []string) error {
+		d, err := o.getDemo(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		var rollouts []rollout
+		var current int64
+		if d.Spec.Storage != nil {
+			rollouts, current, err = o.statefulSetRollouts(ctx, d)
+		} else {
+			rollouts, current, err = o.deploymentRollouts(ctx, d)
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := pickRollout(rollouts, current, *toRevision)
+		if err != nil {
+			return err
+		}
+		if target.image == d.Spec.Image {
+			fmt.Fprintf(o.out, "demo/%s already uses image %s\n", d.Name, target.image)
+			return nil
+		}
+		err = o.patchDemo(ctx, d, func(d *demoappv2.Demo) {
+			d.Spec.Image = target.image
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(o.out, "demo/%s rolled back to revision %d (image %s)\n", d.Name, target.revision, target.image)
+		return nil
+	}
+}
+
+// pickRollout은 toRevision을, 0이면 current 바로 전 revision을 고릅니다.
+func pickRollout(rollouts []rollout, current, toRevision int64) (rollout, error) {
+	sort.Slice(rollouts, func(i, j int) bool { return rollouts[i].revision > rollouts[j].revision })
+	for _, r := range rollouts {
+		if toRevision != 0 && r.revision == toRevision {
+			return r, nil
+		}
+		if toRevision == 0 && r.revision < current {
+			return r, nil
+		}
+	}
+	if toRevision != 0 {
+		return rollout{}, fmt.Errorf("revision %d not found", toRevision)
+	}
+	return rollout{}, errors.New("no previous revision to roll back to")
+}
+
+// deploymentRollouts는 Deployment가 소유한 ReplicaSet에서 rollout을 읽습니다.
+func (o *options) deploymentRollouts(ctx context.Context, d *demoappv2.Demo) ([]rollout, int64, error) {
+	workload, err := controllers.GetWorkload(ctx, o.client, d)
+	if err != nil {
+		return nil, 0, err
+	}
+	dep, ok := workload.(*appsv1.Deployment)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s has no Deployment", d.Name)
+	}
+	current, _ := strconv.ParseInt(dep.Annotations[deploymentRevisionAnnotation], 10, 64)
+
+	list := &appsv1.ReplicaSetList{}
+	if err := o.client.List(ctx, list, client.InNamespace(d.Namespace), client.MatchingLabels(controllers.PodSelector(d))); err != nil {
+		return nil, 0, err
+	}
+	var rollouts []rollout
+	for i := range list.Items {
+		rs := &list.Items[i]
+		if !metav1.IsControlledBy(rs, dep) {
+			continue
+		}
+		revision, err := strconv.ParseInt(rs.Annotations[deploymentRevisionAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+		if image := nginxImage(&rs.Spec.Template); image != "" {
+			rollouts = append(rollouts, rollout{revision: revision, image: image})
+		}
+	}
+	return rollouts, current, nil
+}
+
+// statefulSetRollouts는 StatefulSet이 소유한 ControllerRevision에서 rollout을 읽습니다.
+func (o *options) statefulSetRollouts(ctx context.Context, d *demoappv2.Demo) ([]rollout, int64, error) {
+	workload, err := controllers.GetWorkload(ctx, o.client, d)
+	if err != nil {
+		return nil, 0, err
+	}
+	sts, ok := workload.(*appsv1.StatefulSet)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s has no StatefulSet", d.Name)
+	}
+
+	list := &appsv1.ControllerRevisionList{}
+	if err := o.client.List(ctx, list, client.InNamespace(d.Namespace), client.MatchingLabels(controllers.PodSelector(d))); err != nil {
+		return nil, 0, err
+	}
+	var rollouts []rollout
+	var current int64
+	for i := range list.Items {
+		rev := &list.Items[i]
+		if !metav1.IsControlledBy(rev, sts) {
+			continue
+		}
+		if rev.Name == sts.Status.UpdateRevision {
+			current = rev.Revision
+		}
+		// ControllerRevision에는 StatefulSet의 template이 patch 형태로 들어 있습니다.
+		var data struct {
+			Spec struct {
+				Template corev1.PodTemplateSpec `json:"template"`
+			} `json:"spec"`
+		}
+		if err := json.Unmarshal(rev.Data.Raw, &data); err != nil {
+			continue
+		}
+		if image := nginxImage(&data.Spec.Template); image != "" {
+			rollouts = append(rollouts, rollout{revision: rev.Revision, image: image})
+		}
+	}
+	return rollouts, current, nil
+}
+
+func nginxImage(template *corev1.PodTemplateSpec) string {
+	for _, c := range template.Spec.Containers {
+		if c.Name == nginxContainer {
+			return c.Image
+		}
+	}
+	return ""
+}
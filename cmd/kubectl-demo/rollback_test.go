@@ -0,0 +1,171 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+)
+
+func podTemplate(d *demoappv2.Demo, image string) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: controllers.PodSelector(d)},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: nginxContainer, Image: image}}},
+	}
+}
+
+func controllerRef(owner metav1.Object, kind string) []metav1.OwnerReference {
+	return []metav1.OwnerReference{*metav1.NewControllerRef(owner, appsv1.SchemeGroupVersion.WithKind(kind))}
+}
+
+// Deployment controller 대신 revision마다 ReplicaSet을 만듭니다.
+func TestRollbackDeployment(t *testing.T) {
+	p := newPluginTest(t)
+	d := p.createDemo("web", demoappv2.DemoSpec{Image: "nginx:1.22", Scaling: demoappv2.ScalingSpec{Replicas: 1}})
+
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Annotations: map[string]string{deploymentRevisionAnnotation: "3"}},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: controllers.PodSelector(d)},
+			Template: podTemplate(d, "nginx:1.22"),
+		},
+	}
+	p.create(dep)
+	for revision, image := range map[int]string{1: "nginx:1.20", 2: "nginx:1.21", 3: "nginx:1.22"} {
+		p.create(&appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-" + strconv.Itoa(revision),
+				Labels:          controllers.PodSelector(d),
+				Annotations:     map[string]string{deploymentRevisionAnnotation: strconv.Itoa(revision)},
+				OwnerReferences: controllerRef(dep, "Deployment"),
+			},
+			Spec: appsv1.ReplicaSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: controllers.PodSelector(d)},
+				Template: podTemplate(d, image),
+			},
+		})
+	}
+
+	if out := p.mustRun("rollback", "web"); !strings.Contains(out, "rolled back to revision 2 (image nginx:1.21)") {
+		t.Errorf("got output %q", out)
+	}
+	if got := p.getDemo("web").Spec.Image; got != "nginx:1.21" {
+		t.Errorf("got image %q, want nginx:1.21", got)
+	}
+
+	p.mustRun("rollback", "web", "--to-revision=1")
+	if got := p.getDemo("web").Spec.Image; got != "nginx:1.20" {
+		t.Errorf("got image %q, want nginx:1.20", got)
+	}
+	if _, _, err := p.run("rollback", "web", "--to-revision=7"); err == nil {
+		t.Error("got no error for a missing revision")
+	}
+}
+
+// StatefulSet controller 대신 revision마다 ControllerRevision을 만듭니다.
+func TestRollbackStatefulSet(t *testing.T) {
+	p := newPluginTest(t)
+	d := p.createDemo("web", demoappv2.DemoSpec{
+		Image:   "nginx:1.22",
+		Scaling: demoappv2.ScalingSpec{Replicas: 1},
+		Storage: &demoappv2.StorageSpec{Size: resource.MustParse("1Gi")},
+	})
+
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: controllers.PodSelector(d)},
+			Template: podTemplate(d, "nginx:1.22"),
+		},
+	}
+	p.create(sts)
+	for revision, image := range map[int64]string{1: "nginx:1.21", 2: "nginx:1.22"} {
+		template := podTemplate(d, image)
+		data, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"template": template}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.create(&appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-" + strconv.FormatInt(revision, 10),
+				Labels:          controllers.PodSelector(d),
+				OwnerReferences: controllerRef(sts, "StatefulSet"),
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: revision,
+		})
+	}
+	sts.Status.UpdateRevision = "web-2"
+	if err := k8sClient.Status().Update(p.ctx, sts); err != nil {
+		t.Fatal(err)
+	}
+
+	p.mustRun("rollback", "web")
+	if got := p.getDemo("web").Spec.Image; got != "nginx:1.21" {
+		t.Errorf("got image %q, want nginx:1.21", got)
+	}
+	// operator가 아직 새 revision을 배포하지 않았으면 같은 이미지를 다시 쓰지 않습니다.
+	if out := p.mustRun("rollback", "web"); !strings.Contains(out, "already uses image nginx:1.21") {
+		t.Errorf("got output %q", out)
+	}
+
+	// 첫 revision보다 이전 revision은 없습니다.
+	sts.Status.UpdateRevision = "web-1"
+	if err := k8sClient.Status().Update(p.ctx, sts); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.run("rollback", "web"); err == nil || !strings.Contains(err.Error(), "no previous revision") {
+		t.Errorf("got %v, want an error without a previous revision", err)
+	}
+}
+
+func TestPickRollout(t *testing.T) {
+	rollouts := []rollout{{1, "a"}, {3, "c"}, {2, "b"}}
+	tests := []struct {
+		current, to int64
+		want        string
+	}{
+		{current: 3, want: "b"},
+		{current: 2, want: "a"},
+		{current: 3, to: 1, want: "a"},
+		{current: 1},
+		{current: 3, to: 5},
+	}
+	for _, tt := range tests {
+		got, err := pickRollout(rollouts, tt.current, tt.to)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("current %d, to %d: got %v, want an error", tt.current, tt.to, got)
+			}
+			continue
+		}
+		if err != nil || got.image != tt.want {
+			t.Errorf("current %d, to %d: got %v, %v, want %s", tt.current, tt.to, got, err, tt.want)
+		}
+	}
+}
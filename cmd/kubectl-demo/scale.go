@@ -0,0 +1,64 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	demoappv2 "demo-operator/api/v2"
+)
+
+func scaleCommand(fs *flag.FlagSet) func(ctx context.Context, o *options, args []string) error {
+	replicas := int32(-1)
+	fs.Func("replicas", "The number of pods. Required.", func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || n < 0 {
+			return errors.New("must be a non-negative number")
+		}
+		replicas = int32(n)
+		return nil
+	})
+	return func(ctx context.Context, o *options, args []string) error {
+		if replicas < 0 {
+			return errors.New("--replicas is required")
+		}
+		d, err := o.getDemo(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		err = o.patchDemo(ctx, d, func(d *demoappv2.Demo) {
+			d.Spec.Scaling.Replicas = replicas
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(o.out, "demo/%s scaled to %d\n", d.Name, replicas)
+
+		// spec.scaling.replicas보다 우선하는 설정이 있으면 알려 줍니다.
+		if d.Status.ActiveSchedule != "" {
+			fmt.Fprintf(o.errOut, "warning: schedule %s decides the replicas until it ends\n", d.Status.ActiveSchedule)
+		}
+		if d.Status.Activity == demoappv2.ActivityIdle {
+			fmt.Fprintln(o.errOut, "warning: the Demo is idle and scales up on the next request")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/duration"
+
+	configv1alpha1 "demo-operator/api/config/v1alpha1"
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+	"demo-operator/render"
+)
+
+func statusCommand(fs *flag.FlagSet) func(ctx context.Context, o *options, args []string) error {
+	configFile := fs.String("config", "",
+		"The DemoOperatorConfig file of the operator, used to tell drift with its default image and resource profiles.")
+	defaultImage := fs.String("default-image", "", "The --default-image of the operator. Overrides the config file.")
+	return func(ctx context.Context, o *options, args []string) error {
+		operatorConfig, err := configv1alpha1.Load(*configFile)
+		if err != nil {
+			return err
+		}
+		if *defaultImage != "" {
+			operatorConfig.DefaultImage = *defaultImage
+		}
+		d, err := o.getDemo(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		ready, err := o.readyReplicas(ctx, d)
+		if err != nil {
+			return err
+		}
+		drift, driftErr := o.drift(ctx, d, operatorConfig)
+		printStatus(o, d, ready, drift, driftErr, time.Now())
+		return nil
+	}
+}
+
+// readyReplicas는 workload의 준비된 pod 수입니다. workload가 아직 없으면 0입니다.
+func (o *options) readyReplicas(ctx context.Context, d *demoappv2.Demo) (int32, error) {
+	workload, err := controllers.GetWorkload(ctx, o.client, d)
+	if apierrors.IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		return w.Status.ReadyReplicas, nil
+	case *appsv1.StatefulSet:
+		return w.Status.ReadyReplicas, nil
+	}
+	return 0, nil
+}
+
+// drift는 operator가 만들 객체와 클러스터의 객체가 다른 곳을 찾습니다.
+// `manager render`처럼 operator 설정의 기본 이미지와 resource profile로 객체를 만듭니다.
+// schedule이나 idle이 replicas를 정하는 Demo는 replicas를 비교하지 않습니다.
+// 객체 이름은 operator 설정의 nameTemplate으로 만듭니다.
+func (o *options) drift(ctx context.Context, d *demoappv2.Demo, operatorConfig *configv1alpha1.DemoOperatorConfig) ([]render.Change, error) {
+	nameTemplate, err := configv1alpha1.ParseNameTemplate(operatorConfig.NameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	r := &controllers.DemoReconciler{
+		Scheme:                 scheme,
+		DefaultImage:           operatorConfig.DefaultImage,
+		ResourceProfiles:       operatorConfig.ResourceProfiles,
+		DefaultResourceProfile: operatorConfig.DefaultResourceProfile,
+		NameTemplate:           nameTemplate,
+	}
+	rendered, err := render.Objects(r, d)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.Spec.Scaling.Schedules) > 0 || d.Spec.Scaling.Idle != nil {
+		for _, u := range rendered {
+			if u.GetKind() == "Deployment" || u.GetKind() == "StatefulSet" {
+				unstructured.RemoveNestedField(u.Object, "spec", "replicas")
+			}
+		}
+	}
+	return render.Changes(ctx, rendered, &render.LiveSource{Reader: o.client}, []*demoappv2.Demo{d})
+}
+
+func printStatus(o *options, d *demoappv2.Demo, ready int32, drift []render.Change, driftErr error, now time.Time) {
+	w := tabwriter.NewWriter(o.out, 0, 4, 2, ' ', 0)
+	image := d.Spec.Image
+	if image == "" {
+		image = "(operator default)"
+	}
+	fmt.Fprintf(w, "Name:\t%s\n", d.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", d.Namespace)
+	fmt.Fprintf(w, "Image:\t%s\n", image)
+	fmt.Fprintf(w, "Replicas:\t%d desired, %d ready\n", d.Spec.Scaling.Replicas, ready)
+	if d.Status.ActiveSchedule != "" {
+		fmt.Fprintf(w, "Schedule:\t%s\n", d.Status.ActiveSchedule)
+	}
+	if d.Status.NextTransition != nil {
+		fmt.Fprintf(w, "Next transition:\t%s\n", d.Status.NextTransition.Format(time.RFC3339))
+	}
+	if d.Status.Activity != "" {
+		fmt.Fprintf(w, "Activity:\t%s\n", d.Status.Activity)
+	}
+	if d.Annotations[demoappv2.PausedAnnotation] == "true" {
+		fmt.Fprintf(w, "Paused:\ttrue\n")
+	}
+	if restartedAt := d.Annotations[demoappv2.RestartedAtAnnotation]; restartedAt != "" {
+		fmt.Fprintf(w, "Restarted at:\t%s\n", restartedAt)
+	}
+	if r := d.Status.LastRestart; r != nil {
+		fmt.Fprintf(w, "Last restart:\t%s (%s) %s\n", r.Time.UTC().Format(time.RFC3339), r.Cause, r.Message)
+	}
+	w.Flush()
+
+	fmt.Fprintln(o.out, "\nConditions:")
+	if len(d.Status.Conditions) == 0 {
+		fmt.Fprintln(o.out, "  <none>")
+	} else {
+		w = tabwriter.NewWriter(o.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tAGE\tMESSAGE")
+		for _, c := range d.Status.Conditions {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", c.Type, c.Status, c.Reason,
+				duration.HumanDuration(now.Sub(c.LastTransitionTime.Time)), c.Message)
+		}
+		w.Flush()
+	}
+
+	fmt.Fprintln(o.out, "\nPods:")
+	if len(d.Status.Pods) == 0 {
+		fmt.Fprintln(o.out, "  <none>")
+	} else {
+		w = tabwriter.NewWriter(o.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  NAME\tNODE\tPHASE\tREADY")
+		for _, p := range d.Status.Pods {
+			node := p.NodeName
+			if node == "" {
+				node = "<none>"
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%t\n", p.Name, node, p.Phase, p.Ready)
+		}
+		w.Flush()
+	}
+
+	fmt.Fprintln(o.out, "\nDrift:")
+	switch {
+	case driftErr != nil:
+		fmt.Fprintf(o.out, "  unknown: %v\n", driftErr)
+	case len(drift) == 0:
+		fmt.Fprintln(o.out, "  <none>")
+	default:
+		for _, c := range drift {
+			fmt.Fprintf(o.out, "  %s\n", c)
+		}
+		fmt.Fprintln(o.out, "  Run `manager render --diff live` with the Demo manifest for the full diff.")
+	}
+}
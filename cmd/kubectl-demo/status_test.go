@@ -0,0 +1,129 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	configv1alpha1 "demo-operator/api/config/v1alpha1"
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+	"demo-operator/render"
+)
+
+func TestStatus(t *testing.T) {
+	p := newPluginTest(t)
+	d := p.createDemo("web", demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2}})
+	d.Status = demoappv2.DemoStatus{
+		Pods: []demoappv2.PodSummary{
+			{Name: "web-0", NodeName: "node-a", Phase: corev1.PodRunning, Ready: true},
+			{Name: "web-1", Phase: corev1.PodPending},
+		},
+		Conditions: []metav1.Condition{{
+			Type: demoappv2.ConditionResourcesOwned, Status: metav1.ConditionTrue, Reason: "Owned",
+			Message: "All resources are controlled by the Demo", LastTransitionTime: metav1.Now(),
+		}},
+	}
+	if err := k8sClient.Status().Update(p.ctx, d); err != nil {
+		t.Fatal(err)
+	}
+
+	// operator 대신 operator가 만들 객체를 만듭니다.
+	r := &controllers.DemoReconciler{Scheme: scheme, DefaultImage: configv1alpha1.DefaultImage}
+	objs, err := render.Objects(r, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		if err := k8sClient.Create(p.ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := p.mustRun("status", "web")
+	for _, want := range []string{
+		"Name:       web",
+		"Replicas:   2 desired, 0 ready",
+		"ResourcesOwned  True    Owned",
+		"web-0  node-a  Running  true",
+		"web-1  <none>  Pending  false",
+		"Drift:\n  <none>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status has no %q:\n%s", want, out)
+		}
+	}
+
+	// Deployment를 직접 고치면 drift로 보입니다.
+	dep := &appsv1.Deployment{}
+	if err := k8sClient.Get(p.ctx, client.ObjectKey{Namespace: p.namespace, Name: "web"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	replicas := int32(5)
+	dep.Spec.Replicas = &replicas
+	if err := k8sClient.Update(p.ctx, dep); err != nil {
+		t.Fatal(err)
+	}
+	out = p.mustRun("status", "web")
+	if !strings.Contains(out, "Drift:\n  Deployment/"+p.namespace+"/web\n") {
+		t.Errorf("status does not report the scaled Deployment as drift:\n%s", out)
+	}
+}
+
+// status는 --config의 resource profile로 operator가 만들 객체를 만들어야 합니다.
+// profile 없이 만들면 resources가 빠져 drift가 아닌 Deployment도 drift로 보입니다.
+func TestStatusUsesOperatorConfig(t *testing.T) {
+	p := newPluginTest(t)
+	d := p.createDemo("web", demoappv2.DemoSpec{
+		Scaling: demoappv2.ScalingSpec{Replicas: 1},
+		Pod:     demoappv2.PodSpec{ResourceProfile: "small"},
+	})
+
+	configFile := filepath.Join("..", "..", "config", "manager", "controller_manager_config.yaml")
+	operatorConfig, err := configv1alpha1.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &controllers.DemoReconciler{
+		Scheme:           scheme,
+		DefaultImage:     operatorConfig.DefaultImage,
+		ResourceProfiles: operatorConfig.ResourceProfiles,
+	}
+	objs, err := render.Objects(r, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		if err := k8sClient.Create(p.ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out := p.mustRun("status", "web", "--config="+configFile); !strings.Contains(out, "Drift:\n  <none>") {
+		t.Errorf("status with the operator config reports drift:\n%s", out)
+	}
+	if out := p.mustRun("status", "web"); !strings.Contains(out, "Drift:\n  Deployment/"+p.namespace+"/web\n") {
+		t.Errorf("status without the resource profiles does not report drift:\n%s", out)
+	}
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// conflict가 해결됐는지 다시 확인하는 주기.
+// 다른 controller의 리소스는 Owns로 감시되지 않으므로 주기적으로 확인합니다.
+const conflictRequeueInterval = time.Minute
+
+// 이벤트 reason
+const (
+	reasonAdopted          = "Adopted"
+	reasonAdoptionConflict = "AdoptionConflict"
+)
+
+// adoption 정책 (기본값 Never)
+func adoptionPolicy(d *demoappv2.Demo) demoappv2.AdoptionPolicy {
+	if d.Spec.Adoption == "" {
+		return demoappv2.AdoptNever
+	}
+	return d.Spec.Adoption
+}
+
+// claim은 클러스터에 이미 있는 obj를 이 Demo가 수정해도 되는지 확인합니다.
+// Demo가 controller이면 true를 반환합니다. controller가 없으면 adoption 정책에 따라
+// owner reference를 붙여 가져오고 이벤트를 남깁니다. 가져올 수 없으면 conflict로 기록하고 false를 반환합니다.
+func (r *DemoReconciler) claim(ctx context.Context, s *reconcileState, obj client.Object) (bool, error) {
+	cr := s.cr
+	if metav1.IsControlledBy(obj, cr) {
+		return true, nil
+	}
+
+	kind := objectKind(obj, r)
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		s.conflict(fmt.Sprintf("%s %s is controlled by %s %s", kind, obj.GetName(), owner.Kind, owner.Name))
+		return false, nil
+	}
+
+	switch adoptionPolicy(cr) {
+	case demoappv2.AdoptAlways:
+	case demoappv2.AdoptIfLabeled:
+		if obj.GetLabels()[demoappv2.AdoptLabel] != cr.Name {
+			s.conflict(fmt.Sprintf("%s %s exists without the %s=%s label", kind, obj.GetName(), demoappv2.AdoptLabel, cr.Name))
+			return false, nil
+		}
+	default:
+		s.conflict(fmt.Sprintf("%s %s exists and spec.adoption is Never", kind, obj.GetName()))
+		return false, nil
+	}
+
+	if err := ctrl.SetControllerReference(cr, obj, r.Scheme); err != nil {
+		return false, err
+	}
+	if err := r.Client.Update(ctx, obj); err != nil {
+		return false, err
+	}
+	log.FromContext(ctx).Info("Adopted existing resource", objectKeys(kind, obj.GetNamespace(), obj.GetName())...)
+	r.event(cr, corev1.EventTypeNormal, reasonAdopted, fmt.Sprintf("Adopted existing %s %s", kind, obj.GetName()))
+	return true, nil
+}
+
+// 로그와 이벤트에 쓸 kind 이름
+func objectKind(obj client.Object, r *DemoReconciler) string {
+	if r.Scheme != nil {
+		if gvk, err := apiutil.GVKForObject(obj, r.Scheme); err == nil {
+			return gvk.Kind
+		}
+	}
+	return fmt.Sprintf("%T", obj)
+}
+
+// conflict를 기록합니다. status의 ResourcesOwned condition에 반영됩니다.
+func (s *reconcileState) conflict(msg string) {
+	s.conflicts = append(s.conflicts, msg)
+}
+
+// ResourcesOwned condition을 conflict에 맞춰 설정합니다. 변경된 경우 true를 반환합니다.
+func (s *reconcileState) setOwnedCondition() bool {
+	cond := metav1.Condition{
+		Type:    demoappv2.ConditionResourcesOwned,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Owned",
+		Message: "All resources are controlled by the Demo",
+	}
+	if len(s.conflicts) > 0 {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = "Conflict"
+		cond.Message = strings.Join(s.conflicts, "; ")
+	}
+	return s.setCondition(cond)
+}
+
+// status condition을 설정합니다. 변경된 경우 true를 반환하고 status를 쓰도록 기록합니다.
+func (s *reconcileState) setCondition(cond metav1.Condition) bool {
+	cond.ObservedGeneration = s.cr.Generation
+	if existing := meta.FindStatusCondition(s.cr.Status.Conditions, cond.Type); existing != nil &&
+		existing.Status == cond.Status && existing.Reason == cond.Reason &&
+		existing.Message == cond.Message && existing.ObservedGeneration == cond.ObservedGeneration {
+		return false
+	}
+	meta.SetStatusCondition(&s.cr.Status.Conditions, cond)
+	s.conditionsChanged = true
+	return true
+}
+
+// Recorder가 없으면 (단위 테스트) 이벤트를 남기지 않습니다.
+func (r *DemoReconciler) event(obj client.Object, eventType, reason, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(obj, eventType, reason, message)
+	}
+}
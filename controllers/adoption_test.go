@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+)
+
+func TestAdoption(t *testing.T) {
+	otherOwner := metav1.OwnerReference{
+		APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "other", UID: "other-uid",
+		Controller: pointer.Bool(true),
+	}
+
+	tests := []struct {
+		name    string
+		policy  demoappv2.AdoptionPolicy
+		labels  map[string]string
+		owners  []metav1.OwnerReference
+		adopted bool
+	}{
+		{name: "never", policy: demoappv2.AdoptNever},
+		{name: "default is never", policy: ""},
+		{name: "if labeled without label", policy: demoappv2.AdoptIfLabeled},
+		{name: "if labeled with other demo", policy: demoappv2.AdoptIfLabeled, labels: map[string]string{demoappv2.AdoptLabel: "api"}},
+		{name: "if labeled", policy: demoappv2.AdoptIfLabeled, labels: map[string]string{demoappv2.AdoptLabel: "web"}, adopted: true},
+		{name: "always", policy: demoappv2.AdoptAlways, adopted: true},
+		{name: "always but controlled by other", policy: demoappv2.AdoptAlways, owners: []metav1.OwnerReference{otherOwner}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &demoappv2.Demo{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+				Spec: demoappv2.DemoSpec{
+					Adoption: tt.policy,
+					Scaling:  demoappv2.ScalingSpec{Replicas: 3},
+				},
+			}
+			existing := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "web", Namespace: "default", Labels: tt.labels, OwnerReferences: tt.owners,
+				},
+				Spec: appsv1.DeploymentSpec{Replicas: pointer.Int32(1)},
+			}
+			r, c := newPipelineTest(t, cr, existing)
+			recorder := record.NewFakeRecorder(10)
+			r.Recorder = recorder
+			ctx := context.Background()
+
+			result, err := r.Reconcile(ctx, reconcileRequest("web"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dep := &appsv1.Deployment{}
+			if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, dep); err != nil {
+				t.Fatal(err)
+			}
+			got := &demoappv2.Demo{}
+			if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, got); err != nil {
+				t.Fatal(err)
+			}
+			cond := meta.FindStatusCondition(got.Status.Conditions, demoappv2.ConditionResourcesOwned)
+			if cond == nil {
+				t.Fatal("ResourcesOwned condition not set")
+			}
+			events := drain(recorder)
+
+			if tt.adopted {
+				if !metav1.IsControlledBy(dep, got) || *dep.Spec.Replicas != 3 {
+					t.Errorf("Deployment not adopted: owners %v, replicas %d", dep.OwnerReferences, *dep.Spec.Replicas)
+				}
+				if cond.Status != metav1.ConditionTrue {
+					t.Errorf("got condition %+v, want ResourcesOwned true", cond)
+				}
+				if !strings.Contains(events, reasonAdopted) {
+					t.Errorf("got events %q, want an Adopted event", events)
+				}
+				return
+			}
+
+			// 소유하지 않은 Deployment는 수정하지 않습니다.
+			if metav1.IsControlledBy(dep, got) || *dep.Spec.Replicas != 1 {
+				t.Errorf("Deployment modified: owners %v, replicas %d", dep.OwnerReferences, *dep.Spec.Replicas)
+			}
+			if cond.Status != metav1.ConditionFalse || !strings.Contains(cond.Message, "Deployment web") {
+				t.Errorf("got condition %+v, want a conflict on Deployment web", cond)
+			}
+			if !strings.Contains(events, reasonAdoptionConflict) {
+				t.Errorf("got events %q, want an AdoptionConflict event", events)
+			}
+			if result.RequeueAfter == 0 || result.RequeueAfter > conflictRequeueInterval {
+				t.Errorf("got requeue after %s, want the conflict to be checked again", result.RequeueAfter)
+			}
+
+			// 같은 conflict로 이벤트를 반복해서 남기지 않습니다.
+			if _, err := r.Reconcile(ctx, reconcileRequest("web")); err != nil {
+				t.Fatal(err)
+			}
+			if events := drain(recorder); events != "" {
+				t.Errorf("got repeated events %q", events)
+			}
+		})
+	}
+}
+
+func drain(recorder *record.FakeRecorder) string {
+	var events []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			return strings.Join(events, "\n")
+		}
+	}
+}
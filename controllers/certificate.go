@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// operator가 만드는 인증서의 유효 기간.
+// 남은 기간이 1/3보다 짧아지면 새로 발급합니다.
+const (
+	caValidity      = 5 * 365 * 24 * time.Hour
+	servingValidity = 90 * 24 * time.Hour
+
+	// 노드 사이의 시각 차이를 고려해 발급 시각보다 조금 앞에서부터 유효하게 합니다.
+	certificateBackdate = 5 * time.Minute
+)
+
+// PEM으로 인코딩한 인증서와 개인 키
+type keyPair struct {
+	cert, key []byte
+}
+
+// 새 self-signed CA를 발급합니다.
+func newCA(commonName string, now time.Time) (keyPair, error) {
+	return issueCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, now, caValidity, nil)
+}
+
+// ca로 dnsNames의 서버 인증서를 발급합니다.
+func newServingCertificate(ca keyPair, dnsNames []string, now time.Time) (keyPair, error) {
+	return issueCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:    dnsNames,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, now, servingValidity, &ca)
+}
+
+// template으로 인증서를 발급합니다. issuer가 nil이면 self-signed 입니다.
+func issueCertificate(template *x509.Certificate, now time.Time, validity time.Duration, issuer *keyPair) (keyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return keyPair{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return keyPair{}, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = now.Add(-certificateBackdate)
+	template.NotAfter = now.Add(validity)
+
+	parent, signer := template, interface{}(key)
+	if issuer != nil {
+		if parent, err = parseCertificate(issuer.cert); err != nil {
+			return keyPair{}, fmt.Errorf("parsing CA certificate: %w", err)
+		}
+		if signer, err = parsePrivateKey(issuer.key); err != nil {
+			return keyPair{}, fmt.Errorf("parsing CA key: %w", err)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return keyPair{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return keyPair{}, err
+	}
+	return keyPair{
+		cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// PEM의 첫 번째 인증서를 읽습니다.
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// operator가 만든 EC 개인 키를 읽습니다.
+func parsePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, errors.New("no PEM EC private key found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// 인증서를 새로 발급해야 하는 시각 (유효 기간의 2/3가 지난 때)
+func renewalTime(cert *x509.Certificate) time.Time {
+	return cert.NotAfter.Add(-cert.NotAfter.Sub(cert.NotBefore) / 3)
+}
+
+// 서버 인증서가 ca로 서명되었고 dnsNames를 모두 가지고 있는지 확인합니다.
+func certificateMatches(cert, ca *x509.Certificate, dnsNames []string) bool {
+	if cert.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	got := append([]string{}, cert.DNSNames...)
+	want := append([]string{}, dnsNames...)
+	sort.Strings(got)
+	sort.Strings(want)
+	return reflect.DeepEqual(got, want)
+}
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	contentVolume        = "content"
+	contentSourceVolume  = "content-source"
+	contentInitContainer = "content-init"
+	contentMountPath     = "/usr/share/nginx/html"
+	contentSourcePath    = "/content-source"
+
+	// pod template에 기록하는 content revision. 값이 바뀌면 pod가 다시 배포됩니다.
+	contentRevisionAnnotation = "demoapp.my.domain/content-revision"
+
+	contentCopyImage = "busybox:1.35"
+	contentGitImage  = "alpine/git:2.36.3"
+)
+
+// ConfigMap 볼륨의 파일을 복사하는 스크립트. ..data 같은 숨김 항목은 복사하지 않습니다.
+const copyContentScript = `set -e
+for f in "$SOURCE_DIR"/*; do
+  [ -e "$f" ] || continue
+  cp -L "$f" "$CONTENT_DIR"/
+done
+`
+
+// Git 저장소를 clone 해서 revision을 checkout 한 뒤 .git을 제외하고 복사하는 스크립트
+const gitContentScript = `set -e
+repo=$(mktemp -d)
+git clone --quiet "$GIT_REPOSITORY" "$repo"
+cd "$repo"
+if [ -n "$GIT_REVISION" ]; then
+  git -c advice.detachedHead=false checkout --quiet "$GIT_REVISION"
+fi
+echo "serving $(git rev-parse HEAD)"
+rm -rf .git
+cp -R . "$CONTENT_DIR"/
+`
+
+// spec.content에 source가 하나만 있는지, storage 볼륨과 경로가 겹치지 않는지 확인합니다.
+func validateContent(d *demoappv2.Demo) error {
+	c := d.Spec.Content
+	if c == nil {
+		return nil
+	}
+
+	sources := 0
+	if len(c.Inline) > 0 {
+		sources++
+	}
+	if c.ConfigMap != nil {
+		sources++
+	}
+	if c.Git != nil {
+		sources++
+	}
+	if sources != 1 {
+		return fmt.Errorf("spec.content must set exactly one of inline, configMap or git")
+	}
+
+	if s := d.Spec.Storage; s != nil && (s.MountPath == "" || s.MountPath == contentMountPath) {
+		return fmt.Errorf("spec.storage.mountPath must not be %s when spec.content is set", contentMountPath)
+	}
+	return nil
+}
+
+// inline content를 담는 ConfigMap 이름
+func (r *DemoReconciler) contentConfigMapName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "-content", maxNameLength)
+}
+
+// inline content를 ConfigMap으로 생성하고 컨트롤러에 등록합니다.
+func (r *DemoReconciler) createContentConfigMap(d *demoappv2.Demo) (*corev1.ConfigMap, error) {
+
+	newCm := &corev1.ConfigMap{
+		ObjectMeta: objectMeta(d, r.contentConfigMapName(d), componentContent),
+		Data:       d.Spec.Content.Inline,
+	}
+
+	if err := ctrl.SetControllerReference(d, newCm, r.Scheme); err != nil {
+		return nil, err
+	}
+	return newCm, nil
+}
+
+// 현재 spec.content의 revision을 계산합니다.
+// inline과 ConfigMap은 내용의 해시를, git은 revision이 가리키는 commit SHA를 사용합니다.
+func (r *DemoReconciler) contentRevision(ctx context.Context, d *demoappv2.Demo) (string, error) {
+	if rev, ok := specContentRevision(d); ok {
+		return rev, nil
+	}
+	c := d.Spec.Content
+	if c.Git != nil {
+		return r.gitResolver().ResolveRevision(ctx, c.Git.Repository, c.Git.Revision)
+	}
+	cm := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: c.ConfigMap.Name, Namespace: d.Namespace}, cm)
+	if err != nil {
+		return "", err
+	}
+	return hashObject([]interface{}{cm.Data, cm.BinaryData}), nil
+}
+
+// spec만으로 정해지는 content revision입니다. spec.content.configMap은 ConfigMap을 읽어야 하고,
+// commit SHA가 아닌 git revision은 저장소에 물어야 하므로 false를 반환합니다.
+func specContentRevision(d *demoappv2.Demo) (string, bool) {
+	c := d.Spec.Content
+	switch {
+	case c == nil:
+		return "", true
+	case len(c.Inline) > 0:
+		return hashObject(c.Inline), true
+	case c.ConfigMap != nil:
+		return "", false
+	case c.Git != nil:
+		if commitSHA.MatchString(c.Git.Revision) {
+			return c.Git.Revision, true
+		}
+		return "", false
+	}
+	return "", true
+}
+
+// pod template에 content 볼륨과 이를 채우는 init container를 추가합니다.
+func (r *DemoReconciler) addContent(template *corev1.PodTemplateSpec, d *demoappv2.Demo, revision string) {
+	c := d.Spec.Content
+	if c == nil {
+		return
+	}
+
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         contentVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      contentVolume,
+		MountPath: contentMountPath,
+		ReadOnly:  true,
+	})
+
+	init := corev1.Container{
+		Name: contentInitContainer,
+		Env:  []corev1.EnvVar{{Name: "CONTENT_DIR", Value: contentMountPath}},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: contentVolume, MountPath: contentMountPath},
+		},
+	}
+
+	if c.Git != nil {
+		init.Image = contentGitImage
+		init.Command = []string{"sh", "-c", gitContentScript}
+		init.Env = append(init.Env,
+			corev1.EnvVar{Name: "GIT_REPOSITORY", Value: c.Git.Repository},
+			corev1.EnvVar{Name: "GIT_REVISION", Value: gitCheckoutRevision(c.Git, revision)},
+		)
+	} else {
+		// inline은 operator가 만든 ConfigMap을, configMap은 사용자의 ConfigMap을 복사합니다.
+		name := r.contentConfigMapName(d)
+		if c.ConfigMap != nil {
+			name = c.ConfigMap.Name
+		}
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: contentSourceVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				},
+			},
+		})
+		init.Image = contentCopyImage
+		init.Command = []string{"sh", "-c", copyContentScript}
+		init.Env = append(init.Env, corev1.EnvVar{Name: "SOURCE_DIR", Value: contentSourcePath})
+		init.VolumeMounts = append(init.VolumeMounts, corev1.VolumeMount{
+			Name: contentSourceVolume, MountPath: contentSourcePath, ReadOnly: true,
+		})
+	}
+
+	// content init container는 사용자 init container보다 먼저 실행합니다.
+	podSpec.InitContainers = append([]corev1.Container{init}, podSpec.InitContainers...)
+
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[contentRevisionAnnotation] = revision
+}
+
+// gitCheckoutRevision은 init container가 checkout 할 revision입니다.
+// 확인한 commit SHA를 checkout 해서 pod마다 같은 commit을 배포합니다.
+// commit을 아직 모르면 (render에서 status가 비어 있을 때) spec의 revision을 사용합니다.
+func gitCheckoutRevision(g *demoappv2.GitSource, revision string) string {
+	if commitSHA.MatchString(revision) {
+		return revision
+	}
+	return g.Revision
+}
+
+// commit SHA가 아닌 git revision은 branch가 움직였는지 주기적으로 다시 확인합니다.
+func needsGitPoll(d *demoappv2.Demo) bool {
+	c := d.Spec.Content
+	return c != nil && c.Git != nil && !commitSHA.MatchString(c.Git.Revision)
+}
+
+// 사용자 ConfigMap이 바뀌면 이를 content로 사용하는 Demo를 reconcile 합니다.
+func (r *DemoReconciler) demosForConfigMap(obj client.Object) []reconcile.Request {
+	demoList := &demoappv2.DemoList{}
+	if err := r.Client.List(context.Background(), demoList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, d := range demoList.Items {
+		if c := d.Spec.Content; c != nil && c.ConfigMap != nil && c.ConfigMap.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: d.Namespace, Name: d.Name},
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestValidateContent(t *testing.T) {
+	storage := func(mountPath string) *demoappv2.StorageSpec {
+		return &demoappv2.StorageSpec{Size: resource.MustParse("1Gi"), MountPath: mountPath}
+	}
+
+	tests := []struct {
+		name    string
+		spec    demoappv2.DemoSpec
+		wantErr bool
+	}{
+		{name: "no content"},
+		{name: "inline", spec: demoappv2.DemoSpec{Content: &demoappv2.ContentSpec{Inline: map[string]string{"index.html": "hi"}}}},
+		{name: "no source", spec: demoappv2.DemoSpec{Content: &demoappv2.ContentSpec{}}, wantErr: true},
+		{
+			name: "two sources",
+			spec: demoappv2.DemoSpec{Content: &demoappv2.ContentSpec{
+				Inline:    map[string]string{"index.html": "hi"},
+				ConfigMap: &corev1.LocalObjectReference{Name: "site"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "storage on the default path",
+			spec: demoappv2.DemoSpec{
+				Storage: storage(""),
+				Content: &demoappv2.ContentSpec{Git: &demoappv2.GitSource{Repository: "https://example.com/site.git"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "storage on another path",
+			spec: demoappv2.DemoSpec{
+				Storage: storage("/data"),
+				Content: &demoappv2.ContentSpec{Git: &demoappv2.GitSource{Repository: "https://example.com/site.git"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		err := validateContent(&demoappv2.Demo{Spec: tt.spec})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got err %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPodTemplateWithContent(t *testing.T) {
+	d := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			Pod:     demoappv2.PodSpec{InitContainers: []corev1.Container{{Name: "fetch", Image: "busybox"}}},
+			Content: &demoappv2.ContentSpec{Inline: map[string]string{"index.html": "hi"}},
+		},
+	}
+
+	template := (&DemoReconciler{}).podTemplate(d, "rev1")
+
+	// content init container가 사용자 init container보다 먼저 실행되어야 합니다.
+	if len(template.Spec.InitContainers) != 2 || template.Spec.InitContainers[0].Name != contentInitContainer {
+		t.Fatalf("unexpected init containers %+v", template.Spec.InitContainers)
+	}
+	if template.Annotations[contentRevisionAnnotation] != "rev1" {
+		t.Errorf("got annotations %v, want content revision rev1", template.Annotations)
+	}
+
+	var source string
+	for _, v := range template.Spec.Volumes {
+		if v.Name == contentSourceVolume && v.ConfigMap != nil {
+			source = v.ConfigMap.Name
+		}
+	}
+	if source != "web-content" {
+		t.Errorf("got content source %q, want web-content", source)
+	}
+
+	var content *corev1.VolumeMount
+	for i, m := range template.Spec.Containers[0].VolumeMounts {
+		if m.MountPath == contentMountPath {
+			content = &template.Spec.Containers[0].VolumeMounts[i]
+		}
+	}
+	if content == nil || !content.ReadOnly {
+		t.Errorf("unexpected nginx mounts %+v", template.Spec.Containers[0].VolumeMounts)
+	}
+
+	// revision이 바뀌면 template hash도 바뀌어 pod가 다시 배포됩니다.
+	if hashObject((&DemoReconciler{}).podTemplate(d, "rev1")) == hashObject((&DemoReconciler{}).podTemplate(d, "rev2")) {
+		t.Error("expected template to change with the content revision")
+	}
+}
+
+// ConfigMap 볼륨처럼 ..data 심볼릭 링크로 구성된 디렉터리를 복사합니다.
+func TestCopyContentScript(t *testing.T) {
+	source, content := t.TempDir(), t.TempDir()
+	data := filepath.Join(source, "..2022_01_01")
+	if err := os.Mkdir(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(data, "index.html"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..2022_01_01", filepath.Join(source, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..data/index.html", filepath.Join(source, "index.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	runScript(t, copyContentScript, "SOURCE_DIR="+source, "CONTENT_DIR="+content)
+
+	entries, err := os.ReadDir(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "index.html" || !entries[0].Type().IsRegular() {
+		t.Errorf("unexpected content %v", entries)
+	}
+}
+
+func TestGitContentScript(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(body string) {
+		if err := os.WriteFile(filepath.Join(repo, "index.html"), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "--quiet")
+	write("v1")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "v1")
+	git("tag", "v1")
+	write("v2")
+	git("commit", "--quiet", "-am", "v2")
+
+	tests := []struct {
+		revision string
+		want     string
+	}{
+		{revision: "", want: "v2"},
+		{revision: "v1", want: "v1"},
+	}
+
+	for _, tt := range tests {
+		content := t.TempDir()
+		runScript(t, gitContentScript, "GIT_REPOSITORY=file://"+repo, "GIT_REVISION="+tt.revision, "CONTENT_DIR="+content)
+
+		got, err := os.ReadFile(filepath.Join(content, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("revision %q: got %q, want %q", tt.revision, got, tt.want)
+		}
+		if _, err := os.Stat(filepath.Join(content, ".git")); !os.IsNotExist(err) {
+			t.Errorf("revision %q: .git should not be served", tt.revision)
+		}
+	}
+}
+
+func runScript(t *testing.T, script string, env ...string) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = append(os.Environ(), env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("script failed: %v\n%s", err, strings.TrimSpace(string(out)))
+	}
+}
+
+// fakeGitResolver는 저장소의 branch를 commit SHA로 바꿉니다.
+type fakeGitResolver map[string]string
+
+func (f fakeGitResolver) ResolveRevision(_ context.Context, _, revision string) (string, error) {
+	sha, ok := f[revision]
+	if !ok {
+		return "", fmt.Errorf("revision %q not found", revision)
+	}
+	return sha, nil
+}
+
+// git branch가 움직이면 새 commit이 pod template과 status에 반영되어 다시 배포됩니다.
+func TestReconcileGitBranchMoves(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			Content: &demoappv2.ContentSpec{Git: &demoappv2.GitSource{Repository: "https://example.com/site.git", Revision: "main"}},
+		},
+	}
+	r, _ := newPipelineTest(t, cr)
+	resolver := fakeGitResolver{"main": mainSHA}
+	r.GitResolver = resolver
+	ctx := context.Background()
+
+	check := func(want string) {
+		t.Helper()
+		result, err := r.Reconcile(ctx, reconcileRequest("web"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.RequeueAfter <= 0 || result.RequeueAfter > gitPollInterval {
+			t.Errorf("got RequeueAfter %v, want the branch checked again within %v", result.RequeueAfter, gitPollInterval)
+		}
+		deploy := &appsv1.Deployment{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, deploy); err != nil {
+			t.Fatal(err)
+		}
+		template := deploy.Spec.Template
+		if got := template.Annotations[contentRevisionAnnotation]; got != want {
+			t.Errorf("got content revision annotation %q, want %s", got, want)
+		}
+		for _, e := range template.Spec.InitContainers[0].Env {
+			if e.Name == "GIT_REVISION" && e.Value != want {
+				t.Errorf("got GIT_REVISION %q, want %s", e.Value, want)
+			}
+		}
+		demo := &demoappv2.Demo{}
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, demo); err != nil {
+			t.Fatal(err)
+		}
+		if demo.Status.ContentRevision != want {
+			t.Errorf("got status.contentRevision %q, want %s", demo.Status.ContentRevision, want)
+		}
+	}
+
+	check(mainSHA)
+	resolver["main"] = tagSHA
+	check(tagSHA)
+}
@@ -18,52 +18,197 @@ package controllers
 
 import (
 	"context"
-	"reflect"
+	"text/template"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	demoappv1 "demo-operator/api/v1"
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/trace"
+
+	"demo-operator/activator"
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/scope"
 )
 
 // DemoReconciler reconciles a Demo object
 type DemoReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Activator는 idle 상태인 Demo의 요청을 받아 깨웁니다. nil이면 idle scale-to-zero를 사용하지 않습니다.
+	Activator *activator.Activator
+
+	// DefaultImage는 spec.image가 없을 때 사용하는 nginx 이미지입니다. 비어 있으면 nginx:latest입니다.
+	DefaultImage string
+	// ResourceProfiles는 spec.pod.resourceProfile로 고르는 nginx 컨테이너의 resource requirements입니다.
+	ResourceProfiles map[string]corev1.ResourceRequirements
+	// DefaultResourceProfile은 spec.pod.resourceProfile이 없을 때 사용하는 profile입니다.
+	DefaultResourceProfile string
+	// NameTemplate은 Demo가 만드는 객체 이름의 앞부분을 만드는 operator 설정의 nameTemplate입니다.
+	// nil이면 Demo 이름을 사용합니다. (configv1alpha1.ParseNameTemplate)
+	NameTemplate *template.Template
+	// MaxConcurrentReconciles는 동시에 reconcile 하는 Demo 수입니다. 0이면 1입니다.
+	MaxConcurrentReconciles int
+	// Recorder는 adoption과 conflict를 Demo의 이벤트로 남깁니다.
+	Recorder record.EventRecorder
+	// RateLimiter는 실패한 Demo를 다시 시도하는 간격을 정합니다. nil이면 controller-runtime 기본값입니다.
+	RateLimiter workqueue.RateLimiter
+	// DryRun이면 Client는 NewDryRunClient로 만든 client여야 합니다.
+	// reconcile 마다 가로챈 쓰기를 Demo의 plan으로 모아 pending changes metric에 남깁니다.
+	DryRun bool
+	// PlanWriter가 nil이 아니면 dry-run의 plan을 Demo의 plan annotation에 씁니다. dry-run client가 아닌 client입니다.
+	PlanWriter client.Writer
+	// Log는 reconcile log의 기본 logger입니다. nil이면 ctrl.Log를 사용합니다.
+	Log logr.Logger
+	// APIReader는 cache에 없는 Secret을 읽습니다. nil이면 Client만 사용합니다. (NewCache)
+	APIReader client.Reader
+	// GitResolver는 spec.content.git의 revision을 commit SHA로 바꿉니다. nil이면 저장소에 HTTP로 묻습니다.
+	GitResolver GitResolver
+	// TracerProvider가 있으면 reconcile과 step마다 span을 남깁니다. API 요청의 span은 NewTracingClient로 남깁니다.
+	TracerProvider trace.TracerProvider
+
+	requests requestPoller // idle Demo의 pod 요청 수
 }
 
 //+kubebuilder:rbac:groups=demoapp.my.domain,resources=demoes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=demoapp.my.domain,resources=demoes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=demoapp.my.domain,resources=demoes/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 // 추가
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;update;delete
 //+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;delete
-//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups="",resources=endpoints,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;delete
+// escalate와 bind는 주지 않습니다. Demo의 Role에는 operator가 가진 권한만 넣을 수 있습니다.
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;delete
+
+// RequiredAccess는 위 권한 중 namespace 단위 권한입니다.
+// 시작할 때 감시하는 namespace마다 이 권한이 있는지 확인합니다.
+// 위 marker를 고치면 이 목록도 고쳐야 하며, TestRequiredAccessMatchesRBACMarkers가 config/rbac/role.yaml과 비교합니다.
+var RequiredAccess = []scope.Rule{
+	{Group: "demoapp.my.domain", Resource: "demoes", Verbs: []string{"get", "list", "watch", "create", "update", "patch", "delete"}},
+	{Group: "demoapp.my.domain", Resource: "demoes/status", Verbs: []string{"get", "update", "patch"}},
+	{Group: "demoapp.my.domain", Resource: "demoes/finalizers", Verbs: []string{"update"}},
+	{Group: "apps", Resource: "deployments", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+	{Group: "apps", Resource: "statefulsets", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+	{Resource: "persistentvolumeclaims", Verbs: []string{"get", "list", "watch", "update", "delete"}},
+	{Resource: "services", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+	{Resource: "pods", Verbs: []string{"get", "list", "watch"}},
+	{Resource: "configmaps", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+	{Resource: "endpoints", Verbs: []string{"get", "list", "watch", "create", "update"}},
+	{Resource: "events", Verbs: []string{"create", "patch"}},
+	{Group: "networking.k8s.io", Resource: "networkpolicies", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+	{Resource: "serviceaccounts", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+	{Resource: "secrets", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+	{Group: "rbac.authorization.k8s.io", Resource: "roles", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+	{Group: "rbac.authorization.k8s.io", Resource: "rolebindings", Verbs: []string{"get", "list", "watch", "create", "update", "delete"}},
+}
+
+// NewCache는 newCache (nil이면 cache.New)로 만든 cache가 Secret은 operator의 label이 있는 것만 감시하도록 합니다.
+// 다른 Secret의 키까지 cache에 두지 않기 위해서입니다. 그 밖의 Secret은 DemoReconciler.APIReader로 읽습니다.
+func NewCache(newCache cache.NewCacheFunc) cache.NewCacheFunc {
+	if newCache == nil {
+		newCache = cache.New
+	}
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.SelectorsByObject = cache.SelectorsByObject{
+			&corev1.Secret{}: {Label: labels.SelectorFromSet(labels.Set{labelManagedBy: managedBy})},
+		}
+		return newCache(config, opts)
+	}
+}
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *DemoReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&demoappv1.Demo{}).  // For에 감시할 CR을 설정합니다.
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&demoappv2.Demo{}).  // For에 감시할 CR을 설정합니다.
 		Owns(&corev1.Service{}). // Owns는 서브로 감시할 대상입니다. (서브 감시 대상이 삭제되면 reconcile 되도록)
 		Owns(&appsv1.Deployment{}).
-		Complete(r)
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&corev1.Secret{}).
+		Owns(&rbacv1.Role{}).
+		Owns(&rbacv1.RoleBinding{}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.RateLimiter,
+		})
+
+	// 액티베이터로 요청이 들어오면 해당 Demo를 reconcile 합니다.
+	// idle Demo의 요청 수는 manager가 실행하는 requestPoller가 읽습니다.
+	if r.Activator != nil {
+		b = b.Watches(r.Activator.Source(), &handler.EnqueueRequestForObject{})
+		r.requests.client = mgr.GetClient()
+		if err := mgr.Add(&r.requests); err != nil {
+			return err
+		}
+	}
+
+	// spec.content.configMap으로 참조한 사용자 ConfigMap이 바뀌면 content를 다시 배포합니다.
+	b = b.Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.demosForConfigMap))
+
+	return b.Complete(r)
 
 	// 여기서 서브로 감시할 대상에 추가된 service와 deploy는
 	// 추후 임의로 삭제하면 다시 복구됩니다.
 }
 
-func (r *DemoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *DemoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	// reconcile 한 번이 trace 하나가 되고, 그 log는 같은 reconcileID를 가집니다.
+	// generation은 Demo를 읽은 뒤 더합니다.
+	ctx, reconcileID := r.reconcileLogger(ctx, req)
+	ctx, span := r.tracer().Start(ctx, "Reconcile", trace.WithAttributes(
+		demoNamespaceKey.String(req.Namespace), demoNameKey.String(req.Name), reconcileIDKey.String(reconcileID)))
+	defer func() { endSpan(span, err) }()
+
+	if !r.DryRun {
+		return r.reconcile(ctx, req)
+	}
+	// dry-run에서는 가로챈 쓰기를 plan으로 모아 metric과 plan annotation에 남깁니다.
+	p := &plan{}
+	result, err = r.reconcile(withPlan(ctx, p), req)
+	if err := r.reportPlan(ctx, req.NamespacedName, p); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to report plan")
+	}
+	return result, err
+}
+
+// updateConditions는 step을 실행하지 않고 바뀐 condition만 status에 씁니다.
+func (r *DemoReconciler) updateConditions(ctx context.Context, s *reconcileState) error {
+	if !s.conditionsChanged {
+		return nil
+	}
+	return r.Client.Status().Update(ctx, s.cr)
+}
 
-	logger := log.FromContext(ctx) // logger 정의
-	cr := &demoappv1.Demo{}        // CR 객체 정의
-	svc := &corev1.Service{}       // svc 객체 정의
-	dply := &appsv1.Deployment{}   // deploy 객체 정의
+func (r *DemoReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	cr := &demoappv2.Demo{} // CR 객체 정의
 
 	// 클러스터에서 해당 CR이 있는지 확인합니다.
 	err := r.Client.Get(ctx, req.NamespacedName, cr)
@@ -71,111 +216,54 @@ func (r *DemoReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	if err != nil { // Get CR에 에러가 있는 경우
 
 		if errors.IsNotFound(err) { // 변경사항인 cr이 k8s에 존재하지 않는 경우
-			logger.Info("CR is Deleted")
+			logger.Info("Demo not found, it was deleted")
 			return ctrl.Result{}, nil
 		}
 
-		logger.Error(err, "Failed to get CR")
+		logger.Error(err, "Failed to get Demo")
 		return ctrl.Result{}, err // 기타 에러 처리
 	}
+	trace.SpanFromContext(ctx).SetAttributes(demoGenerationKey.Int64(cr.Generation))
+	ctx = withGeneration(ctx, cr.Generation)
+	logger = log.FromContext(ctx)
 
-	size := cr.Spec.Size // deploy.Spec.Replicas 값으로 들어감.
-
-	// 클러스터에서 cr용 service가 있는지 확인합니다.
-	err = r.Client.Get(ctx, types.NamespacedName{
-		Name:      cr.Name,
-		Namespace: cr.Namespace,
-	}, svc)
-
-	if err != nil { // Get service에 에러가 있는 경우
-
-		if errors.IsNotFound(err) { // 해당 service가 클러스터에 없는 경우 생성합니다.
-			newSvc := r.createService(cr)
-			err = r.Create(ctx, newSvc)
-
-			if err != nil { // 생성중 에러가 발생하면 이벤트 큐에 다시 넣음.
-				logger.Info("failed to create Service", "svc.namespace", newSvc.Namespace, "svc.name", newSvc.Name)
-				return ctrl.Result{}, err
-				// 이벤트 큐 - return에서 err 포함하거나, ctrl.Result{}에 Requeue 옵션 포함
-			}
-
-			logger.Info("Service Created", "svc.namespace", newSvc.Namespace, "svc.name", newSvc.Name)
-			return ctrl.Result{}, nil
-		}
-
-		logger.Error(err, "Failed to Get Service")
-		return ctrl.Result{}, err // 기타 에러 처리
-	}
-
-	// 클러스터에서 cr용 deploy가 있는지 확인합니다.
-	err = r.Client.Get(ctx, types.NamespacedName{
-		Name:      cr.Name,
-		Namespace: cr.Namespace,
-	}, dply)
-
-	if err != nil { // Get deploy에 에러가 있는 경우
-
-		if errors.IsNotFound(err) { // 해당 deploy가 클러스터에 없는 경우 생성합니다.
-			newDply := r.createDeployment(cr)
-			err = r.Create(ctx, newDply)
-
-			if err != nil {
-				logger.Info("failed to create Service", "deploy.namespace", newDply.Namespace, "deploy.name", newDply.Name)
-				return ctrl.Result{}, err
-			}
-
-			logger.Info("Deployment Created", "deploy.namespace", newDply.Namespace, "deploy.name", newDply.Name)
-			return ctrl.Result{}, nil
+	// 삭제 중인 Demo는 retention 정책에 따라 PVC를 정리합니다.
+	if !cr.DeletionTimestamp.IsZero() {
+		err = r.finalizeVolumes(ctx, cr)
+		if err != nil {
+			logger.Error(err, "Failed to clean up volumes")
 		}
-
-		logger.Error(err, "Failed to Get Deployment")
 		return ctrl.Result{}, err
 	}
 
-	// deploy 정의할 때 사용한 replicas 값과 cr.Spec.Size 값이 다른 경우
-	if *dply.Spec.Replicas != size {
-
-		dply.Spec.Replicas = &size // replicas를 변경된 값으로 맞춰줌.
+	// 잘못된 spec은 spec이 바뀌어야 고쳐지므로 다시 시도하지 않고 Valid condition으로 알립니다.
+	if reason, err := r.validateSpec(cr); err != nil {
+		logger.Error(err, "Invalid spec", "reason", reason)
+		state := newReconcileState(cr, scheduleState{}, time.Now())
+		state.setInvalidCondition(reason, err.Error())
+		return ctrl.Result{}, r.updateConditions(ctx, state)
+	}
 
-		// deploy에 replicas 값 변경을 반영합니다.
-		logger.Info("changed replicas Size", "deploy.namespace", dply.Namespace, "deploy.Name", dply.Name, "Size", size)
-		err = r.Client.Update(ctx, dply)
+	// spec.scaling.schedules가 있으면 현재 시각 기준으로 적용할 size를 계산합니다.
+	now := time.Now()
+	sched, err := scheduledSize(cr, now)
+	if err != nil { // 잘못된 스케줄은 무시하고 spec.scaling.replicas를 사용하며, SchedulesValid condition으로 알립니다.
+		logger.Error(err, "Invalid schedule, falling back to spec.scaling.replicas")
+	}
 
-		if err != nil {
-			logger.Error(err, "Error in Updating ReplicaSet", "deploy.Namespace", dply.Namespace,
-				"deploy.Name", dply.Name)
+	// Service, workload, status를 한 번에 맞춥니다. 실패한 단계가 있으면 에러를 모아 다시 시도합니다.
+	state := newReconcileState(cr, sched, now)
+	state.setSchedulesCondition(err)
+	if isPaused(cr) {
+		logger.V(logDebug).Info("Demo is paused, only updating status")
+		if err := r.runSteps(ctx, state, r.pausedSteps()); err != nil {
 			return ctrl.Result{}, err
 		}
-
 		return ctrl.Result{}, nil
 	}
-
-	// status.Nodes
-	podList := &corev1.PodList{}
-	label := getLabelForCR(cr.Name)
-	listOps := []client.ListOption{
-		client.InNamespace(req.NamespacedName.Namespace),
-		client.MatchingLabels(label),
-	}
-
-	err = r.Client.List(ctx, podList, listOps...)
-	if err != nil {
-		logger.Error(err, "Failed to list Pods.", "demo.Namespace", cr.Namespace, "demo.Name", cr.Name)
+	if err := r.runSteps(ctx, state, r.steps()); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	podNames := getPodNames(podList.Items)
-
-	// Update status.Nodes if needed
-	if !reflect.DeepEqual(podNames, cr.Status.Nodes) {
-		logger.Info("Update pod list in demo", "podNames", podNames)
-		cr.Status.Nodes = podNames
-		err := r.Client.Status().Update(ctx, cr)
-		if err != nil {
-			logger.Error(err, "Failed to update Demo Status.")
-			return ctrl.Result{}, err
-		}
-	}
-
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: state.requeueAfter()}, nil
 }
@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+
+	demoappv2 "demo-operator/api/v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/util/retry"
+)
+
+var _ = Describe("Demo controller", func() {
+	var (
+		ctx context.Context
+		key types.NamespacedName
+		cr  *demoappv2.Demo
+	)
+
+	// envtest는 namespace를 지우지 못하므로 spec마다 새 namespace를 사용합니다.
+	BeforeEach(func() {
+		ctx = context.Background()
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "demo-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+
+		key = types.NamespacedName{Namespace: ns.Name, Name: "web"}
+		cr = &demoappv2.Demo{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+			Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2}},
+		}
+		Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+	})
+
+	// Demo의 spec을 고칩니다. controller가 status를 함께 쓰므로 conflict가 나면 다시 읽습니다.
+	updateDemo := func(mutate func(d *demoappv2.Demo)) {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			d := &demoappv2.Demo{}
+			if err := k8sClient.Get(ctx, key, d); err != nil {
+				return err
+			}
+			mutate(d)
+			return k8sClient.Update(ctx, d)
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	getDeployment := func() *appsv1.Deployment {
+		dep := &appsv1.Deployment{}
+		Eventually(func() error { return k8sClient.Get(ctx, key, dep) }, timeout, interval).Should(Succeed())
+		return dep
+	}
+
+	getService := func() *corev1.Service {
+		svc := &corev1.Service{}
+		Eventually(func() error { return k8sClient.Get(ctx, key, svc) }, timeout, interval).Should(Succeed())
+		return svc
+	}
+
+	It("creates a Service and a Deployment owned by the Demo", func() {
+		Expect(k8sClient.Get(ctx, key, cr)).To(Succeed())
+
+		dep := getDeployment()
+		Expect(*dep.Spec.Replicas).To(Equal(int32(2)))
+		Expect(dep.Spec.Selector.MatchLabels).To(Equal(selectorLabels(cr)))
+		Expect(metav1.IsControlledBy(dep, cr)).To(BeTrue())
+
+		pod := dep.Spec.Template
+		Expect(pod.Labels).To(HaveKeyWithValue(labelManagedBy, managedBy))
+		Expect(pod.Spec.Containers).To(HaveLen(1))
+		nginx := pod.Spec.Containers[0]
+		Expect(nginx.Image).To(Equal(defaultImage))
+		Expect(nginx.Ports).To(ContainElement(corev1.ContainerPort{Name: "http", ContainerPort: restrictedNginxPort, Protocol: corev1.ProtocolTCP}))
+		Expect(nginx.SecurityContext).NotTo(BeNil())
+		Expect(*nginx.SecurityContext.ReadOnlyRootFilesystem).To(BeTrue())
+
+		svc := getService()
+		Expect(svc.Spec.Type).To(Equal(corev1.ServiceTypeClusterIP))
+		Expect(svc.Spec.Selector).To(Equal(selectorLabels(cr)))
+		Expect(svc.Spec.Ports).To(HaveLen(1))
+		Expect(svc.Spec.Ports[0].Port).To(Equal(int32(defaultServicePort)))
+		Expect(svc.Spec.Ports[0].TargetPort).To(Equal(intstr.FromString("http")))
+		Expect(metav1.IsControlledBy(svc, cr)).To(BeTrue())
+	})
+
+	It("scales the Deployment with spec.scaling.replicas", func() {
+		getDeployment()
+		updateDemo(func(d *demoappv2.Demo) { d.Spec.Scaling.Replicas = 3 })
+
+		Eventually(func() int32 {
+			return *getDeployment().Spec.Replicas
+		}, timeout, interval).Should(Equal(int32(3)))
+
+		updateDemo(func(d *demoappv2.Demo) { d.Spec.Scaling.Replicas = 0 })
+		Eventually(func() int32 {
+			return *getDeployment().Spec.Replicas
+		}, timeout, interval).Should(BeZero())
+	})
+
+	It("recreates deleted children", func() {
+		dep := getDeployment()
+		svc := getService()
+
+		Expect(k8sClient.Delete(ctx, dep)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, svc)).To(Succeed())
+
+		Eventually(func() types.UID {
+			return getDeployment().UID
+		}, timeout, interval).ShouldNot(Equal(dep.UID))
+		Eventually(func() types.UID {
+			return getService().UID
+		}, timeout, interval).ShouldNot(Equal(svc.UID))
+	})
+
+	It("reports the simulated pods in status", func() {
+		getDeployment()
+		fakePods(ctx, key, 1)
+
+		Eventually(func() []demoappv2.PodSummary {
+			d := &demoappv2.Demo{}
+			Expect(k8sClient.Get(ctx, key, d)).To(Succeed())
+			return d.Status.Pods
+		}, timeout, interval).Should(Equal([]demoappv2.PodSummary{
+			{Name: "web-fake-0", NodeName: fakePodNode, Phase: corev1.PodRunning, Ready: true},
+			{Name: "web-fake-1", NodeName: fakePodNode, Phase: corev1.PodRunning, Ready: false},
+		}))
+
+		// 모든 pod가 Ready가 되면 컨테이너 readiness도 갱신됩니다.
+		fakePods(ctx, key, 2)
+		Eventually(func() []demoappv2.ContainerReadiness {
+			d := &demoappv2.Demo{}
+			Expect(k8sClient.Get(ctx, key, d)).To(Succeed())
+			return d.Status.Containers
+		}, timeout, interval).Should(Equal([]demoappv2.ContainerReadiness{
+			{Name: nginxContainerName, Ready: 2, Total: 2},
+		}))
+
+		// scale-down하면 남은 pod만 status에 남습니다.
+		updateDemo(func(d *demoappv2.Demo) { d.Spec.Scaling.Replicas = 1 })
+		Eventually(func() int32 {
+			return *getDeployment().Spec.Replicas
+		}, timeout, interval).Should(Equal(int32(1)))
+		fakePods(ctx, key, 1)
+		Eventually(func() []string {
+			d := &demoappv2.Demo{}
+			Expect(k8sClient.Get(ctx, key, d)).To(Succeed())
+			var names []string
+			for _, p := range d.Status.Pods {
+				names = append(names, p.Name)
+			}
+			return names
+		}, timeout, interval).Should(Equal([]string{"web-fake-0"}))
+	})
+
+	It("leaves the children to garbage collection when the Demo is deleted", func() {
+		dep := getDeployment()
+		svc := getService()
+
+		Expect(k8sClient.Delete(ctx, cr)).To(Succeed())
+		Eventually(func() bool {
+			return errors.IsNotFound(k8sClient.Get(ctx, key, &demoappv2.Demo{}))
+		}, timeout, interval).Should(BeTrue())
+
+		// envtest에는 garbage collector가 없으므로 삭제 대신 owner reference를 확인합니다.
+		for _, owners := range [][]metav1.OwnerReference{dep.OwnerReferences, svc.OwnerReferences} {
+			Expect(owners).To(HaveLen(1))
+			Expect(owners[0].UID).To(Equal(cr.UID))
+			Expect(*owners[0].BlockOwnerDeletion).To(BeTrue())
+		}
+	})
+})
@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	demoappv2 "demo-operator/api/v2"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+// dry-run으로 실행할 때 Demo마다 적용하지 않은 변경 수를 노출합니다.
+var pendingChanges = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "demo_operator_pending_changes",
+	Help: "Number of changes a dry-run operator would make to the resources of a Demo.",
+}, []string{"namespace", "name"})
+
+func init() {
+	metrics.Registry.MustRegister(pendingChanges)
+}
+
+// plannedChange는 dry-run client가 가로챈 쓰기 하나입니다.
+type plannedChange struct {
+	Verb      string `json:"verb"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// 바뀌는 내용의 unified diff. 길어질 수 있으므로 plan annotation에는 넣지 않습니다.
+	Diff string `json:"-"`
+}
+
+// plan은 Demo 하나를 reconcile 하는 동안 가로챈 쓰기를 모읍니다.
+type plan struct {
+	mu      sync.Mutex
+	changes []plannedChange
+}
+
+func (p *plan) add(c plannedChange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.changes = append(p.changes, c)
+}
+
+type planKey struct{}
+
+func withPlan(ctx context.Context, p *plan) context.Context {
+	return context.WithValue(ctx, planKey{}, p)
+}
+
+// planFrom은 reconcile 중인 Demo의 plan입니다. reconcile 밖의 쓰기이면 nil입니다.
+func planFrom(ctx context.Context) *plan {
+	p, _ := ctx.Value(planKey{}).(*plan)
+	return p
+}
+
+// NewDryRunClient는 쓰기 요청을 API 서버로 보내지 않고, 바뀔 내용을 diff로 log에 남기는 client를 만듭니다.
+// reconcile 중이면 가로챈 쓰기를 그 Demo의 plan에 더합니다. 읽기는 c로 합니다.
+// 쓰기가 적용되지 않으므로 같은 reconcile의 다음 step은 바뀌기 전의 객체를 읽습니다.
+func NewDryRunClient(c client.Client) client.Client {
+	return &dryRunClient{Client: c}
+}
+
+type dryRunClient struct {
+	client.Client
+}
+
+// record는 쓰기 하나를 log와 plan에 남깁니다. diff를 만들지 못해도 쓰기는 기록합니다.
+func (c *dryRunClient) record(ctx context.Context, verb string, obj client.Object, diff func() (string, error)) {
+	change := plannedChange{Verb: verb, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		change.Kind = gvk.Kind
+	}
+	logger := log.FromContext(ctx)
+	if diff != nil {
+		var err error
+		if change.Diff, err = diff(); err != nil {
+			logger.Error(err, "Failed to diff planned change",
+				append(objectKeys(change.Kind, change.Namespace, change.Name), "verb", verb)...)
+		}
+	}
+	logger.Info("Planned change",
+		append(objectKeys(change.Kind, change.Namespace, change.Name), "verb", verb, "diff", change.Diff)...)
+	if p := planFrom(ctx); p != nil {
+		p.add(change)
+	}
+}
+
+// current는 obj의 클러스터 값을 새 객체로 읽습니다.
+func (c *dryRunClient) current(ctx context.Context, obj client.Object) (client.Object, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return nil, err
+	}
+	o, err := c.Scheme().New(gvk)
+	if err != nil {
+		return nil, err
+	}
+	current, ok := o.(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a client.Object", gvk)
+	}
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.record(ctx, "create", obj, func() (string, error) { return diffPlanned(nil, obj, false) })
+	return nil
+}
+
+// 클러스터에 없는 객체의 Update는 API 서버처럼 NotFound를 반환합니다.
+func (c *dryRunClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	current, err := c.current(ctx, obj)
+	if err != nil {
+		return err
+	}
+	c.record(ctx, "update", obj, func() (string, error) { return diffPlanned(current, obj, false) })
+	return nil
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.record(ctx, "patch", obj, patchBody(patch, obj))
+	return nil
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.record(ctx, "delete", obj, nil)
+	return nil
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	c.record(ctx, "deletecollection", obj, nil)
+	return nil
+}
+
+func (c *dryRunClient) Status() client.StatusWriter {
+	return &dryRunStatusWriter{client: c}
+}
+
+type dryRunStatusWriter struct {
+	client *dryRunClient
+}
+
+func (w *dryRunStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	current, err := w.client.current(ctx, obj)
+	if err != nil {
+		return err
+	}
+	w.client.record(ctx, "update-status", obj, func() (string, error) { return diffPlanned(current, obj, true) })
+	return nil
+}
+
+func (w *dryRunStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	w.client.record(ctx, "patch-status", obj, patchBody(patch, obj))
+	return nil
+}
+
+// patchBody는 patch를 적용한 결과 대신 보낼 patch 자체를 diff로 남깁니다.
+func patchBody(patch client.Patch, obj client.Object) func() (string, error) {
+	return func() (string, error) {
+		data, err := patch.Data(obj)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s: %s", patch.Type(), data), nil
+	}
+}
+
+// diffPlanned는 current에서 want로 바뀌는 내용을 YAML unified diff로 만듭니다.
+// status가 true이면 status만, 아니면 status를 뺀 나머지를 비교합니다.
+func diffPlanned(current, want client.Object, status bool) (string, error) {
+	var a, b string
+	if current != nil {
+		var err error
+		if a, err = plannedYAML(current, status); err != nil {
+			return "", err
+		}
+	}
+	b, err := plannedYAML(want, status)
+	if err != nil {
+		return "", err
+	}
+	name := want.GetNamespace() + "/" + want.GetName()
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: "current/" + name,
+		ToFile:   "planned/" + name,
+		Context:  3,
+	})
+}
+
+// plannedYAML은 API 서버가 관리하는 필드를 빼고 YAML로 만듭니다. Secret 값은 해시로 가립니다.
+func plannedYAML(obj client.Object, status bool) (string, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return "", err
+	}
+	if status {
+		u = map[string]interface{}{"status": u["status"]}
+	} else {
+		delete(u, "status")
+		for _, f := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink"} {
+			unstructured.RemoveNestedField(u, "metadata", f)
+		}
+	}
+	if _, ok := obj.(*corev1.Secret); ok {
+		for _, f := range []string{"data", "stringData"} {
+			values, _, _ := unstructured.NestedMap(u, f)
+			for k, v := range values {
+				sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+				values[k] = fmt.Sprintf("<redacted sha256:%x>", sum[:8])
+			}
+			if values != nil {
+				u[f] = values
+			}
+		}
+	}
+	data, err := yaml.Marshal(u)
+	return string(data), err
+}
+
+// reportPlan은 reconcile에서 모은 plan을 pending changes metric에 남기고,
+// PlanWriter가 nil이 아니면 Demo의 plan annotation에 씁니다. plan이 비어 있으면 annotation을 지웁니다.
+func (r *DemoReconciler) reportPlan(ctx context.Context, key types.NamespacedName, p *plan) error {
+	cr := &demoappv2.Demo{}
+	if err := r.Client.Get(ctx, key, cr); errors.IsNotFound(err) {
+		pendingChanges.DeleteLabelValues(key.Namespace, key.Name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pendingChanges.WithLabelValues(key.Namespace, key.Name).Set(float64(len(p.changes)))
+	if r.PlanWriter == nil {
+		return nil
+	}
+
+	var value string
+	if len(p.changes) > 0 {
+		data, err := json.Marshal(p.changes)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	if cr.Annotations[demoappv2.PlanAnnotation] == value {
+		return nil
+	}
+	patch := client.MergeFrom(cr.DeepCopy())
+	if value == "" {
+		delete(cr.Annotations, demoappv2.PlanAnnotation)
+	} else {
+		if cr.Annotations == nil {
+			cr.Annotations = map[string]string{}
+		}
+		cr.Annotations[demoappv2.PlanAnnotation] = value
+	}
+	return r.PlanWriter.Patch(ctx, cr, patch)
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// dry-run reconcile은 아무것도 쓰지 않고 plan을 metric과 annotation에 남깁니다.
+// 실제 operator가 plan을 적용하면 plan이 비고 annotation도 지워집니다.
+func TestReconcileDryRun(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2}},
+	}
+	r, c := newPipelineTest(t, cr)
+	r.Client = NewDryRunClient(c)
+	r.DryRun = true
+	// plan annotation을 쓰는 것은 reconcile의 쓰기가 아니므로 세지 않습니다.
+	r.PlanWriter = c.Client
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	planned := func() []plannedChange {
+		t.Helper()
+		d := &demoappv2.Demo{}
+		if err := c.Get(ctx, key, d); err != nil {
+			t.Fatal(err)
+		}
+		value, ok := d.Annotations[demoappv2.PlanAnnotation]
+		if !ok {
+			return nil
+		}
+		var changes []plannedChange
+		if err := json.Unmarshal([]byte(value), &changes); err != nil {
+			t.Fatalf("invalid plan annotation %q: %v", value, err)
+		}
+		return changes
+	}
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != 0 {
+		t.Errorf("dry-run reconcile sent %d writes", c.writes)
+	}
+	if err := c.Get(ctx, key, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Errorf("dry-run reconcile created the Deployment: %v", err)
+	}
+	changes := planned()
+	var summary []string
+	for _, change := range changes {
+		summary = append(summary, change.Verb+" "+change.Kind+"/"+change.Name)
+	}
+	for _, want := range []string{"create Service/web", "create Deployment/web", "update-status Demo/web"} {
+		if !strings.Contains(strings.Join(summary, ","), want) {
+			t.Errorf("plan %v has no %q", summary, want)
+		}
+	}
+	if got := testutil.ToFloat64(pendingChanges.WithLabelValues("default", "web")); got != float64(len(changes)) {
+		t.Errorf("got %v pending changes, want %d", got, len(changes))
+	}
+
+	// 같은 plan은 annotation을 다시 쓰지 않습니다.
+	d := &demoappv2.Demo{}
+	if err := c.Get(ctx, key, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	after := &demoappv2.Demo{}
+	if err := c.Get(ctx, key, after); err != nil {
+		t.Fatal(err)
+	}
+	if after.ResourceVersion != d.ResourceVersion {
+		t.Error("plan annotation was rewritten although the plan did not change")
+	}
+
+	// 실제 operator가 적용한 뒤에는 바꿀 것이 없습니다.
+	applied := &DemoReconciler{Client: c, Scheme: r.Scheme}
+	if err := reconcileDemo(t, applied, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if changes := planned(); changes != nil {
+		t.Errorf("got plan %+v after the operator applied it, want no annotation", changes)
+	}
+	if got := testutil.ToFloat64(pendingChanges.WithLabelValues("default", "web")); got != 0 {
+		t.Errorf("got %v pending changes after the operator applied the plan", got)
+	}
+}
+
+// Secret의 값은 log에 남지 않도록 해시로 가립니다.
+func TestDiffPlannedRedactsSecrets(t *testing.T) {
+	current := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-tls", Namespace: "default"},
+		Data:       map[string][]byte{corev1.TLSPrivateKeyKey: []byte("old-key")},
+	}
+	want := current.DeepCopy()
+	want.Data[corev1.TLSPrivateKeyKey] = []byte("new-key")
+
+	diff, err := diffPlanned(current, want, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(diff, "b2xkLWtleQ==") || strings.Contains(diff, "bmV3LWtleQ==") || !strings.Contains(diff, "<redacted sha256:") {
+		t.Errorf("diff does not redact the Secret data:\n%s", diff)
+	}
+	if !strings.Contains(diff, "-  tls.key") || !strings.Contains(diff, "+  tls.key") {
+		t.Errorf("diff does not show the changed key:\n%s", diff)
+	}
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// envtest에는 kubelet과 Deployment controller가 없으므로 그 역할을 흉내 냅니다.
+// 이름이 fakePodNode인 노드에 key의 Deployment replicas만큼 pod를 만들고, 앞의 ready개만 Ready로 표시합니다.
+// 남는 pod는 지우고, Deployment status도 pod에 맞춰 갱신합니다.
+// status가 바뀌면 Owns로 감시하는 Deployment 이벤트로 Demo가 다시 reconcile 됩니다.
+const fakePodNode = "envtest-node"
+
+func fakePods(ctx context.Context, key types.NamespacedName, ready int) []corev1.Pod {
+	dep := &appsv1.Deployment{}
+	Expect(k8sClient.Get(ctx, key, dep)).To(Succeed())
+	replicas := int(*dep.Spec.Replicas)
+	pods := make([]corev1.Pod, 0, replicas)
+
+	// ServiceAccount controller도 없으므로 ServiceAccount admission이 켜져 있어도 pod를 만들 수 있도록
+	// pod가 사용하는 ServiceAccount를 만듭니다.
+	saName := dep.Spec.Template.Spec.ServiceAccountName
+	if saName == "" {
+		saName = "default"
+	}
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: saName, Namespace: dep.Namespace}}
+	if err := k8sClient.Create(ctx, sa); err != nil && !errors.IsAlreadyExists(err) {
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	for i := 0; i < replicas; i++ {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("%s-fake-%d", dep.Name, i),
+				Namespace:   dep.Namespace,
+				Labels:      dep.Spec.Template.Labels,
+				Annotations: dep.Spec.Template.Annotations,
+			},
+			Spec: *dep.Spec.Template.Spec.DeepCopy(),
+		}
+		pod.Spec.NodeName = fakePodNode
+
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), pod)
+		if client.IgnoreNotFound(err) != nil {
+			Expect(err).NotTo(HaveOccurred())
+		}
+		if err != nil {
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		}
+
+		setFakePodStatus(pod, i, i < ready)
+		Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+		pods = append(pods, *pod)
+	}
+
+	// scale-down으로 남은 pod를 지웁니다.
+	list := &corev1.PodList{}
+	Expect(k8sClient.List(ctx, list, client.InNamespace(dep.Namespace), client.MatchingLabels(dep.Spec.Selector.MatchLabels))).To(Succeed())
+	for i := range list.Items {
+		if !containsPod(pods, list.Items[i].Name) {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &list.Items[i], client.GracePeriodSeconds(0)))).To(Succeed())
+		}
+	}
+
+	// reconciler가 Deployment를 함께 고칠 수 있으므로 conflict가 나면 다시 읽어 씁니다.
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := k8sClient.Get(ctx, key, dep); err != nil {
+			return err
+		}
+		dep.Status = appsv1.DeploymentStatus{
+			ObservedGeneration: dep.Generation,
+			Replicas:           int32(replicas),
+			UpdatedReplicas:    int32(replicas),
+			ReadyReplicas:      int32(ready),
+			AvailableReplicas:  int32(ready),
+		}
+		return k8sClient.Status().Update(ctx, dep)
+	})
+	Expect(err).NotTo(HaveOccurred())
+	return pods
+}
+
+// kubelet이 기록하는 pod status
+func setFakePodStatus(pod *corev1.Pod, i int, ready bool) {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	now := metav1.Now()
+	pod.Status = corev1.PodStatus{
+		Phase:     corev1.PodRunning,
+		PodIP:     fmt.Sprintf("10.0.0.%d", i+1),
+		HostIP:    "10.0.1.1",
+		StartTime: &now,
+		Conditions: []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionTrue, LastTransitionTime: now},
+			{Type: corev1.PodReady, Status: status, LastTransitionTime: now},
+			{Type: corev1.ContainersReady, Status: status, LastTransitionTime: now},
+		},
+	}
+	for _, c := range pod.Spec.Containers {
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:    c.Name,
+			Image:   c.Image,
+			ImageID: "docker-pullable://" + c.Image,
+			Ready:   ready,
+			Started: &ready,
+			State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: now}},
+		})
+	}
+}
+
+func containsPod(pods []corev1.Pod, name string) bool {
+	for _, p := range pods {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
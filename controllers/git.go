@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// branch를 다시 확인하는 간격. branch가 움직이면 이 간격 안에 새 commit이 배포됩니다.
+	gitPollInterval = 5 * time.Minute
+	// 같은 저장소와 revision을 reconcile 마다 묻지 않도록 확인한 commit을 기억하는 시간
+	gitResolveCacheTTL = time.Minute
+)
+
+// 40자 (SHA-1) 또는 64자 (SHA-256) commit SHA
+var commitSHA = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// GitResolver는 spec.content.git의 revision (branch, tag 또는 빈 값)을 commit SHA로 바꿉니다.
+type GitResolver interface {
+	ResolveRevision(ctx context.Context, repository, revision string) (string, error)
+}
+
+// defaultGitResolver는 DemoReconciler.GitResolver가 nil일 때 사용합니다.
+var defaultGitResolver GitResolver = &httpGitResolver{
+	client: &http.Client{Timeout: 10 * time.Second},
+	ttl:    gitResolveCacheTTL,
+}
+
+func (r *DemoReconciler) gitResolver() GitResolver {
+	if r.GitResolver == nil {
+		return defaultGitResolver
+	}
+	return r.GitResolver
+}
+
+// httpGitResolver는 git ls-remote처럼 smart HTTP 프로토콜의 ref 목록에서 revision을 찾습니다.
+// operator 이미지에 git이 없으므로 저장소를 clone 하지 않고 ref 목록만 읽습니다.
+type httpGitResolver struct {
+	client *http.Client
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]resolvedRevision
+}
+
+type resolvedRevision struct {
+	sha     string
+	expires time.Time
+}
+
+func (g *httpGitResolver) ResolveRevision(ctx context.Context, repository, revision string) (string, error) {
+	if commitSHA.MatchString(revision) {
+		return revision, nil
+	}
+
+	key := repository + "#" + revision
+	now := time.Now()
+	g.mu.Lock()
+	cached, ok := g.cache[key]
+	g.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.sha, nil
+	}
+
+	refs, err := g.listRefs(ctx, repository)
+	if err != nil {
+		return "", err
+	}
+	sha, ok := lookupRef(refs, revision)
+	if !ok {
+		if revision == "" {
+			return "", fmt.Errorf("%s has no default branch", repository)
+		}
+		return "", fmt.Errorf("revision %q not found in %s", revision, repository)
+	}
+
+	g.mu.Lock()
+	if g.cache == nil {
+		g.cache = map[string]resolvedRevision{}
+	}
+	g.cache[key] = resolvedRevision{sha: sha, expires: now.Add(g.ttl)}
+	g.mu.Unlock()
+	return sha, nil
+}
+
+// listRefs는 저장소의 ref 이름과 commit SHA를 읽습니다.
+func (g *httpGitResolver) listRefs(ctx context.Context, repository string) (map[string]string, error) {
+	u, err := url.Parse(repository)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("cannot resolve revisions of %s, only http and https repositories are supported unless spec.content.git.revision is a commit SHA", repository)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/info/refs"
+	u.RawQuery = "service=git-upload-pack"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// 일부 Git 서버는 git client에만 smart HTTP로 응답합니다.
+	req.Header.Set("User-Agent", "git/demo-operator")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing refs of %s: %s", repository, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-git-upload-pack-advertisement" {
+		return nil, fmt.Errorf("listing refs of %s: unexpected content type %q, the server does not speak smart HTTP", repository, ct)
+	}
+	return parseRefs(resp.Body)
+}
+
+// parseRefs는 git-upload-pack의 ref advertisement (pkt-line)를 읽습니다.
+// 첫 ref 뒤의 capability 목록과 "# service=" 줄은 무시합니다.
+func parseRefs(r io.Reader) (map[string]string, error) {
+	refs := map[string]string{}
+	br := bufio.NewReader(r)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(br, size[:]); err == io.EOF {
+			return refs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseUint(string(size[:]), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pkt-line length %q", size[:])
+		}
+		if n == 0 { // flush-pkt
+			continue
+		}
+		if n < 4 {
+			return nil, fmt.Errorf("invalid pkt-line length %d", n)
+		}
+		data := make([]byte, n-4)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+
+		line := strings.TrimSuffix(string(data), "\n")
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("git server: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexByte(line, 0); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || !commitSHA.MatchString(fields[0]) {
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+}
+
+// lookupRef는 git rev-parse와 같은 순서로 revision에 맞는 ref를 찾습니다. 빈 revision은 HEAD입니다.
+// annotated tag는 tag 객체가 아니라 tag가 가리키는 commit을 반환합니다.
+func lookupRef(refs map[string]string, revision string) (string, bool) {
+	if revision == "" {
+		revision = "HEAD"
+	}
+	for _, name := range []string{revision, "refs/" + revision, "refs/tags/" + revision, "refs/heads/" + revision} {
+		if sha, ok := refs[name+"^{}"]; ok {
+			return sha, true
+		}
+		if sha, ok := refs[name]; ok {
+			return sha, true
+		}
+	}
+	return "", false
+}
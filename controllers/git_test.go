@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pktLines는 git-upload-pack의 ref advertisement를 만듭니다. 빈 줄은 flush-pkt입니다.
+func pktLines(lines ...string) string {
+	var b strings.Builder
+	for _, l := range lines {
+		if l == "" {
+			b.WriteString("0000")
+			continue
+		}
+		fmt.Fprintf(&b, "%04x%s", len(l)+4, l)
+	}
+	return b.String()
+}
+
+const (
+	mainSHA   = "1111111111111111111111111111111111111111"
+	tagSHA    = "2222222222222222222222222222222222222222"
+	taggedSHA = "3333333333333333333333333333333333333333"
+)
+
+func TestHTTPGitResolver(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/site.git/info/refs" || r.URL.Query().Get("service") != "git-upload-pack" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		fmt.Fprint(w, pktLines(
+			"# service=git-upload-pack\n",
+			"",
+			mainSHA+" HEAD\x00multi_ack symref=HEAD:refs/heads/main\n",
+			mainSHA+" refs/heads/main\n",
+			tagSHA+" refs/tags/v1\n",
+			taggedSHA+" refs/tags/v1^{}\n",
+			"",
+		))
+	}))
+	defer srv.Close()
+
+	g := &httpGitResolver{client: srv.Client(), ttl: time.Minute}
+	tests := []struct {
+		revision string
+		want     string
+	}{
+		{revision: "", want: mainSHA},
+		{revision: "main", want: mainSHA},
+		{revision: "refs/heads/main", want: mainSHA},
+		// annotated tag는 tag가 가리키는 commit
+		{revision: "v1", want: taggedSHA},
+		// commit SHA는 저장소에 묻지 않습니다.
+		{revision: tagSHA, want: tagSHA},
+	}
+	for _, tt := range tests {
+		got, err := g.ResolveRevision(context.Background(), srv.URL+"/site.git", tt.revision)
+		if err != nil {
+			t.Errorf("revision %q: %v", tt.revision, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("revision %q: got %s, want %s", tt.revision, got, tt.want)
+		}
+	}
+	if requests != 4 {
+		t.Errorf("got %d requests, want one per branch or tag", requests)
+	}
+
+	// 같은 revision은 ttl 동안 다시 묻지 않습니다.
+	if _, err := g.ResolveRevision(context.Background(), srv.URL+"/site.git", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 4 {
+		t.Errorf("got %d requests, want the cached commit", requests)
+	}
+
+	if _, err := g.ResolveRevision(context.Background(), srv.URL+"/site.git", "missing"); err == nil {
+		t.Error("expected an error for a missing revision")
+	}
+	if _, err := g.ResolveRevision(context.Background(), srv.URL+"/other.git", "main"); err == nil {
+		t.Error("expected an error for a missing repository")
+	}
+	if _, err := g.ResolveRevision(context.Background(), "git@example.com:site.git", "main"); err == nil {
+		t.Error("expected an error for an ssh repository")
+	}
+}
@@ -0,0 +1,459 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// Active 상태에서 pod의 요청 수를 확인하는 주기
+	activityPollInterval = 30 * time.Second
+	// Waking 상태에서 pod가 준비됐는지 확인하는 주기
+	wakePollInterval = 2 * time.Second
+	// lastActivityTime은 분 단위로만 갱신해 status 업데이트를 줄입니다.
+	activityResolution = time.Minute
+)
+
+// stub_status, exporter metrics 조회용 http client
+var stubStatusClient = &http.Client{Timeout: 2 * time.Second}
+
+// activity는 이번 reconcile에서 결정된 idle 상태입니다.
+type activity struct {
+	State        demoappv2.ActivityState
+	LastActivity *metav1.Time
+	RequeueAfter time.Duration
+}
+
+// Idle, Waking 상태에서는 Service가 액티베이터를 가리킵니다.
+func (a activity) toActivator() bool {
+	return a.State == demoappv2.ActivityIdle || a.State == demoappv2.ActivityWaking
+}
+
+// idle 상태에서는 replicas를 0으로 내립니다.
+func (a activity) replicas(size int32) int32 {
+	if a.State == demoappv2.ActivityIdle {
+		return 0
+	}
+	return size
+}
+
+// 액티베이터로 Service를 돌릴 수 있는지 확인합니다.
+func (r *DemoReconciler) activatorReady() bool {
+	if r.Activator == nil {
+		return false
+	}
+	_, _, ok := r.Activator.Address()
+	return ok
+}
+
+// status.activity와 selector로 고른 pod의 요청 수로 이번 reconcile의 idle 상태를 결정합니다.
+//
+//	Active --(spec.scaling.idle.afterMinutes 동안 요청 없음)--> Idle
+//	Idle   --(액티베이터로 요청 들어옴)--> Waking
+//	Waking --(ready pod 생김)--> Active
+func (r *DemoReconciler) observeActivity(ctx context.Context, cr *demoappv2.Demo, selector map[string]string, readyReplicas int32, size int32, now time.Time) activity {
+	logger := log.FromContext(ctx)
+	nn := types.NamespacedName{Namespace: cr.Namespace, Name: cr.Name}
+
+	// idle 설정이 없거나 액티베이터를 쓸 수 없으면 상태를 추적하지 않습니다.
+	// 스케줄로 0이 된 경우에도 요청으로 깨우지 않습니다.
+	if cr.Spec.Scaling.Idle == nil || size == 0 || !r.activatorReady() {
+		r.requests.forget(nn)
+		if r.Activator != nil {
+			r.Activator.Unregister(nn)
+		}
+		return activity{}
+	}
+
+	state := cr.Status.Activity
+	if state == "" {
+		state = demoappv2.ActivityActive
+	}
+	last := cr.Status.LastActivityTime
+
+	if state == demoappv2.ActivityIdle {
+		r.requests.forget(nn)
+		// operator가 재시작된 경우에도 액티베이터가 요청을 받을 수 있도록 매번 등록합니다.
+		r.Activator.Register(nn, r.serviceName(cr))
+		if !r.Activator.WakeRequested(nn) {
+			return activity{State: demoappv2.ActivityIdle, LastActivity: last}
+		}
+		logger.Info("Wake requested")
+		state = demoappv2.ActivityWaking
+	}
+
+	if state == demoappv2.ActivityWaking {
+		r.Activator.Register(nn, r.serviceName(cr))
+		if readyReplicas == 0 {
+			return activity{State: demoappv2.ActivityWaking, LastActivity: last, RequeueAfter: wakePollInterval}
+		}
+		// pod가 준비되면 다시 Active로 돌아가고, idle 타이머를 새로 시작합니다.
+		r.Activator.Unregister(nn)
+		r.requests.forget(nn)
+		last = &metav1.Time{Time: now}
+	}
+
+	// Active: 요청 수는 requestPoller가 reconcile 밖에서 읽습니다. 여기서는 Demo를 등록하고,
+	// poller가 마지막으로 요청을 본 시각으로 마지막 활동 시각을 갱신합니다.
+	r.requests.watch(nn, selector, hasSidecar(cr, demoappv2.NginxPrometheusExporter))
+	if last == nil {
+		last = &metav1.Time{Time: now}
+	} else if t := r.requests.lastActivity(nn); t.Sub(last.Time) >= activityResolution {
+		last = &metav1.Time{Time: t}
+	}
+
+	idleAt := last.Add(time.Duration(cr.Spec.Scaling.Idle.AfterMinutes) * time.Minute)
+	if !now.Before(idleAt) {
+		logger.Info("No requests, scaling to zero", "lastActivity", last.Time)
+		r.requests.forget(nn)
+		r.Activator.Register(nn, r.serviceName(cr))
+		return activity{State: demoappv2.ActivityIdle, LastActivity: last}
+	}
+
+	requeue := activityPollInterval
+	if d := idleAt.Sub(now); d < requeue {
+		requeue = d
+	}
+	return activity{State: demoappv2.ActivityActive, LastActivity: last, RequeueAfter: requeue}
+}
+
+// requestPoller는 idle 설정이 있는 Active Demo의 pod 요청 수를 reconcile 밖에서 주기적으로 읽고,
+// 요청이 늘어난 마지막 시각을 기억합니다. reconcile은 Demo를 등록하고 그 시각만 읽으므로
+// pod로 HTTP 요청을 보내며 기다리지 않습니다.
+type requestPoller struct {
+	client   client.Reader
+	interval time.Duration
+	fetch    func(ctx context.Context, podIP string, exporter bool) (uint64, error)
+
+	mu    sync.Mutex
+	demos map[types.NamespacedName]*polledDemo
+}
+
+type polledDemo struct {
+	selector map[string]string
+	// nginx-prometheus-exporter가 있으면 exporter의 metrics로 요청 수를 읽습니다.
+	exporter bool
+	// pod별로 마지막에 읽은 요청 수
+	counts       map[types.UID]uint64
+	lastActivity time.Time
+}
+
+// Demo를 조회 대상으로 등록하거나 selector를 갱신합니다.
+// 요청 수를 읽는 방법이 바뀌면 이전 값과 비교할 수 없으므로 기준값을 다시 잡습니다.
+func (p *requestPoller) watch(nn types.NamespacedName, selector map[string]string, exporter bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.demos == nil {
+		p.demos = map[types.NamespacedName]*polledDemo{}
+	}
+	d, ok := p.demos[nn]
+	if !ok || d.exporter != exporter {
+		d = &polledDemo{}
+		p.demos[nn] = d
+	}
+	d.selector = selector
+	d.exporter = exporter
+}
+
+func (p *requestPoller) forget(nn types.NamespacedName) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.demos, nn)
+}
+
+// 마지막으로 요청이 늘어난 시각. 아직 요청을 보지 못했으면 zero time을 반환합니다.
+func (p *requestPoller) lastActivity(nn types.NamespacedName) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d, ok := p.demos[nn]; ok {
+		return d.lastActivity
+	}
+	return time.Time{}
+}
+
+// Start는 manager가 실행하며, ctx가 끝날 때까지 interval마다 등록된 Demo의 pod를 조회합니다.
+func (p *requestPoller) Start(ctx context.Context) error {
+	interval := p.interval
+	if interval == 0 {
+		interval = activityPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.pollAll(ctx, time.Now())
+		}
+	}
+}
+
+func (p *requestPoller) pollAll(ctx context.Context, now time.Time) {
+	type target struct {
+		nn       types.NamespacedName
+		selector map[string]string
+		exporter bool
+	}
+	p.mu.Lock()
+	targets := make([]target, 0, len(p.demos))
+	for nn, d := range p.demos {
+		targets = append(targets, target{nn: nn, selector: d.selector, exporter: d.exporter})
+	}
+	p.mu.Unlock()
+
+	for _, t := range targets {
+		if ctx.Err() != nil {
+			return
+		}
+		counts := p.poll(ctx, t.nn, t.selector, t.exporter)
+		p.observe(t.nn, t.exporter, counts, now)
+	}
+}
+
+// Demo의 pod마다 요청 수를 읽습니다. 읽지 못한 pod는 결과에서 빠집니다.
+func (p *requestPoller) poll(ctx context.Context, nn types.NamespacedName, selector map[string]string, exporter bool) map[types.UID]uint64 {
+	logger := log.FromContext(ctx).WithValues(objectKeys("Demo", nn.Namespace, nn.Name)...)
+
+	podList := &corev1.PodList{}
+	if err := p.client.List(ctx, podList, client.InNamespace(nn.Namespace), client.MatchingLabels(selector)); err != nil {
+		logger.Error(err, "Failed to list Pods")
+		return nil
+	}
+
+	fetch := p.fetch
+	if fetch == nil {
+		fetch = fetchRequestCount
+	}
+	counts := map[types.UID]uint64{}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		n, err := fetch(ctx, pod.Status.PodIP, exporter)
+		if err != nil {
+			logger.V(logDebug).Info("Failed to read request count", append(objectKeys("Pod", pod.Namespace, pod.Name), "error", err.Error())...)
+			continue
+		}
+		counts[pod.UID] = n
+	}
+	return counts
+}
+
+// 새로 읽은 요청 수를 저장하고, 지난 조회 이후 요청이 있었으면 now를 마지막 활동 시각으로 기록합니다.
+// stub_status를 직접 읽으면 그 조회도 요청 1개로 집계되므로 1보다 많이 늘어난 경우만 활동으로 봅니다.
+// exporter의 값은 조회와 scrape를 이미 뺀 값이라서 늘어나기만 하면 활동입니다.
+func (p *requestPoller) observe(nn types.NamespacedName, exporter bool, counts map[types.UID]uint64, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	d, ok := p.demos[nn]
+	// 조회하는 동안 forget 됐거나 읽는 방법이 바뀐 Demo의 결과는 버립니다.
+	if !ok || d.exporter != exporter {
+		return false
+	}
+	var overhead uint64
+	if !exporter {
+		overhead = 1
+	}
+
+	active := false
+	for uid, n := range counts {
+		if prev, ok := d.counts[uid]; ok && n > prev+overhead {
+			active = true
+		}
+	}
+	d.counts = counts
+	if active {
+		d.lastActivity = now
+	}
+	return active
+}
+
+// pod의 누적 요청 수를 읽습니다. nginx-prometheus-exporter가 있으면 exporter도 scrape 할 때마다
+// stub_status를 조회하므로, exporter의 metrics에서 scrape 수를 뺀 값을 사용합니다.
+// nginx 컨테이너에는 probe가 없고 exporter의 probe는 stub_status를 조회하지 않습니다.
+func fetchRequestCount(ctx context.Context, podIP string, exporter bool) (uint64, error) {
+	if exporter {
+		url := fmt.Sprintf("http://%s/metrics", net.JoinHostPort(podIP, strconv.Itoa(exporterPort)))
+		return getCount(ctx, url, parseExporterMetrics)
+	}
+	url := fmt.Sprintf("http://%s/stub_status", net.JoinHostPort(podIP, strconv.Itoa(stubStatusPort)))
+	return getCount(ctx, url, parseStubStatus)
+}
+
+func getCount(ctx context.Context, url string, parse func(io.Reader) (uint64, error)) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := stubStatusClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return parse(resp.Body)
+}
+
+// stub_status 응답에서 requests 값을 꺼냅니다.
+//
+//	Active connections: 1
+//	server accepts handled requests
+//	 7 7 7
+//	Reading: 0 Writing: 1 Waiting: 0
+func parseStubStatus(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "server accepts handled requests" {
+			continue
+		}
+		if !scanner.Scan() {
+			break
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			return 0, fmt.Errorf("unexpected stub_status counters %q", scanner.Text())
+		}
+		return strconv.ParseUint(fields[2], 10, 64)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("stub_status counters not found")
+}
+
+// exporter metrics에서 exporter의 scrape를 뺀 nginx 요청 수를 계산합니다.
+//
+//	nginx_http_requests_total 42
+//	promhttp_metric_handler_requests_total{code="200"} 40
+//
+// exporter는 /metrics 요청마다 stub_status를 한 번 조회하고, 요청이 끝난 뒤 promhttp 카운터를 올립니다.
+// 그래서 이번 조회를 포함한 모든 scrape가 빠지고 Service로 들어온 요청만 남습니다.
+func parseExporterMetrics(r io.Reader) (uint64, error) {
+	var requests, scrapes float64
+	found := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name := fields[0]
+		if i := strings.IndexByte(name, '{'); i >= 0 {
+			name = name[:i]
+		}
+		if name != "nginx_http_requests_total" && name != "promhttp_metric_handler_requests_total" {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected metric %q", line)
+		}
+		if name == "nginx_http_requests_total" {
+			requests, found = v, true
+		} else {
+			scrapes += v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	// exporter가 nginx를 조회하지 못하면 nginx_http_requests_total이 없습니다.
+	if !found {
+		return 0, fmt.Errorf("nginx_http_requests_total not found")
+	}
+	if requests < scrapes {
+		return 0, nil
+	}
+	return uint64(requests - scrapes), nil
+}
+
+// Service가 selector의 pod 또는 액티베이터를 가리키도록 합니다.
+// 액티베이터로 돌릴 때는 selector를 지우고 Endpoints를 직접 관리합니다.
+func (r *DemoReconciler) routeService(ctx context.Context, cr *demoappv2.Demo, svc *corev1.Service, selector map[string]string, toActivator bool) error {
+	logger := log.FromContext(ctx)
+
+	if !toActivator {
+		if reflect.DeepEqual(svc.Spec.Selector, selector) {
+			return nil
+		}
+		// selector가 돌아오면 endpoints controller가 Endpoints를 pod 주소로 다시 채웁니다.
+		svc.Spec.Selector = selector
+		logger.Info("Routing Service to pods", objectKeys("Service", svc.Namespace, svc.Name)...)
+		return r.Client.Update(ctx, svc)
+	}
+
+	if svc.Spec.Selector != nil {
+		svc.Spec.Selector = nil
+		logger.Info("Routing Service to activator", objectKeys("Service", svc.Namespace, svc.Name)...)
+		if err := r.Client.Update(ctx, svc); err != nil {
+			return err
+		}
+	}
+
+	return r.ensureActivatorEndpoints(ctx, cr, svc)
+}
+
+// selector가 없는 Service의 Endpoints를 액티베이터 주소로 맞춥니다.
+func (r *DemoReconciler) ensureActivatorEndpoints(ctx context.Context, cr *demoappv2.Demo, svc *corev1.Service) error {
+	ip, port, _ := r.Activator.Address()
+
+	// 액티베이터는 HTTP만 proxy 하므로 http 포트만 액티베이터로 보냅니다.
+	// https, metrics 포트는 idle 동안 endpoint가 없어 연결이 거부됩니다.
+	subsets := []corev1.EndpointSubset{{
+		Addresses: []corev1.EndpointAddress{{IP: ip}},
+		Ports:     []corev1.EndpointPort{{Name: "http", Port: port, Protocol: corev1.ProtocolTCP}},
+	}}
+
+	ep := &corev1.Endpoints{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, ep)
+	if errors.IsNotFound(err) {
+		ep = &corev1.Endpoints{
+			ObjectMeta: objectMeta(cr, svc.Name, componentServer),
+			Subsets:    subsets,
+		}
+		if err := ctrl.SetControllerReference(cr, ep, r.Scheme); err != nil {
+			return err
+		}
+		return r.Client.Create(ctx, ep)
+	}
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(ep.Subsets, subsets) {
+		return nil
+	}
+	ep.Subsets = subsets
+	return r.Client.Update(ctx, ep)
+}
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"demo-operator/activator"
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestParseStubStatus(t *testing.T) {
+	body := "Active connections: 1 \nserver accepts handled requests\n 7 7 42 \nReading: 0 Writing: 1 Waiting: 0 \n"
+
+	n, err := parseStubStatus(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 42 {
+		t.Errorf("got %d requests, want 42", n)
+	}
+
+	if _, err := parseStubStatus(strings.NewReader("<html>not stub_status</html>")); err == nil {
+		t.Error("expected error for unexpected body")
+	}
+}
+
+func TestParseExporterMetrics(t *testing.T) {
+	body := `# HELP nginx_http_requests_total Total http requests
+# TYPE nginx_http_requests_total counter
+nginx_http_requests_total 57
+# HELP nginx_up Status of the last metric scrape
+nginx_up 1
+promhttp_metric_handler_requests_total{code="200"} 40
+promhttp_metric_handler_requests_total{code="500"} 2
+promhttp_metric_handler_requests_total{code="503"} 0
+`
+	n, err := parseExporterMetrics(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 15 {
+		t.Errorf("got %d requests, want 15", n)
+	}
+
+	// nginx를 조회하지 못한 exporter
+	if _, err := parseExporterMetrics(strings.NewReader("nginx_up 0\n")); err == nil {
+		t.Error("expected error without nginx_http_requests_total")
+	}
+}
+
+func TestRequestPollerObserve(t *testing.T) {
+	var p requestPoller
+	nn := types.NamespacedName{Namespace: "default", Name: "web"}
+	p.watch(nn, map[string]string{"app": "web"}, false)
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		counts map[types.UID]uint64
+		want   bool
+	}{
+		{counts: map[types.UID]uint64{"a": 10}, want: false},         // 첫 조회는 기준값만 저장
+		{counts: map[types.UID]uint64{"a": 11}, want: false},         // 직전 stub_status 조회 1건
+		{counts: map[types.UID]uint64{"a": 15}, want: true},          // 실제 요청
+		{counts: map[types.UID]uint64{"a": 16, "b": 3}, want: false}, // 새 pod는 기준값만 저장
+		{counts: map[types.UID]uint64{"a": 17, "b": 9}, want: true},  // 새 pod로 들어온 요청
+		{counts: map[types.UID]uint64{"a": 1, "b": 10}, want: false}, // 재시작된 컨테이너
+	}
+
+	for i, s := range steps {
+		now := start.Add(time.Duration(i) * time.Minute)
+		if got := p.observe(nn, false, s.counts, now); got != s.want {
+			t.Errorf("step %d: got %v, want %v", i, got, s.want)
+		}
+	}
+	if got, want := p.lastActivity(nn), start.Add(4*time.Minute); !got.Equal(want) {
+		t.Errorf("lastActivity = %v, want %v", got, want)
+	}
+
+	// exporter 값은 조회와 scrape가 빠져 있으므로 1만 늘어도 요청입니다.
+	p.watch(nn, map[string]string{"app": "web"}, true)
+	if !p.lastActivity(nn).IsZero() {
+		t.Error("switching to the exporter should reset the poller state")
+	}
+	p.observe(nn, true, map[types.UID]uint64{"a": 5}, start)
+	if p.observe(nn, true, map[types.UID]uint64{"a": 5}, start) {
+		t.Error("unchanged exporter count reported as activity")
+	}
+	if !p.observe(nn, true, map[types.UID]uint64{"a": 6}, start) {
+		t.Error("one request through the exporter not reported as activity")
+	}
+
+	// forget 된 Demo의 조회 결과는 버립니다.
+	p.forget(nn)
+	if p.observe(nn, true, map[types.UID]uint64{"a": 100}, start) || !p.lastActivity(nn).IsZero() {
+		t.Error("forgotten Demo should not record activity")
+	}
+}
+
+// requestPoller는 등록된 Demo의 running pod만 읽고, exporter 여부를 fetch에 넘깁니다.
+func TestRequestPollerPollAll(t *testing.T) {
+	pod := func(name, ip string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name), Labels: map[string]string{"app": "web"}},
+			Status:     corev1.PodStatus{Phase: phase, PodIP: ip},
+		}
+	}
+	r, _ := newPipelineTest(t, pod("web-0", "10.0.0.1", corev1.PodRunning), pod("web-1", "10.0.0.2", corev1.PodPending))
+
+	counts := map[string]uint64{"10.0.0.1": 3}
+	var fetched []string
+	p := &requestPoller{
+		client: r.Client,
+		fetch: func(ctx context.Context, podIP string, exporter bool) (uint64, error) {
+			if !exporter {
+				t.Errorf("fetch for %s without exporter", podIP)
+			}
+			fetched = append(fetched, podIP)
+			return counts[podIP], nil
+		},
+	}
+	nn := types.NamespacedName{Namespace: "default", Name: "web"}
+	p.watch(nn, map[string]string{"app": "web"}, true)
+
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	p.pollAll(context.Background(), now)
+	counts["10.0.0.1"] = 4
+	p.pollAll(context.Background(), now.Add(time.Minute))
+
+	if !reflect.DeepEqual(fetched, []string{"10.0.0.1", "10.0.0.1"}) {
+		t.Errorf("fetched %v, want only the running pod", fetched)
+	}
+	if got := p.lastActivity(nn); !got.Equal(now.Add(time.Minute)) {
+		t.Errorf("lastActivity = %v, want %v", got, now.Add(time.Minute))
+	}
+}
+
+// idle Demo의 Endpoints는 액티베이터가 proxy 하는 http 포트만 가집니다.
+func TestEnsureActivatorEndpointsOnlyHTTP(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Pod: demoappv2.PodSpec{Sidecars: []demoappv2.BuiltinSidecar{demoappv2.NginxPrometheusExporter}},
+		},
+	}
+	r, _ := newPipelineTest(t, cr)
+	a, err := activator.New(r.Client, activator.Options{BindAddress: ":8090", PodIP: "10.0.0.9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Activator = a
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: servicePorts(cr)},
+	}
+	if len(svc.Spec.Ports) < 2 {
+		t.Fatalf("expected http and metrics Service ports, got %v", svc.Spec.Ports)
+	}
+	if err := r.ensureActivatorEndpoints(context.Background(), cr, svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ep := &corev1.Endpoints{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, ep); err != nil {
+		t.Fatal(err)
+	}
+	want := []corev1.EndpointPort{{Name: "http", Port: 8090, Protocol: corev1.ProtocolTCP}}
+	if len(ep.Subsets) != 1 || !reflect.DeepEqual(ep.Subsets[0].Ports, want) {
+		t.Errorf("Endpoints subsets = %+v, want only port %+v", ep.Subsets, want)
+	}
+}
+
+// 긴 Demo 이름은 Service 이름에서 잘리므로 액티베이터에는 Service 이름으로 등록합니다.
+func TestObserveActivityRegistersServiceName(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 70), Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1, Idle: &demoappv2.IdleSpec{AfterMinutes: 5}},
+		},
+		Status: demoappv2.DemoStatus{Activity: demoappv2.ActivityIdle},
+	}
+	r, _ := newPipelineTest(t, cr)
+	a, err := activator.New(r.Client, activator.Options{BindAddress: ":8090", PodIP: "10.0.0.9", Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Activator = a
+
+	act := r.observeActivity(context.Background(), cr, selectorLabels(cr), 0, 1, time.Now())
+	if act.State != demoappv2.ActivityIdle {
+		t.Fatalf("state = %s, want Idle", act.State)
+	}
+
+	// pod가 없으므로 503이지만, Service 이름으로 Demo를 찾아 깨웁니다.
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+r.serviceName(cr)+".default.svc/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want 503 while waking", rec.Code)
+	}
+	if !a.WakeRequested(types.NamespacedName{Namespace: "default", Name: cr.Name}) {
+		t.Error("expected wake to be requested for the Demo")
+	}
+}
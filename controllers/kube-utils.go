@@ -1,18 +1,81 @@
 package controllers
 
 import (
-	demoappv1 "demo-operator/api/v1"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+
+	demoappv2 "demo-operator/api/v2"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/rand"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// spec.image가 비어 있을 때 사용하는 nginx 이미지
+	defaultImage = "nginx:latest"
+	// spec.service.port가 비어 있을 때 Service가 사용하는 포트
+	defaultServicePort = 80
+
+	// nginx stub_status를 노출하는 포트 (idle 감지에 사용)
+	stubStatusPort = 8081
+
+	// Deployment에 기록하는 pod template 해시. 값이 다르면 template을 다시 맞춥니다.
+	templateHashAnnotation = "demoapp.my.domain/template-hash"
+	// pod template에 기록하는 nginx 설정 해시. 설정이 바뀌면 pod가 다시 배포됩니다.
+	nginxConfigHashAnnotation = "demoapp.my.domain/nginx-config-hash"
+
+	nginxConfigVolume = "nginx-conf"
+
+	// StatefulSet의 volumeClaimTemplate 이름과 기본 마운트 경로
+	storageVolume           = "data"
+	defaultStorageMountPath = "/usr/share/nginx/html"
+	// StatefulSet에 기록하는 retention 정책. Deployment로 옮겨갈 때 PVC 정리에 사용합니다.
+	volumeRetentionAnnotation = "demoapp.my.domain/volume-retention"
 )
 
-// Label을 메소드로 모듈화하여 사용
-func getLabelForCR(crName string) map[string]string {
-	return map[string]string{"app": crName}
+// nginx 기본 설정에 stub_status 전용 server를 추가한 설정입니다.
+// 첫 번째 server는 nginxPort에서 요청을 받습니다.
+const nginxConfTemplate = `server {
+    listen       %d;
+    server_name  localhost;
+
+    location / {
+        root   /usr/share/nginx/html;
+        index  index.html index.htm;
+    }
+
+    error_page   500 502 503 504  /50x.html;
+    location = /50x.html {
+        root   /usr/share/nginx/html;
+    }
+}
+
+server {
+    listen       %d;
+
+    location = /stub_status {
+        stub_status;
+    }
+}
+`
+
+func nginxConf(d *demoappv2.Demo) string {
+	conf := fmt.Sprintf(nginxConfTemplate, nginxPort(d), stubStatusPort)
+	if d.Spec.TLS != nil {
+		conf += nginxTLSServer(d)
+	}
+	return conf
 }
 
 // pod Name List
@@ -24,73 +87,369 @@ func getPodNames(pods []corev1.Pod) []string {
 	return podNames
 }
 
-// Service를 생성하고, 컨트롤러에 등록해 cr이 삭제된 경우 함께 삭제되도록 합니다.
-func (r *DemoReconciler) createService(d *demoappv1.Demo) *corev1.Service {
+// status.pods에 기록할 pod 요약 (이름순)
+func getPodSummaries(pods []corev1.Pod) []demoappv2.PodSummary {
+	var summaries []demoappv2.PodSummary
+	for _, p := range pods {
+		summary := demoappv2.PodSummary{Name: p.Name, NodeName: p.Spec.NodeName, Phase: p.Status.Phase}
+		for _, c := range p.Status.Conditions {
+			if c.Type == corev1.PodReady {
+				summary.Ready = c.Status == corev1.ConditionTrue
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+// nginx 설정 ConfigMap이 필요한지 확인합니다.
+// Restricted에서는 이미지의 설정 대신 root가 아니어도 열 수 있는 포트를 사용해야 합니다.
+func needsNginxConfig(d *demoappv2.Demo) bool {
+	return d.Spec.Scaling.Idle != nil || hasSidecar(d, demoappv2.NginxPrometheusExporter) || restricted(d) || d.Spec.TLS != nil
+}
+
+// nginx 설정 ConfigMap 이름
+func (r *DemoReconciler) nginxConfigName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "-nginx", maxNameLength)
+}
+
+// 객체를 json으로 직렬화한 해시를 만듭니다.
+func hashObject(obj interface{}) string {
+	data, _ := json.Marshal(obj)
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// stub_status가 켜진 nginx 설정 ConfigMap을 생성하고 컨트롤러에 등록합니다.
+func (r *DemoReconciler) createNginxConfig(d *demoappv2.Demo) (*corev1.ConfigMap, error) {
+
+	newCm := &corev1.ConfigMap{
+		ObjectMeta: objectMeta(d, r.nginxConfigName(d), componentConfig),
+		Data: map[string]string{
+			"default.conf": nginxConf(d),
+		},
+	}
+
+	if err := ctrl.SetControllerReference(d, newCm, r.Scheme); err != nil {
+		return nil, err
+	}
+	return newCm, nil
+}
 
-	label := getLabelForCR(d.Name)
+// operator가 관리하는 ConfigMap을 만들거나 내용을 맞춥니다. 변경한 경우 true를 반환합니다.
+func (r *DemoReconciler) ensureConfigMap(ctx context.Context, s *reconcileState, newCm *corev1.ConfigMap) (bool, error) {
+	logger := log.FromContext(ctx)
+
+	cm := &corev1.ConfigMap{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: newCm.Name, Namespace: newCm.Namespace}, cm)
+	if errors.IsNotFound(err) {
+		err = r.Create(ctx, newCm)
+		if err != nil {
+			logger.Error(err, "Failed to create ConfigMap", objectKeys("ConfigMap", newCm.Namespace, newCm.Name)...)
+			return false, err
+		}
+
+		logger.Info("ConfigMap created", objectKeys("ConfigMap", newCm.Namespace, newCm.Name)...)
+		return true, nil
+	}
+	if err != nil {
+		logger.Error(err, "Failed to get ConfigMap", objectKeys("ConfigMap", newCm.Namespace, newCm.Name)...)
+		return false, err
+	}
+	if owned, err := r.claim(ctx, s, cm); err != nil || !owned {
+		return false, err
+	}
+
+	// 설정 내용이나 label이 다르면 맞춰줍니다.
+	changed := mergeMetadata(cm, newCm)
+	if !changed && reflect.DeepEqual(cm.Data, newCm.Data) {
+		return false, nil
+	}
+	cm.Data = newCm.Data
+	err = r.Client.Update(ctx, cm)
+	if err != nil {
+		logger.Error(err, "Failed to update ConfigMap", objectKeys("ConfigMap", cm.Namespace, cm.Name)...)
+		return false, err
+	}
+	return true, nil
+}
+
+// nginx 컨테이너 이미지 (기본값은 operator 설정의 defaultImage, 없으면 nginx:latest)
+func (r *DemoReconciler) nginxImage(d *demoappv2.Demo) string {
+	switch {
+	case d.Spec.Image != "":
+		return d.Spec.Image
+	case r.DefaultImage != "":
+		return r.DefaultImage
+	}
+	return defaultImage
+}
+
+// nginx 컨테이너에 적용할 resource profile 이름 (없으면 operator 설정의 기본 profile)
+func (r *DemoReconciler) resourceProfile(d *demoappv2.Demo) string {
+	if d.Spec.Pod.ResourceProfile != "" {
+		return d.Spec.Pod.ResourceProfile
+	}
+	return r.DefaultResourceProfile
+}
+
+// resource profile의 resource requirements (profile이 없으면 비어 있습니다)
+func (r *DemoReconciler) nginxResources(d *demoappv2.Demo) corev1.ResourceRequirements {
+	profile := r.ResourceProfiles[r.resourceProfile(d)]
+	return *profile.DeepCopy()
+}
+
+// spec.pod.resourceProfile이 operator 설정에 있는 profile인지 확인합니다.
+func (r *DemoReconciler) validateResourceProfile(d *demoappv2.Demo) error {
+	name := r.resourceProfile(d)
+	if name == "" {
+		return nil
+	}
+	if _, ok := r.ResourceProfiles[name]; !ok {
+		return fmt.Errorf("resource profile %q is not defined in the operator config", name)
+	}
+	return nil
+}
+
+// Service 종류 (기본값 ClusterIP)
+func serviceType(d *demoappv2.Demo) corev1.ServiceType {
+	if d.Spec.Service.Type == "" {
+		return corev1.ServiceTypeClusterIP
+	}
+	return d.Spec.Service.Type
+}
+
+// Service 포트. 내장 sidecar가 있으면 sidecar 포트도 함께 노출합니다.
+func servicePorts(d *demoappv2.Demo) []corev1.ServicePort {
+	port := d.Spec.Service.Port
+	if port == 0 {
+		port = defaultServicePort
+	}
+
+	ports := []corev1.ServicePort{
+		{
+			Name:       "http",
+			Protocol:   corev1.ProtocolTCP,
+			Port:       port,
+			TargetPort: intstr.FromString("http"),
+		},
+	}
+	ports = append(ports, tlsServicePorts(d)...)
+	return append(ports, sidecarServicePorts(d)...)
+}
+
+// 현재 Service 포트가 원하는 포트와 같은지 확인합니다.
+// NodePort는 클러스터가 할당하므로 비교하지 않습니다.
+func servicePortsEqual(current, desired []corev1.ServicePort) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+	for i := range desired {
+		p := current[i]
+		p.NodePort = 0
+		if !reflect.DeepEqual(p, desired[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Service를 생성하고, 컨트롤러에 등록해 cr이 삭제된 경우 함께 삭제되도록 합니다.
+func (r *DemoReconciler) createService(d *demoappv2.Demo) (*corev1.Service, error) {
 
 	// service yaml을 하드코딩으로 정의
 	newSvc := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      d.Name,
-			Namespace: d.Namespace,
-		},
+		ObjectMeta: objectMeta(d, r.serviceName(d), componentServer),
 		Spec: corev1.ServiceSpec{
-			Type:     corev1.ServiceTypeClusterIP,
-			Selector: label,
-			Ports: []corev1.ServicePort{
-				{
-					Protocol:   corev1.ProtocolTCP,
-					Port:       80,
-					TargetPort: intstr.IntOrString{IntVal: 80},
-				},
-			},
+			Type:     serviceType(d),
+			Selector: selectorLabels(d),
+			Ports:    servicePorts(d),
 		},
 	} // svc 정의 끝
 
 	// cr이 삭제됐을때 svc가 남아있는걸 막기 위해 ref에 추가
-	ctrl.SetControllerReference(d, newSvc, r.Scheme)
-	return newSvc
+	if err := ctrl.SetControllerReference(d, newSvc, r.Scheme); err != nil {
+		return nil, err
+	}
+	return newSvc, nil
+}
+
+// Deployment와 StatefulSet이 함께 사용하는 pod template을 정의합니다.
+// contentRevision은 spec.content의 revision으로, 바뀌면 pod가 다시 배포됩니다.
+func (r *DemoReconciler) podTemplate(d *demoappv2.Demo, contentRevision string) corev1.PodTemplateSpec {
+
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      workloadLabels(d),
+			Annotations: objectAnnotations(d),
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: r.podServiceAccountName(d),
+			Containers: []corev1.Container{{
+				Image:     r.nginxImage(d),
+				Name:      nginxContainerName,
+				Resources: r.nginxResources(d),
+				Ports: []corev1.ContainerPort{
+					{
+						Name:          "http",
+						ContainerPort: nginxPort(d),
+						Protocol:      corev1.ProtocolTCP,
+					},
+				},
+			}},
+		},
+	} // template 정의 끝
+
+	// spec의 추가 컨테이너와 내장 sidecar를 nginx 뒤에 붙입니다.
+	template.Spec.InitContainers = append(template.Spec.InitContainers, d.Spec.Pod.InitContainers...)
+	template.Spec.Containers = append(template.Spec.Containers, d.Spec.Pod.Containers...)
+	for _, s := range d.Spec.Pod.Sidecars {
+		template.Spec.Containers = append(template.Spec.Containers, builtinSidecar(s))
+	}
+
+	// idle 감지와 exporter를 위해 stub_status가 켜진 nginx 설정을 마운트합니다.
+	// Restricted에서 포트를 바꾸거나 HTTPS server를 추가할 때도 마운트합니다.
+	if needsNginxConfig(d) {
+		podSpec := &template.Spec
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: nginxConfigVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: r.nginxConfigName(d)},
+				},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      nginxConfigVolume,
+			MountPath: "/etc/nginx/conf.d",
+			ReadOnly:  true,
+		})
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[nginxConfigHashAnnotation] = hashObject(nginxConf(d))
+	}
+
+	// spec.content가 있으면 init container가 채운 볼륨을 nginx가 서비스합니다.
+	r.addContent(&template, d, contentRevision)
+
+	// spec.tls가 있으면 HTTPS 포트와 서버 인증서를 추가합니다.
+	r.addTLS(&template, d)
+
+	// restartedAt annotation이 바뀌면 pod를 다시 만듭니다.
+	addRestartedAt(&template, d)
+
+	// operator가 추가한 컨테이너까지 모두 붙인 뒤 security profile을 적용합니다.
+	applySecurityProfile(&template, d)
+
+	return template
 }
 
 // Deployment를 생성하고 컨트롤러에 등록해 cr이 삭제되면 함께 삭제되도록 합니다.
-func (r *DemoReconciler) createDeployment(d *demoappv1.Demo) *appsv1.Deployment {
+func (r *DemoReconciler) createDeployment(d *demoappv2.Demo, contentRevision string) (*appsv1.Deployment, error) {
 
-	label := getLabelForCR(d.Name)
-	size := d.Spec.Size // CR.Spec.Size 정의 내용을 사용
+	size := d.Spec.Scaling.Replicas // CR.Spec.Size 정의 내용을 사용
 
 	// Deployment yaml을 하드코딩으로 정의
 	newDply := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      d.Name,
-			Namespace: d.Namespace,
-		},
+		ObjectMeta: workloadMeta(d, r.deploymentName(d)),
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &size,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: label,
+				MatchLabels: selectorLabels(d),
+			},
+			Template: r.podTemplate(d, contentRevision),
+		},
+	} // deploy 정의 끝
+
+	// template이 바뀐 경우를 알 수 있도록 해시를 기록합니다.
+	setTemplateHash(newDply)
+
+	// cr이 삭제됐을때 deploy가 남아있는걸 막기 위해 ref에 추가
+	if err := ctrl.SetControllerReference(d, newDply, r.Scheme); err != nil {
+		return nil, err
+	}
+	return newDply, nil
+}
+
+// StatefulSet이 사용하는 headless Service 이름
+func (r *DemoReconciler) headlessServiceName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "-headless", maxNameLength)
+}
+
+// StatefulSet pod의 DNS를 위한 headless Service를 생성하고 컨트롤러에 등록합니다.
+// 포트는 Service와 같습니다.
+func (r *DemoReconciler) createHeadlessService(d *demoappv2.Demo) (*corev1.Service, error) {
+
+	newSvc := &corev1.Service{
+		ObjectMeta: objectMeta(d, r.headlessServiceName(d), componentServer),
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector:  selectorLabels(d),
+			Ports:     servicePorts(d),
+		},
+	}
+
+	if err := ctrl.SetControllerReference(d, newSvc, r.Scheme); err != nil {
+		return nil, err
+	}
+	return newSvc, nil
+}
+
+// spec.storage가 있으면 replica마다 PVC를 갖는 StatefulSet을 생성하고 컨트롤러에 등록합니다.
+func (r *DemoReconciler) createStatefulSet(d *demoappv2.Demo, contentRevision string) (*appsv1.StatefulSet, error) {
+
+	size := d.Spec.Scaling.Replicas
+	storage := d.Spec.Storage
+
+	accessModes := storage.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	mountPath := storage.MountPath
+	if mountPath == "" {
+		mountPath = defaultStorageMountPath
+	}
+
+	template := r.podTemplate(d, contentRevision)
+	template.Spec.Containers[0].VolumeMounts = append(template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      storageVolume,
+		MountPath: mountPath,
+	})
+
+	newSts := &appsv1.StatefulSet{
+		ObjectMeta: workloadMeta(d, r.statefulSetName(d)),
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &size,
+			ServiceName: r.headlessServiceName(d),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: selectorLabels(d),
 			},
-			Template: corev1.PodTemplateSpec{
+			Template: template,
+			// PVC에도 같은 label을 붙여 Demo의 PVC를 찾을 수 있도록 합니다.
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: label,
+					Name:   storageVolume,
+					Labels: objectLabels(d, componentServer),
 				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{{
-						Image: "nginx:latest",
-						Name:  "nginx",
-						Ports: []corev1.ContainerPort{
-							{
-								ContainerPort: 80,
-								Protocol:      corev1.ProtocolTCP,
-							},
-						},
-					}},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes:      accessModes,
+					StorageClassName: storage.StorageClassName,
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceStorage: storage.Size},
+					},
 				},
-			},
+			}},
 		},
-	} // deploy 정의 끝
+	} // sts 정의 끝
 
-	// cr이 삭제됐을때 deploy가 남아있는걸 막기 위해 ref에 추가
-	ctrl.SetControllerReference(d, newDply, r.Scheme)
-	return newDply
+	setTemplateHash(newSts)
+	newSts.Annotations[volumeRetentionAnnotation] = string(volumeRetention(d))
+
+	if err := ctrl.SetControllerReference(d, newSts, r.Scheme); err != nil {
+		return nil, err
+	}
+	return newSts, nil
 }
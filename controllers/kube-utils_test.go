@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServicePorts(t *testing.T) {
+	d := &demoappv2.Demo{}
+	if ports := servicePorts(d); ports[0].Port != defaultServicePort || serviceType(d) != corev1.ServiceTypeClusterIP {
+		t.Errorf("unexpected defaults %+v %s", ports, serviceType(d))
+	}
+
+	d.Spec.Service = demoappv2.ServiceSpec{Type: corev1.ServiceTypeNodePort, Port: 8080}
+	desired := servicePorts(d)
+	if desired[0].Port != 8080 || desired[0].TargetPort.String() != "http" {
+		t.Errorf("unexpected ports %+v", desired)
+	}
+
+	// 클러스터가 할당한 NodePort는 차이로 보지 않습니다.
+	current := append([]corev1.ServicePort{}, desired...)
+	current[0].NodePort = 30080
+	if !servicePortsEqual(current, desired) {
+		t.Error("expected ports with an allocated NodePort to be equal")
+	}
+	current[0].Port = 80
+	if servicePortsEqual(current, desired) {
+		t.Error("expected a changed port to differ")
+	}
+}
+
+func TestGetPodSummaries(t *testing.T) {
+	pods := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-b"},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-a"},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		},
+	}
+
+	want := []demoappv2.PodSummary{
+		{Name: "web-a", NodeName: "node-1", Phase: corev1.PodRunning, Ready: true},
+		{Name: "web-b", Phase: corev1.PodPending},
+	}
+	if got := getPodSummaries(pods); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestNginxDefaultsFromConfig(t *testing.T) {
+	small := corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")}}
+	large := corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}
+	r := &DemoReconciler{
+		DefaultImage:           "nginx:1.21",
+		ResourceProfiles:       map[string]corev1.ResourceRequirements{"small": small, "large": large},
+		DefaultResourceProfile: "small",
+	}
+
+	d := &demoappv2.Demo{}
+	nginx := r.podTemplate(d, "").Spec.Containers[0]
+	if nginx.Image != "nginx:1.21" || !reflect.DeepEqual(nginx.Resources, small) {
+		t.Errorf("got image %s resources %+v, want the config defaults", nginx.Image, nginx.Resources)
+	}
+
+	d.Spec.Image = "nginx:1.23"
+	d.Spec.Pod.ResourceProfile = "large"
+	nginx = r.podTemplate(d, "").Spec.Containers[0]
+	if nginx.Image != "nginx:1.23" || !reflect.DeepEqual(nginx.Resources, large) {
+		t.Errorf("got image %s resources %+v, want the Demo's", nginx.Image, nginx.Resources)
+	}
+
+	d.Spec.Pod.ResourceProfile = "huge"
+	if err := r.validateResourceProfile(d); err == nil {
+		t.Error("expected an error for an undefined resource profile")
+	}
+
+	// 설정이 없으면 nginx:latest를 resource 없이 실행합니다.
+	nginx = (&DemoReconciler{}).podTemplate(&demoappv2.Demo{}, "").Spec.Containers[0]
+	if nginx.Image != defaultImage || !reflect.DeepEqual(nginx.Resources, corev1.ResourceRequirements{}) {
+		t.Errorf("got image %s resources %+v without config", nginx.Image, nginx.Resources)
+	}
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// log 수준. reconcile 마다 반복되는 메시지는 logDebug로 남겨 --zap-log-level=debug에서만 보이게 합니다.
+const logDebug = 1
+
+// reconcile log의 key.
+// 모든 메시지에 reconcileID, demo (namespace/name), generation이 붙고,
+// 자식 객체에 관한 메시지는 objectKeys로 kind, namespace, name을 붙입니다.
+const (
+	logKeyReconcileID = "reconcileID"
+	logKeyDemo        = "demo"
+	logKeyGeneration  = "generation"
+)
+
+// reconcileLogger는 reconcile 한 번에 쓰는 logger를 ctx에 넣습니다.
+// controller-runtime이 붙이는 name, namespace는 자식 객체의 key와 겹치므로 demo key로 대신합니다.
+func (r *DemoReconciler) reconcileLogger(ctx context.Context, req ctrl.Request) (context.Context, string) {
+	logger := r.Log
+	if logger == nil {
+		logger = ctrl.Log.WithName("controllers").WithName("Demo")
+	}
+	id := string(uuid.NewUUID())
+	logger = logger.WithValues(logKeyReconcileID, id, logKeyDemo, req.NamespacedName.String())
+	return log.IntoContext(ctx, logger), id
+}
+
+// withGeneration은 Demo를 읽은 뒤 ctx의 logger에 generation을 더합니다.
+func withGeneration(ctx context.Context, generation int64) context.Context {
+	return log.IntoContext(ctx, log.FromContext(ctx).WithValues(logKeyGeneration, generation))
+}
+
+// objectKeys는 Demo가 만드는 객체에 관한 log의 key와 값입니다.
+func objectKeys(kind, namespace, name string) []interface{} {
+	return []interface{}{"kind", kind, "namespace", namespace, "name", name}
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"strings"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// reconcile log는 JSON 한 줄마다 reconcileID, demo, generation을 갖고,
+// 자식 객체에 관한 메시지는 kind, namespace, name을 함께 갖습니다.
+func TestReconcileLogKeys(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Generation: 2},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+	}
+	r, _ := newPipelineTest(t, cr)
+
+	// main.go와 같은 flag로 production (JSON) logger를 만듭니다.
+	var buf bytes.Buffer
+	opts := zap.Options{DestWriter: &buf}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.BindFlags(fs)
+	if err := fs.Parse([]string{"--zap-log-level=debug"}); err != nil {
+		t.Fatal(err)
+	}
+	r.Log = zap.New(zap.UseFlagOptions(&opts))
+
+	readLines := func() []map[string]interface{} {
+		var lines []map[string]interface{}
+		scanner := bufio.NewScanner(&buf)
+		for scanner.Scan() {
+			line := map[string]interface{}{}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Fatalf("log line %q is not JSON: %v", scanner.Text(), err)
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	lines := readLines()
+	if len(lines) == 0 {
+		t.Fatal("no log lines")
+	}
+	created := false
+	firstID := lines[0][logKeyReconcileID]
+	for _, line := range lines {
+		if id, ok := line[logKeyReconcileID].(string); !ok || id == "" || id != firstID {
+			t.Errorf("got %s %v in %v, want the same ID on every line", logKeyReconcileID, line[logKeyReconcileID], line)
+		}
+		if line[logKeyDemo] != "default/web" {
+			t.Errorf("got %s %v in %v, want default/web", logKeyDemo, line[logKeyDemo], line)
+		}
+		if line[logKeyGeneration] != float64(2) {
+			t.Errorf("got %s %v in %v, want 2", logKeyGeneration, line[logKeyGeneration], line)
+		}
+		if _, ok := line["name"]; ok {
+			if line["kind"] == nil || line["namespace"] == nil {
+				t.Errorf("got name without kind and namespace in %v", line)
+			}
+		}
+		for key := range line {
+			if strings.Contains(key, ".") {
+				t.Errorf("got key %q in %v, want one of the documented keys", key, line)
+			}
+		}
+		if line["msg"] == "Deployment created" {
+			created = true
+			if line["kind"] != "Deployment" || line["namespace"] != "default" || line["name"] != "web" {
+				t.Errorf("got %v, want the Deployment kind, namespace and name", line)
+			}
+		}
+	}
+	if !created {
+		t.Errorf("no \"Deployment created\" message in %v", lines)
+	}
+
+	// 다음 reconcile은 새 ID를 받습니다.
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range readLines() {
+		if line[logKeyReconcileID] == firstID {
+			t.Errorf("got the previous %s in %v", logKeyReconcileID, line)
+			break
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	demoCRDName = "demoes.demoapp.my.domain"
+
+	// 한 번에 다시 쓰는 Demo 수
+	migrationPageSize = 100
+)
+
+var crdGVK = schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"}
+
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get,resourceNames=demoes.demoapp.my.domain
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=update,resourceNames=demoes.demoapp.my.domain
+
+// StorageVersionMigrator는 operator 시작 시 이전 버전으로 저장된 Demo를 storage version(v2)으로 다시 씁니다.
+// 모든 Demo를 다시 쓴 뒤 CRD의 status.storedVersions에서 이전 버전을 제거하므로,
+// 이후 CRD에서 v1을 내릴 수 있습니다.
+type StorageVersionMigrator struct {
+	// Client로 Demo를 다시 쓰고 CRD status를 갱신합니다.
+	Client client.Client
+	// Reader는 cache를 거치지 않고 CRD와 Demo를 읽습니다. (manager의 APIReader)
+	Reader client.Reader
+	// RetryInterval은 conversion webhook이 준비되지 않은 경우 등 실패했을 때 다시 시도하는 간격입니다.
+	RetryInterval time.Duration
+}
+
+// leader만 migration을 실행합니다.
+func (m *StorageVersionMigrator) NeedLeaderElection() bool {
+	return true
+}
+
+// Start는 migration이 성공하거나 manager가 종료될 때까지 다시 시도합니다.
+// 실패해도 manager를 멈추지 않습니다.
+func (m *StorageVersionMigrator) Start(ctx context.Context) error {
+	logger := ctrl.Log.WithName("storage-version-migration")
+
+	interval := m.RetryInterval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+
+	_ = wait.PollImmediateUntil(interval, func() (bool, error) {
+		migrated, err := m.Migrate(ctx)
+		if err != nil {
+			logger.Error(err, "Failed to migrate Demo storage version, retrying")
+			return false, nil
+		}
+		if migrated > 0 {
+			logger.Info("Migrated Demo storage version", "version", demoappv2.GroupVersion.Version, "count", migrated)
+		}
+		return true, nil
+	}, ctx.Done())
+	return nil
+}
+
+// Migrate는 storedVersions에 storage version 외의 버전이 남아 있으면 모든 Demo를 다시 쓰고
+// storedVersions를 storage version만 남도록 갱신합니다. 다시 쓴 Demo 수를 반환합니다.
+func (m *StorageVersionMigrator) Migrate(ctx context.Context) (int, error) {
+	storage := demoappv2.GroupVersion.Version
+
+	crd := &unstructured.Unstructured{}
+	crd.SetGroupVersionKind(crdGVK)
+	if err := m.Reader.Get(ctx, client.ObjectKey{Name: demoCRDName}, crd); err != nil {
+		return 0, err
+	}
+	stored, _, err := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	if err != nil {
+		return 0, err
+	}
+	if len(stored) == 1 && stored[0] == storage {
+		return 0, nil
+	}
+
+	// 변경 없이 Update 하면 API server가 현재 storage version으로 다시 저장합니다.
+	migrated := 0
+	list := &demoappv2.DemoList{}
+	for {
+		if err := m.Reader.List(ctx, list, client.Limit(migrationPageSize), client.Continue(list.Continue)); err != nil {
+			return migrated, err
+		}
+		for i := range list.Items {
+			err := m.Client.Update(ctx, &list.Items[i])
+			// 그 사이 삭제되었거나 다른 쪽에서 수정한 Demo는 이미 storage version으로 저장되어 있습니다.
+			if errors.IsNotFound(err) || errors.IsConflict(err) {
+				continue
+			}
+			if err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+		if list.Continue == "" {
+			break
+		}
+	}
+
+	if err := unstructured.SetNestedStringSlice(crd.Object, []string{storage}, "status", "storedVersions"); err != nil {
+		return migrated, err
+	}
+	return migrated, m.Client.Status().Update(ctx, crd)
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStorageVersionMigration(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = demoappv2.AddToScheme(scheme)
+
+	crd := &unstructured.Unstructured{}
+	crd.SetGroupVersionKind(crdGVK)
+	crd.SetName(demoCRDName)
+	_ = unstructured.SetNestedStringSlice(crd.Object, []string{"v1", "v2"}, "status", "storedVersions")
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		crd,
+		&demoappv2.Demo{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}},
+		&demoappv2.Demo{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "other"}},
+	).Build()
+	m := &StorageVersionMigrator{Client: c, Reader: c}
+	ctx := context.Background()
+
+	migrated, err := m.Migrate(ctx)
+	if err != nil || migrated != 2 {
+		t.Fatalf("got migrated=%d err=%v, want 2 Demos migrated", migrated, err)
+	}
+
+	got := &unstructured.Unstructured{}
+	got.SetGroupVersionKind(crdGVK)
+	if err := c.Get(ctx, client.ObjectKey{Name: demoCRDName}, got); err != nil {
+		t.Fatal(err)
+	}
+	stored, _, _ := unstructured.NestedStringSlice(got.Object, "status", "storedVersions")
+	if !reflect.DeepEqual(stored, []string{"v2"}) {
+		t.Errorf("got storedVersions %v, want [v2]", stored)
+	}
+
+	// storedVersions가 정리된 뒤에는 다시 쓰지 않습니다.
+	migrated, err = m.Migrate(ctx)
+	if err != nil || migrated != 0 {
+		t.Errorf("got migrated=%d err=%v on second run, want nothing to do", migrated, err)
+	}
+}
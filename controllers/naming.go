@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	configv1alpha1 "demo-operator/api/config/v1alpha1"
+	demoappv2 "demo-operator/api/v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+const (
+	labelName      = "app.kubernetes.io/name"
+	labelInstance  = "app.kubernetes.io/instance"
+	labelVersion   = "app.kubernetes.io/version"
+	labelComponent = "app.kubernetes.io/component"
+	labelManagedBy = "app.kubernetes.io/managed-by"
+
+	appName   = "demo"
+	managedBy = "demo-operator"
+
+	// 이전 버전이 selector로 사용하던 label. 마이그레이션 중인 workload에만 남습니다.
+	legacyAppLabel = "app"
+)
+
+// app.kubernetes.io/component 값
+const (
+	componentServer  = "server"
+	componentConfig  = "config"
+	componentContent = "content"
+)
+
+// 이름 길이 제한.
+// Service 이름은 DNS label(63자)이어야 하고, StatefulSet 이름은 pod의
+// controller-revision-hash label(63자)에 해시와 함께 들어가므로 52자까지 사용합니다.
+const (
+	maxNameLength            = validation.DNS1123LabelMaxLength
+	maxStatefulSetNameLength = 52
+)
+
+// childName은 Demo 이름에 suffix를 붙인 자식 객체 이름을 maxLen 이내로 만듭니다.
+// 길면 Demo 이름을 자르고 전체 이름의 해시를 붙여 서로 다른 Demo의 이름이 겹치지 않도록 합니다.
+// name은 operator 설정의 nameTemplate으로 만든 baseName이고, suffix는 객체 종류마다 고정입니다.
+// 자른 이름은 새로 만드는 객체에만 쓰고, 이전에 Demo 이름 그대로 만든 workload는 getWorkload가 찾아 계속 사용합니다.
+func childName(name, suffix string, maxLen int) string {
+	if len(name)+len(suffix) <= maxLen {
+		return name + suffix
+	}
+	hasher := fnv.New32a()
+	hasher.Write([]byte(name + suffix))
+	hash := fmt.Sprintf("%08x", hasher.Sum32())
+
+	prefix := strings.TrimRight(name[:maxLen-len(suffix)-len(hash)-1], "-.")
+	return prefix + "-" + hash + suffix
+}
+
+// baseName은 Demo가 만드는 객체 이름의 앞부분입니다. NameTemplate이 없으면 Demo 이름입니다.
+// template은 operator 설정을 읽을 때 검증하므로, 그래도 실패하면 Demo 이름을 사용합니다.
+func (r *DemoReconciler) baseName(d *demoappv2.Demo) string {
+	if r.NameTemplate == nil {
+		return d.Name
+	}
+	var b strings.Builder
+	if err := r.NameTemplate.Execute(&b, configv1alpha1.NameTemplateData{Name: d.Name, Namespace: d.Namespace}); err != nil || b.Len() == 0 {
+		return d.Name
+	}
+	return b.String()
+}
+
+// Demo의 Service 이름
+func (r *DemoReconciler) serviceName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "", maxNameLength)
+}
+
+// Demo의 Deployment 이름
+func (r *DemoReconciler) deploymentName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "", maxNameLength)
+}
+
+// Demo의 StatefulSet 이름
+func (r *DemoReconciler) statefulSetName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "", maxStatefulSetNameLength)
+}
+
+// app.kubernetes.io/instance 값. label 값도 63자 제한이 있습니다.
+func instanceName(d *demoappv2.Demo) string {
+	return childName(d.Name, "", validation.LabelValueMaxLength)
+}
+
+// selectorLabels는 Demo의 pod를 고르는 label입니다.
+// Deployment와 StatefulSet의 selector는 바꿀 수 없으므로 여기에 값을 추가하면 마이그레이션이 필요합니다.
+func selectorLabels(d *demoappv2.Demo) map[string]string {
+	return map[string]string{
+		labelName:     appName,
+		labelInstance: instanceName(d),
+	}
+}
+
+// PodSelector는 Demo의 pod를 고르는 label입니다. kubectl-demo처럼 operator 밖에서 pod를 찾을 때 사용합니다.
+// selector 마이그레이션 중인 workload의 pod는 이전 selector를 사용하므로 찾지 못할 수 있습니다.
+func PodSelector(d *demoappv2.Demo) map[string]string {
+	return selectorLabels(d)
+}
+
+// objectLabels는 Demo가 만드는 객체에 붙이는 label입니다.
+// spec.commonLabels에 operator가 관리하는 app.kubernetes.io label을 더합니다.
+func objectLabels(d *demoappv2.Demo, component string) map[string]string {
+	labels := map[string]string{}
+	for k, v := range d.Spec.CommonLabels {
+		labels[k] = v
+	}
+	for k, v := range selectorLabels(d) {
+		labels[k] = v
+	}
+	labels[labelManagedBy] = managedBy
+	labels[labelComponent] = component
+	return labels
+}
+
+// workloadLabels는 workload와 pod에 붙이는 label입니다.
+// 이미지를 바꿀 때 다른 객체까지 고치지 않도록 app.kubernetes.io/version은 여기에만 붙입니다.
+func workloadLabels(d *demoappv2.Demo) map[string]string {
+	labels := objectLabels(d, componentServer)
+	if version := imageVersion(d.Spec.Image); version != "" {
+		labels[labelVersion] = version
+	}
+	return labels
+}
+
+// objectAnnotations는 Demo가 만드는 객체에 붙이는 annotation입니다.
+func objectAnnotations(d *demoappv2.Demo) map[string]string {
+	if len(d.Spec.CommonAnnotations) == 0 {
+		return nil
+	}
+	annotations := make(map[string]string, len(d.Spec.CommonAnnotations))
+	for k, v := range d.Spec.CommonAnnotations {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// objectMeta는 Demo가 만드는 객체의 metadata입니다.
+func objectMeta(d *demoappv2.Demo, name, component string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   d.Namespace,
+		Labels:      objectLabels(d, component),
+		Annotations: objectAnnotations(d),
+	}
+}
+
+// workloadMeta는 Deployment와 StatefulSet의 metadata입니다.
+func workloadMeta(d *demoappv2.Demo, name string) metav1.ObjectMeta {
+	meta := objectMeta(d, name, componentServer)
+	meta.Labels = workloadLabels(d)
+	return meta
+}
+
+// imageVersion은 이미지 tag를 app.kubernetes.io/version 값으로 사용합니다.
+// tag가 없거나 label 값으로 쓸 수 없으면 빈 문자열을 반환합니다.
+func imageVersion(image string) string {
+	if image == "" {
+		image = defaultImage
+	}
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	version := image[i+1:]
+	if len(validation.IsValidLabelValue(version)) > 0 {
+		return ""
+	}
+	return version
+}
+
+// mergeMetadata는 desired의 label과 annotation을 current에 더합니다. 바뀐 경우 true를 반환합니다.
+// 사용자나 다른 도구가 붙인 값은 지우지 않습니다.
+func mergeMetadata(current, desired metav1.Object) bool {
+	merge := func(cur, want map[string]string) (map[string]string, bool) {
+		changed := false
+		for k, v := range want {
+			if got, ok := cur[k]; ok && got == v {
+				continue
+			}
+			if cur == nil {
+				cur = map[string]string{}
+			}
+			cur[k] = v
+			changed = true
+		}
+		return cur, changed
+	}
+
+	labels, labelsChanged := merge(current.GetLabels(), desired.GetLabels())
+	annotations, annotationsChanged := merge(current.GetAnnotations(), desired.GetAnnotations())
+	current.SetLabels(labels)
+	current.SetAnnotations(annotations)
+	return labelsChanged || annotationsChanged
+}
+
+// spec.commonLabels와 spec.commonAnnotations가 label과 annotation으로 쓸 수 있는지 확인합니다.
+func validateMetadata(d *demoappv2.Demo) error {
+	path := field.NewPath("spec")
+	var errs field.ErrorList
+	errs = append(errs, metav1validation.ValidateLabels(d.Spec.CommonLabels, path.Child("commonLabels"))...)
+	for k := range d.Spec.CommonLabels {
+		if strings.HasPrefix(k, "app.kubernetes.io/") {
+			errs = append(errs, field.Forbidden(path.Child("commonLabels").Key(k), "app.kubernetes.io labels are set by the operator"))
+		}
+	}
+	for k := range d.Spec.CommonAnnotations {
+		for _, msg := range validation.IsQualifiedName(strings.ToLower(k)) {
+			errs = append(errs, field.Invalid(path.Child("commonAnnotations"), k, msg))
+		}
+	}
+	return errs.ToAggregate()
+}
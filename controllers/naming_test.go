@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	configv1alpha1 "demo-operator/api/config/v1alpha1"
+	demoappv2 "demo-operator/api/v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestChildName(t *testing.T) {
+	if got := childName("web", "-content", maxNameLength); got != "web-content" {
+		t.Errorf("got %q, want the name unchanged", got)
+	}
+
+	long := strings.Repeat("a", 70) + "-b"
+	other := strings.Repeat("a", 70) + "-c"
+	for _, suffix := range []string{"", "-headless", "-content"} {
+		got := childName(long, suffix, maxNameLength)
+		if len(got) > maxNameLength || !strings.HasSuffix(got, suffix) {
+			t.Errorf("got %q (%d), want at most %d characters ending in %q", got, len(got), maxNameLength, suffix)
+		}
+		if errs := validation.IsDNS1123Label(got); len(errs) > 0 {
+			t.Errorf("got invalid name %q: %v", got, errs)
+		}
+		if got == childName(other, suffix, maxNameLength) {
+			t.Errorf("names of different Demos collide: %q", got)
+		}
+	}
+
+	if got := childName(long, "", maxStatefulSetNameLength); len(got) > maxStatefulSetNameLength {
+		t.Errorf("got %q, want at most %d characters", got, maxStatefulSetNameLength)
+	}
+}
+
+// nameTemplate으로 만든 이름도 Demo 이름처럼 길면 잘리고, label은 Demo 이름을 그대로 사용합니다.
+func TestNameTemplate(t *testing.T) {
+	tmpl, err := configv1alpha1.ParseNameTemplate("{{.Namespace}}-{{.Name}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &DemoReconciler{NameTemplate: tmpl}
+
+	d := &demoappv2.Demo{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team-a"}}
+	if got := r.serviceName(d); got != "team-a-web" {
+		t.Errorf("got Service name %q, want team-a-web", got)
+	}
+	if got := r.headlessServiceName(d); got != "team-a-web-headless" {
+		t.Errorf("got headless Service name %q, want team-a-web-headless", got)
+	}
+	if got := selectorLabels(d)[labelInstance]; got != "web" {
+		t.Errorf("got instance label %q, want the Demo name", got)
+	}
+
+	long := &demoappv2.Demo{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 60), Namespace: "team-a"}}
+	for _, got := range []string{r.serviceName(long), r.contentConfigMapName(long), r.statefulSetName(long)} {
+		if errs := validation.IsDNS1123Label(got); len(errs) > 0 || !strings.HasPrefix(got, "team-a-") {
+			t.Errorf("got name %q, want a DNS label starting with the namespace: %v", got, errs)
+		}
+	}
+	if got := r.statefulSetName(long); len(got) > maxStatefulSetNameLength {
+		t.Errorf("got %q, want at most %d characters", got, maxStatefulSetNameLength)
+	}
+}
+
+func TestImageVersion(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "", want: "latest"},
+		{image: "nginx", want: ""},
+		{image: "nginx:1.21", want: "1.21"},
+		{image: "registry:5000/nginx", want: ""},
+		{image: "registry:5000/nginx:1.21-alpine", want: "1.21-alpine"},
+		{image: "nginx:1.21@sha256:abcdef", want: "1.21"},
+		{image: "nginx@sha256:abcdef", want: ""},
+	}
+	for _, tt := range tests {
+		if got := imageVersion(tt.image); got != tt.want {
+			t.Errorf("imageVersion(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestObjectLabels(t *testing.T) {
+	d := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: demoappv2.DemoSpec{
+			Image:             "nginx:1.21",
+			CommonLabels:      map[string]string{"team": "a"},
+			CommonAnnotations: map[string]string{"owner": "a@example.com"},
+		},
+	}
+
+	labels := objectLabels(d, componentContent)
+	want := map[string]string{
+		"team":         "a",
+		labelName:      appName,
+		labelInstance:  "web",
+		labelManagedBy: managedBy,
+		labelComponent: componentContent,
+	}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("got label %s=%q, want %q", k, labels[k], v)
+		}
+	}
+	if _, ok := labels[labelVersion]; ok {
+		t.Error("version label should only be on the workload and pods")
+	}
+	if got := workloadLabels(d)[labelVersion]; got != "1.21" {
+		t.Errorf("got workload version %q, want 1.21", got)
+	}
+	if got := objectMeta(d, "web", componentServer).Annotations["owner"]; got != "a@example.com" {
+		t.Errorf("got annotation %q, want the common annotation", got)
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	current := &metav1.ObjectMeta{Labels: map[string]string{"other": "x", "team": "a"}}
+	desired := &metav1.ObjectMeta{Labels: map[string]string{"team": "a"}}
+	if mergeMetadata(current, desired) {
+		t.Error("expected no change")
+	}
+
+	desired.Labels["team"] = "b"
+	desired.Annotations = map[string]string{"owner": "b"}
+	if !mergeMetadata(current, desired) {
+		t.Error("expected a change")
+	}
+	if current.Labels["team"] != "b" || current.Labels["other"] != "x" || current.Annotations["owner"] != "b" {
+		t.Errorf("unexpected metadata %+v", current)
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{name: "empty"},
+		{name: "valid", labels: map[string]string{"example.com/team": "a"}, annotations: map[string]string{"note": "any value"}},
+		{name: "reserved label", labels: map[string]string{labelInstance: "x"}, wantErr: true},
+		{name: "invalid label value", labels: map[string]string{"team": "a b"}, wantErr: true},
+		{name: "invalid annotation key", annotations: map[string]string{"a b": "x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &demoappv2.Demo{Spec: demoappv2.DemoSpec{CommonLabels: tt.labels, CommonAnnotations: tt.annotations}}
+			if err := validateMetadata(d); (err != nil) != tt.wantErr {
+				t.Errorf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Demo의 NetworkPolicy 이름
+func (r *DemoReconciler) networkPolicyName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "", maxNameLength)
+}
+
+// spec.networkPolicy로 selector의 pod에 적용할 NetworkPolicy를 정의합니다.
+// Service가 노출하는 pod 포트로의 ingress를 허용하고, idle Demo는 액티베이터와
+// 요청 수 조회 (stub_status, exporter metrics)를 위해 operator pod도 허용합니다.
+func (r *DemoReconciler) createNetworkPolicy(d *demoappv2.Demo, selector map[string]string) (*networkingv1.NetworkPolicy, error) {
+	spec := d.Spec.NetworkPolicy.DeepCopy()
+
+	var ports []networkingv1.NetworkPolicyPort
+	for _, p := range servicePorts(d) {
+		protocol, port := p.Protocol, p.TargetPort
+		ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+	}
+	// spec.networkPolicy.ingress가 비어 있으면 From이 없는 rule이 되어 모든 source가 Service 포트에 접근할 수 있습니다.
+	ingress := []networkingv1.NetworkPolicyIngressRule{{From: spec.Ingress, Ports: ports}}
+	if peer, ok := r.operatorPeer(d); ok {
+		operatorPorts := []networkingv1.NetworkPolicyPort{tcpPort(intstr.FromString("http")), tcpPort(intstr.FromInt(stubStatusPort))}
+		if hasSidecar(d, demoappv2.NginxPrometheusExporter) {
+			operatorPorts = append(operatorPorts, tcpPort(intstr.FromString("metrics")))
+		}
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			From:  []networkingv1.NetworkPolicyPeer{peer},
+			Ports: operatorPorts,
+		})
+	}
+
+	policyTypes := []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+	if len(spec.Egress) > 0 {
+		policyTypes = append(policyTypes, networkingv1.PolicyTypeEgress)
+	}
+	// API 서버가 채우는 기본 protocol을 미리 채워 매번 차이가 나지 않도록 합니다.
+	for i := range spec.Egress {
+		for j := range spec.Egress[i].Ports {
+			if spec.Egress[i].Ports[j].Protocol == nil {
+				protocol := corev1.ProtocolTCP
+				spec.Egress[i].Ports[j].Protocol = &protocol
+			}
+		}
+	}
+
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: objectMeta(d, r.networkPolicyName(d), componentServer),
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: selector},
+			Ingress:     ingress,
+			Egress:      spec.Egress,
+			PolicyTypes: policyTypes,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(d, np, r.Scheme); err != nil {
+		return nil, err
+	}
+	return np, nil
+}
+
+// spec.networkPolicy가 있으면 NetworkPolicy를 정의합니다. 없으면 nil입니다.
+func (r *DemoReconciler) desiredNetworkPolicy(d *demoappv2.Demo, selector map[string]string) (*networkingv1.NetworkPolicy, error) {
+	if d.Spec.NetworkPolicy == nil {
+		return nil, nil
+	}
+	return r.createNetworkPolicy(d, selector)
+}
+
+func tcpPort(port intstr.IntOrString) networkingv1.NetworkPolicyPort {
+	protocol := corev1.ProtocolTCP
+	return networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port}
+}
+
+// idle Demo에 요청을 전달하는 operator pod. 액티베이터를 쓰지 않으면 false를 반환합니다.
+// pod IP는 operator가 다시 시작되면 바뀌므로 operator의 namespace와 pod label로 고릅니다.
+// operator의 namespace를 모르면 모든 namespace에서 label이 맞는 pod를 허용합니다.
+func (r *DemoReconciler) operatorPeer(d *demoappv2.Demo) (networkingv1.NetworkPolicyPeer, bool) {
+	if d.Spec.Scaling.Idle == nil || !r.activatorReady() {
+		return networkingv1.NetworkPolicyPeer{}, false
+	}
+	namespace, podLabels := r.Activator.Pod()
+	peer := networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{},
+		PodSelector:       &metav1.LabelSelector{},
+	}
+	if namespace != "" {
+		peer.NamespaceSelector.MatchLabels = map[string]string{corev1.LabelMetadataName: namespace}
+	}
+	if len(podLabels) > 0 {
+		peer.PodSelector.MatchLabels = podLabels
+	}
+	return peer, true
+}
+
+// spec.networkPolicy가 있으면 NetworkPolicy를 만들거나 맞추고, 없으면 이 Demo가 만든 NetworkPolicy를 지웁니다.
+// 결과는 spec.networkPolicy가 있을 때만 NetworkPolicyActive condition에 기록합니다.
+func (r *DemoReconciler) syncNetworkPolicy(ctx context.Context, s *reconcileState) error {
+	logger := log.FromContext(ctx)
+	cr := s.cr
+	name := r.networkPolicyName(cr)
+
+	current := &networkingv1.NetworkPolicy{}
+	err := r.Client.Get(ctx, client.ObjectKey{Name: name, Namespace: cr.Namespace}, current)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	desired, err := r.desiredNetworkPolicy(cr, s.selector)
+	if err != nil {
+		return err
+	}
+	if desired == nil {
+		if exists && metav1.IsControlledBy(current, cr) {
+			if err := r.Client.Delete(ctx, current); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			logger.Info("NetworkPolicy deleted", objectKeys("NetworkPolicy", cr.Namespace, name)...)
+		}
+		// NetworkPolicy를 쓰지 않는 Demo에는 condition을 남기지 않습니다.
+		if meta.FindStatusCondition(cr.Status.Conditions, demoappv2.ConditionNetworkPolicyActive) != nil {
+			meta.RemoveStatusCondition(&cr.Status.Conditions, demoappv2.ConditionNetworkPolicyActive)
+			s.conditionsChanged = true
+		}
+		return nil
+	}
+
+	if !exists {
+		if err := r.Client.Create(ctx, desired); err != nil {
+			return err
+		}
+		logger.Info("NetworkPolicy created", objectKeys("NetworkPolicy", cr.Namespace, name)...)
+		s.setNetworkPolicyCondition(metav1.ConditionTrue, "Applied", fmt.Sprintf("NetworkPolicy %s selects the Demo pods", name))
+		return nil
+	}
+
+	if owned, err := r.claim(ctx, s, current); err != nil {
+		return err
+	} else if !owned {
+		s.setNetworkPolicyCondition(metav1.ConditionFalse, "Conflict", fmt.Sprintf("NetworkPolicy %s is not controlled by the Demo", name))
+		return nil
+	}
+
+	changed := mergeMetadata(current, desired)
+	if !equality.Semantic.DeepEqual(current.Spec, desired.Spec) {
+		current.Spec = desired.Spec
+		changed = true
+	}
+	if changed {
+		if err := r.Client.Update(ctx, current); err != nil {
+			return err
+		}
+		logger.Info("NetworkPolicy updated", objectKeys("NetworkPolicy", cr.Namespace, name)...)
+	}
+	s.setNetworkPolicyCondition(metav1.ConditionTrue, "Applied", fmt.Sprintf("NetworkPolicy %s selects the Demo pods", name))
+	return nil
+}
+
+func (s *reconcileState) setNetworkPolicyCondition(status metav1.ConditionStatus, reason, message string) {
+	s.setCondition(metav1.Condition{
+		Type:    demoappv2.ConditionNetworkPolicyActive,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
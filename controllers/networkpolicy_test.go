@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"demo-operator/activator"
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestSyncNetworkPolicy(t *testing.T) {
+	udp := corev1.ProtocolUDP
+	dns := intstr.FromInt(53)
+	https := intstr.FromInt(443)
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			Pod:     demoappv2.PodSpec{Sidecars: []demoappv2.BuiltinSidecar{demoappv2.NginxPrometheusExporter}},
+			NetworkPolicy: &demoappv2.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyPeer{{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+				}},
+				Egress: []networkingv1.NetworkPolicyEgressRule{
+					{Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &dns}}},
+					{Ports: []networkingv1.NetworkPolicyPort{{Port: &https}}},
+				},
+			},
+		},
+	}
+	r, c := newPipelineTest(t, cr)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	np := &networkingv1.NetworkPolicy{}
+	if err := c.Get(ctx, key, np); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(np.Spec.PodSelector.MatchLabels, selectorLabels(cr)) {
+		t.Errorf("got pod selector %v, want the Demo pods", np.Spec.PodSelector.MatchLabels)
+	}
+	if len(np.Spec.Ingress) != 1 || !reflect.DeepEqual(np.Spec.Ingress[0].From, cr.Spec.NetworkPolicy.Ingress) {
+		t.Fatalf("got ingress %+v, want the spec sources", np.Spec.Ingress)
+	}
+	var ports []string
+	for _, p := range np.Spec.Ingress[0].Ports {
+		ports = append(ports, p.Port.String())
+	}
+	if want := []string{"http", "metrics"}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("got ingress ports %v, want the Service target ports %v", ports, want)
+	}
+	if want := []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}; !reflect.DeepEqual(np.Spec.PolicyTypes, want) {
+		t.Errorf("got policy types %v, want %v", np.Spec.PolicyTypes, want)
+	}
+	if p := np.Spec.Egress[1].Ports[0].Protocol; p == nil || *p != corev1.ProtocolTCP {
+		t.Errorf("got egress protocol %v, want TCP by default", p)
+	}
+	assertNetworkPolicyCondition(t, c, key, metav1.ConditionTrue, "Applied")
+
+	// 바뀐 것이 없으면 다시 쓰지 않습니다.
+	c.reset()
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != 0 {
+		t.Errorf("got %d writes after convergence, want none", c.writes)
+	}
+
+	// spec.networkPolicy를 지우면 NetworkPolicy도 지웁니다.
+	got := &demoappv2.Demo{}
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	got.Spec.NetworkPolicy = nil
+	if err := c.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, &networkingv1.NetworkPolicy{}); !errors.IsNotFound(err) {
+		t.Errorf("got %v, want the NetworkPolicy deleted", err)
+	}
+	// NetworkPolicy를 쓰지 않는 Demo에는 condition이 없습니다.
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, demoappv2.ConditionNetworkPolicyActive); cond != nil {
+		t.Errorf("got condition %+v, want it removed", cond)
+	}
+}
+
+// spec.networkPolicy.ingress가 비어 있으면 Service 포트는 모든 source에 열리고, 다른 포트만 막힙니다.
+func TestNetworkPolicyEmptyIngressAllowsAllSources(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling:       demoappv2.ScalingSpec{Replicas: 1},
+			NetworkPolicy: &demoappv2.NetworkPolicySpec{},
+		},
+	}
+	r, _ := newPipelineTest(t, cr)
+	np, err := r.desiredNetworkPolicy(cr, selectorLabels(cr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(np.Spec.Ingress) != 1 {
+		t.Fatalf("got %d ingress rules, want one rule for the Service ports", len(np.Spec.Ingress))
+	}
+	rule := np.Spec.Ingress[0]
+	if len(rule.From) != 0 {
+		t.Errorf("got sources %+v, want none so that every source is allowed", rule.From)
+	}
+	if len(rule.Ports) != 1 || rule.Ports[0].Port.String() != "http" {
+		t.Errorf("got ports %+v, want only the Service target port", rule.Ports)
+	}
+}
+
+func assertNetworkPolicyCondition(t *testing.T, c *countingClient, key types.NamespacedName, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	d := &demoappv2.Demo{}
+	if err := c.Get(context.Background(), key, d); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(d.Status.Conditions, demoappv2.ConditionNetworkPolicyActive)
+	if cond == nil || cond.Status != status || cond.Reason != reason {
+		t.Errorf("got condition %+v, want %s %s", cond, status, reason)
+	}
+}
+
+// operator pod IP가 바뀌어도 NetworkPolicy는 그대로입니다. operator는 namespace와 pod label로 허용합니다.
+func TestNetworkPolicyOperatorPeerSurvivesRestart(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling:       demoappv2.ScalingSpec{Replicas: 1, Idle: &demoappv2.IdleSpec{AfterMinutes: 10}},
+			Pod:           demoappv2.PodSpec{Sidecars: []demoappv2.BuiltinSidecar{demoappv2.NginxPrometheusExporter}},
+			NetworkPolicy: &demoappv2.NetworkPolicySpec{},
+		},
+	}
+	r, _ := newPipelineTest(t, cr)
+	podLabels := map[string]string{"control-plane": "controller-manager"}
+
+	policy := func(podIP string) *networkingv1.NetworkPolicy {
+		t.Helper()
+		a, err := activator.New(r.Client, activator.Options{
+			BindAddress: ":8082", PodIP: podIP, PodNamespace: "demo-operator-system", PodLabels: podLabels,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Activator = a
+		np, err := r.createNetworkPolicy(cr, selectorLabels(cr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return np
+	}
+
+	before, after := policy("10.0.0.1"), policy("10.0.0.2")
+	if !reflect.DeepEqual(before.Spec, after.Spec) {
+		t.Errorf("NetworkPolicy changed with the operator pod IP:\n%+v\n%+v", before.Spec, after.Spec)
+	}
+	if len(after.Spec.Ingress) != 2 {
+		t.Fatalf("got %d ingress rules, want the spec rule and the operator rule", len(after.Spec.Ingress))
+	}
+	operator := after.Spec.Ingress[1]
+	want := networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: "demo-operator-system"}},
+		PodSelector:       &metav1.LabelSelector{MatchLabels: podLabels},
+	}
+	if len(operator.From) != 1 || !reflect.DeepEqual(operator.From[0], want) {
+		t.Errorf("got operator peer %+v, want %+v", operator.From, want)
+	}
+	var ports []string
+	for _, p := range operator.Ports {
+		ports = append(ports, p.Port.String())
+	}
+	if want := []string{"http", "8081", "metrics"}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("got operator ports %v, want %v", ports, want)
+	}
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	demoappv2 "demo-operator/api/v2"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Demo에 paused annotation이 "true"로 붙어 있는지 확인합니다.
+func isPaused(d *demoappv2.Demo) bool {
+	return d.Annotations[demoappv2.PausedAnnotation] == "true"
+}
+
+// 멈춘 Demo는 리소스를 고치지 않고 status만 맞춥니다.
+// 사용자가 workload를 직접 고쳐도 되돌리지 않습니다.
+func (r *DemoReconciler) pausedSteps() []reconcileStep {
+	return []reconcileStep{
+		{name: "status", run: r.syncStatus},
+	}
+}
+
+// 멈춘 상태를 Paused condition에 기록합니다.
+// 한 번도 멈춘 적 없는 Demo에는 condition을 추가하지 않습니다.
+func (s *reconcileState) setPausedCondition() {
+	if isPaused(s.cr) {
+		s.setCondition(metav1.Condition{
+			Type:    demoappv2.ConditionPaused,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Annotation",
+			Message: "Resources are not reconciled while " + demoappv2.PausedAnnotation + " is true",
+		})
+		return
+	}
+	if meta.FindStatusCondition(s.cr.Status.Conditions, demoappv2.ConditionPaused) != nil {
+		s.setCondition(metav1.Condition{
+			Type:    demoappv2.ConditionPaused,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Resumed",
+			Message: "Resources are reconciled",
+		})
+	}
+}
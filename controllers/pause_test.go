@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// 멈춘 Demo는 workload를 직접 고쳐도 되돌리지 않고, 다시 시작하면 되돌립니다.
+func TestReconcilePaused(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2}},
+	}
+	r, c := newPipelineTest(t, cr)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	setPaused := func(paused string) {
+		t.Helper()
+		d := &demoappv2.Demo{}
+		if err := c.Get(ctx, key, d); err != nil {
+			t.Fatal(err)
+		}
+		d.Annotations = map[string]string{demoappv2.PausedAnnotation: paused}
+		if err := c.Update(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scaleDeployment := func(replicas int32) {
+		t.Helper()
+		dep := &appsv1.Deployment{}
+		if err := c.Get(ctx, key, dep); err != nil {
+			t.Fatal(err)
+		}
+		dep.Spec.Replicas = &replicas
+		if err := c.Update(ctx, dep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(wantReplicas int32, wantPaused metav1.ConditionStatus) {
+		t.Helper()
+		dep := &appsv1.Deployment{}
+		if err := c.Get(ctx, key, dep); err != nil {
+			t.Fatal(err)
+		}
+		if *dep.Spec.Replicas != wantReplicas {
+			t.Errorf("got %d replicas, want %d", *dep.Spec.Replicas, wantReplicas)
+		}
+		d := &demoappv2.Demo{}
+		if err := c.Get(ctx, key, d); err != nil {
+			t.Fatal(err)
+		}
+		cond := meta.FindStatusCondition(d.Status.Conditions, demoappv2.ConditionPaused)
+		switch {
+		case wantPaused == "" && cond != nil:
+			t.Errorf("got Paused condition %s on a Demo that was never paused", cond.Status)
+		case wantPaused != "" && (cond == nil || cond.Status != wantPaused):
+			t.Errorf("got Paused condition %v, want %s", cond, wantPaused)
+		}
+	}
+
+	check(2, "")
+
+	setPaused("true")
+	scaleDeployment(5)
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	check(5, metav1.ConditionTrue)
+
+	setPaused("false")
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	check(2, metav1.ConditionFalse)
+}
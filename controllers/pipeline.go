@@ -0,0 +1,481 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reconcileState는 한 번의 reconcile 동안 step들이 함께 쓰는 상태입니다.
+// 앞 step이 실패해 값이 없으면 그 값이 필요한 step은 건너뜁니다.
+type reconcileState struct {
+	cr  *demoappv2.Demo
+	now time.Time
+
+	sched scheduleState
+	// schedule과 idle 상태를 반영한 replicas
+	size int32
+	// spec.content의 revision (확인하지 못하면 status의 이전 값)
+	contentRev string
+	// spec.content.git의 branch를 다시 확인할 시각
+	contentRecheck time.Time
+	act            activity
+
+	// spec.tls의 서버 인증서 만료 시각 (확인하지 못하면 status의 이전 값)
+	certExpiry *metav1.Time
+	// 인증서를 다시 확인할 시각
+	certRecheck time.Time
+	// 마지막 재시작. 이번 reconcile에서 재시작하지 않았으면 status의 이전 값입니다.
+	lastRestart *demoappv2.RestartStatus
+
+	// 클러스터의 Service, 확인하지 못하면 nil
+	svc *corev1.Service
+	// 클러스터의 Deployment 또는 StatefulSet, 확인하지 못하면 nil
+	workload client.Object
+	// Demo의 pod를 고르는 label. selector 마이그레이션 중에는 workload의 이전 selector입니다.
+	selector map[string]string
+
+	// Demo가 controller가 아니어서 수정하지 않은 리소스
+	conflicts []string
+	// 이번 reconcile에서 status condition이 바뀌었는지
+	conditionsChanged bool
+}
+
+func newReconcileState(cr *demoappv2.Demo, sched scheduleState, now time.Time) *reconcileState {
+	return &reconcileState{
+		cr:          cr,
+		now:         now,
+		sched:       sched,
+		size:        sched.Size,
+		selector:    selectorLabels(cr),
+		contentRev:  cr.Status.ContentRevision,
+		certExpiry:  cr.Status.CertificateExpiry,
+		lastRestart: cr.Status.LastRestart,
+		act:         activity{State: cr.Status.Activity, LastActivity: cr.Status.LastActivityTime},
+	}
+}
+
+// reconcileStep은 Demo가 소유한 리소스 하나를 맞추는 단계입니다.
+type reconcileStep struct {
+	name string
+	run  func(ctx context.Context, s *reconcileState) error
+}
+
+// reconcile 한 번에 순서대로 실행하는 단계들입니다.
+// 리소스를 만들거나 고친 뒤 돌아가지 않고 status까지 한 번에 맞춥니다.
+func (r *DemoReconciler) steps() []reconcileStep {
+	return []reconcileStep{
+		{name: "finalizer", run: r.syncFinalizer},
+		{name: "service-account", run: r.syncServiceAccount},
+		{name: "tls", run: r.syncTLS},
+		{name: "service", run: r.syncService},
+		{name: "configmaps", run: r.syncConfigMaps},
+		{name: "headless-service", run: r.syncHeadlessService},
+		{name: "workload", run: r.syncWorkload},
+		{name: "stale-workload", run: r.syncStaleWorkload},
+		{name: "network-policy", run: r.syncNetworkPolicy},
+		{name: "status", run: r.syncStatus},
+	}
+}
+
+// runSteps는 모든 단계를 실행하고, 실패한 단계의 에러를 모아 반환합니다.
+// 한 단계가 실패해도 나머지 단계는 계속 실행합니다.
+func (r *DemoReconciler) runSteps(ctx context.Context, s *reconcileState, steps []reconcileStep) error {
+	logger := log.FromContext(ctx)
+
+	var errs []error
+	for _, step := range steps {
+		stepCtx, span := r.tracer().Start(ctx, step.name, trace.WithAttributes(demoAttributes(s.cr)...))
+		err := step.run(stepCtx, s)
+		endSpan(span, err)
+		if err != nil {
+			logger.Error(err, "Reconcile step failed", "step", step.name)
+			errs = append(errs, fmt.Errorf("%s: %w", step.name, err))
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// retentionPolicy가 Delete이면 삭제 시 PVC를 지울 수 있도록 finalizer를 붙입니다.
+func (r *DemoReconciler) syncFinalizer(ctx context.Context, s *reconcileState) error {
+	_, err := r.ensureVolumeFinalizer(ctx, s.cr)
+	return err
+}
+
+// Service가 없으면 만들고, spec.service나 sidecar 추가 등으로 종류나 포트가 바뀌면 맞춥니다.
+func (r *DemoReconciler) syncService(ctx context.Context, s *reconcileState) error {
+	logger := log.FromContext(ctx)
+	cr := s.cr
+
+	svc := &corev1.Service{}
+	newSvc, err := r.createService(cr)
+	if err != nil {
+		return err
+	}
+	err = r.Client.Get(ctx, client.ObjectKeyFromObject(newSvc), svc)
+	if errors.IsNotFound(err) {
+		if err := r.Client.Create(ctx, newSvc); err != nil {
+			return err
+		}
+		logger.Info("Service created", objectKeys("Service", newSvc.Namespace, newSvc.Name)...)
+		s.svc = newSvc
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// 다른 Service를 건드리지 않도록 수정하기 전에 소유 여부를 확인합니다.
+	// 소유하지 않은 Service는 s.svc가 nil로 남아 액티베이터로 돌리지도 않습니다.
+	if owned, err := r.claim(ctx, s, svc); err != nil || !owned {
+		return err
+	}
+
+	changed := mergeMetadata(svc, newSvc)
+	if ports := servicePorts(cr); svc.Spec.Type != serviceType(cr) || !servicePortsEqual(svc.Spec.Ports, ports) {
+		svc.Spec.Type = serviceType(cr)
+		svc.Spec.Ports = ports
+		logger.Info("Service ports changed", objectKeys("Service", svc.Namespace, svc.Name)...)
+		changed = true
+	}
+	if changed {
+		if err := r.Client.Update(ctx, svc); err != nil {
+			return err
+		}
+	}
+	s.svc = svc
+	return nil
+}
+
+// idle 감지 등에 필요한 nginx 설정과 inline content ConfigMap을 정의합니다.
+func (r *DemoReconciler) desiredConfigMaps(d *demoappv2.Demo) ([]*corev1.ConfigMap, error) {
+	var desired []*corev1.ConfigMap
+	if needsNginxConfig(d) {
+		cm, err := r.createNginxConfig(d)
+		if err != nil {
+			return nil, err
+		}
+		desired = append(desired, cm)
+	}
+	if c := d.Spec.Content; c != nil && len(c.Inline) > 0 {
+		cm, err := r.createContentConfigMap(d)
+		if err != nil {
+			return nil, err
+		}
+		desired = append(desired, cm)
+	}
+	return desired, nil
+}
+
+// desiredConfigMaps의 ConfigMap을 맞춥니다.
+func (r *DemoReconciler) syncConfigMaps(ctx context.Context, s *reconcileState) error {
+	desired, err := r.desiredConfigMaps(s.cr)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, cm := range desired {
+		if _, err := r.ensureConfigMap(ctx, s, cm); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// spec.storage가 있으면 StatefulSet이 사용하는 headless Service를 정의합니다. 없으면 nil입니다.
+func (r *DemoReconciler) desiredHeadlessService(d *demoappv2.Demo) (*corev1.Service, error) {
+	if d.Spec.Storage == nil {
+		return nil, nil
+	}
+	return r.createHeadlessService(d)
+}
+
+// desiredHeadlessService의 headless Service를 만들거나, spec.service나 sidecar로 포트가 바뀌면 맞춥니다.
+func (r *DemoReconciler) syncHeadlessService(ctx context.Context, s *reconcileState) error {
+	newHeadless, err := r.desiredHeadlessService(s.cr)
+	if err != nil || newHeadless == nil {
+		return err
+	}
+	headless := &corev1.Service{}
+	err = r.Client.Get(ctx, client.ObjectKeyFromObject(newHeadless), headless)
+	if err == nil {
+		if owned, err := r.claim(ctx, s, headless); err != nil || !owned {
+			return err
+		}
+		changed := mergeMetadata(headless, newHeadless)
+		if !servicePortsEqual(headless.Spec.Ports, newHeadless.Spec.Ports) {
+			headless.Spec.Ports = newHeadless.Spec.Ports
+			log.FromContext(ctx).Info("Headless Service ports changed", objectKeys("Service", headless.Namespace, headless.Name)...)
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		return r.Client.Update(ctx, headless)
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	if err := r.Client.Create(ctx, newHeadless); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("Headless Service created", objectKeys("Service", newHeadless.Namespace, newHeadless.Name)...)
+	return nil
+}
+
+// workload (Deployment 또는 StatefulSet)를 만들거나, pod template과 replicas를 한 번의 Update로 맞춥니다.
+// idle 상태에 따라 Service를 액티베이터로 돌리는 것도 scale-down 전에 여기서 합니다.
+func (r *DemoReconciler) syncWorkload(ctx context.Context, s *reconcileState) error {
+	logger := log.FromContext(ctx)
+	cr := s.cr
+
+	// spec.content의 revision이 바뀌면 pod template도 바뀌어 다시 배포됩니다.
+	// git branch는 움직일 수 있으므로 실패하더라도 주기적으로 다시 확인합니다.
+	if needsGitPoll(cr) {
+		s.contentRecheck = s.now.Add(gitPollInterval)
+	}
+	contentRev, err := r.contentRevision(ctx, cr)
+	if err != nil {
+		return fmt.Errorf("resolving content revision: %w", err)
+	}
+	s.contentRev = contentRev
+
+	desired, err := r.desiredWorkload(cr, contentRev, s.certExpiry)
+	if err != nil {
+		return err
+	}
+	kind := workloadKind(desired)
+	workload := emptyWorkload(desired)
+	workload.SetName(desired.GetName())
+	err = getWorkload(ctx, r.Client, cr, workload)
+	if errors.IsNotFound(err) {
+		workload = nil
+	} else if err != nil {
+		return err
+	}
+	// 이전 이름 규칙으로 만든 workload는 그 이름을 계속 사용합니다.
+	if workload != nil {
+		desired.SetName(workload.GetName())
+	}
+
+	// 이 Demo가 만들지 않은 workload의 replicas나 template은 바꾸지 않습니다.
+	if workload != nil {
+		if owned, err := r.claim(ctx, s, workload); err != nil || !owned {
+			return err
+		}
+	}
+
+	// 이전 selector를 쓰는 workload는 마이그레이션이 끝날 때까지 그 selector로 pod를 고릅니다.
+	// orphan으로 삭제 중인 workload의 pod는 이미 새 label을 가지고 있습니다.
+	deleting := workload != nil && !workload.GetDeletionTimestamp().IsZero()
+	migrating := workload != nil && !deleting && needsSelectorMigration(cr, workload)
+	if migrating {
+		s.selector = map[string]string{}
+		for k, v := range workloadSelector(workload).MatchLabels {
+			s.selector[k] = v
+		}
+		addTemplateLabels(desired, s.selector)
+	}
+
+	// 요청이 없는 Demo는 replicas를 0으로 내리고 Service를 액티베이터로 돌립니다.
+	var ready int32
+	if workload != nil {
+		ready = workloadReadyReplicas(workload)
+	}
+	s.act = r.observeActivity(ctx, cr, s.selector, ready, s.sched.Size, s.now)
+	s.size = s.act.replicas(s.sched.Size)
+
+	// scale-down 전에 Service를 먼저 액티베이터로 돌려 요청이 유실되지 않도록 합니다.
+	// Service를 확인하지 못했으면 요청을 받을 수 있도록 pod를 내리지 않습니다.
+	if s.svc != nil {
+		if err := r.routeService(ctx, cr, s.svc, s.selector, s.act.toActivator()); err != nil {
+			return fmt.Errorf("routing Service: %w", err)
+		}
+	} else {
+		s.size = s.sched.Size
+	}
+
+	// 삭제가 끝나면 watch로 다시 reconcile 되어 새 selector로 만듭니다.
+	if deleting {
+		logger.V(logDebug).Info("Waiting for old workload to be removed", objectKeys(kind, workload.GetNamespace(), workload.GetName())...)
+		return nil
+	}
+
+	if workload == nil {
+		*workloadReplicas(desired) = s.size
+		if err := r.Client.Create(ctx, desired); err != nil {
+			return err
+		}
+		logger.Info(kind+" created", append(objectKeys(kind, desired.GetNamespace(), desired.GetName()), "replicas", s.size)...)
+		if _, ok := desired.(*appsv1.StatefulSet); ok {
+			s.setStorageSynced()
+		}
+		s.workload = desired
+		return nil
+	}
+	s.workload = workload
+
+	if sts, ok := workload.(*appsv1.StatefulSet); ok && !migrating {
+		deleted, err := r.syncVolumeClaimTemplate(ctx, s, sts, desired.(*appsv1.StatefulSet))
+		if err != nil {
+			return err
+		}
+		// 삭제가 끝나면 watch로 다시 reconcile 되어 새 volumeClaimTemplates로 만듭니다.
+		if deleted {
+			s.workload = nil
+			return nil
+		}
+	}
+
+	// workload annotation 중 template 해시는 template과 함께 맞추므로 spec.commonAnnotations만 더합니다.
+	changed := mergeMetadata(workload, &metav1.ObjectMeta{Labels: desired.GetLabels(), Annotations: objectAnnotations(cr)})
+	var restart *demoappv2.RestartStatus
+	// pod template이 바뀐 경우 (예: spec.scaling.idle 설정 변경) template을 다시 맞춥니다.
+	if desiredHash := desired.GetAnnotations()[templateHashAnnotation]; workload.GetAnnotations()[templateHashAnnotation] != desiredHash {
+		restart = restartCause(workloadTemplate(workload), workloadTemplate(desired), s.now)
+		*workloadTemplate(workload) = *workloadTemplate(desired)
+		annotations := workload.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		for k, v := range desired.GetAnnotations() {
+			annotations[k] = v
+		}
+		workload.SetAnnotations(annotations)
+		logger.Info("Pod template changed", objectKeys(kind, workload.GetNamespace(), workload.GetName())...)
+		changed = true
+	}
+	if replicas := workloadReplicas(workload); *replicas != s.size {
+		*replicas = s.size
+		logger.Info("Replicas changed", append(objectKeys(kind, workload.GetNamespace(), workload.GetName()), "replicas", s.size)...)
+		changed = true
+	}
+	if changed {
+		if err := r.Client.Update(ctx, workload); err != nil {
+			return err
+		}
+		if restart != nil {
+			s.lastRestart = restart
+			r.event(cr, corev1.EventTypeNormal, reasonRestarted, restart.Message)
+		}
+		return nil
+	}
+
+	if migrating {
+		_, err := r.migrateSelector(ctx, cr, workload)
+		return err
+	}
+	return nil
+}
+
+// Deployment <-> StatefulSet 전환 중이면 새 workload가 준비된 뒤 이전 workload를 정리합니다.
+func (r *DemoReconciler) syncStaleWorkload(ctx context.Context, s *reconcileState) error {
+	if s.workload == nil {
+		return nil
+	}
+	_, err := r.cleanupStaleWorkload(ctx, s.cr, s.workload, s.size)
+	return err
+}
+
+// pod, volume, schedule, idle 상태를 status에 기록합니다. 바뀐 것이 없으면 쓰지 않습니다.
+func (r *DemoReconciler) syncStatus(ctx context.Context, s *reconcileState) error {
+	logger := log.FromContext(ctx)
+	cr := s.cr
+
+	volumes, err := r.volumeStatuses(ctx, cr)
+	if err != nil {
+		return fmt.Errorf("listing PersistentVolumeClaims: %w", err)
+	}
+
+	podList := &corev1.PodList{}
+	err = r.Client.List(ctx, podList, client.InNamespace(cr.Namespace), client.MatchingLabels(s.selector))
+	if err != nil {
+		return fmt.Errorf("listing Pods: %w", err)
+	}
+
+	podNames := getPodNames(podList.Items)
+	pods := getPodSummaries(podList.Items)
+	containers := cr.Status.Containers
+	if s.workload != nil {
+		containers = containerReadiness(workloadTemplate(s.workload), podList.Items)
+	}
+
+	// conflict가 새로 생기거나 바뀐 경우에만 이벤트를 남깁니다.
+	// 멈춘 Demo는 리소스를 확인하지 않으므로 이전 condition을 그대로 둡니다.
+	if !isPaused(cr) && s.setOwnedCondition() && len(s.conflicts) > 0 {
+		r.event(cr, corev1.EventTypeWarning, reasonAdoptionConflict, strings.Join(s.conflicts, "; "))
+	}
+	s.setPausedCondition()
+	s.setValidCondition()
+	s.clearStorageCondition()
+
+	var nextTransition *metav1.Time
+	if s.sched.Next != nil {
+		nextTransition = &metav1.Time{Time: *s.sched.Next}
+	}
+
+	if reflect.DeepEqual(pods, cr.Status.Pods) &&
+		cr.Status.ActiveSchedule == s.sched.Active &&
+		nextTransition.Equal(cr.Status.NextTransition) &&
+		cr.Status.Activity == s.act.State &&
+		s.act.LastActivity.Equal(cr.Status.LastActivityTime) &&
+		reflect.DeepEqual(volumes, cr.Status.Volumes) &&
+		reflect.DeepEqual(containers, cr.Status.Containers) &&
+		cr.Status.ContentRevision == s.contentRev &&
+		s.certExpiry.Equal(cr.Status.CertificateExpiry) &&
+		s.lastRestart == cr.Status.LastRestart &&
+		!s.conditionsChanged {
+		return nil
+	}
+
+	logger.V(logDebug).Info("Updating status", "pods", podNames, "activeSchedule", s.sched.Active, "activity", s.act.State)
+	cr.Status.Pods = pods
+	cr.Status.ActiveSchedule = s.sched.Active
+	cr.Status.NextTransition = nextTransition
+	cr.Status.Activity = s.act.State
+	cr.Status.LastActivityTime = s.act.LastActivity
+	cr.Status.Volumes = volumes
+	cr.Status.Containers = containers
+	cr.Status.ContentRevision = s.contentRev
+	cr.Status.CertificateExpiry = s.certExpiry
+	cr.Status.LastRestart = s.lastRestart
+	return r.Client.Status().Update(ctx, cr)
+}
+
+// 다음 스케줄 전환이나 idle 확인 시점에 정확히 다시 reconcile 되도록 합니다.
+func (s *reconcileState) requeueAfter() time.Duration {
+	requeue := s.act.RequeueAfter
+	if s.sched.Next != nil {
+		if d := s.sched.Next.Sub(s.now); requeue == 0 || d < requeue {
+			requeue = d
+		}
+	}
+	if !s.certRecheck.IsZero() {
+		if d := s.certRecheck.Sub(s.now); requeue == 0 || d < requeue {
+			requeue = d
+		}
+	}
+	if !s.contentRecheck.IsZero() {
+		if d := s.contentRecheck.Sub(s.now); requeue == 0 || d < requeue {
+			requeue = d
+		}
+	}
+	if len(s.conflicts) > 0 && (requeue == 0 || conflictRequeueInterval < requeue) {
+		requeue = conflictRequeueInterval
+	}
+	return requeue
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// countingClient는 reconcile 한 번에 보낸 API 요청 수를 셉니다.
+// failCreate에 있는 kind는 Create가 실패합니다.
+type countingClient struct {
+	client.Client
+	reads, writes int
+	failCreate    string
+}
+
+func (c *countingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	c.reads++
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *countingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.reads++
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *countingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.writes++
+	if kind := fmt.Sprintf("%T", obj); c.failCreate != "" && strings.HasSuffix(kind, "."+c.failCreate) {
+		return fmt.Errorf("create %s refused", c.failCreate)
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *countingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.writes++
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *countingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.writes++
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *countingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.writes++
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// Status는 writer를 얻을 때가 아니라 status를 쓸 때 셉니다.
+func (c *countingClient) Status() client.StatusWriter {
+	return &countingStatusWriter{StatusWriter: c.Client.Status(), c: c}
+}
+
+type countingStatusWriter struct {
+	client.StatusWriter
+	c *countingClient
+}
+
+func (w *countingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	w.c.writes++
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *countingStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	w.c.writes++
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *countingClient) reset() {
+	c.reads, c.writes = 0, 0
+}
+
+func newPipelineTest(t *testing.T, objs ...client.Object) (*DemoReconciler, *countingClient) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = demoappv2.AddToScheme(scheme)
+
+	c := &countingClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()}
+	return &DemoReconciler{Client: c, Scheme: scheme}, c
+}
+
+func reconcileRequest(name string) ctrl.Request {
+	return ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}}
+}
+
+func reconcileDemo(t *testing.T, r *DemoReconciler, name string) error {
+	t.Helper()
+	_, err := r.Reconcile(context.Background(), reconcileRequest(name))
+	return err
+}
+
+// 새 Demo는 한 번의 reconcile로 Service, Deployment, status까지 맞춰집니다.
+func TestReconcileConvergesInOnePass(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 2},
+			Content: &demoappv2.ContentSpec{Inline: map[string]string{"index.html": "hello"}},
+		},
+	}
+	r, c := newPipelineTest(t, cr)
+	ctx := context.Background()
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	// Service, nginx 설정과 content ConfigMap, Deployment 생성과 status.contentRevision 기록
+	t.Logf("first reconcile: %d reads, %d writes", c.reads, c.writes)
+	if c.writes != 5 {
+		t.Errorf("got %d writes for a new Demo, want 5", c.writes)
+	}
+
+	dep := &appsv1.Deployment{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, dep); err != nil {
+		t.Fatal(err)
+	}
+	if *dep.Spec.Replicas != 2 {
+		t.Errorf("got %d replicas, want 2", *dep.Spec.Replicas)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &corev1.Service{}); err != nil {
+		t.Error(err)
+	}
+	got := &demoappv2.Demo{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.ContentRevision == "" {
+		t.Error("status.contentRevision was not written in the first pass")
+	}
+
+	// 수렴한 뒤에는 아무것도 쓰지 않습니다.
+	c.reset()
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("converged reconcile: %d reads, %d writes", c.reads, c.writes)
+	if c.writes != 0 {
+		t.Errorf("got %d writes after convergence, want none", c.writes)
+	}
+
+	// replicas와 template이 함께 바뀌어도 workload는 한 번만 Update 합니다.
+	got.Spec.Scaling.Replicas = 3
+	got.Spec.Image = "nginx:1.21"
+	if err := c.Client.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	c.reset()
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != 1 {
+		t.Errorf("got %d writes for a replicas and image change, want 1", c.writes)
+	}
+}
+
+// 한 단계가 실패해도 나머지 단계는 실행하고, 에러를 모아 반환합니다.
+func TestReconcileCollectsStepErrors(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+	}
+	r, c := newPipelineTest(t, cr)
+	c.failCreate = "Service"
+
+	err := reconcileDemo(t, r, "web")
+	if err == nil || !strings.Contains(err.Error(), "service: create Service refused") {
+		t.Fatalf("got %v, want the service step error", err)
+	}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, &appsv1.Deployment{}); err != nil {
+		t.Errorf("Deployment should be created although the Service failed: %v", err)
+	}
+}
+
+// 각 단계는 reconcileState만으로 따로 실행할 수 있습니다.
+func TestSyncServiceUpdatesPorts(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Service: demoappv2.ServiceSpec{Port: 8080}},
+	}
+	r, c := newPipelineTest(t, cr)
+	// spec.service가 없을 때 만든 Service
+	svc, _ := r.createService(&demoappv2.Demo{ObjectMeta: cr.ObjectMeta})
+	if err := c.Client.Create(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newReconcileState(cr, scheduleState{}, metav1.Now().Time)
+	if err := r.syncService(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != 1 || s.svc == nil || s.svc.Spec.Ports[0].Port != 8080 {
+		t.Errorf("got %d writes and Service %+v, want the port updated", c.writes, s.svc)
+	}
+}
+
+func TestSyncHeadlessServiceUpdatesPorts(t *testing.T) {
+	storage := &demoappv2.StorageSpec{Size: resource.MustParse("1Gi")}
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Service: demoappv2.ServiceSpec{Port: 8080}, Storage: storage},
+	}
+	r, c := newPipelineTest(t, cr)
+	// spec.service가 없을 때 만든 headless Service
+	headless, _ := r.createHeadlessService(&demoappv2.Demo{ObjectMeta: cr.ObjectMeta, Spec: demoappv2.DemoSpec{Storage: storage}})
+	if err := c.Client.Create(context.Background(), headless); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newReconcileState(cr, scheduleState{}, metav1.Now().Time)
+	if err := r.syncHeadlessService(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	got := &corev1.Service{}
+	if err := c.Client.Get(context.Background(), client.ObjectKeyFromObject(headless), got); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != 1 || got.Spec.Ports[0].Port != 8080 {
+		t.Errorf("got %d writes and ports %+v, want the port updated", c.writes, got.Spec.Ports)
+	}
+
+	// 포트가 같으면 쓰지 않습니다.
+	c.reset()
+	if err := r.syncHeadlessService(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != 0 {
+		t.Errorf("got %d writes for an unchanged headless Service", c.writes)
+	}
+}
+
+// Service를 확인하지 못해도 workload는 schedule의 replicas로 한 번에 만듭니다.
+func TestSyncWorkloadCreatesWorkloadWithoutService(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2}},
+	}
+	r, c := newPipelineTest(t, cr)
+
+	s := newReconcileState(cr, scheduleState{Size: 2}, metav1.Now().Time)
+	if err := r.syncWorkload(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if s.workload == nil || *workloadReplicas(s.workload) != 2 || c.writes != 1 {
+		t.Errorf("got workload %v with %d writes, want a Deployment with 2 replicas", s.workload, c.writes)
+	}
+}
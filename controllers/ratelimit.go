@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// controller-runtime이 이미 제공하는 workqueue_depth, workqueue_queue_duration_seconds,
+// controller_runtime_reconcile_time_seconds 등 (controller="demo")과 함께
+// API 쓰기 제한으로 기다린 시간을 노출합니다.
+var (
+	apiWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "demo_operator_api_writes_total",
+		Help: "Number of create, update, patch and delete requests sent by the Demo controller.",
+	}, []string{"verb"})
+
+	apiWriteWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "demo_operator_api_write_wait_seconds",
+		Help:    "Time API writes waited for the write rate limit.",
+		Buckets: []float64{0.001, 0.01, 0.1, 0.5, 1, 5, 10, 30},
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(apiWrites, apiWriteWait)
+}
+
+// NewRateLimiter는 실패한 Demo를 baseDelay부터 두 배씩 maxDelay까지 늦춰 다시 시도하는 rate limiter를 만듭니다.
+// 전체 큐에 대한 제한(초당 10개, burst 100)은 controller-runtime 기본값과 같습니다.
+func NewRateLimiter(baseDelay, maxDelay time.Duration) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// NewWriteLimitedClient는 모든 worker가 함께 쓰는 token bucket으로 쓰기 요청을 제한하는 client를 만듭니다.
+// 읽기는 cache에서 하므로 제한하지 않습니다.
+func NewWriteLimitedClient(c client.Client, limiter *rate.Limiter) client.Client {
+	return &writeLimitedClient{Client: c, limiter: limiter}
+}
+
+type writeLimitedClient struct {
+	client.Client
+	limiter *rate.Limiter
+}
+
+// 쓰기 전에 token을 기다립니다. ctx가 끝나면 에러를 반환합니다.
+func (c *writeLimitedClient) wait(ctx context.Context, verb string) error {
+	start := time.Now()
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	apiWriteWait.Observe(time.Since(start).Seconds())
+	apiWrites.WithLabelValues(verb).Inc()
+	return nil
+}
+
+func (c *writeLimitedClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.wait(ctx, "create"); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *writeLimitedClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.wait(ctx, "update"); err != nil {
+		return err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *writeLimitedClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := c.wait(ctx, "patch"); err != nil {
+		return err
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *writeLimitedClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.wait(ctx, "delete"); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *writeLimitedClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	if err := c.wait(ctx, "deletecollection"); err != nil {
+		return err
+	}
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *writeLimitedClient) Status() client.StatusWriter {
+	return &writeLimitedStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type writeLimitedStatusWriter struct {
+	client.StatusWriter
+	client *writeLimitedClient
+}
+
+func (w *writeLimitedStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := w.client.wait(ctx, "update"); err != nil {
+		return err
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *writeLimitedStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := w.client.wait(ctx, "patch"); err != nil {
+		return err
+	}
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
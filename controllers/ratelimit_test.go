@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	l := NewRateLimiter(10*time.Millisecond, 50*time.Millisecond)
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond}
+	for i, w := range want {
+		if got := l.When("demo"); got != w {
+			t.Errorf("failure %d: got delay %s, want %s", i+1, got, w)
+		}
+	}
+
+	// 성공하면 다시 처음 간격부터 시작합니다.
+	l.Forget("demo")
+	if got := l.When("demo"); got != 10*time.Millisecond {
+		t.Errorf("got delay %s after Forget, want 10ms", got)
+	}
+}
+
+func TestWriteLimitedClient(t *testing.T) {
+	c := NewWriteLimitedClient(fake.NewClientBuilder().Build(), rate.NewLimiter(rate.Every(100*time.Millisecond), 1))
+	ctx := context.Background()
+
+	start := time.Now()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := c.Create(ctx, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// burst 1이므로 두 번째와 세 번째 쓰기는 각각 100ms를 기다립니다.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("3 writes took %s, want them throttled", elapsed)
+	}
+
+	// 읽기는 제한하지 않습니다.
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		if err := c.List(ctx, &corev1.ConfigMapList{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("reads took %s, want them unthrottled", elapsed)
+	}
+
+	// 기다리는 중 ctx가 끝나면 쓰지 않고 에러를 반환합니다.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := c.Delete(cancelled, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}}); err == nil {
+		t.Error("expected an error for a cancelled context")
+	}
+}
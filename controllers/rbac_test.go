@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// RequiredAccess는 +kubebuilder:rbac marker로 만든 config/rbac/role.yaml의 namespace 단위 권한과 같아야 합니다.
+// marker만 고치면 시작할 때 확인하는 권한과 배포하는 Role이 달라집니다.
+// role.yaml은 `make manifests`로 marker에서 다시 만듭니다.
+func TestRequiredAccessMatchesRBACMarkers(t *testing.T) {
+	content, err := ioutil.ReadFile("../config/rbac/role.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := &rbacv1.ClusterRole{}
+	if err := yaml.Unmarshal(content, role); err != nil {
+		t.Fatal(err)
+	}
+
+	// cluster 단위 리소스와 이름을 지정한 권한은 namespace마다 확인하지 않습니다.
+	clusterScoped := map[string]bool{"namespaces": true, "customresourcedefinitions": true}
+
+	markers := map[string][]string{}
+	for _, rule := range role.Rules {
+		if len(rule.ResourceNames) > 0 {
+			continue
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				if clusterScoped[resource] {
+					continue
+				}
+				key := group + "/" + resource
+				markers[key] = append(markers[key], rule.Verbs...)
+			}
+		}
+	}
+
+	required := map[string][]string{}
+	for _, rule := range RequiredAccess {
+		key := rule.Group + "/" + rule.Resource
+		required[key] = append(required[key], rule.Verbs...)
+	}
+
+	for _, verbs := range markers {
+		sort.Strings(verbs)
+	}
+	for _, verbs := range required {
+		sort.Strings(verbs)
+	}
+	for key, verbs := range markers {
+		if !reflect.DeepEqual(required[key], verbs) {
+			t.Errorf("%s: RequiredAccess has %v, the RBAC markers grant %v", key, required[key], verbs)
+		}
+	}
+	for key, verbs := range required {
+		if _, ok := markers[key]; !ok {
+			t.Errorf("%s: RequiredAccess has %v, the RBAC markers grant nothing", key, verbs)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// apiFault는 verb, kind, name이 맞는 요청을 실패시킵니다. name이 비어 있으면 모든 이름에 적용합니다.
+// verb는 get, list, create, update, delete, status 중 하나이고, kind는 Service나 PodList 같은 Go 타입 이름입니다.
+type apiFault struct {
+	verb, kind, name string
+}
+
+// faultyClient는 fault에 맞는 요청에 ServiceUnavailable 에러를 돌려줍니다.
+type faultyClient struct {
+	client.Client
+	fault apiFault
+}
+
+func (c *faultyClient) inject(verb string, obj runtime.Object, name string) error {
+	kind := reflect.TypeOf(obj).Elem().Name()
+	if c.fault.verb == verb && c.fault.kind == kind && (c.fault.name == "" || c.fault.name == name) {
+		return apierrors.NewServiceUnavailable("injected " + verb + " " + kind + " failure")
+	}
+	return nil
+}
+
+func (c *faultyClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if err := c.inject("get", obj, key.Name); err != nil {
+		return err
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *faultyClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := c.inject("list", list, ""); err != nil {
+		return err
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *faultyClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.inject("create", obj, obj.GetName()); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *faultyClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.inject("update", obj, obj.GetName()); err != nil {
+		return err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *faultyClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.inject("delete", obj, obj.GetName()); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *faultyClient) Status() client.StatusWriter {
+	return &faultyStatusWriter{StatusWriter: c.Client.Status(), c: c}
+}
+
+type faultyStatusWriter struct {
+	client.StatusWriter
+	c *faultyClient
+}
+
+func (w *faultyStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := w.c.inject("status", obj, obj.GetName()); err != nil {
+		return err
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+// 각 단계의 API 요청이 실패하면 Reconcile은 그 단계의 에러를 돌려주고, 나머지 단계는 계속 진행합니다.
+// 표는 단계마다 객체를 읽고, 만들고, 고치고, 지우는 요청을 실패시킵니다. 준비된 pod가 있어야 하는 요청
+// (이전 workload 삭제, 액티베이터로 Service 돌리기)과 인증서 갱신 요청은 다루지 않습니다.
+func TestReconcileAPIErrors(t *testing.T) {
+	storage := func(d *demoappv2.Demo) {
+		d.Spec.Storage = &demoappv2.StorageSpec{Size: resource.MustParse("1Gi")}
+	}
+	tls := func(d *demoappv2.Demo) { d.Spec.TLS = &demoappv2.TLSSpec{} }
+	networkPolicy := func(d *demoappv2.Demo) { d.Spec.NetworkPolicy = &demoappv2.NetworkPolicySpec{} }
+	serviceAccount := func(d *demoappv2.Demo) {
+		d.Spec.ServiceAccount = &demoappv2.ServiceAccountSpec{Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}},
+		}}
+	}
+	scale := func(d *demoappv2.Demo) { d.Spec.Scaling.Replicas = 3 }
+	servicePort := func(d *demoappv2.Demo) { d.Spec.Service.Port = 8080 }
+	content := func(d *demoappv2.Demo) {
+		d.Spec.Content = &demoappv2.ContentSpec{Inline: map[string]string{"index.html": "hello"}}
+	}
+	changeContent := func(d *demoappv2.Demo) { d.Spec.Content.Inline["index.html"] = "bye" }
+	labels := func(d *demoappv2.Demo) { d.Spec.CommonLabels = map[string]string{"team": "web"} }
+	deleteVolumes := func(d *demoappv2.Demo) {
+		storage(d)
+		d.Spec.Storage.RetentionPolicy = demoappv2.DeleteVolumes
+	}
+	changeRules := func(d *demoappv2.Demo) { d.Spec.ServiceAccount.Rules[0].Verbs = []string{"get", "list"} }
+	changeIngress := func(d *demoappv2.Demo) {
+		d.Spec.NetworkPolicy.Ingress = []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}
+	}
+
+	tests := []struct {
+		name  string
+		fault apiFault
+		// Demo의 처음 spec
+		setup func(d *demoappv2.Demo)
+		// 한 번 reconcile 한 뒤 바꾸는 spec. nil이면 처음 reconcile에서 실패시킵니다.
+		change func(d *demoappv2.Demo)
+		// 에러 메시지의 단계 이름. 비어 있으면 단계를 실행하기 전에 실패합니다.
+		step string
+		// 실패한 단계와 상관없이 만들어져야 하는 객체
+		want client.Object
+	}{
+		{name: "get demo", fault: apiFault{"get", "Demo", ""}},
+		{name: "get service", fault: apiFault{"get", "Service", "web"}, step: "service", want: &appsv1.Deployment{}},
+		{name: "create service", fault: apiFault{"create", "Service", "web"}, step: "service", want: &appsv1.Deployment{}},
+		{name: "update service", fault: apiFault{"update", "Service", "web"}, change: servicePort, step: "service", want: &appsv1.Deployment{}},
+		{name: "get configmap", fault: apiFault{"get", "ConfigMap", "web-nginx"}, step: "configmaps", want: &corev1.Service{}},
+		{name: "create configmap", fault: apiFault{"create", "ConfigMap", "web-nginx"}, step: "configmaps", want: &appsv1.Deployment{}},
+		{name: "create content configmap", fault: apiFault{"create", "ConfigMap", "web-content"}, setup: content, step: "configmaps", want: &appsv1.Deployment{}},
+		{name: "update content configmap", fault: apiFault{"update", "ConfigMap", "web-content"}, setup: content, change: changeContent, step: "configmaps", want: &appsv1.Deployment{}},
+		{name: "get deployment", fault: apiFault{"get", "Deployment", ""}, step: "workload", want: &corev1.Service{}},
+		{name: "create deployment", fault: apiFault{"create", "Deployment", ""}, step: "workload", want: &corev1.Service{}},
+		{name: "update deployment", fault: apiFault{"update", "Deployment", ""}, change: scale, step: "workload", want: &corev1.Service{}},
+		{name: "get headless service", fault: apiFault{"get", "Service", "web-headless"}, setup: storage, step: "headless-service", want: &appsv1.StatefulSet{}},
+		{name: "create headless service", fault: apiFault{"create", "Service", "web-headless"}, setup: storage, step: "headless-service", want: &appsv1.StatefulSet{}},
+		{name: "update headless service", fault: apiFault{"update", "Service", "web-headless"}, setup: storage, change: labels, step: "headless-service", want: &appsv1.StatefulSet{}},
+		{name: "get statefulset", fault: apiFault{"get", "StatefulSet", ""}, setup: storage, step: "workload", want: &corev1.Service{}},
+		{name: "create statefulset", fault: apiFault{"create", "StatefulSet", ""}, setup: storage, step: "workload", want: &corev1.Service{}},
+		{name: "update statefulset", fault: apiFault{"update", "StatefulSet", ""}, setup: storage, change: scale, step: "workload", want: &corev1.Service{}},
+		{name: "get stale deployment", fault: apiFault{"get", "Deployment", ""}, change: storage, step: "stale-workload", want: &appsv1.StatefulSet{}},
+		{name: "add finalizer", fault: apiFault{"update", "Demo", ""}, setup: deleteVolumes, step: "finalizer", want: &appsv1.StatefulSet{}},
+		{name: "list volumes", fault: apiFault{"list", "PersistentVolumeClaimList", ""}, setup: storage, step: "status", want: &appsv1.StatefulSet{}},
+		{name: "list pods", fault: apiFault{"list", "PodList", ""}, step: "status", want: &appsv1.Deployment{}},
+		{name: "update status", fault: apiFault{"status", "Demo", ""}, step: "status", want: &appsv1.Deployment{}},
+		{name: "get network policy", fault: apiFault{"get", "NetworkPolicy", ""}, step: "network-policy", want: &appsv1.Deployment{}},
+		{name: "create network policy", fault: apiFault{"create", "NetworkPolicy", ""}, setup: networkPolicy, step: "network-policy", want: &appsv1.Deployment{}},
+		{name: "update network policy", fault: apiFault{"update", "NetworkPolicy", ""}, setup: networkPolicy, change: changeIngress, step: "network-policy", want: &appsv1.Deployment{}},
+		{name: "delete network policy", fault: apiFault{"delete", "NetworkPolicy", ""}, setup: networkPolicy,
+			change: func(d *demoappv2.Demo) { d.Spec.NetworkPolicy = nil }, step: "network-policy", want: &appsv1.Deployment{}},
+		{name: "get service account", fault: apiFault{"get", "ServiceAccount", ""}, step: "service-account", want: &appsv1.Deployment{}},
+		{name: "create service account", fault: apiFault{"create", "ServiceAccount", ""}, setup: serviceAccount, step: "service-account", want: &appsv1.Deployment{}},
+		{name: "create role", fault: apiFault{"create", "Role", ""}, setup: serviceAccount, step: "service-account", want: &corev1.ServiceAccount{}},
+		{name: "update role", fault: apiFault{"update", "Role", ""}, setup: serviceAccount, change: changeRules, step: "service-account", want: &appsv1.Deployment{}},
+		{name: "delete role", fault: apiFault{"delete", "Role", ""}, setup: serviceAccount,
+			change: func(d *demoappv2.Demo) { d.Spec.ServiceAccount.Rules = nil }, step: "service-account", want: &appsv1.Deployment{}},
+		{name: "create role binding", fault: apiFault{"create", "RoleBinding", ""}, setup: serviceAccount, step: "service-account", want: &rbacv1.Role{}},
+		{name: "update role binding", fault: apiFault{"update", "RoleBinding", ""}, setup: serviceAccount,
+			change: func(d *demoappv2.Demo) { d.Spec.ServiceAccount.Name = "shared" }, step: "service-account", want: &appsv1.Deployment{}},
+		{name: "get tls secret", fault: apiFault{"get", "Secret", ""}, setup: tls, step: "tls", want: &appsv1.Deployment{}},
+		{name: "create tls secret", fault: apiFault{"create", "Secret", ""}, setup: tls, step: "tls", want: &appsv1.Deployment{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &demoappv2.Demo{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+				Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+			}
+			if tt.setup != nil {
+				tt.setup(cr)
+			}
+			r, c := newPipelineTest(t, cr)
+			ctx := context.Background()
+			key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+			if tt.change != nil {
+				if err := reconcileDemo(t, r, "web"); err != nil {
+					t.Fatal(err)
+				}
+				got := &demoappv2.Demo{}
+				if err := c.Get(ctx, key, got); err != nil {
+					t.Fatal(err)
+				}
+				tt.change(got)
+				if err := c.Update(ctx, got); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r.Client = &faultyClient{Client: c, fault: tt.fault}
+			err := reconcileDemo(t, r, "web")
+			if !apierrors.IsServiceUnavailable(unwrapAggregate(err)) {
+				t.Fatalf("got error %v, want the injected failure", err)
+			}
+			if tt.step != "" && !strings.Contains(err.Error(), tt.step+": ") {
+				t.Errorf("got error %q, want it from step %s", err, tt.step)
+			}
+			if tt.want != nil {
+				if err := c.Get(ctx, key, tt.want); err != nil {
+					t.Errorf("got %v, want %T to be reconciled despite the failure", err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// 여러 단계의 에러를 모은 aggregate에서 첫 번째 에러를 꺼냅니다.
+func unwrapAggregate(err error) error {
+	for err != nil {
+		if agg, ok := err.(interface{ Errors() []error }); ok && len(agg.Errors()) > 0 {
+			err = agg.Errors()[0]
+			continue
+		}
+		next, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return err
+		}
+		err = next.Unwrap()
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	demoappv2 "demo-operator/api/v2"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Render는 API 서버에 요청하지 않고 d가 원하는 객체를 만듭니다. reconcile이 만드는 순서대로 반환합니다.
+// 클러스터에서 정해지는 값은 다음과 같이 정합니다.
+//   - replicas는 schedule과 idle 상태 대신 spec.scaling.replicas를 사용합니다.
+//   - spec.content.configMap의 revision과 인증서 만료 시각은 status의 값을 사용합니다.
+//   - operator가 발급하는 TLS Secret은 발급할 때마다 달라지므로 포함하지 않습니다.
+//   - NetworkPolicy에는 액티베이터 pod를 허용하는 규칙이 없습니다.
+//
+// spec은 Reconcile과 같은 순서로 먼저 검사합니다.
+func (r *DemoReconciler) Render(d *demoappv2.Demo) ([]client.Object, error) {
+	if _, err := r.validateSpec(d); err != nil {
+		return nil, err
+	}
+
+	// 각 sync step이 쓰는 desired* 함수로 만듭니다. 필요 없는 객체는 step이 지우는 대상이므로 뺍니다.
+	var objs []client.Object
+	sa, err := r.desiredServiceAccount(d)
+	if err != nil {
+		return nil, err
+	}
+	if sa.createAccount {
+		objs = append(objs, sa.account)
+	}
+	if sa.grant {
+		objs = append(objs, sa.role, sa.binding)
+	}
+
+	svc, err := r.createService(d)
+	if err != nil {
+		return nil, err
+	}
+	objs = append(objs, svc)
+
+	configMaps, err := r.desiredConfigMaps(d)
+	if err != nil {
+		return nil, err
+	}
+	for _, cm := range configMaps {
+		objs = append(objs, cm)
+	}
+
+	headless, err := r.desiredHeadlessService(d)
+	if err != nil {
+		return nil, err
+	}
+	if headless != nil {
+		objs = append(objs, headless)
+	}
+
+	contentRev, ok := specContentRevision(d)
+	if !ok {
+		contentRev = d.Status.ContentRevision
+	}
+	workload, err := r.desiredWorkload(d, contentRev, d.Status.CertificateExpiry)
+	if err != nil {
+		return nil, err
+	}
+	objs = append(objs, workload)
+
+	np, err := r.desiredNetworkPolicy(d, selectorLabels(d))
+	if err != nil {
+		return nil, err
+	}
+	if np != nil {
+		objs = append(objs, np)
+	}
+
+	// 출력했을 때 apiVersion과 kind가 보이도록 채웁니다.
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+	}
+	return objs, nil
+}
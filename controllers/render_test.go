@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+)
+
+// go test ./controllers/ -run TestRenderGolden -update 로 testdata/render의 golden 파일을 다시 만듭니다.
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestRenderGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		spec demoappv2.DemoSpec
+		// status에서 읽는 값
+		status demoappv2.DemoStatus
+	}{
+		{
+			name: "default",
+			spec: demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2}},
+		},
+		{
+			name: "unrestricted-nodeport",
+			spec: demoappv2.DemoSpec{
+				Image:   "nginx:1.21.6",
+				Service: demoappv2.ServiceSpec{Type: corev1.ServiceTypeNodePort, Port: 8080},
+				Scaling: demoappv2.ScalingSpec{Replicas: 1},
+				Pod:     demoappv2.PodSpec{SecurityProfile: demoappv2.SecurityUnrestricted},
+			},
+		},
+		{
+			name: "storage",
+			spec: demoappv2.DemoSpec{
+				Scaling: demoappv2.ScalingSpec{Replicas: 3},
+				Storage: &demoappv2.StorageSpec{
+					Size:             resource.MustParse("1Gi"),
+					StorageClassName: pointer.String("fast"),
+					RetentionPolicy:  demoappv2.DeleteVolumes,
+				},
+			},
+		},
+		{
+			name: "content-inline",
+			spec: demoappv2.DemoSpec{
+				Scaling: demoappv2.ScalingSpec{Replicas: 1},
+				Content: &demoappv2.ContentSpec{Inline: map[string]string{"index.html": "<h1>hello</h1>"}},
+			},
+		},
+		{
+			name: "content-git",
+			spec: demoappv2.DemoSpec{
+				Scaling: demoappv2.ScalingSpec{Replicas: 1},
+				Content: &demoappv2.ContentSpec{Git: &demoappv2.GitSource{Repository: "https://example.com/site.git", Revision: "v1"}},
+			},
+			// git revision은 offline으로 확인할 수 없으므로 status의 commit을 사용합니다.
+			status: demoappv2.DemoStatus{ContentRevision: "3f786850e387550fdab836ed7e6dc881de23001b"},
+		},
+		{
+			name: "content-configmap",
+			spec: demoappv2.DemoSpec{
+				Scaling: demoappv2.ScalingSpec{Replicas: 1},
+				Content: &demoappv2.ContentSpec{ConfigMap: &corev1.LocalObjectReference{Name: "site"}},
+			},
+			status: demoappv2.DemoStatus{ContentRevision: "5c7b4d9f"},
+		},
+		{
+			name: "sidecars-idle",
+			spec: demoappv2.DemoSpec{
+				Scaling: demoappv2.ScalingSpec{Replicas: 1, Idle: &demoappv2.IdleSpec{AfterMinutes: 10}},
+				Pod: demoappv2.PodSpec{
+					Sidecars:   []demoappv2.BuiltinSidecar{demoappv2.NginxPrometheusExporter},
+					Containers: []corev1.Container{{Name: "log-shipper", Image: "fluent-bit:1.8"}},
+				},
+			},
+		},
+		{
+			name: "network-policy",
+			spec: demoappv2.DemoSpec{
+				Scaling: demoappv2.ScalingSpec{Replicas: 1},
+				NetworkPolicy: &demoappv2.NetworkPolicySpec{
+					Ingress: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}},
+					}},
+				},
+			},
+		},
+		{
+			name: "service-account",
+			spec: demoappv2.DemoSpec{
+				Scaling: demoappv2.ScalingSpec{Replicas: 1},
+				ServiceAccount: &demoappv2.ServiceAccountSpec{Rules: []rbacv1.PolicyRule{
+					{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "list"}},
+				}},
+			},
+		},
+		{
+			name: "tls",
+			spec: demoappv2.DemoSpec{
+				Scaling: demoappv2.ScalingSpec{Replicas: 1},
+				TLS:     &demoappv2.TLSSpec{Port: 8443},
+			},
+			status: demoappv2.DemoStatus{CertificateExpiry: &metav1.Time{Time: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "common-metadata",
+			spec: demoappv2.DemoSpec{
+				Scaling:           demoappv2.ScalingSpec{Replicas: 1},
+				CommonLabels:      map[string]string{"team": "web"},
+				CommonAnnotations: map[string]string{"example.com/owner": "web-team"},
+			},
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = demoappv2.AddToScheme(scheme)
+	r := &DemoReconciler{Scheme: scheme}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &demoappv2.Demo{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+				Spec:       tt.spec,
+				Status:     tt.status,
+			}
+			objs, err := r.Render(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got bytes.Buffer
+			for _, obj := range objs {
+				data, err := yaml.Marshal(obj)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got.WriteString("---\n")
+				got.Write(data)
+			}
+
+			golden := filepath.Join("testdata", "render", tt.name+".yaml")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, got.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("rendered objects differ from %s (run with -update to accept):\n%s", golden, got.String())
+			}
+		})
+	}
+}
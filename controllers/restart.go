@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const reasonRestarted = "Restarted"
+
+// Demo의 restartedAt annotation을 pod template에 복사합니다.
+// 값이 바뀌면 template 해시도 바뀌어 workload가 pod를 하나씩 다시 만듭니다.
+func addRestartedAt(template *corev1.PodTemplateSpec, d *demoappv2.Demo) {
+	restartedAt := d.Annotations[demoappv2.RestartedAtAnnotation]
+	if restartedAt == "" {
+		return
+	}
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[demoappv2.RestartedAtAnnotation] = restartedAt
+}
+
+// restartCause는 pod template을 바꿀 때 operator가 pod를 다시 만들게 하는 annotation 중 바뀐 것을 찾습니다.
+// 여러 개가 함께 바뀌면 사용자가 요청한 재시작을 먼저 기록합니다.
+// annotation이 바뀌지 않았으면 spec 변경에 의한 배포이므로 nil을 반환합니다.
+// 인증서와 content annotation이 처음 생긴 것은 spec.tls나 spec.content를 추가한 spec 변경입니다.
+// restartedAt은 처음 생겨도 사용자가 요청한 재시작입니다.
+func restartCause(current, desired *corev1.PodTemplateSpec, now time.Time) *demoappv2.RestartStatus {
+	causes := []struct {
+		annotation string
+		cause      demoappv2.RestartCause
+		message    string
+		firstSet   bool // annotation이 처음 생긴 것도 재시작으로 볼지
+	}{
+		{demoappv2.RestartedAtAnnotation, demoappv2.RestartRequested, "Restart requested at ", true},
+		{certificateExpiryAnnotation, demoappv2.RestartCertificateRotated, "Serving certificate renewed, expires at ", false},
+		{contentRevisionAnnotation, demoappv2.RestartContentChanged, "Content changed to revision ", false},
+	}
+	for _, c := range causes {
+		value, previous := desired.Annotations[c.annotation], current.Annotations[c.annotation]
+		if value == "" || value == previous || (previous == "" && !c.firstSet) {
+			continue
+		}
+		return &demoappv2.RestartStatus{Time: metav1.NewTime(now), Cause: c.cause, Message: c.message + value}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// restartedAt annotation이 바뀌면 template 해시가 바뀌어 pod가 다시 만들어집니다.
+func TestRestartedAtChangesTemplate(t *testing.T) {
+	r, _ := newPipelineTest(t)
+	d := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+	}
+	hash := func() string {
+		t.Helper()
+		workload, err := r.desiredWorkload(d, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return workload.GetAnnotations()[templateHashAnnotation]
+	}
+
+	before := hash()
+	if got := r.podTemplate(d, "").Annotations[demoappv2.RestartedAtAnnotation]; got != "" {
+		t.Errorf("got restartedAt %q in the template of a Demo without the annotation", got)
+	}
+
+	d.Annotations = map[string]string{demoappv2.RestartedAtAnnotation: "2022-05-01T10:00:00Z"}
+	if got := r.podTemplate(d, "").Annotations[demoappv2.RestartedAtAnnotation]; got != "2022-05-01T10:00:00Z" {
+		t.Errorf("got restartedAt %q in the template, want the Demo annotation", got)
+	}
+	first := hash()
+	if first == before {
+		t.Error("template hash did not change when restartedAt was set")
+	}
+
+	d.Annotations[demoappv2.RestartedAtAnnotation] = "2022-05-02T10:00:00Z"
+	if hash() == first {
+		t.Error("template hash did not change when restartedAt changed")
+	}
+}
+
+// restartedAt으로 요청한 재시작은 status.lastRestart에 기록되고, spec 변경으로 인한 배포는 기록되지 않습니다.
+func TestReconcileRecordsLastRestart(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+	}
+	r, c := newPipelineTest(t, cr)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	update := func(mutate func(d *demoappv2.Demo)) *demoappv2.RestartStatus {
+		t.Helper()
+		d := &demoappv2.Demo{}
+		if err := c.Get(ctx, key, d); err != nil {
+			t.Fatal(err)
+		}
+		mutate(d)
+		if err := c.Update(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+		if err := reconcileDemo(t, r, "web"); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Get(ctx, key, d); err != nil {
+			t.Fatal(err)
+		}
+		return d.Status.LastRestart
+	}
+
+	if got := update(func(d *demoappv2.Demo) {}); got != nil {
+		t.Errorf("got lastRestart %+v after creating the workload", got)
+	}
+
+	got := update(func(d *demoappv2.Demo) {
+		d.Annotations = map[string]string{demoappv2.RestartedAtAnnotation: "2022-05-01T10:00:00Z"}
+	})
+	if got == nil || got.Cause != demoappv2.RestartRequested || got.Time.IsZero() {
+		t.Fatalf("got lastRestart %+v, want cause %s", got, demoappv2.RestartRequested)
+	}
+	requested := *got
+
+	got = update(func(d *demoappv2.Demo) { d.Spec.Image = "nginx:1.21" })
+	if got == nil || got.Message != requested.Message || got.Cause != requested.Cause {
+		t.Errorf("got lastRestart %+v after changing spec.image, want the previous restart %+v", got, requested)
+	}
+}
+
+func TestRestartCause(t *testing.T) {
+	template := func(annotations map[string]string) *corev1.PodTemplateSpec {
+		return &corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+	}
+	now := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name             string
+		current, desired map[string]string
+		want             demoappv2.RestartCause
+	}{
+		{"spec change", map[string]string{contentRevisionAnnotation: "a"}, map[string]string{contentRevisionAnnotation: "a"}, ""},
+		{"first content revision", nil, map[string]string{contentRevisionAnnotation: "a"}, ""},
+		{"first certificate", nil, map[string]string{certificateExpiryAnnotation: "a"}, ""},
+		{"first restart request", nil, map[string]string{demoappv2.RestartedAtAnnotation: "2022-05-01T10:00:00Z"}, demoappv2.RestartRequested},
+		{"content", map[string]string{contentRevisionAnnotation: "a"}, map[string]string{contentRevisionAnnotation: "b"}, demoappv2.RestartContentChanged},
+		{"content removed", map[string]string{contentRevisionAnnotation: "a"}, nil, ""},
+		{"certificate", map[string]string{certificateExpiryAnnotation: "a"}, map[string]string{certificateExpiryAnnotation: "b"}, demoappv2.RestartCertificateRotated},
+		{
+			"requested wins",
+			map[string]string{contentRevisionAnnotation: "a"},
+			map[string]string{contentRevisionAnnotation: "b", demoappv2.RestartedAtAnnotation: "2022-05-01T10:00:00Z"},
+			demoappv2.RestartRequested,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := restartCause(template(tt.current), template(tt.desired), now)
+			switch {
+			case tt.want == "" && got != nil:
+				t.Errorf("got %+v, want no restart", got)
+			case tt.want != "" && (got == nil || got.Cause != tt.want || !got.Time.Equal(&metav1.Time{Time: now})):
+				t.Errorf("got %+v, want cause %s at %s", got, tt.want, now)
+			}
+		})
+	}
+}
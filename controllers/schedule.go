@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// 마지막 실행 시각을 찾을 때 점점 넓혀가며 확인하는 구간입니다.
+// 자주 실행되는 스케줄은 앞쪽 구간에서 바로 찾으므로 반복 횟수가 작게 유지됩니다.
+var scheduleLookback = []time.Duration{
+	time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	31 * 24 * time.Hour,
+	366 * 24 * time.Hour,
+}
+
+// scheduleState는 now 시점에 스케줄로 계산된 결과입니다.
+type scheduleState struct {
+	Size   int32      // 적용할 replicas 값
+	Active string     // 현재 size를 결정한 스케줄 이름 (없으면 "")
+	Next   *time.Time // 다음 스케줄 실행 시각 (스케줄이 없으면 nil)
+}
+
+// spec.scaling.replicas와 spec.scaling.schedules로 now 시점의 실제 size를 계산합니다.
+// 가장 최근에 실행된 스케줄의 size가 적용되고, 실행된 스케줄이 없으면 spec.scaling.replicas를 사용합니다.
+func scheduledSize(d *demoappv2.Demo, now time.Time) (scheduleState, error) {
+	state := scheduleState{Size: d.Spec.Scaling.Replicas}
+	var lastFired time.Time
+
+	for _, s := range d.Spec.Scaling.Schedules {
+		sched, loc, err := parseSchedule(s)
+		if err != nil {
+			return scheduleState{Size: d.Spec.Scaling.Replicas}, err
+		}
+		local := now.In(loc)
+
+		// 가장 최근에 실행된 스케줄이 size를 결정합니다.
+		if prev, ok := lastFire(sched, local); ok && prev.After(lastFired) {
+			lastFired = prev
+			state.Size = s.Replicas
+			state.Active = s.Name
+		}
+
+		// 가장 빠른 다음 실행 시각이 다음 전환 시점입니다.
+		next := sched.Next(local)
+		if next.IsZero() {
+			continue
+		}
+		if state.Next == nil || next.Before(*state.Next) {
+			state.Next = &next
+		}
+	}
+
+	return state, nil
+}
+
+// cron 표현식과 time zone을 해석합니다.
+func parseSchedule(s demoappv2.ScalingSchedule) (cron.Schedule, *time.Location, error) {
+	loc := time.UTC
+	if s.TimeZone != "" {
+		l, err := time.LoadLocation(s.TimeZone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("schedule %q: invalid time zone %q: %w", s.Name, s.TimeZone, err)
+		}
+		loc = l
+	}
+
+	sched, err := cron.ParseStandard(s.Schedule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("schedule %q: invalid cron expression %q: %w", s.Name, s.Schedule, err)
+	}
+	return sched, loc, nil
+}
+
+// now 이전에 스케줄이 마지막으로 실행된 시각을 찾습니다.
+// cron 라이브러리는 Next만 제공하므로 조회 구간의 시작부터 now까지 Next를 따라갑니다.
+func lastFire(sched cron.Schedule, now time.Time) (time.Time, bool) {
+	for _, window := range scheduleLookback {
+		var last time.Time
+		for t := sched.Next(now.Add(-window)); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+			last = t
+		}
+		if !last.IsZero() {
+			return last, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// 잘못된 schedule로 spec.scaling.replicas를 사용하는 것을 SchedulesValid condition에 기록합니다.
+// 한 번도 잘못된 적 없는 Demo에는 condition을 추가하지 않습니다.
+func (s *reconcileState) setSchedulesCondition(err error) {
+	if err != nil {
+		s.setCondition(metav1.Condition{
+			Type:    demoappv2.ConditionSchedulesValid,
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidSchedule",
+			Message: fmt.Sprintf("%v; using spec.scaling.replicas", err),
+		})
+		return
+	}
+	if meta.FindStatusCondition(s.cr.Status.Conditions, demoappv2.ConditionSchedulesValid) != nil {
+		s.setCondition(metav1.Condition{
+			Type:    demoappv2.ConditionSchedulesValid,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Valid",
+			Message: "All schedules are parsed",
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestScheduledSize(t *testing.T) {
+	weekdays := []demoappv2.ScalingSchedule{
+		{Name: "night", Schedule: "0 20 * * 1-5", Replicas: 0},
+		{Name: "day", Schedule: "0 8 * * 1-5", Replicas: 3},
+	}
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		schedules  []demoappv2.ScalingSchedule
+		now        time.Time
+		wantSize   int32
+		wantActive string
+		wantNext   time.Time
+	}{
+		{
+			name:     "no schedules uses spec.scaling.replicas",
+			now:      time.Date(2022, 4, 20, 10, 0, 0, 0, time.UTC),
+			wantSize: 1,
+		},
+		{
+			name:       "weekday daytime",
+			schedules:  weekdays,
+			now:        time.Date(2022, 4, 20, 10, 0, 0, 0, time.UTC), // Wednesday
+			wantSize:   3,
+			wantActive: "day",
+			wantNext:   time.Date(2022, 4, 20, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "weekday night",
+			schedules:  weekdays,
+			now:        time.Date(2022, 4, 20, 23, 0, 0, 0, time.UTC),
+			wantSize:   0,
+			wantActive: "night",
+			wantNext:   time.Date(2022, 4, 21, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "weekend keeps friday night",
+			schedules:  weekdays,
+			now:        time.Date(2022, 4, 23, 12, 0, 0, 0, time.UTC), // Saturday
+			wantSize:   0,
+			wantActive: "night",
+			wantNext:   time.Date(2022, 4, 25, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "exactly at boundary",
+			schedules:  weekdays,
+			now:        time.Date(2022, 4, 20, 20, 0, 0, 0, time.UTC),
+			wantSize:   0,
+			wantActive: "night",
+			wantNext:   time.Date(2022, 4, 21, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "time zone",
+			schedules: []demoappv2.ScalingSchedule{
+				{Name: "night", Schedule: "0 20 * * *", Replicas: 0, TimeZone: "Asia/Seoul"},
+				{Name: "day", Schedule: "0 8 * * *", Replicas: 3, TimeZone: "Asia/Seoul"},
+			},
+			now:        time.Date(2022, 4, 20, 12, 0, 0, 0, time.UTC), // 21:00 KST
+			wantSize:   0,
+			wantActive: "night",
+			wantNext:   time.Date(2022, 4, 21, 8, 0, 0, 0, seoul),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &demoappv2.Demo{Spec: demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1, Schedules: tt.schedules}}}
+
+			got, err := scheduledSize(d, tt.now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Size != tt.wantSize || got.Active != tt.wantActive {
+				t.Errorf("got size %d active %q, want size %d active %q", got.Size, got.Active, tt.wantSize, tt.wantActive)
+			}
+			switch {
+			case tt.wantNext.IsZero() && got.Next != nil:
+				t.Errorf("got next %v, want none", got.Next)
+			case !tt.wantNext.IsZero() && (got.Next == nil || !got.Next.Equal(tt.wantNext)):
+				t.Errorf("got next %v, want %v", got.Next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestScheduledSizeInvalid(t *testing.T) {
+	tests := []demoappv2.ScalingSchedule{
+		{Name: "bad-cron", Schedule: "every day", Replicas: 0},
+		{Name: "bad-tz", Schedule: "0 8 * * *", Replicas: 0, TimeZone: "Mars/Olympus"},
+	}
+
+	for _, s := range tests {
+		d := &demoappv2.Demo{Spec: demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2, Schedules: []demoappv2.ScalingSchedule{s}}}}
+		got, err := scheduledSize(d, time.Now())
+		if err == nil {
+			t.Errorf("%s: expected error", s.Name)
+		}
+		if got.Size != 2 {
+			t.Errorf("%s: got size %d, want spec.scaling.replicas on error", s.Name, got.Size)
+		}
+	}
+}
+
+// 잘못된 schedule은 spec.scaling.replicas로 배포하고 SchedulesValid condition으로 알립니다.
+func TestReconcileInvalidSchedule(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{
+			Replicas:  2,
+			Schedules: []demoappv2.ScalingSchedule{{Name: "night", Schedule: "every night", Replicas: 0}},
+		}},
+	}
+	r, c := newPipelineTest(t, cr)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	dep := &appsv1.Deployment{}
+	if err := c.Get(ctx, key, dep); err != nil {
+		t.Fatal(err)
+	}
+	if *dep.Spec.Replicas != 2 {
+		t.Errorf("got %d replicas, want spec.scaling.replicas", *dep.Spec.Replicas)
+	}
+	got := &demoappv2.Demo{}
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(got.Status.Conditions, demoappv2.ConditionSchedulesValid)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "InvalidSchedule" {
+		t.Errorf("got condition %+v, want SchedulesValid False InvalidSchedule", cond)
+	}
+
+	got.Spec.Scaling.Schedules[0].Schedule = "0 20 * * *"
+	if err := c.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, demoappv2.ConditionSchedulesValid); cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Errorf("got condition %+v after fixing the schedule, want SchedulesValid True", cond)
+	}
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"fmt"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// nginx 공식 이미지의 nginx 사용자와 그룹 (nginx-unprivileged 이미지도 같습니다)
+	nginxUID = 101
+
+	// root가 아닌 nginx가 사용하는 포트와 이전부터 사용하던 포트
+	restrictedNginxPort = 8080
+	defaultNginxPort    = 80
+
+	tmpVolume = "tmp"
+	tmpPath   = "/tmp"
+)
+
+// Restricted에서 읽기 전용 root filesystem 대신 nginx가 쓰는 emptyDir (cache, pid 파일, 임시 파일)
+var nginxWritableDirs = []struct{ volume, path string }{
+	{volume: "nginx-cache", path: "/var/cache/nginx"},
+	{volume: "nginx-run", path: "/var/run"},
+	{volume: tmpVolume, path: tmpPath},
+}
+
+// pod security profile (기본값 Restricted)
+func securityProfile(d *demoappv2.Demo) demoappv2.SecurityProfile {
+	if d.Spec.Pod.SecurityProfile == "" {
+		return demoappv2.SecurityRestricted
+	}
+	return d.Spec.Pod.SecurityProfile
+}
+
+func restricted(d *demoappv2.Demo) bool {
+	return securityProfile(d) == demoappv2.SecurityRestricted
+}
+
+// nginx가 요청을 받는 포트. root가 아니면 1024 미만 포트를 열 수 없습니다.
+func nginxPort(d *demoappv2.Demo) int32 {
+	if restricted(d) {
+		return restrictedNginxPort
+	}
+	return defaultNginxPort
+}
+
+// Restricted에서 operator가 추가하는 컨테이너의 security context.
+// nginx 사용자는 operator의 컨테이너에만 지정하고, spec.pod의 컨테이너는 이미지의 사용자로 실행합니다.
+func restrictedSecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsUser:                pointer.Int64(nginxUID),
+		RunAsGroup:               pointer.Int64(nginxUID),
+		RunAsNonRoot:             pointer.Bool(true),
+		AllowPrivilegeEscalation: pointer.Bool(false),
+		ReadOnlyRootFilesystem:   pointer.Bool(true),
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+	}
+}
+
+// nginx 컨테이너의 security context. spec.pod.securityContext의 값이 profile보다 우선합니다.
+// profile을 깨는 값은 validateSecurity가 거부합니다.
+func nginxSecurityContext(d *demoappv2.Demo) *corev1.SecurityContext {
+	override := d.Spec.Pod.SecurityContext
+	if !restricted(d) {
+		return override.DeepCopy()
+	}
+
+	sc := restrictedSecurityContext()
+	if override == nil {
+		return sc
+	}
+	// capabilities는 바꾸지 않고 더합니다. drop ALL은 그대로 두고 허용된 add만 넣습니다.
+	if override.Capabilities != nil {
+		sc.Capabilities.Add = append([]corev1.Capability(nil), override.Capabilities.Add...)
+	}
+	if override.Privileged != nil {
+		sc.Privileged = override.Privileged
+	}
+	if override.SELinuxOptions != nil {
+		sc.SELinuxOptions = override.SELinuxOptions.DeepCopy()
+	}
+	if override.WindowsOptions != nil {
+		sc.WindowsOptions = override.WindowsOptions.DeepCopy()
+	}
+	if override.RunAsUser != nil {
+		sc.RunAsUser = override.RunAsUser
+	}
+	if override.RunAsGroup != nil {
+		sc.RunAsGroup = override.RunAsGroup
+	}
+	if override.RunAsNonRoot != nil {
+		sc.RunAsNonRoot = override.RunAsNonRoot
+	}
+	if override.ReadOnlyRootFilesystem != nil {
+		sc.ReadOnlyRootFilesystem = override.ReadOnlyRootFilesystem
+	}
+	if override.AllowPrivilegeEscalation != nil {
+		sc.AllowPrivilegeEscalation = override.AllowPrivilegeEscalation
+	}
+	if override.ProcMount != nil {
+		sc.ProcMount = override.ProcMount
+	}
+	if override.SeccompProfile != nil {
+		sc.SeccompProfile = override.SeccompProfile.DeepCopy()
+	}
+	return sc
+}
+
+// pod template에 security profile을 적용합니다. addContent와 sidecar를 추가한 뒤에 호출합니다.
+func applySecurityProfile(template *corev1.PodTemplateSpec, d *demoappv2.Demo) {
+	podSpec := &template.Spec
+	podSpec.AutomountServiceAccountToken = d.Spec.Pod.AutomountServiceAccountToken
+	podSpec.Containers[0].SecurityContext = nginxSecurityContext(d)
+	if !restricted(d) {
+		return
+	}
+
+	if podSpec.AutomountServiceAccountToken == nil && !needsServiceAccountToken(d) {
+		podSpec.AutomountServiceAccountToken = pointer.Bool(false)
+	}
+	// 사용자와 runAsNonRoot는 operator의 컨테이너마다 지정합니다. pod에 runAsNonRoot를 두면
+	// root로 실행되는 이미지의 spec.pod 컨테이너를 kubelet이 시작하지 않습니다.
+	// pod에는 seccomp profile과 nginx가 content volume을 읽을 수 있도록 fsGroup만 둡니다.
+	podSpec.SecurityContext = &corev1.PodSecurityContext{
+		FSGroup:        pointer.Int64(nginxUID),
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+
+	for _, dir := range nginxWritableDirs {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name:         dir.volume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      dir.volume,
+			MountPath: dir.path,
+		})
+	}
+
+	// operator가 추가한 init container와 sidecar도 같은 제한으로 실행합니다.
+	for i := range podSpec.InitContainers {
+		c := &podSpec.InitContainers[i]
+		if c.Name != contentInitContainer {
+			continue
+		}
+		c.SecurityContext = restrictedSecurityContext()
+		// git은 HOME 아래 설정을 읽고 임시 디렉터리에 clone 합니다.
+		c.Env = append(c.Env, corev1.EnvVar{Name: "HOME", Value: tmpPath})
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: tmpVolume, MountPath: tmpPath})
+	}
+	for i := range podSpec.Containers {
+		c := &podSpec.Containers[i]
+		if isBuiltinSidecar(c.Name) {
+			c.SecurityContext = restrictedSecurityContext()
+		}
+	}
+}
+
+// Restricted에서 nginx와 spec.pod의 컨테이너가 동작할 수 있는 설정인지 확인합니다.
+func validateSecurity(d *demoappv2.Demo) error {
+	if !restricted(d) {
+		return nil
+	}
+	path := field.NewPath("spec", "pod")
+	hint := fmt.Sprintf("not allowed with securityProfile %s, use %s", demoappv2.SecurityRestricted, demoappv2.SecurityUnrestricted)
+
+	var errs field.ErrorList
+	if sc := d.Spec.Pod.SecurityContext; sc != nil {
+		scPath := path.Child("securityContext")
+		if sc.Privileged != nil && *sc.Privileged {
+			errs = append(errs, field.Forbidden(scPath.Child("privileged"), hint))
+		}
+		if sc.AllowPrivilegeEscalation != nil && *sc.AllowPrivilegeEscalation {
+			errs = append(errs, field.Forbidden(scPath.Child("allowPrivilegeEscalation"), hint))
+		}
+		if sc.RunAsNonRoot != nil && !*sc.RunAsNonRoot {
+			errs = append(errs, field.Forbidden(scPath.Child("runAsNonRoot"), hint))
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			errs = append(errs, field.Forbidden(scPath.Child("runAsUser"), hint))
+		}
+		if sc.Capabilities != nil {
+			for i, c := range sc.Capabilities.Add {
+				if c != "NET_BIND_SERVICE" {
+					errs = append(errs, field.Forbidden(scPath.Child("capabilities", "add").Index(i), hint))
+				}
+			}
+		}
+		if sc.ReadOnlyRootFilesystem != nil && !*sc.ReadOnlyRootFilesystem {
+			errs = append(errs, field.Forbidden(scPath.Child("readOnlyRootFilesystem"), hint))
+		}
+		if sc.SeccompProfile != nil && sc.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+			errs = append(errs, field.Forbidden(scPath.Child("seccompProfile", "type"), hint))
+		}
+		if sc.ProcMount != nil && *sc.ProcMount != corev1.DefaultProcMount {
+			errs = append(errs, field.Forbidden(scPath.Child("procMount"), hint))
+		}
+		if sc.WindowsOptions != nil && sc.WindowsOptions.HostProcess != nil && *sc.WindowsOptions.HostProcess {
+			errs = append(errs, field.Forbidden(scPath.Child("windowsOptions", "hostProcess"), hint))
+		}
+		// Pod Security Standards의 restricted와 같이 SELinux는 컨테이너 type만 허용합니다.
+		if se := sc.SELinuxOptions; se != nil {
+			if se.User != "" || se.Role != "" {
+				errs = append(errs, field.Forbidden(scPath.Child("seLinuxOptions"), "user and role are "+hint))
+			}
+			switch se.Type {
+			case "", "container_t", "container_init_t", "container_kvm_t":
+			default:
+				errs = append(errs, field.Forbidden(scPath.Child("seLinuxOptions", "type"), hint))
+			}
+		}
+	}
+
+	// root가 아닌 컨테이너는 1024 미만 포트를 열 수 없습니다.
+	for i, c := range d.Spec.Pod.Containers {
+		for j, p := range c.Ports {
+			if p.ContainerPort < 1024 {
+				errs = append(errs, field.Invalid(path.Child("containers").Index(i).Child("ports").Index(j).Child("containerPort"),
+					p.ContainerPort, "ports below 1024 need root, "+hint))
+			}
+		}
+	}
+	return errs.ToAggregate()
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestValidateSecurity(t *testing.T) {
+	tests := []struct {
+		name    string
+		pod     demoappv2.PodSpec
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{
+			name: "net bind service",
+			pod: demoappv2.PodSpec{SecurityContext: &corev1.SecurityContext{
+				Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_BIND_SERVICE"}},
+			}},
+		},
+		{
+			name:    "privileged",
+			pod:     demoappv2.PodSpec{SecurityContext: &corev1.SecurityContext{Privileged: pointer.Bool(true)}},
+			wantErr: true,
+		},
+		{
+			name:    "root user",
+			pod:     demoappv2.PodSpec{SecurityContext: &corev1.SecurityContext{RunAsUser: pointer.Int64(0)}},
+			wantErr: true,
+		},
+		{
+			name: "added capability",
+			pod: demoappv2.PodSpec{SecurityContext: &corev1.SecurityContext{
+				Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"SYS_ADMIN"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "writable root filesystem",
+			pod:     demoappv2.PodSpec{SecurityContext: &corev1.SecurityContext{ReadOnlyRootFilesystem: pointer.Bool(false)}},
+			wantErr: true,
+		},
+		{
+			name: "unconfined seccomp",
+			pod: demoappv2.PodSpec{SecurityContext: &corev1.SecurityContext{
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "localhost seccomp",
+			pod: demoappv2.PodSpec{SecurityContext: &corev1.SecurityContext{
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: pointer.String("nginx.json")},
+			}},
+		},
+		{
+			name:    "unmasked proc",
+			pod:     demoappv2.PodSpec{SecurityContext: &corev1.SecurityContext{ProcMount: procMount(corev1.UnmaskedProcMount)}},
+			wantErr: true,
+		},
+		{
+			name: "selinux type",
+			pod: demoappv2.PodSpec{SecurityContext: &corev1.SecurityContext{
+				SELinuxOptions: &corev1.SELinuxOptions{Type: "spc_t"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "privileged port",
+			pod: demoappv2.PodSpec{Containers: []corev1.Container{{
+				Name: "proxy", Ports: []corev1.ContainerPort{{ContainerPort: 443}},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "unrestricted",
+			pod: demoappv2.PodSpec{
+				SecurityProfile: demoappv2.SecurityUnrestricted,
+				SecurityContext: &corev1.SecurityContext{Privileged: pointer.Bool(true)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &demoappv2.Demo{Spec: demoappv2.DemoSpec{Pod: tt.pod}}
+			if err := validateSecurity(d); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPodTemplateSecurityProfile(t *testing.T) {
+	d := &demoappv2.Demo{
+		Spec: demoappv2.DemoSpec{
+			Content: &demoappv2.ContentSpec{Git: &demoappv2.GitSource{Repository: "https://example.com/site.git"}},
+			Pod: demoappv2.PodSpec{
+				Containers: []corev1.Container{{Name: "proxy", Image: "envoyproxy/envoy"}},
+				SecurityContext: &corev1.SecurityContext{
+					RunAsUser:    pointer.Int64(1000),
+					Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_BIND_SERVICE"}},
+				},
+			},
+		},
+	}
+	template := (&DemoReconciler{}).podTemplate(d, "")
+	spec := template.Spec
+
+	if spec.AutomountServiceAccountToken == nil || *spec.AutomountServiceAccountToken {
+		t.Error("expected the service account token not to be mounted")
+	}
+	// nginx 사용자와 runAsNonRoot는 pod가 아니라 operator의 컨테이너에만 지정합니다.
+	// root로 실행되는 이미지의 spec.pod 컨테이너도 시작할 수 있어야 합니다.
+	if spec.SecurityContext == nil || spec.SecurityContext.RunAsNonRoot != nil || spec.SecurityContext.RunAsUser != nil {
+		t.Errorf("unexpected pod security context %+v", spec.SecurityContext)
+	}
+
+	nginx := spec.Containers[0]
+	if nginx.Ports[0].ContainerPort != restrictedNginxPort {
+		t.Errorf("got nginx port %d, want %d", nginx.Ports[0].ContainerPort, restrictedNginxPort)
+	}
+	// spec.pod.securityContext의 값이 profile보다 우선하고, capabilities는 drop ALL에 더해집니다.
+	sc := nginx.SecurityContext
+	if sc == nil || !*sc.ReadOnlyRootFilesystem || !*sc.RunAsNonRoot || *sc.RunAsUser != 1000 {
+		t.Errorf("unexpected nginx security context %+v", sc)
+	}
+	wantCaps := &corev1.Capabilities{Add: []corev1.Capability{"NET_BIND_SERVICE"}, Drop: []corev1.Capability{"ALL"}}
+	if !reflect.DeepEqual(sc.Capabilities, wantCaps) {
+		t.Errorf("got capabilities %+v, want %+v", sc.Capabilities, wantCaps)
+	}
+	for _, c := range spec.Containers {
+		if c.Name == "proxy" && c.SecurityContext != nil {
+			t.Errorf("expected the user container to keep its own security context, got %+v", c.SecurityContext)
+		}
+	}
+	mounts := map[string]bool{}
+	for _, m := range nginx.VolumeMounts {
+		mounts[m.MountPath] = true
+	}
+	for _, dir := range nginxWritableDirs {
+		if !mounts[dir.path] {
+			t.Errorf("expected a writable volume at %s", dir.path)
+		}
+	}
+
+	init := spec.InitContainers[0]
+	if init.SecurityContext == nil || !*init.SecurityContext.ReadOnlyRootFilesystem || *init.SecurityContext.RunAsUser != nginxUID ||
+		!*init.SecurityContext.RunAsNonRoot {
+		t.Errorf("unexpected init container security context %+v", init.SecurityContext)
+	}
+	var home string
+	for _, e := range init.Env {
+		if e.Name == "HOME" {
+			home = e.Value
+		}
+	}
+	if home != tmpPath {
+		t.Errorf("got HOME %q, want %q", home, tmpPath)
+	}
+
+	// Unrestricted는 이전처럼 root로 80 포트를 사용합니다.
+	d.Spec.Pod = demoappv2.PodSpec{SecurityProfile: demoappv2.SecurityUnrestricted}
+	template = (&DemoReconciler{}).podTemplate(d, "")
+	if template.Spec.SecurityContext != nil || template.Spec.Containers[0].SecurityContext != nil {
+		t.Errorf("expected no security context, got %+v", template.Spec.SecurityContext)
+	}
+	if port := template.Spec.Containers[0].Ports[0].ContainerPort; port != defaultNginxPort {
+		t.Errorf("got nginx port %d, want %d", port, defaultNginxPort)
+	}
+}
+
+func procMount(t corev1.ProcMountType) *corev1.ProcMountType {
+	return &t
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// 이전 버전이 만든 workload는 app=<name> selector를 사용합니다. selector는 바꿀 수 없으므로
+// pod가 내려가지 않도록 다음 순서로 옮깁니다.
+//
+//  1. 이전 selector를 유지한 채 pod template에 새 label을 더해 pod를 다시 배포합니다.
+//     Service도 배포가 끝날 때까지 이전 selector를 사용합니다.
+//  2. 배포가 끝나면 workload만 orphan으로 삭제합니다. pod(와 ReplicaSet)는 남습니다.
+//  3. 다음 reconcile에서 새 selector로 workload를 다시 만들면, label이 맞는 기존
+//     ReplicaSet이나 pod를 가져온 뒤 이전 label이 없는 template으로 다시 배포합니다.
+
+// workload의 selector가 Demo의 selector와 다르면 true를 반환합니다.
+// matchLabels가 없는 selector는 Service selector로 쓸 수 없으므로 옮기지 않습니다.
+func needsSelectorMigration(d *demoappv2.Demo, workload client.Object) bool {
+	selector := workloadSelector(workload)
+	if selector == nil || len(selector.MatchLabels) == 0 {
+		return false
+	}
+	return len(selector.MatchExpressions) > 0 || !reflect.DeepEqual(selector.MatchLabels, selectorLabels(d))
+}
+
+// 이전 selector의 label을 pod template에 더하고 template 해시를 다시 계산합니다.
+func addTemplateLabels(workload client.Object, labels map[string]string) {
+	template := workloadTemplate(workload)
+	if template.Labels == nil {
+		template.Labels = map[string]string{}
+	}
+	for k, v := range labels {
+		template.Labels[k] = v
+	}
+	setTemplateHash(workload)
+}
+
+// 새 label로 배포가 끝난 workload를 orphan으로 삭제합니다. 삭제한 경우 true를 반환합니다.
+func (r *DemoReconciler) migrateSelector(ctx context.Context, cr *demoappv2.Demo, workload client.Object) (bool, error) {
+	logger := log.FromContext(ctx)
+	kind := workloadKind(workload)
+
+	// StatefulSet의 PVC는 volumeClaimTemplate의 이전 label을 가지므로 새 label을 붙입니다.
+	if sts, ok := workload.(*appsv1.StatefulSet); ok {
+		if err := r.relabelVolumes(ctx, cr, sts); err != nil {
+			return false, err
+		}
+	}
+
+	if !workloadRolledOut(workload) {
+		logger.V(logDebug).Info("Waiting for pods with new labels before migrating the selector",
+			objectKeys(kind, workload.GetNamespace(), workload.GetName())...)
+		return false, nil
+	}
+
+	err := r.Client.Delete(ctx, workload, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	logger.Info("Workload deleted to migrate its selector, pods are kept",
+		objectKeys(kind, workload.GetNamespace(), workload.GetName())...)
+	return true, nil
+}
+
+// StatefulSet이 만든 PVC에 Demo의 label을 붙입니다.
+func (r *DemoReconciler) relabelVolumes(ctx context.Context, cr *demoappv2.Demo, sts *appsv1.StatefulSet) error {
+	pvcList := &corev1.PersistentVolumeClaimList{}
+	if err := r.Client.List(ctx, pvcList, client.InNamespace(cr.Namespace)); err != nil {
+		return err
+	}
+
+	prefix := storageVolume + "-" + sts.Name + "-"
+	desired := &metav1.ObjectMeta{Labels: objectLabels(cr, componentServer)}
+	for i := range pvcList.Items {
+		pvc := &pvcList.Items[i]
+		// data-<name>-<ordinal> 형식이 아니면 다른 StatefulSet의 PVC입니다.
+		ordinal := strings.TrimPrefix(pvc.Name, prefix)
+		if _, err := strconv.Atoi(ordinal); ordinal == pvc.Name || err != nil {
+			continue
+		}
+		if !mergeMetadata(pvc, desired) {
+			continue
+		}
+		if err := r.Client.Update(ctx, pvc); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("PersistentVolumeClaim relabeled", objectKeys("PersistentVolumeClaim", pvc.Namespace, pvc.Name)...)
+	}
+	return nil
+}
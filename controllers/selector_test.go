@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+// 이전 버전이 app=<name> selector로 만든 Deployment를 pod를 유지한 채 새 selector로 옮깁니다.
+func TestSelectorMigration(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2}},
+	}
+	legacy := map[string]string{legacyAppLabel: "web"}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer.Int32(2),
+			Selector: &metav1.LabelSelector{MatchLabels: legacy},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: legacy}},
+		},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Selector: legacy, Ports: servicePorts(cr)},
+	}
+	owner := []metav1.OwnerReference{*metav1.NewControllerRef(cr, demoappv2.GroupVersion.WithKind("Demo"))}
+	dep.OwnerReferences, svc.OwnerReferences = owner, owner
+	r, c := newPipelineTest(t, cr, dep, svc)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	// 1. 이전 selector를 유지한 채 pod template에 새 label을 더합니다. Service도 이전 selector를 씁니다.
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, dep); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dep.Spec.Selector.MatchLabels, legacy) {
+		t.Errorf("got selector %v, want it unchanged", dep.Spec.Selector.MatchLabels)
+	}
+	for k, v := range selectorLabels(cr) {
+		if dep.Spec.Template.Labels[k] != v {
+			t.Errorf("pod template is missing label %s=%s", k, v)
+		}
+	}
+	if dep.Spec.Template.Labels[legacyAppLabel] != "web" {
+		t.Error("pod template lost the legacy selector label")
+	}
+	if err := c.Get(ctx, key, svc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(svc.Spec.Selector, legacy) {
+		t.Errorf("got Service selector %v, want the legacy selector during the rollout", svc.Spec.Selector)
+	}
+
+	// 배포가 끝나기 전에는 다시 reconcile 해도 Deployment를 유지합니다.
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, dep); err != nil {
+		t.Fatalf("Deployment removed before the rollout finished: %v", err)
+	}
+
+	// 2. 배포가 끝나면 Deployment를 orphan으로 삭제합니다.
+	dep.Status = appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, ReadyReplicas: 2}
+	if err := c.Status().Update(ctx, dep); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Fatalf("got %v, want the Deployment deleted", err)
+	}
+
+	// 3. 새 selector로 다시 만들고 Service도 새 selector로 옮깁니다.
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	dep = &appsv1.Deployment{}
+	if err := c.Get(ctx, key, dep); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dep.Spec.Selector.MatchLabels, selectorLabels(cr)) {
+		t.Errorf("got selector %v, want %v", dep.Spec.Selector.MatchLabels, selectorLabels(cr))
+	}
+	if _, ok := dep.Spec.Template.Labels[legacyAppLabel]; ok {
+		t.Error("new pod template still has the legacy label")
+	}
+	svc = &corev1.Service{}
+	if err := c.Get(ctx, key, svc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(svc.Spec.Selector, selectorLabels(cr)) {
+		t.Errorf("got Service selector %v, want %v", svc.Spec.Selector, selectorLabels(cr))
+	}
+}
+
+func TestRelabelVolumes(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			Storage: &demoappv2.StorageSpec{Size: resource.MustParse("1Gi")},
+		},
+	}
+	pvc := func(name string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "default", Labels: map[string]string{legacyAppLabel: "web"},
+		}}
+	}
+	r, c := newPipelineTest(t, cr, pvc("data-web-0"), pvc("data-web-api-0"))
+	ctx := context.Background()
+
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	if err := r.relabelVolumes(ctx, cr, sts); err != nil {
+		t.Fatal(err)
+	}
+
+	volumes, err := r.volumeStatuses(ctx, cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 || volumes[0].Name != "data-web-0" {
+		t.Errorf("got volumes %+v, want only data-web-0", volumes)
+	}
+	other := &corev1.PersistentVolumeClaim{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "data-web-api-0"}, other); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := other.Labels[labelInstance]; ok {
+		t.Error("relabeled the PVC of another StatefulSet")
+	}
+}
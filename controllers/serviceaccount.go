@@ -0,0 +1,323 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// 이벤트 reason
+const (
+	reasonRoleForbidden = "RoleForbidden"
+	reasonRoleRejected  = "RoleRejected"
+)
+
+// Demo의 Role로 줄 수 없는 resource. Role은 Demo를 편집하는 사람의 컨테이너가 쓰므로,
+// operator가 가진 권한이라도 Secret을 읽거나 다른 pod에 들어가는 권한은 읽기 verb라도 주지 않습니다.
+var deniedRoleResources = map[string]bool{
+	"secrets":               true,
+	"serviceaccounts":       true,
+	"serviceaccounts/token": true,
+	"roles":                 true,
+	"rolebindings":          true,
+	"pods/exec":             true,
+	"pods/attach":           true,
+	"pods/portforward":      true,
+}
+
+// Demo의 Role로 줄 수 있는 verb. pod나 Deployment, Job, Demo를 만들 수 있으면 그 pod로 namespace의
+// 어떤 Secret이든 mount하거나 어떤 ServiceAccount로든 실행할 수 있으므로 읽기 verb만 허용합니다.
+var allowedRoleVerbs = map[string]bool{
+	"get":   true,
+	"list":  true,
+	"watch": true,
+}
+
+// validateRoleRules는 spec.serviceAccount.rules가 Demo의 Role로 줄 수 있는 권한인지 확인합니다.
+// 새로 생기는 resource까지 열리지 않도록 wildcard도 허용하지 않습니다.
+func validateRoleRules(rules []rbacv1.PolicyRule) error {
+	var errs []string
+	for i, rule := range rules {
+		field := fmt.Sprintf("spec.serviceAccount.rules[%d]", i)
+		if len(rule.NonResourceURLs) > 0 {
+			errs = append(errs, field+": nonResourceURLs are not allowed")
+		}
+		for _, g := range rule.APIGroups {
+			if g == rbacv1.APIGroupAll {
+				errs = append(errs, field+`: apiGroups must not contain "*"`)
+			}
+		}
+		for _, res := range rule.Resources {
+			switch {
+			case res == rbacv1.ResourceAll || strings.HasSuffix(res, "/*"):
+				errs = append(errs, fmt.Sprintf("%s: resources must not contain %q", field, res))
+			case deniedRoleResources[res]:
+				errs = append(errs, fmt.Sprintf("%s: %s cannot be granted", field, res))
+			}
+		}
+		for _, v := range rule.Verbs {
+			switch {
+			case v == rbacv1.VerbAll:
+				errs = append(errs, field+`: verbs must not contain "*"`)
+			case !allowedRoleVerbs[v]:
+				errs = append(errs, fmt.Sprintf("%s: verb %s cannot be granted, only get, list and watch are allowed", field, v))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Demo가 만드는 ServiceAccount, Role, RoleBinding 이름
+func (r *DemoReconciler) ownedServiceAccountName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "", maxNameLength)
+}
+
+// pod가 사용하는 ServiceAccount. spec.serviceAccount가 없으면 namespace의 기본 ServiceAccount입니다.
+func (r *DemoReconciler) podServiceAccountName(d *demoappv2.Demo) string {
+	switch {
+	case d.Spec.ServiceAccount == nil:
+		return ""
+	case d.Spec.ServiceAccount.Name != "":
+		return d.Spec.ServiceAccount.Name
+	default:
+		return r.ownedServiceAccountName(d)
+	}
+}
+
+// Role의 권한을 쓰려면 pod에 token이 있어야 합니다. 줄 수 없는 rule이 있으면 Role을 만들지 않습니다.
+func needsServiceAccountToken(d *demoappv2.Demo) bool {
+	return d.Spec.ServiceAccount != nil && len(d.Spec.ServiceAccount.Rules) > 0 &&
+		validateRoleRules(d.Spec.ServiceAccount.Rules) == nil
+}
+
+// Demo가 만드는 ServiceAccount를 정의하고 컨트롤러에 등록합니다.
+func (r *DemoReconciler) createServiceAccount(d *demoappv2.Demo) (*corev1.ServiceAccount, error) {
+	sa := &corev1.ServiceAccount{ObjectMeta: objectMeta(d, r.ownedServiceAccountName(d), componentServer)}
+	if err := ctrl.SetControllerReference(d, sa, r.Scheme); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// spec.serviceAccount.rules의 Role을 정의하고 컨트롤러에 등록합니다.
+func (r *DemoReconciler) createRole(d *demoappv2.Demo) (*rbacv1.Role, error) {
+	role := &rbacv1.Role{ObjectMeta: objectMeta(d, r.ownedServiceAccountName(d), componentServer)}
+	if needsServiceAccountToken(d) {
+		role.Rules = d.Spec.ServiceAccount.Rules
+	}
+	if err := ctrl.SetControllerReference(d, role, r.Scheme); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// pod의 ServiceAccount에 Role을 주는 RoleBinding을 정의하고 컨트롤러에 등록합니다.
+func (r *DemoReconciler) createRoleBinding(d *demoappv2.Demo) (*rbacv1.RoleBinding, error) {
+	name := r.ownedServiceAccountName(d)
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: objectMeta(d, name, componentServer),
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      r.podServiceAccountName(d),
+			Namespace: d.Namespace,
+		}},
+	}
+	if err := ctrl.SetControllerReference(d, binding, r.Scheme); err != nil {
+		return nil, err
+	}
+	return binding, nil
+}
+
+// serviceAccountObjects는 spec.serviceAccount로 Demo가 소유하는 객체입니다.
+// 지금 spec에서 필요 없는 객체도 이전에 만든 것을 지울 수 있도록 정의합니다.
+type serviceAccountObjects struct {
+	account *corev1.ServiceAccount
+	role    *rbacv1.Role
+	binding *rbacv1.RoleBinding
+	// ServiceAccount를 만들지 (spec.serviceAccount.name이 없을 때)
+	createAccount bool
+	// Role과 RoleBinding을 만들지
+	grant bool
+}
+
+func (r *DemoReconciler) desiredServiceAccount(d *demoappv2.Demo) (*serviceAccountObjects, error) {
+	objs := &serviceAccountObjects{
+		createAccount: d.Spec.ServiceAccount != nil && d.Spec.ServiceAccount.Name == "",
+		grant:         needsServiceAccountToken(d),
+	}
+	var err error
+	if objs.account, err = r.createServiceAccount(d); err != nil {
+		return nil, err
+	}
+	if objs.role, err = r.createRole(d); err != nil {
+		return nil, err
+	}
+	if objs.binding, err = r.createRoleBinding(d); err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+// spec.serviceAccount의 ServiceAccount와 Role, RoleBinding을 맞추고, 필요 없어진 것은 지웁니다.
+// operator는 escalate, bind 권한이 없으므로 자신에게 없는 권한은 Role로 줄 수 없습니다.
+// 그런 경우 API 서버가 거부하며, 다시 시도해도 같으므로 condition과 이벤트로만 알립니다.
+func (r *DemoReconciler) syncServiceAccount(ctx context.Context, s *reconcileState) error {
+	cr := s.cr
+	spec := cr.Spec.ServiceAccount
+	name := r.ownedServiceAccountName(cr)
+
+	// 소유하지 않은 객체가 이미 있으면 conflict로 기록하고 다음 객체로 넘어가지 않습니다.
+	conflict := func(obj client.Object) error {
+		s.setServiceAccountCondition(metav1.ConditionFalse, "Conflict",
+			fmt.Sprintf("%s %s is not controlled by the Demo", objectKind(obj, r), name))
+		return nil
+	}
+
+	desired, err := r.desiredServiceAccount(cr)
+	if err != nil {
+		return err
+	}
+	sa, role, binding, grant := desired.account, desired.role, desired.binding, desired.grant
+
+	ready, err := r.syncOwned(ctx, s, sa, desired.createAccount, func(current client.Object) bool { return false })
+	if err != nil {
+		return err
+	}
+	if desired.createAccount && !ready {
+		return conflict(sa)
+	}
+
+	ready, err = r.syncOwned(ctx, s, role, grant, func(current client.Object) bool {
+		cur := current.(*rbacv1.Role)
+		if equality.Semantic.DeepEqual(cur.Rules, role.Rules) {
+			return false
+		}
+		cur.Rules = role.Rules
+		return true
+	})
+	if errors.IsForbidden(err) {
+		msg := fmt.Sprintf("Role %s cannot grant spec.serviceAccount.rules: %v", name, err)
+		log.FromContext(ctx).Error(err, "Role rejected", objectKeys("Role", cr.Namespace, name)...)
+		r.event(cr, corev1.EventTypeWarning, reasonRoleForbidden, msg)
+		s.setServiceAccountCondition(metav1.ConditionFalse, "Forbidden", msg)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if grant && !ready {
+		return conflict(role)
+	}
+
+	ready, err = r.syncOwned(ctx, s, binding, grant, func(current client.Object) bool {
+		cur := current.(*rbacv1.RoleBinding)
+		if equality.Semantic.DeepEqual(cur.Subjects, binding.Subjects) {
+			return false
+		}
+		cur.Subjects = binding.Subjects
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if grant && !ready {
+		return conflict(binding)
+	}
+
+	switch {
+	case spec != nil && len(spec.Rules) > 0 && !grant:
+		// 줄 수 없는 rule이 있으면 이전에 만든 Role도 지우고 condition과 이벤트로 알립니다.
+		rulesErr := validateRoleRules(spec.Rules)
+		msg := fmt.Sprintf("Role %s is not created: %v", name, rulesErr)
+		if cond := meta.FindStatusCondition(cr.Status.Conditions, demoappv2.ConditionServiceAccountReady); cond == nil || cond.Message != msg {
+			r.event(cr, corev1.EventTypeWarning, reasonRoleRejected, msg)
+		}
+		s.setServiceAccountCondition(metav1.ConditionFalse, "RulesRejected", msg)
+	case spec == nil:
+		s.setServiceAccountCondition(metav1.ConditionFalse, "NotConfigured", "spec.serviceAccount is not set")
+	case grant:
+		s.setServiceAccountCondition(metav1.ConditionTrue, "Granted",
+			fmt.Sprintf("ServiceAccount %s is bound to Role %s", r.podServiceAccountName(cr), name))
+	default:
+		s.setServiceAccountCondition(metav1.ConditionTrue, "Ready",
+			fmt.Sprintf("Pods run as ServiceAccount %s", r.podServiceAccountName(cr)))
+	}
+	return nil
+}
+
+// syncOwned는 Demo가 controller로 등록된 desired를 want이면 만들거나 맞추고, 아니면 Demo가 만든 것만 지웁니다.
+// update는 클러스터의 객체를 desired에 맞추고 바뀐 경우 true를 반환합니다.
+// Demo가 쓸 수 있는 객체가 있으면 true를 반환합니다. 소유하지 않은 객체는 claim이 conflict로 기록합니다.
+func (r *DemoReconciler) syncOwned(ctx context.Context, s *reconcileState, desired client.Object, want bool, update func(current client.Object) bool) (bool, error) {
+	logger := log.FromContext(ctx)
+	cr := s.cr
+	kind := objectKind(desired, r)
+
+	// 이전 값과 섞이지 않도록 빈 객체로 읽습니다.
+	current := reflect.New(reflect.TypeOf(desired).Elem()).Interface().(client.Object)
+	err := r.Client.Get(ctx, client.ObjectKeyFromObject(desired), current)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	exists := err == nil
+
+	if !want {
+		if exists && metav1.IsControlledBy(current, cr) {
+			if err := r.Client.Delete(ctx, current); err != nil && !errors.IsNotFound(err) {
+				return false, err
+			}
+			logger.Info(kind+" deleted", objectKeys(kind, cr.Namespace, desired.GetName())...)
+		}
+		return false, nil
+	}
+
+	if !exists {
+		if err := r.Client.Create(ctx, desired); err != nil {
+			return false, err
+		}
+		logger.Info(kind+" created", objectKeys(kind, cr.Namespace, desired.GetName())...)
+		return true, nil
+	}
+
+	if owned, err := r.claim(ctx, s, current); err != nil || !owned {
+		return false, err
+	}
+
+	changed := mergeMetadata(current, desired)
+	if update(current) {
+		changed = true
+	}
+	if changed {
+		if err := r.Client.Update(ctx, current); err != nil {
+			return false, err
+		}
+		logger.Info(kind+" updated", objectKeys(kind, cr.Namespace, desired.GetName())...)
+	}
+	return true, nil
+}
+
+func (s *reconcileState) setServiceAccountCondition(status metav1.ConditionStatus, reason, message string) {
+	s.setCondition(metav1.Condition{
+		Type:    demoappv2.ConditionServiceAccountReady,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
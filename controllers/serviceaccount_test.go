@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestSyncServiceAccount(t *testing.T) {
+	rules := []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}}}
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling:        demoappv2.ScalingSpec{Replicas: 1},
+			ServiceAccount: &demoappv2.ServiceAccountSpec{Rules: rules},
+		},
+	}
+	r, c := newPipelineTest(t, cr)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, &corev1.ServiceAccount{}); err != nil {
+		t.Fatal(err)
+	}
+	role := &rbacv1.Role{}
+	if err := c.Get(ctx, key, role); err != nil {
+		t.Fatal(err)
+	}
+	if len(role.Rules) != 1 || role.Rules[0].Resources[0] != "configmaps" {
+		t.Errorf("got rules %+v, want the spec rules", role.Rules)
+	}
+	binding := &rbacv1.RoleBinding{}
+	if err := c.Get(ctx, key, binding); err != nil {
+		t.Fatal(err)
+	}
+	if binding.RoleRef.Name != "web" || len(binding.Subjects) != 1 || binding.Subjects[0].Name != "web" {
+		t.Errorf("unexpected RoleBinding %+v %+v", binding.RoleRef, binding.Subjects)
+	}
+	dep := &appsv1.Deployment{}
+	if err := c.Get(ctx, key, dep); err != nil {
+		t.Fatal(err)
+	}
+	// Role의 권한을 쓰도록 Restricted에서도 token을 마운트합니다. (nil이면 ServiceAccount의 기본값으로 마운트)
+	podSpec := dep.Spec.Template.Spec
+	if podSpec.ServiceAccountName != "web" || (podSpec.AutomountServiceAccountToken != nil && !*podSpec.AutomountServiceAccountToken) {
+		t.Errorf("got service account %q with automount %v", podSpec.ServiceAccountName, podSpec.AutomountServiceAccountToken)
+	}
+	assertServiceAccountCondition(t, c, key, metav1.ConditionTrue, "Granted")
+
+	c.reset()
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != 0 {
+		t.Errorf("got %d writes after convergence, want none", c.writes)
+	}
+
+	// 기존 ServiceAccount를 쓰도록 바꾸면 Demo가 만든 것은 모두 지웁니다.
+	got := &demoappv2.Demo{}
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	got.Spec.ServiceAccount = &demoappv2.ServiceAccountSpec{Name: "shared"}
+	if err := c.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []client.Object{&corev1.ServiceAccount{}, &rbacv1.Role{}, &rbacv1.RoleBinding{}} {
+		if err := c.Get(ctx, key, obj); !errors.IsNotFound(err) {
+			t.Errorf("got %v, want %T deleted", err, obj)
+		}
+	}
+	dep = &appsv1.Deployment{}
+	if err := c.Get(ctx, key, dep); err != nil {
+		t.Fatal(err)
+	}
+	if name := dep.Spec.Template.Spec.ServiceAccountName; name != "shared" {
+		t.Errorf("got service account %q, want shared", name)
+	}
+	assertServiceAccountCondition(t, c, key, metav1.ConditionTrue, "Ready")
+}
+
+// forbiddenRoleClient는 escalation을 막는 API 서버처럼 Role 생성을 거부합니다.
+type forbiddenRoleClient struct {
+	*countingClient
+}
+
+func (c forbiddenRoleClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if role, ok := obj.(*rbacv1.Role); ok {
+		return errors.NewForbidden(schema.GroupResource{Group: rbacv1.GroupName, Resource: "roles"}, role.Name,
+			errors.NewBadRequest("attempting to grant RBAC permissions not currently held"))
+	}
+	return c.countingClient.Create(ctx, obj, opts...)
+}
+
+func TestSyncServiceAccountForbidden(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			ServiceAccount: &demoappv2.ServiceAccountSpec{Rules: []rbacv1.PolicyRule{
+				{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"list"}},
+			}},
+		},
+	}
+	r, c := newPipelineTest(t, cr)
+	r.Client = forbiddenRoleClient{c}
+
+	// 다시 시도해도 같은 결과이므로 에러로 돌려주지 않고 condition으로 알립니다.
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	assertServiceAccountCondition(t, c, types.NamespacedName{Namespace: "default", Name: "web"}, metav1.ConditionFalse, "Forbidden")
+}
+
+func TestValidateRoleRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  rbacv1.PolicyRule
+		valid bool
+	}{
+		{name: "configmaps", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "list"}}, valid: true},
+		{name: "secrets", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}}},
+		{name: "rolebindings", rule: rbacv1.PolicyRule{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"rolebindings"}, Verbs: []string{"create"}}},
+		{name: "serviceaccount token", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"serviceaccounts/token"}, Verbs: []string{"create"}}},
+		{name: "pods exec", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods/exec"}, Verbs: []string{"create"}}},
+		{name: "wildcard resource", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"*"}, Verbs: []string{"get"}}},
+		{name: "wildcard subresource", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods/*"}, Verbs: []string{"get"}}},
+		{name: "wildcard group", rule: rbacv1.PolicyRule{APIGroups: []string{"*"}, Resources: []string{"configmaps"}, Verbs: []string{"get"}}},
+		{name: "wildcard verb", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"*"}}},
+		{name: "escalate", rule: rbacv1.PolicyRule{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"clusterroles"}, Verbs: []string{"escalate"}}},
+		{name: "impersonate", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"users"}, Verbs: []string{"impersonate"}}},
+		{name: "watch pods", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch"}}, valid: true},
+		// workload를 만들 수 있으면 그 pod로 어떤 Secret이나 ServiceAccount든 쓸 수 있습니다.
+		{name: "create pods", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"create"}}},
+		{name: "create deployments", rule: rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"create"}}},
+		{name: "update statefulsets", rule: rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, Verbs: []string{"update"}}},
+		{name: "patch replicasets", rule: rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"replicasets"}, Verbs: []string{"patch"}}},
+		{name: "create jobs", rule: rbacv1.PolicyRule{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"create"}}},
+		{name: "create cronjobs", rule: rbacv1.PolicyRule{APIGroups: []string{"batch"}, Resources: []string{"cronjobs"}, Verbs: []string{"create"}}},
+		{name: "update demoes", rule: rbacv1.PolicyRule{APIGroups: []string{demoappv2.GroupVersion.Group}, Resources: []string{"demoes"}, Verbs: []string{"update"}}},
+		{name: "delete configmaps", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"delete"}}},
+	}
+	for _, tt := range tests {
+		err := validateRoleRules([]rbacv1.PolicyRule{tt.rule})
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+// 줄 수 없는 rule이 있으면 Role을 만들지 않고, 이전에 만든 Role도 지웁니다.
+func TestSyncServiceAccountRejectsRules(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			ServiceAccount: &demoappv2.ServiceAccountSpec{Rules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}},
+			}},
+		},
+	}
+	r, c := newPipelineTest(t, cr)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, key, &rbacv1.Role{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &demoappv2.Demo{}
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	got.Spec.ServiceAccount.Rules = append(got.Spec.ServiceAccount.Rules,
+		rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}})
+	if err := c.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []client.Object{&rbacv1.Role{}, &rbacv1.RoleBinding{}} {
+		if err := c.Get(ctx, key, obj); !errors.IsNotFound(err) {
+			t.Errorf("got %v, want %T deleted", err, obj)
+		}
+	}
+	assertServiceAccountCondition(t, c, key, metav1.ConditionFalse, "RulesRejected")
+}
+
+func assertServiceAccountCondition(t *testing.T, c *countingClient, key types.NamespacedName, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	d := &demoappv2.Demo{}
+	if err := c.Get(context.Background(), key, d); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(d.Status.Conditions, demoappv2.ConditionServiceAccountReady)
+	if cond == nil || cond.Status != status || cond.Reason != reason {
+		t.Errorf("got condition %+v, want %s %s", cond, status, reason)
+	}
+}
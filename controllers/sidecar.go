@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"fmt"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	nginxContainerName = "nginx"
+
+	// nginx-prometheus-exporter가 metrics를 노출하는 포트
+	exporterPort = 9113
+)
+
+// spec.pod.sidecars에 name이 있는지 확인합니다.
+func hasSidecar(d *demoappv2.Demo, name demoappv2.BuiltinSidecar) bool {
+	for _, s := range d.Spec.Pod.Sidecars {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 컨테이너 이름이 내장 sidecar인지 확인합니다.
+func isBuiltinSidecar(name string) bool {
+	return name == string(demoappv2.NginxPrometheusExporter)
+}
+
+// 내장 sidecar 컨테이너를 정의합니다.
+func builtinSidecar(name demoappv2.BuiltinSidecar) corev1.Container {
+	switch name {
+	case demoappv2.NginxPrometheusExporter:
+		// "/"는 stub_status를 조회하지 않는 안내 페이지라서 probe가 idle 감지용 요청 수에 잡히지 않습니다.
+		probe := &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromString("metrics")},
+			},
+		}
+		return corev1.Container{
+			Name:  string(name),
+			Image: "nginx/nginx-prometheus-exporter:0.10.0",
+			Args:  []string{fmt.Sprintf("-nginx.scrape-uri=http://localhost:%d/stub_status", stubStatusPort)},
+			Ports: []corev1.ContainerPort{
+				{
+					Name:          "metrics",
+					ContainerPort: exporterPort,
+					Protocol:      corev1.ProtocolTCP,
+				},
+			},
+			ReadinessProbe: probe,
+			LivenessProbe:  probe,
+		}
+	}
+	return corev1.Container{}
+}
+
+// 내장 sidecar가 Service에 노출하는 포트
+func sidecarServicePorts(d *demoappv2.Demo) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	if hasSidecar(d, demoappv2.NginxPrometheusExporter) {
+		ports = append(ports, corev1.ServicePort{
+			Name:       "metrics",
+			Protocol:   corev1.ProtocolTCP,
+			Port:       exporterPort,
+			TargetPort: intstr.FromString("metrics"),
+		})
+	}
+	return ports
+}
+
+// 사용자 컨테이너 이름이 nginx나 내장 sidecar와 겹치지 않는지 확인합니다.
+func validateContainers(d *demoappv2.Demo) error {
+	names := map[string]bool{nginxContainerName: true}
+	for _, s := range d.Spec.Pod.Sidecars {
+		names[string(s)] = true
+	}
+
+	for _, c := range append(append([]corev1.Container{}, d.Spec.Pod.InitContainers...), d.Spec.Pod.Containers...) {
+		if names[c.Name] {
+			return fmt.Errorf("container name %q is already used by the Demo pod", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return nil
+}
+
+// pod template의 컨테이너 순서대로 pod 전체의 준비 상태를 집계합니다.
+func containerReadiness(template *corev1.PodTemplateSpec, pods []corev1.Pod) []demoappv2.ContainerReadiness {
+	var readiness []demoappv2.ContainerReadiness
+	for _, c := range template.Spec.Containers {
+		cr := demoappv2.ContainerReadiness{Name: c.Name}
+		for _, p := range pods {
+			for _, cs := range p.Status.ContainerStatuses {
+				if cs.Name != c.Name {
+					continue
+				}
+				cr.Total++
+				if cs.Ready {
+					cr.Ready++
+				}
+			}
+		}
+		readiness = append(readiness, cr)
+	}
+	return readiness
+}
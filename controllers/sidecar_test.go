@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodTemplateWithSidecars(t *testing.T) {
+	d := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			Pod: demoappv2.PodSpec{
+				InitContainers: []corev1.Container{{Name: "fetch", Image: "busybox"}},
+				Containers:     []corev1.Container{{Name: "log-shipper", Image: "fluent-bit"}},
+				Sidecars:       []demoappv2.BuiltinSidecar{demoappv2.NginxPrometheusExporter},
+			},
+		},
+	}
+
+	template := (&DemoReconciler{}).podTemplate(d, "")
+
+	var names []string
+	for _, c := range template.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	want := []string{"nginx", "log-shipper", "nginx-prometheus-exporter"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got containers %v, want %v", names, want)
+	}
+	if len(template.Spec.InitContainers) != 1 || template.Spec.InitContainers[0].Name != "fetch" {
+		t.Errorf("unexpected init containers %+v", template.Spec.InitContainers)
+	}
+
+	// exporter가 stub_status를 읽을 수 있도록 nginx 설정이 마운트되어야 합니다.
+	if !needsNginxConfig(d) || len(template.Spec.Volumes) == 0 || template.Spec.Volumes[0].Name != nginxConfigVolume {
+		t.Errorf("expected nginx config volume, got %+v", template.Spec.Volumes)
+	}
+
+	ports := servicePorts(d)
+	if len(ports) != 2 || ports[1].Name != "metrics" || ports[1].Port != exporterPort {
+		t.Errorf("unexpected service ports %+v", ports)
+	}
+}
+
+func TestValidateContainers(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    demoappv2.PodSpec
+		wantErr bool
+	}{
+		{name: "no extra containers"},
+		{
+			name: "unique names",
+			spec: demoappv2.PodSpec{Containers: []corev1.Container{{Name: "a"}}, InitContainers: []corev1.Container{{Name: "b"}}},
+		},
+		{
+			name:    "clashes with nginx",
+			spec:    demoappv2.PodSpec{Containers: []corev1.Container{{Name: "nginx"}}},
+			wantErr: true,
+		},
+		{
+			name: "clashes with builtin sidecar",
+			spec: demoappv2.PodSpec{
+				Containers: []corev1.Container{{Name: "nginx-prometheus-exporter"}},
+				Sidecars:   []demoappv2.BuiltinSidecar{demoappv2.NginxPrometheusExporter},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "init and app container share a name",
+			spec:    demoappv2.PodSpec{Containers: []corev1.Container{{Name: "a"}}, InitContainers: []corev1.Container{{Name: "a"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		err := validateContainers(&demoappv2.Demo{Spec: demoappv2.DemoSpec{Pod: tt.spec}})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got err %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestContainerReadiness(t *testing.T) {
+	template := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "nginx"}, {Name: "nginx-prometheus-exporter"},
+	}}}
+	pods := []corev1.Pod{
+		{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "nginx", Ready: true}, {Name: "nginx-prometheus-exporter", Ready: false},
+		}}},
+		{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "nginx", Ready: true}, {Name: "nginx-prometheus-exporter", Ready: true},
+		}}},
+	}
+
+	got := containerReadiness(template, pods)
+	want := []demoappv2.ContainerReadiness{
+		{Name: "nginx", Ready: 2, Total: 2},
+		{Name: "nginx-prometheus-exporter", Ready: 1, Total: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
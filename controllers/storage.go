@@ -0,0 +1,489 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// retentionPolicy가 Delete인 Demo가 삭제될 때 PVC를 정리하기 위한 finalizer
+const volumeFinalizer = "demoapp.my.domain/volumes"
+
+// spec.storage의 retention 정책 (기본값 Retain)
+func volumeRetention(d *demoappv2.Demo) demoappv2.VolumeRetentionPolicy {
+	if d.Spec.Storage == nil || d.Spec.Storage.RetentionPolicy == "" {
+		return demoappv2.RetainVolumes
+	}
+	return d.Spec.Storage.RetentionPolicy
+}
+
+// storage 설정에 따라 Deployment 또는 StatefulSet을 원하는 workload로 사용합니다.
+// 서버 인증서가 바뀌면 nginx가 새 인증서를 읽도록 certExpiry를 pod template에 기록합니다.
+func (r *DemoReconciler) desiredWorkload(d *demoappv2.Demo, contentRevision string, certExpiry *metav1.Time) (client.Object, error) {
+	var workload client.Object
+	var err error
+	if d.Spec.Storage != nil {
+		workload, err = r.createStatefulSet(d, contentRevision)
+	} else {
+		workload, err = r.createDeployment(d, contentRevision)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.Spec.TLS != nil && certExpiry != nil {
+		addCertificateExpiry(workload, certExpiry)
+	}
+	return workload, nil
+}
+
+// 사용하지 않는 종류의 workload (마이그레이션 후 정리 대상)
+func (r *DemoReconciler) staleWorkload(d *demoappv2.Demo) client.Object {
+	if d.Spec.Storage != nil {
+		return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: r.deploymentName(d), Namespace: d.Namespace}}
+	}
+	return &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: r.statefulSetName(d), Namespace: d.Namespace}}
+}
+
+// getWorkload는 Demo의 workload를 obj에 읽습니다. 이름은 obj의 이름을 사용하고, 없으면 NotFound를 반환합니다.
+// 이름 규칙이 생기기 전에는 workload 이름이 Demo 이름 그대로였고, nameTemplate을 바꾸면 지금 규칙의 이름과 다를 수 있습니다.
+// 이름을 바꾸면 pod와 StatefulSet의 PVC가 버려지므로 이 Demo가 소유한 다른 이름의 workload가 있으면 그것을 읽습니다.
+func getWorkload(ctx context.Context, c client.Reader, d *demoappv2.Demo, obj client.Object) error {
+	name := obj.GetName()
+	err := c.Get(ctx, client.ObjectKey{Namespace: d.Namespace, Name: name}, obj)
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	owned, ownedErr := findOwnedWorkload(ctx, c, d, obj)
+	if ownedErr != nil {
+		return ownedErr
+	}
+	if owned == nil {
+		return err
+	}
+	switch w := obj.(type) {
+	case *appsv1.Deployment:
+		*w = *owned.(*appsv1.Deployment)
+	case *appsv1.StatefulSet:
+		*w = *owned.(*appsv1.StatefulSet)
+	}
+	return nil
+}
+
+// findOwnedWorkload는 obj와 같은 종류이면서 Demo가 소유한 다른 이름의 workload를 찾습니다. 없으면 nil입니다.
+// 이름 규칙 전에 만든 workload는 selector label이 없을 수 있으므로 Demo 이름으로도 찾습니다.
+func findOwnedWorkload(ctx context.Context, c client.Reader, d *demoappv2.Demo, obj client.Object) (client.Object, error) {
+	if obj.GetName() != d.Name {
+		legacy := emptyWorkload(obj)
+		err := c.Get(ctx, client.ObjectKey{Namespace: d.Namespace, Name: d.Name}, legacy)
+		if err == nil && metav1.IsControlledBy(legacy, d) {
+			return legacy, nil
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	var candidates []client.Object
+	opts := []client.ListOption{client.InNamespace(d.Namespace), client.MatchingLabels(selectorLabels(d))}
+	if _, ok := obj.(*appsv1.StatefulSet); ok {
+		list := &appsv1.StatefulSetList{}
+		if err := c.List(ctx, list, opts...); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			candidates = append(candidates, &list.Items[i])
+		}
+	} else {
+		list := &appsv1.DeploymentList{}
+		if err := c.List(ctx, list, opts...); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			candidates = append(candidates, &list.Items[i])
+		}
+	}
+	for _, w := range candidates {
+		if metav1.IsControlledBy(w, d) {
+			return w, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetWorkload는 Demo의 Deployment 또는 StatefulSet을 읽습니다. spec.storage가 있으면 StatefulSet입니다.
+// operator 설정의 nameTemplate을 몰라도 Demo가 소유한 workload를 찾으므로 kubectl-demo처럼 operator 밖에서 workload를 찾을 때 사용합니다.
+func GetWorkload(ctx context.Context, c client.Reader, d *demoappv2.Demo) (client.Object, error) {
+	// 기본 nameTemplate의 이름을 먼저 읽습니다.
+	var obj client.Object = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: childName(d.Name, "", maxNameLength)}}
+	if d.Spec.Storage != nil {
+		obj = &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: childName(d.Name, "", maxStatefulSetNameLength)}}
+	}
+	return obj, getWorkload(ctx, c, d, obj)
+}
+
+// obj와 같은 종류의 빈 객체 (Get에 사용)
+func emptyWorkload(obj client.Object) client.Object {
+	if _, ok := obj.(*appsv1.StatefulSet); ok {
+		return &appsv1.StatefulSet{}
+	}
+	return &appsv1.Deployment{}
+}
+
+// 로그에 사용할 workload 종류
+func workloadKind(obj client.Object) string {
+	if _, ok := obj.(*appsv1.StatefulSet); ok {
+		return "StatefulSet"
+	}
+	return "Deployment"
+}
+
+func workloadTemplate(obj client.Object) *corev1.PodTemplateSpec {
+	switch w := obj.(type) {
+	case *appsv1.Deployment:
+		return &w.Spec.Template
+	case *appsv1.StatefulSet:
+		return &w.Spec.Template
+	}
+	return nil
+}
+
+// pod template 해시를 workload annotation에 기록합니다.
+func setTemplateHash(obj client.Object) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[templateHashAnnotation] = hashObject(workloadTemplate(obj))
+	obj.SetAnnotations(annotations)
+}
+
+func workloadSelector(obj client.Object) *metav1.LabelSelector {
+	switch w := obj.(type) {
+	case *appsv1.Deployment:
+		return w.Spec.Selector
+	case *appsv1.StatefulSet:
+		return w.Spec.Selector
+	}
+	return nil
+}
+
+func workloadReplicas(obj client.Object) *int32 {
+	switch w := obj.(type) {
+	case *appsv1.Deployment:
+		if w.Spec.Replicas == nil {
+			w.Spec.Replicas = new(int32)
+		}
+		return w.Spec.Replicas
+	case *appsv1.StatefulSet:
+		if w.Spec.Replicas == nil {
+			w.Spec.Replicas = new(int32)
+		}
+		return w.Spec.Replicas
+	}
+	return nil
+}
+
+func workloadReadyReplicas(obj client.Object) int32 {
+	switch w := obj.(type) {
+	case *appsv1.Deployment:
+		return w.Status.ReadyReplicas
+	case *appsv1.StatefulSet:
+		return w.Status.ReadyReplicas
+	}
+	return 0
+}
+
+// 현재 pod template으로 모든 pod가 배포되었는지 확인합니다.
+func workloadRolledOut(obj client.Object) bool {
+	replicas := *workloadReplicas(obj)
+	switch w := obj.(type) {
+	case *appsv1.Deployment:
+		return w.Status.ObservedGeneration >= w.Generation &&
+			w.Status.UpdatedReplicas == replicas && w.Status.Replicas == replicas
+	case *appsv1.StatefulSet:
+		return w.Status.ObservedGeneration >= w.Generation &&
+			w.Status.CurrentRevision == w.Status.UpdateRevision && w.Status.UpdatedReplicas == replicas
+	}
+	return false
+}
+
+// retentionPolicy가 Delete일 때만 finalizer를 유지합니다. 변경된 경우 true를 반환합니다.
+func (r *DemoReconciler) ensureVolumeFinalizer(ctx context.Context, cr *demoappv2.Demo) (bool, error) {
+	want := cr.Spec.Storage != nil && volumeRetention(cr) == demoappv2.DeleteVolumes
+	has := controllerutil.ContainsFinalizer(cr, volumeFinalizer)
+
+	switch {
+	case want && !has:
+		controllerutil.AddFinalizer(cr, volumeFinalizer)
+	case !want && has:
+		controllerutil.RemoveFinalizer(cr, volumeFinalizer)
+	default:
+		return false, nil
+	}
+	return true, r.Client.Update(ctx, cr)
+}
+
+// 삭제 중인 Demo의 PVC를 지우고 finalizer를 제거합니다.
+func (r *DemoReconciler) finalizeVolumes(ctx context.Context, cr *demoappv2.Demo) error {
+	if !controllerutil.ContainsFinalizer(cr, volumeFinalizer) {
+		return nil
+	}
+
+	if err := r.deleteVolumes(ctx, cr); err != nil {
+		return err
+	}
+
+	controllerutil.RemoveFinalizer(cr, volumeFinalizer)
+	return r.Client.Update(ctx, cr)
+}
+
+// Demo의 label이 붙은 PVC를 모두 삭제합니다.
+func (r *DemoReconciler) deleteVolumes(ctx context.Context, cr *demoappv2.Demo) error {
+	logger := log.FromContext(ctx)
+
+	pvcList := &corev1.PersistentVolumeClaimList{}
+	err := r.Client.List(ctx, pvcList, client.InNamespace(cr.Namespace), client.MatchingLabels(selectorLabels(cr)))
+	if err != nil {
+		return err
+	}
+
+	for i := range pvcList.Items {
+		pvc := &pvcList.Items[i]
+		if err := r.Client.Delete(ctx, pvc); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		logger.Info("PersistentVolumeClaim deleted", objectKeys("PersistentVolumeClaim", pvc.Namespace, pvc.Name)...)
+	}
+	return nil
+}
+
+// Deployment <-> StatefulSet 마이그레이션 중이면 새 workload가 준비된 뒤 이전 workload를 삭제합니다.
+// 두 workload의 pod는 같은 label을 가지므로 마이그레이션 동안 Service는 양쪽 pod로 요청을 보냅니다.
+// 정리한 경우 true를 반환합니다.
+func (r *DemoReconciler) cleanupStaleWorkload(ctx context.Context, cr *demoappv2.Demo, current client.Object, size int32) (bool, error) {
+	logger := log.FromContext(ctx)
+
+	stale := r.staleWorkload(cr)
+	err := getWorkload(ctx, r.Client, cr, stale)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// 이 Demo가 만든 workload만 정리합니다.
+	if !metav1.IsControlledBy(stale, cr) {
+		return false, nil
+	}
+
+	// 새 workload가 준비될 때까지 이전 workload를 유지합니다.
+	if workloadReadyReplicas(current) < size {
+		logger.V(logDebug).Info("Waiting for new workload before removing the old one",
+			append(objectKeys(workloadKind(current), current.GetNamespace(), current.GetName()),
+				"ready", workloadReadyReplicas(current), "replicas", size)...)
+		return false, nil
+	}
+
+	err = r.Client.Delete(ctx, stale, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	logger.Info("Old workload deleted", objectKeys(workloadKind(stale), stale.GetNamespace(), stale.GetName())...)
+
+	// StatefulSet에서 옮겨온 경우 headless Service와, 정책에 따라 PVC를 정리합니다.
+	if _, ok := stale.(*appsv1.StatefulSet); ok {
+		headless := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: r.headlessServiceName(cr), Namespace: cr.Namespace}}
+		if err := r.Client.Delete(ctx, headless); err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+		if stale.GetAnnotations()[volumeRetentionAnnotation] == string(demoappv2.DeleteVolumes) {
+			if err := r.deleteVolumes(ctx, cr); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// replica별 PVC의 binding 상태를 이름순으로 반환합니다.
+func (r *DemoReconciler) volumeStatuses(ctx context.Context, cr *demoappv2.Demo) ([]demoappv2.VolumeStatus, error) {
+	if cr.Spec.Storage == nil {
+		return nil, nil
+	}
+
+	pvcList := &corev1.PersistentVolumeClaimList{}
+	err := r.Client.List(ctx, pvcList, client.InNamespace(cr.Namespace), client.MatchingLabels(selectorLabels(cr)))
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []demoappv2.VolumeStatus
+	for _, pvc := range pvcList.Items {
+		volumes = append(volumes, demoappv2.VolumeStatus{Name: pvc.Name, Phase: pvc.Status.Phase})
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+// 이벤트 reason
+const (
+	reasonStorageDrift    = "StorageDrift"
+	reasonVolumesExpanded = "VolumesExpanded"
+)
+
+// StatefulSet의 volumeClaimTemplates는 바꿀 수 없으므로 pod template 해시에 넣지 않고 따로 비교합니다.
+//
+//   - size를 늘리면 기존 PVC의 요청 크기를 늘리고, StatefulSet을 orphan으로 삭제해 새 template으로
+//     다시 만듭니다. pod와 PVC는 남고, 새 replica도 늘린 크기의 PVC를 받습니다.
+//     StorageClass가 확장을 허용하지 않으면 API server가 PVC 변경을 거부합니다.
+//   - size를 줄이거나 storageClassName, accessModes를 바꾸면 기존 volume에 적용할 수 없으므로
+//     StatefulSet을 그대로 두고 StorageSynced=False condition과 StorageDrift 이벤트로 알립니다.
+//
+// StatefulSet을 삭제한 경우 true를 반환합니다.
+func (r *DemoReconciler) syncVolumeClaimTemplate(ctx context.Context, s *reconcileState, current, desired *appsv1.StatefulSet) (bool, error) {
+	logger := log.FromContext(ctx)
+	cur, want := claimTemplate(current), claimTemplate(desired)
+	if cur == nil || want == nil {
+		return false, nil
+	}
+
+	var drift []string
+	if !equality.Semantic.DeepEqual(cur.Spec.AccessModes, want.Spec.AccessModes) {
+		drift = append(drift, fmt.Sprintf("accessModes cannot be changed from %v", cur.Spec.AccessModes))
+	}
+	if storageClass(cur) != storageClass(want) {
+		drift = append(drift, fmt.Sprintf("storageClassName cannot be changed from %q", storageClass(cur)))
+	}
+	curSize := cur.Spec.Resources.Requests[corev1.ResourceStorage]
+	wantSize := want.Spec.Resources.Requests[corev1.ResourceStorage]
+	if wantSize.Cmp(curSize) < 0 {
+		drift = append(drift, fmt.Sprintf("size cannot be decreased from %s", curSize.String()))
+	}
+	if len(drift) > 0 {
+		r.setStorageDrift(s, "spec.storage: "+strings.Join(drift, "; ")+" once the volumes exist")
+		return false, nil
+	}
+	if wantSize.Cmp(curSize) == 0 {
+		s.setStorageSynced()
+		return false, nil
+	}
+
+	pvcs, err := r.statefulSetVolumes(ctx, s.cr, current)
+	if err != nil {
+		return false, err
+	}
+	for _, pvc := range pvcs {
+		if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.Cmp(wantSize) >= 0 {
+			continue
+		}
+		if pvc.Spec.Resources.Requests == nil {
+			pvc.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = wantSize
+		if err := r.Client.Update(ctx, pvc); err != nil {
+			if errors.IsForbidden(err) || errors.IsInvalid(err) {
+				r.setStorageDrift(s, fmt.Sprintf("PersistentVolumeClaim %s cannot be expanded to %s: %v", pvc.Name, wantSize.String(), err))
+				return false, nil
+			}
+			return false, err
+		}
+		logger.Info("PersistentVolumeClaim expanded", append(objectKeys("PersistentVolumeClaim", pvc.Namespace, pvc.Name), "size", wantSize.String())...)
+	}
+
+	err = r.Client.Delete(ctx, current, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	msg := fmt.Sprintf("Volumes expanded to %s, StatefulSet %s is recreated with the new size", wantSize.String(), current.Name)
+	logger.Info("StatefulSet deleted to change its volume size, pods and volumes are kept", objectKeys("StatefulSet", current.Namespace, current.Name)...)
+	s.setCondition(metav1.Condition{
+		Type:    demoappv2.ConditionStorageSynced,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Expanding",
+		Message: msg,
+	})
+	r.event(s.cr, corev1.EventTypeNormal, reasonVolumesExpanded, msg)
+	return true, nil
+}
+
+func (s *reconcileState) setStorageSynced() {
+	s.setCondition(metav1.Condition{
+		Type:    demoappv2.ConditionStorageSynced,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Synced",
+		Message: "The volumes match spec.storage",
+	})
+}
+
+// 바뀐 condition에만 이벤트를 남깁니다.
+func (r *DemoReconciler) setStorageDrift(s *reconcileState, msg string) {
+	changed := s.setCondition(metav1.Condition{
+		Type:    demoappv2.ConditionStorageSynced,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonStorageDrift,
+		Message: msg,
+	})
+	if changed {
+		r.event(s.cr, corev1.EventTypeWarning, reasonStorageDrift, msg)
+	}
+}
+
+// spec.storage가 없어지면 StorageSynced condition을 지웁니다.
+func (s *reconcileState) clearStorageCondition() {
+	if s.cr.Spec.Storage == nil && meta.FindStatusCondition(s.cr.Status.Conditions, demoappv2.ConditionStorageSynced) != nil {
+		meta.RemoveStatusCondition(&s.cr.Status.Conditions, demoappv2.ConditionStorageSynced)
+		s.conditionsChanged = true
+	}
+}
+
+// StatefulSet의 data volume claim template
+func claimTemplate(sts *appsv1.StatefulSet) *corev1.PersistentVolumeClaim {
+	for i := range sts.Spec.VolumeClaimTemplates {
+		if sts.Spec.VolumeClaimTemplates[i].Name == storageVolume {
+			return &sts.Spec.VolumeClaimTemplates[i]
+		}
+	}
+	return nil
+}
+
+func storageClass(pvc *corev1.PersistentVolumeClaim) string {
+	if pvc.Spec.StorageClassName == nil {
+		return ""
+	}
+	return *pvc.Spec.StorageClassName
+}
+
+// StatefulSet이 만든 Demo의 PVC (data-<name>-<ordinal>)
+func (r *DemoReconciler) statefulSetVolumes(ctx context.Context, cr *demoappv2.Demo, sts *appsv1.StatefulSet) ([]*corev1.PersistentVolumeClaim, error) {
+	pvcList := &corev1.PersistentVolumeClaimList{}
+	err := r.Client.List(ctx, pvcList, client.InNamespace(cr.Namespace), client.MatchingLabels(selectorLabels(cr)))
+	if err != nil {
+		return nil, err
+	}
+	prefix := storageVolume + "-" + sts.Name + "-"
+	var pvcs []*corev1.PersistentVolumeClaim
+	for i := range pvcList.Items {
+		ordinal := strings.TrimPrefix(pvcList.Items[i].Name, prefix)
+		if _, err := strconv.Atoi(ordinal); ordinal == pvcList.Items[i].Name || err != nil {
+			continue
+		}
+		pvcs = append(pvcs, &pvcList.Items[i])
+	}
+	return pvcs, nil
+}
@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	configv1alpha1 "demo-operator/api/config/v1alpha1"
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCleanupStaleWorkload(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = demoappv2.AddToScheme(scheme)
+
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 2},
+			Storage: &demoappv2.StorageSpec{Size: resource.MustParse("1Gi")},
+		},
+	}
+	r := &DemoReconciler{Scheme: scheme}
+
+	// storage가 추가되기 전에 만든 Deployment
+	old, _ := r.createDeployment(&demoappv2.Demo{ObjectMeta: cr.ObjectMeta, Spec: demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 2}}}, "")
+	// volumeClaimTemplate으로 만들어진 PVC
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name: "data-web-0", Namespace: "default", Labels: selectorLabels(cr),
+	}}
+	r.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr, old, pvc).Build()
+
+	ctx := context.Background()
+	sts, _ := r.createStatefulSet(cr, "")
+
+	// StatefulSet이 아직 준비되지 않으면 Deployment를 유지합니다.
+	sts.Status.ReadyReplicas = 1
+	cleaned, err := r.cleanupStaleWorkload(ctx, cr, sts, 2)
+	if err != nil || cleaned {
+		t.Fatalf("got cleaned=%v err=%v, want Deployment kept", cleaned, err)
+	}
+
+	// 준비되면 Deployment를 삭제합니다.
+	sts.Status.ReadyReplicas = 2
+	cleaned, err = r.cleanupStaleWorkload(ctx, cr, sts, 2)
+	if err != nil || !cleaned {
+		t.Fatalf("got cleaned=%v err=%v, want Deployment removed", cleaned, err)
+	}
+	err = r.Client.Get(ctx, types.NamespacedName{Name: "web", Namespace: "default"}, &appsv1.Deployment{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected Deployment to be deleted, got %v", err)
+	}
+
+	volumes, err := r.volumeStatuses(ctx, cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 || volumes[0].Name != "data-web-0" {
+		t.Errorf("unexpected volumes %+v", volumes)
+	}
+}
+
+// 이름 규칙 전에 Demo 이름 그대로 만든 StatefulSet은 이름이 52자를 넘어도 그대로 사용합니다.
+// 새 이름으로 만들면 이전 StatefulSet과 PVC가 버려집니다.
+func TestSyncWorkloadKeepsLegacyStatefulSetName(t *testing.T) {
+	name := "a-very-long-demo-name-that-is-longer-than-fifty-two-chars"
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			Storage: &demoappv2.StorageSpec{Size: resource.MustParse("1Gi")},
+		},
+	}
+	r, c := newPipelineTest(t, cr)
+	if r.statefulSetName(cr) == name {
+		t.Fatalf("expected %q to be truncated", name)
+	}
+	legacy, err := r.createStatefulSet(cr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.Name = name
+	legacy.Spec.Replicas = new(int32)
+	if err := c.Create(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reconcileDemo(t, r, name); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = c.Get(ctx, types.NamespacedName{Namespace: "default", Name: r.statefulSetName(cr)}, &appsv1.StatefulSet{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected no StatefulSet with the truncated name, got %v", err)
+	}
+	sts := &appsv1.StatefulSet{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, sts); err != nil {
+		t.Fatal(err)
+	}
+	if *sts.Spec.Replicas != 1 {
+		t.Errorf("got %d replicas on the existing StatefulSet, want 1", *sts.Spec.Replicas)
+	}
+
+	workload, err := GetWorkload(ctx, c, cr)
+	if err != nil || workload.GetName() != name {
+		t.Errorf("GetWorkload returned %v, %v, want the existing StatefulSet", workload, err)
+	}
+}
+
+// nameTemplate을 바꾸면 Service는 새 이름으로 만들지만, pod를 버리지 않도록 workload는 이전 이름을 계속 사용합니다.
+func TestSyncWorkloadKeepsWorkloadAfterNameTemplateChange(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+	}
+	r, c := newPipelineTest(t, cr)
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := configv1alpha1.ParseNameTemplate("demo-{{.Name}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.NameTemplate = tmpl
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "demo-web"}, &corev1.Service{}); err != nil {
+		t.Errorf("expected a Service named after the template: %v", err)
+	}
+	err = c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "demo-web"}, &appsv1.Deployment{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected no Deployment named after the template, got %v", err)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &appsv1.Deployment{}); err != nil {
+		t.Errorf("expected the existing Deployment to be kept: %v", err)
+	}
+}
+
+// 크기를 늘리면 PVC를 늘리고 StatefulSet을 orphan으로 삭제해 새 volumeClaimTemplates로 다시 만듭니다.
+func TestSyncWorkloadExpandsVolumes(t *testing.T) {
+	_, r, c := newStorageTest(t)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	setStorage(t, c, func(s *demoappv2.StorageSpec) { s.Size = resource.MustParse("2Gi") })
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "data-web-0"}, pvc); err != nil {
+		t.Fatal(err)
+	}
+	if got := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; got.Cmp(resource.MustParse("2Gi")) != 0 {
+		t.Errorf("got PVC size %s, want 2Gi", got.String())
+	}
+	if err := c.Get(ctx, key, &appsv1.StatefulSet{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the StatefulSet to be deleted, got %v", err)
+	}
+	assertStorageCondition(t, c, key, metav1.ConditionFalse, "Expanding")
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	sts := &appsv1.StatefulSet{}
+	if err := c.Get(ctx, key, sts); err != nil {
+		t.Fatal(err)
+	}
+	if got := claimTemplate(sts).Spec.Resources.Requests[corev1.ResourceStorage]; got.Cmp(resource.MustParse("2Gi")) != 0 {
+		t.Errorf("got claim template size %s, want 2Gi", got.String())
+	}
+	assertStorageCondition(t, c, key, metav1.ConditionTrue, "Synced")
+}
+
+// storageClassName이나 accessModes, 줄인 크기는 기존 volume에 적용할 수 없으므로 StatefulSet을 그대로 둡니다.
+func TestSyncWorkloadReportsStorageDrift(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(s *demoappv2.StorageSpec)
+	}{
+		{name: "storage class", mutate: func(s *demoappv2.StorageSpec) {
+			class := "fast"
+			s.StorageClassName = &class
+		}},
+		{name: "access modes", mutate: func(s *demoappv2.StorageSpec) {
+			s.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+		}},
+		{name: "smaller size", mutate: func(s *demoappv2.StorageSpec) { s.Size = resource.MustParse("512Mi") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, r, c := newStorageTest(t)
+			key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+			setStorage(t, c, tt.mutate)
+			if err := reconcileDemo(t, r, "web"); err != nil {
+				t.Fatal(err)
+			}
+			sts := &appsv1.StatefulSet{}
+			if err := c.Get(context.Background(), key, sts); err != nil {
+				t.Fatalf("expected the StatefulSet to be kept: %v", err)
+			}
+			if got := claimTemplate(sts).Spec.Resources.Requests[corev1.ResourceStorage]; got.Cmp(resource.MustParse("1Gi")) != 0 {
+				t.Errorf("got claim template size %s, want 1Gi", got.String())
+			}
+			assertStorageCondition(t, c, key, metav1.ConditionFalse, reasonStorageDrift)
+		})
+	}
+}
+
+// StorageClass가 확장을 허용하지 않아 PVC 변경이 거부되면 StatefulSet을 지우지 않습니다.
+func TestSyncWorkloadReportsRejectedExpansion(t *testing.T) {
+	_, r, c := newStorageTest(t)
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+	r.Client = rejectingPVCClient{c}
+
+	setStorage(t, c, func(s *demoappv2.StorageSpec) { s.Size = resource.MustParse("2Gi") })
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(context.Background(), key, &appsv1.StatefulSet{}); err != nil {
+		t.Errorf("expected the StatefulSet to be kept: %v", err)
+	}
+	assertStorageCondition(t, c, key, metav1.ConditionFalse, reasonStorageDrift)
+}
+
+type rejectingPVCClient struct {
+	*countingClient
+}
+
+func (c rejectingPVCClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if pvc, ok := obj.(*corev1.PersistentVolumeClaim); ok {
+		return errors.NewForbidden(schema.GroupResource{Resource: "persistentvolumeclaims"}, pvc.Name,
+			fmt.Errorf("only dynamically provisioned pvc can be resized and the storageclass that provisions the pvc must support resize"))
+	}
+	return c.countingClient.Update(ctx, obj, opts...)
+}
+
+// 1Gi volume의 StatefulSet과 그 PVC가 있는 Demo
+func newStorageTest(t *testing.T) (*demoappv2.Demo, *DemoReconciler, *countingClient) {
+	t.Helper()
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			Storage: &demoappv2.StorageSpec{Size: resource.MustParse("1Gi")},
+		},
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data-web-0", Namespace: "default", Labels: objectLabels(cr, componentServer)},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}},
+		},
+	}
+	r, c := newPipelineTest(t, cr, pvc)
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	assertStorageCondition(t, c, types.NamespacedName{Namespace: "default", Name: "web"}, metav1.ConditionTrue, "Synced")
+	return cr, r, c
+}
+
+func setStorage(t *testing.T, c *countingClient, mutate func(s *demoappv2.StorageSpec)) {
+	t.Helper()
+	d := &demoappv2.Demo{}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, d); err != nil {
+		t.Fatal(err)
+	}
+	mutate(d.Spec.Storage)
+	if err := c.Update(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertStorageCondition(t *testing.T, c *countingClient, key types.NamespacedName, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	d := &demoappv2.Demo{}
+	if err := c.Get(context.Background(), key, d); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(d.Status.Conditions, demoappv2.ConditionStorageSynced)
+	if cond == nil || cond.Status != status || cond.Reason != reason {
+		t.Errorf("got condition %+v, want %s %s", cond, status, reason)
+	}
+}
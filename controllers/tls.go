@@ -0,0 +1,390 @@
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"reflect"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// spec.tls.port가 비어 있을 때 Service가 HTTPS에 사용하는 포트
+	defaultTLSPort = 443
+	// nginx가 HTTPS 요청을 받는 포트 (Restricted와 Unrestricted)
+	restrictedNginxTLSPort = 8443
+	defaultNginxTLSPort    = 443
+
+	tlsVolume    = "tls"
+	tlsMountPath = "/etc/nginx/tls"
+
+	// CA 인증서와 키의 key. ca.key는 pod가 마운트하는 서버 인증서 Secret이 아니라 CA Secret에만 둡니다.
+	caCertKey = "ca.crt"
+	caKeyKey  = "ca.key"
+
+	// pod template에 기록하는 서버 인증서 만료 시각. 인증서가 바뀌면 nginx가 새 인증서를 읽도록 pod를 다시 배포합니다.
+	certificateExpiryAnnotation = "demoapp.my.domain/certificate-expiry"
+
+	// 사용자가 관리하는 Secret은 감시하지 않으므로 이 주기로 인증서가 바뀌었는지 확인합니다.
+	tlsSecretCheckInterval = time.Hour
+)
+
+// 이벤트 reason
+const (
+	reasonCertificateIssued         = "CertificateIssued"
+	reasonCertificateRotationFailed = "CertificateRotationFailed"
+)
+
+// 서버 인증서 Secret 이름. spec.tls.secretName이 없으면 operator가 만드는 Secret입니다.
+func (r *DemoReconciler) tlsSecretName(d *demoappv2.Demo) string {
+	if d.Spec.TLS != nil && d.Spec.TLS.SecretName != "" {
+		return d.Spec.TLS.SecretName
+	}
+	return r.managedTLSSecretName(d)
+}
+
+func (r *DemoReconciler) managedTLSSecretName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "-tls", maxNameLength)
+}
+
+// 서버 인증서를 발급하는 CA의 Secret. pod에는 마운트하지 않습니다.
+func (r *DemoReconciler) managedCASecretName(d *demoappv2.Demo) string {
+	return childName(r.baseName(d), "-tls-ca", maxNameLength)
+}
+
+// Service가 HTTPS에 사용하는 포트
+func tlsPort(d *demoappv2.Demo) int32 {
+	if d.Spec.TLS == nil || d.Spec.TLS.Port == 0 {
+		return defaultTLSPort
+	}
+	return d.Spec.TLS.Port
+}
+
+// nginx가 HTTPS 요청을 받는 포트
+func nginxTLSPort(d *demoappv2.Demo) int32 {
+	if restricted(d) {
+		return restrictedNginxTLSPort
+	}
+	return defaultNginxTLSPort
+}
+
+// 서버 인증서에 넣는 Service DNS 이름
+func (r *DemoReconciler) tlsDNSNames(d *demoappv2.Demo) []string {
+	svc := r.serviceName(d)
+	return []string{
+		fmt.Sprintf("%s.%s.svc", svc, d.Namespace),
+		fmt.Sprintf("%s.%s", svc, d.Namespace),
+		svc,
+	}
+}
+
+// spec.tls의 nginx server 설정
+func nginxTLSServer(d *demoappv2.Demo) string {
+	return fmt.Sprintf(`
+server {
+    listen       %d ssl;
+    server_name  localhost;
+
+    ssl_certificate      %s/%s;
+    ssl_certificate_key  %s/%s;
+
+    location / {
+        root   /usr/share/nginx/html;
+        index  index.html index.htm;
+    }
+
+    error_page   500 502 503 504  /50x.html;
+    location = /50x.html {
+        root   /usr/share/nginx/html;
+    }
+}
+`, nginxTLSPort(d), tlsMountPath, corev1.TLSCertKey, tlsMountPath, corev1.TLSPrivateKeyKey)
+}
+
+// Service의 HTTPS 포트
+func tlsServicePorts(d *demoappv2.Demo) []corev1.ServicePort {
+	if d.Spec.TLS == nil {
+		return nil
+	}
+	return []corev1.ServicePort{{
+		Name:       "https",
+		Protocol:   corev1.ProtocolTCP,
+		Port:       tlsPort(d),
+		TargetPort: intstr.FromString("https"),
+	}}
+}
+
+// nginx에 HTTPS 포트와 서버 인증서를 추가합니다. CA 키는 마운트하지 않습니다.
+func (r *DemoReconciler) addTLS(template *corev1.PodTemplateSpec, d *demoappv2.Demo) {
+	if d.Spec.TLS == nil {
+		return
+	}
+	nginx := &template.Spec.Containers[0]
+	nginx.Ports = append(nginx.Ports, corev1.ContainerPort{
+		Name:          "https",
+		ContainerPort: nginxTLSPort(d),
+		Protocol:      corev1.ProtocolTCP,
+	})
+	nginx.VolumeMounts = append(nginx.VolumeMounts, corev1.VolumeMount{
+		Name:      tlsVolume,
+		MountPath: tlsMountPath,
+		ReadOnly:  true,
+	})
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: tlsVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: r.tlsSecretName(d),
+				Items: []corev1.KeyToPath{
+					{Key: corev1.TLSCertKey, Path: corev1.TLSCertKey},
+					{Key: corev1.TLSPrivateKeyKey, Path: corev1.TLSPrivateKeyKey},
+				},
+			},
+		},
+	})
+}
+
+// 서버 인증서 만료 시각을 pod template에 기록하고 template 해시를 다시 계산합니다.
+func addCertificateExpiry(workload client.Object, expiry *metav1.Time) {
+	template := workloadTemplate(workload)
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[certificateExpiryAnnotation] = expiry.UTC().Format(time.RFC3339)
+	setTemplateHash(workload)
+}
+
+// HTTPS 포트가 HTTP 포트와 겹치지 않는지 확인합니다.
+func validateTLS(d *demoappv2.Demo) error {
+	if d.Spec.TLS == nil {
+		return nil
+	}
+	for _, p := range servicePorts(d) {
+		if p.Name != "https" && p.Port == tlsPort(d) {
+			return field.Invalid(field.NewPath("spec", "tls", "port"), tlsPort(d),
+				fmt.Sprintf("already used by the Service port %s", p.Name))
+		}
+	}
+	return nil
+}
+
+// spec.tls의 서버 인증서를 확인합니다. secretName이 없으면 operator가 서버 인증서와 CA Secret을 만들고
+// 만료되기 전에 새로 발급합니다. 필요 없어진 operator의 Secret은 지웁니다.
+// 인증서의 만료 시각은 status와 pod template에, 결과는 TLSReady condition에 기록합니다.
+func (r *DemoReconciler) syncTLS(ctx context.Context, s *reconcileState) error {
+	cr := s.cr
+	managed := cr.Spec.TLS != nil && cr.Spec.TLS.SecretName == ""
+
+	if !managed {
+		if err := r.deleteManagedTLSSecrets(ctx, cr); err != nil {
+			return err
+		}
+	}
+	switch {
+	case cr.Spec.TLS == nil:
+		s.certExpiry = nil
+		s.setTLSCondition(metav1.ConditionFalse, "NotConfigured", "spec.tls is not set")
+		return nil
+	case !managed:
+		return r.observeTLSSecret(ctx, s)
+	}
+
+	secret, changed, err := r.managedSecret(ctx, s, r.managedTLSSecretName(cr), corev1.SecretTypeTLS)
+	if err != nil || secret == nil {
+		return err
+	}
+	caSecret, caChanged, err := r.managedSecret(ctx, s, r.managedCASecretName(cr), corev1.SecretTypeOpaque)
+	if err != nil || caSecret == nil {
+		return err
+	}
+
+	oldData, oldCAData := secret.Data, caSecret.Data
+	cert, issued, err := ensureCertificate(secret, caSecret, r.tlsDNSNames(cr), s.now)
+	// 이전 버전이 서버 인증서 Secret에 둔 ca.key를 잃지 않도록 CA Secret을 먼저 씁니다.
+	if err == nil {
+		err = r.writeSecret(ctx, caSecret, caChanged, oldCAData)
+	}
+	if err == nil {
+		err = r.writeSecret(ctx, secret, changed, oldData)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Failed to issue the certificate in Secret %s: %v", secret.Name, err)
+		r.event(cr, corev1.EventTypeWarning, reasonCertificateRotationFailed, msg)
+		s.setTLSCondition(metav1.ConditionFalse, "CertificateFailed", msg)
+		return fmt.Errorf("issuing certificate: %w", err)
+	}
+	if issued {
+		log.FromContext(ctx).Info("Certificate issued", append(objectKeys("Secret", secret.Namespace, secret.Name), "notAfter", cert.NotAfter)...)
+		r.event(cr, corev1.EventTypeNormal, reasonCertificateIssued,
+			fmt.Sprintf("Issued a certificate in Secret %s valid until %s", secret.Name, cert.NotAfter.UTC().Format(time.RFC3339)))
+	}
+
+	s.certExpiry = &metav1.Time{Time: cert.NotAfter}
+	s.certRecheck = renewalTime(cert)
+	s.setTLSCondition(metav1.ConditionTrue, "CertificateValid", certificateMessage(secret.Name, cert))
+	return nil
+}
+
+// managedSecret은 operator가 만드는 Secret을 읽습니다. 없으면 Demo가 controller인 새 Secret을 반환하고,
+// Demo가 쓸 수 없는 Secret이면 claim이 conflict로 기록한 뒤 nil을 반환합니다.
+// metadata를 맞춰 써야 하면 changed가 true입니다.
+func (r *DemoReconciler) managedSecret(ctx context.Context, s *reconcileState, name string, secretType corev1.SecretType) (secret *corev1.Secret, changed bool, err error) {
+	desired := &corev1.Secret{
+		ObjectMeta: objectMeta(s.cr, name, componentServer),
+		Type:       secretType,
+	}
+	if err := ctrl.SetControllerReference(s.cr, desired, r.Scheme); err != nil {
+		return nil, false, err
+	}
+	secret = &corev1.Secret{}
+	err = r.getSecret(ctx, client.ObjectKeyFromObject(desired), secret)
+	if errors.IsNotFound(err) {
+		return desired, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if owned, err := r.claim(ctx, s, secret); err != nil || !owned {
+		return nil, false, err
+	}
+	// cache는 operator의 label이 있는 Secret만 감시하므로 adoption 한 Secret에도 label을 붙입니다.
+	return secret, mergeMetadata(secret, desired), nil
+}
+
+// writeSecret은 새 Secret을 만들거나, metadata나 Data가 바뀐 Secret을 씁니다.
+func (r *DemoReconciler) writeSecret(ctx context.Context, secret *corev1.Secret, changed bool, oldData map[string][]byte) error {
+	if secret.ResourceVersion == "" {
+		return r.Client.Create(ctx, secret)
+	}
+	if !changed && reflect.DeepEqual(secret.Data, oldData) {
+		return nil
+	}
+	return r.Client.Update(ctx, secret)
+}
+
+// getSecret은 cache에서 Secret을 읽고, 없으면 APIReader로 다시 읽습니다.
+// cache에는 operator가 만든 Secret만 있으므로 (NewCache) 사용자의 Secret과 adoption 할 Secret은 API 서버에서 읽습니다.
+func (r *DemoReconciler) getSecret(ctx context.Context, key client.ObjectKey, secret *corev1.Secret) error {
+	err := r.Client.Get(ctx, key, secret)
+	if errors.IsNotFound(err) && r.APIReader != nil {
+		return r.APIReader.Get(ctx, key, secret)
+	}
+	return err
+}
+
+// 사용자가 지정한 Secret의 인증서 만료 시각을 읽습니다.
+func (r *DemoReconciler) observeTLSSecret(ctx context.Context, s *reconcileState) error {
+	cr := s.cr
+	secret := &corev1.Secret{}
+	err := r.getSecret(ctx, client.ObjectKey{Namespace: cr.Namespace, Name: cr.Spec.TLS.SecretName}, secret)
+	if err != nil {
+		err = fmt.Errorf("reading TLS Secret %s: %w", cr.Spec.TLS.SecretName, err)
+		s.setTLSCondition(metav1.ConditionFalse, "SecretInvalid", err.Error())
+		return err
+	}
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		err = fmt.Errorf("reading %s of TLS Secret %s: %w", corev1.TLSCertKey, secret.Name, err)
+		s.setTLSCondition(metav1.ConditionFalse, "SecretInvalid", err.Error())
+		return err
+	}
+	s.certExpiry = &metav1.Time{Time: cert.NotAfter}
+	s.certRecheck = s.now.Add(tlsSecretCheckInterval)
+	s.setTLSCondition(metav1.ConditionTrue, "CertificateValid", certificateMessage(secret.Name, cert))
+	return nil
+}
+
+// spec.tls를 지우거나 secretName을 지정하면 operator가 만든 서버 인증서와 CA Secret을 지웁니다.
+func (r *DemoReconciler) deleteManagedTLSSecrets(ctx context.Context, cr *demoappv2.Demo) error {
+	for _, name := range []string{r.managedTLSSecretName(cr), r.managedCASecretName(cr)} {
+		secret := &corev1.Secret{}
+		err := r.Client.Get(ctx, client.ObjectKey{Namespace: cr.Namespace, Name: name}, secret)
+		if errors.IsNotFound(err) || (err == nil && !metav1.IsControlledBy(secret, cr)) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.Client.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.FromContext(ctx).Info("TLS Secret deleted", objectKeys("Secret", secret.Namespace, secret.Name)...)
+	}
+	return nil
+}
+
+// TLSReady condition의 인증서 메시지
+func certificateMessage(secretName string, cert *x509.Certificate) string {
+	return fmt.Sprintf("Serving certificate in Secret %s is valid until %s", secretName, cert.NotAfter.UTC().Format(time.RFC3339))
+}
+
+func (s *reconcileState) setTLSCondition(status metav1.ConditionStatus, reason, message string) {
+	s.setCondition(metav1.Condition{
+		Type:    demoappv2.ConditionTLSReady,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// CA와 서버 인증서를 확인하고, 없거나 새로 발급할 때가 되었으면 발급합니다.
+// CA는 caSecret.Data에, 서버 인증서와 키, 그리고 client가 쓸 ca.crt는 secret.Data에 둡니다.
+// 서버 인증서와 새로 발급했는지를 반환합니다.
+func ensureCertificate(secret, caSecret *corev1.Secret, dnsNames []string, now time.Time) (*x509.Certificate, bool, error) {
+	// 이전 버전은 CA 키를 서버 인증서 Secret에 두었으므로, CA Secret이 비어 있으면 그 CA를 옮겨 씁니다.
+	ca := keyPair{cert: caSecret.Data[caCertKey], key: caSecret.Data[caKeyKey]}
+	if len(ca.key) == 0 {
+		ca = keyPair{cert: secret.Data[caCertKey], key: secret.Data[caKeyKey]}
+	}
+	caCert, err := parseCertificate(ca.cert)
+	caValid := err == nil && now.Before(renewalTime(caCert))
+	if caValid {
+		_, err = parsePrivateKey(ca.key)
+		caValid = err == nil
+	}
+
+	serving := keyPair{cert: secret.Data[corev1.TLSCertKey], key: secret.Data[corev1.TLSPrivateKeyKey]}
+	var cert *x509.Certificate
+	if caValid {
+		cert, err = parseCertificate(serving.cert)
+		if err != nil || !now.Before(renewalTime(cert)) || !certificateMatches(cert, caCert, dnsNames) {
+			cert = nil
+		}
+	} else {
+		// CA를 바꾸면 client가 새 ca.crt를 받아야 합니다.
+		if ca, err = newCA(dnsNames[0]+" CA", now); err != nil {
+			return nil, false, err
+		}
+	}
+
+	issued := cert == nil
+	if issued {
+		if serving, err = newServingCertificate(ca, dnsNames, now); err != nil {
+			return nil, false, err
+		}
+		if cert, err = parseCertificate(serving.cert); err != nil {
+			return nil, false, err
+		}
+	}
+	caSecret.Data = map[string][]byte{
+		caCertKey: ca.cert,
+		caKeyKey:  ca.key,
+	}
+	secret.Data = map[string][]byte{
+		corev1.TLSCertKey:       serving.cert,
+		corev1.TLSPrivateKeyKey: serving.key,
+		caCertKey:               ca.cert,
+	}
+	return cert, issued, nil
+}
@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEnsureCertificate(t *testing.T) {
+	now := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	names := []string{"web.default.svc", "web.default", "web"}
+	secret, caSecret := &corev1.Secret{}, &corev1.Secret{}
+
+	cert, issued, err := ensureCertificate(secret, caSecret, names, now)
+	if err != nil || !issued {
+		t.Fatalf("got issued %v, error %v", issued, err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(secret.Data[caCertKey])
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "web.default.svc", Roots: roots, CurrentTime: now}); err != nil {
+		t.Errorf("certificate does not verify with ca.crt: %v", err)
+	}
+	// CA 키는 pod가 마운트하는 Secret에 두지 않습니다.
+	if _, ok := secret.Data[caKeyKey]; ok {
+		t.Error("got ca.key in the serving certificate Secret")
+	}
+	if len(caSecret.Data[caKeyKey]) == 0 || string(caSecret.Data[caCertKey]) != string(secret.Data[caCertKey]) {
+		t.Error("expected the CA in the CA Secret")
+	}
+	ca := string(secret.Data[caCertKey])
+
+	// 유효 기간의 2/3가 지나기 전에는 다시 발급하지 않습니다.
+	if _, issued, _ := ensureCertificate(secret, caSecret, names, now.Add(59*24*time.Hour)); issued {
+		t.Error("expected the certificate to be kept")
+	}
+
+	// 새로 발급해도 CA는 유지되어 client가 ca.crt를 바꾸지 않아도 됩니다.
+	renewed, issued, err := ensureCertificate(secret, caSecret, names, now.Add(61*24*time.Hour))
+	if err != nil || !issued {
+		t.Fatalf("got issued %v, error %v, want a renewed certificate", issued, err)
+	}
+	if !renewed.NotAfter.After(cert.NotAfter) || string(secret.Data[caCertKey]) != ca {
+		t.Errorf("got expiry %s and CA changed %v, want a later expiry with the same CA", renewed.NotAfter, string(secret.Data[caCertKey]) != ca)
+	}
+
+	// Service 이름이 바뀌면 다시 발급합니다.
+	if _, issued, _ := ensureCertificate(secret, caSecret, []string{"site.default.svc"}, now.Add(62*24*time.Hour)); !issued {
+		t.Error("expected a certificate for the new DNS names")
+	}
+}
+
+// 이전 버전이 서버 인증서 Secret에 둔 CA는 CA Secret으로 옮기고, 서버 인증서는 그대로 둡니다.
+func TestEnsureCertificateMovesCAKey(t *testing.T) {
+	now := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	names := []string{"web.default.svc"}
+	secret, caSecret := &corev1.Secret{}, &corev1.Secret{}
+	if _, _, err := ensureCertificate(secret, caSecret, names, now); err != nil {
+		t.Fatal(err)
+	}
+	secret.Data[caKeyKey] = caSecret.Data[caKeyKey]
+	serving := string(secret.Data[corev1.TLSCertKey])
+
+	moved := &corev1.Secret{}
+	if _, issued, err := ensureCertificate(secret, moved, names, now.Add(time.Hour)); err != nil || issued {
+		t.Fatalf("got issued %v, error %v, want the certificate kept", issued, err)
+	}
+	if string(moved.Data[caKeyKey]) != string(caSecret.Data[caKeyKey]) {
+		t.Error("expected the CA key moved to the CA Secret")
+	}
+	if _, ok := secret.Data[caKeyKey]; ok || string(secret.Data[corev1.TLSCertKey]) != serving {
+		t.Error("expected ca.key removed and the serving certificate kept")
+	}
+}
+
+func TestSyncTLS(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			TLS:     &demoappv2.TLSSpec{},
+		},
+	}
+	r, c := newPipelineTest(t, cr)
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "web"}
+	secretKey := types.NamespacedName{Namespace: "default", Name: "web-tls"}
+	caSecretKey := types.NamespacedName{Namespace: "default", Name: "web-tls-ca"}
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, secretKey, secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != corev1.SecretTypeTLS || !metav1.IsControlledBy(secret, cr) {
+		t.Errorf("got Secret type %s, controller %v", secret.Type, metav1.GetControllerOf(secret))
+	}
+	if _, ok := secret.Data[caKeyKey]; ok {
+		t.Error("got ca.key in the Secret mounted by the pods")
+	}
+	caSecret := &corev1.Secret{}
+	if err := c.Get(ctx, caSecretKey, caSecret); err != nil {
+		t.Fatal(err)
+	}
+	if len(caSecret.Data[caKeyKey]) == 0 || !metav1.IsControlledBy(caSecret, cr) || caSecret.Labels[labelManagedBy] != managedBy {
+		t.Errorf("got CA Secret labels %v, controller %v, want the CA key", caSecret.Labels, metav1.GetControllerOf(caSecret))
+	}
+
+	got := &demoappv2.Demo{}
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.CertificateExpiry == nil {
+		t.Fatal("status.certificateExpiry was not written")
+	}
+	if cond := meta.FindStatusCondition(got.Status.Conditions, demoappv2.ConditionTLSReady); cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Errorf("got condition %+v, want TLSReady", cond)
+	}
+
+	dep := &appsv1.Deployment{}
+	if err := c.Get(ctx, key, dep); err != nil {
+		t.Fatal(err)
+	}
+	template := dep.Spec.Template
+	if template.Annotations[certificateExpiryAnnotation] != got.Status.CertificateExpiry.UTC().Format(time.RFC3339) {
+		t.Errorf("got annotations %v, want the certificate expiry", template.Annotations)
+	}
+	nginx := template.Spec.Containers[0]
+	if len(nginx.Ports) != 2 || nginx.Ports[1].Name != "https" || nginx.Ports[1].ContainerPort != restrictedNginxTLSPort {
+		t.Errorf("unexpected nginx ports %+v", nginx.Ports)
+	}
+	for _, v := range template.Spec.Volumes {
+		if v.Name == tlsVolume && len(v.Secret.Items) != 2 {
+			t.Errorf("got TLS volume items %+v, want only the serving certificate and key", v.Secret.Items)
+		}
+	}
+
+	svc := &corev1.Service{}
+	if err := c.Get(ctx, key, svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.Spec.Ports) != 2 || svc.Spec.Ports[1].Port != defaultTLSPort {
+		t.Errorf("unexpected Service ports %+v", svc.Spec.Ports)
+	}
+
+	conf := &corev1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "default", Name: r.nginxConfigName(cr)}, conf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(conf.Data["default.conf"], "listen       8443 ssl;") {
+		t.Errorf("expected an HTTPS server in %s", conf.Data["default.conf"])
+	}
+
+	c.reset()
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if c.writes != 0 {
+		t.Errorf("got %d writes after convergence, want none", c.writes)
+	}
+
+	// spec.tls를 지우면 operator가 만든 Secret도 지웁니다.
+	got.Spec.TLS = nil
+	if err := c.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []types.NamespacedName{secretKey, caSecretKey} {
+		if err := c.Get(ctx, key, &corev1.Secret{}); !errors.IsNotFound(err) {
+			t.Errorf("got %v, want Secret %s deleted", err, key.Name)
+		}
+	}
+	got = &demoappv2.Demo{}
+	if err := c.Get(ctx, key, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.CertificateExpiry != nil {
+		t.Errorf("got certificate expiry %s without spec.tls", got.Status.CertificateExpiry)
+	}
+}
+
+func TestSyncTLSRotationFailed(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			TLS:     &demoappv2.TLSSpec{},
+		},
+	}
+	r, c := newPipelineTest(t, cr)
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+	c.failCreate = "Secret"
+
+	if err := reconcileDemo(t, r, "web"); err == nil {
+		t.Fatal("expected the reconcile to fail")
+	}
+	if events := drain(recorder); !strings.Contains(events, "Warning "+reasonCertificateRotationFailed) {
+		t.Errorf("got events %q, want %s", events, reasonCertificateRotationFailed)
+	}
+}
+
+// 사용자의 TLS Secret은 operator의 label이 없어 cache에 없으므로 APIReader로 읽습니다.
+func TestSyncTLSReadsUserSecretThroughAPIReader(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec: demoappv2.DemoSpec{
+			Scaling: demoappv2.ScalingSpec{Replicas: 1},
+			TLS:     &demoappv2.TLSSpec{SecretName: "site-tls"},
+		},
+	}
+	userSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "site-tls", Namespace: "default"}}
+	if _, _, err := ensureCertificate(userSecret, &corev1.Secret{}, []string{"web.default.svc"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := newPipelineTest(t, cr)
+	reader, _ := newPipelineTest(t, userSecret)
+	r.APIReader = reader.Client
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	got := &demoappv2.Demo{}
+	if err := r.Client.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.CertificateExpiry == nil {
+		t.Error("status.certificateExpiry was not written from the user Secret")
+	}
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	demoappv2 "demo-operator/api/v2"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+const tracerName = "demo-operator/controllers"
+
+// span attribute. reconcile과 step span에는 Demo를, API 요청 span에는 요청한 객체를 기록합니다.
+const (
+	demoNamespaceKey  = attribute.Key("demo.namespace")
+	demoNameKey       = attribute.Key("demo.name")
+	demoGenerationKey = attribute.Key("demo.generation")
+	reconcileIDKey    = attribute.Key("reconcile.id")
+	kindKey           = attribute.Key("k8s.kind")
+	namespaceKey      = attribute.Key("k8s.namespace")
+	nameKey           = attribute.Key("k8s.name")
+)
+
+// NewTracerProvider는 span을 OTLP/HTTP로 endpoint (host:port)에 보내는 TracerProvider와,
+// 종료할 때 남은 span을 보내는 shutdown을 만듭니다. insecure이면 TLS 없이 보냅니다.
+// endpoint가 비어 있으면 tracing을 끄고 nil TracerProvider를 돌려줍니다.
+// DemoReconciler.TracerProvider에 nil *sdktrace.TracerProvider가 들어가지 않도록 interface로 돌려줍니다.
+func NewTracerProvider(ctx context.Context, endpoint string, insecure bool) (trace.TracerProvider, func(context.Context) error, error) {
+	if endpoint == "" {
+		return nil, func(context.Context) error { return nil }, nil
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("demo-operator"))),
+	)
+	return tp, tp.Shutdown, nil
+}
+
+// tracer는 TracerProvider가 없으면 아무것도 남기지 않는 tracer입니다.
+func (r *DemoReconciler) tracer() trace.Tracer {
+	if r.TracerProvider == nil {
+		return trace.NewNoopTracerProvider().Tracer(tracerName)
+	}
+	return r.TracerProvider.Tracer(tracerName)
+}
+
+func demoAttributes(d *demoappv2.Demo) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		demoNamespaceKey.String(d.Namespace),
+		demoNameKey.String(d.Name),
+		demoGenerationKey.Int64(d.Generation),
+	}
+}
+
+// endSpan은 err를 span에 기록하고 끝냅니다.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// NewTracingClient는 API 요청마다 span을 남기는 client를 만듭니다.
+// reconcile 중이면 span은 그 reconcile의 trace에 들어갑니다.
+func NewTracingClient(c client.Client, tp trace.TracerProvider) client.Client {
+	return &tracingClient{Client: c, tracer: tp.Tracer(tracerName)}
+}
+
+type tracingClient struct {
+	client.Client
+	tracer trace.Tracer
+}
+
+// start는 "Get Deployment"처럼 요청과 kind로 이름 붙인 span을 시작합니다.
+func (c *tracingClient) start(ctx context.Context, verb string, obj client.Object, key client.ObjectKey) (context.Context, trace.Span) {
+	var kind string
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	return c.tracer.Start(ctx, verb+" "+kind, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		kindKey.String(kind), namespaceKey.String(key.Namespace), nameKey.String(key.Name)))
+}
+
+func (c *tracingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) (err error) {
+	ctx, span := c.start(ctx, "Get", obj, key)
+	defer func() { endSpan(span, err) }()
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *tracingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) (err error) {
+	listOpts := (&client.ListOptions{}).ApplyOptions(opts)
+	var kind string
+	if gvk, err := apiutil.GVKForObject(list, c.Scheme()); err == nil {
+		kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	ctx, span := c.tracer.Start(ctx, "List "+kind, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		kindKey.String(kind), namespaceKey.String(listOpts.Namespace)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(attribute.Int("k8s.items", meta.LenList(list)))
+		}
+		endSpan(span, err)
+	}()
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *tracingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) (err error) {
+	ctx, span := c.start(ctx, "Create", obj, client.ObjectKeyFromObject(obj))
+	defer func() { endSpan(span, err) }()
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *tracingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := c.start(ctx, "Update", obj, client.ObjectKeyFromObject(obj))
+	defer func() { endSpan(span, err) }()
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *tracingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := c.start(ctx, "Patch", obj, client.ObjectKeyFromObject(obj))
+	defer func() { endSpan(span, err) }()
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *tracingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) (err error) {
+	ctx, span := c.start(ctx, "Delete", obj, client.ObjectKeyFromObject(obj))
+	defer func() { endSpan(span, err) }()
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *tracingClient) Status() client.StatusWriter {
+	return &tracingStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type tracingStatusWriter struct {
+	client.StatusWriter
+	client *tracingClient
+}
+
+func (w *tracingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) (err error) {
+	ctx, span := w.client.start(ctx, "UpdateStatus", obj, client.ObjectKeyFromObject(obj))
+	defer func() { endSpan(span, err) }()
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *tracingStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) (err error) {
+	ctx, span := w.client.start(ctx, "PatchStatus", obj, client.ObjectKeyFromObject(obj))
+	defer func() { endSpan(span, err) }()
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// reconcile 한 번은 Reconcile span 아래에 step과 API 요청 span을 가진 trace 하나가 됩니다.
+func TestReconcileTrace(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid", Generation: 3},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+	}
+	r, c := newPipelineTest(t, cr)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	r.Client = NewTracingClient(c, tp)
+	r.TracerProvider = tp
+
+	c.failCreate = "ConfigMap"
+	if err := reconcileDemo(t, r, "web"); err == nil {
+		t.Fatal("expected the configmaps step to fail")
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		if _, ok := spans[span.Name]; !ok {
+			spans[span.Name] = span
+		}
+	}
+	root, ok := spans["Reconcile"]
+	if !ok {
+		t.Fatalf("no Reconcile span in %v", exporter.GetSpans())
+	}
+	attrs := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		m := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+	if got := attrs(root); got[demoNameKey].AsString() != "web" || got[demoNamespaceKey].AsString() != "default" ||
+		got[demoGenerationKey].AsInt64() != 3 {
+		t.Errorf("got Reconcile attributes %v, want the Demo namespace, name and generation", root.Attributes)
+	}
+	if root.Status.Code != codes.Error {
+		t.Errorf("got Reconcile status %v, want the step error", root.Status)
+	}
+
+	// span 이름과 부모 span
+	for name, parent := range map[string]string{
+		"Get Demo":          "Reconcile",
+		"service":           "Reconcile",
+		"Get Service":       "service",
+		"Create Service":    "service",
+		"configmaps":        "Reconcile",
+		"workload":          "Reconcile",
+		"Create Deployment": "workload",
+		"status":            "Reconcile",
+		"List Pod":          "status",
+		"UpdateStatus Demo": "status",
+	} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %q span", name)
+			continue
+		}
+		if span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("span %q is not in the reconcile trace", name)
+		}
+		if got := spans[parent].SpanContext.SpanID(); span.Parent.SpanID() != got {
+			t.Errorf("span %q is not a child of %q", name, parent)
+		}
+	}
+	if got := attrs(spans["workload"]); got[demoGenerationKey].AsInt64() != 3 {
+		t.Errorf("got workload step attributes %v, want the Demo generation", spans["workload"].Attributes)
+	}
+	if spans["configmaps"].Status.Code != codes.Error {
+		t.Errorf("got configmaps step status %v, want the Create error", spans["configmaps"].Status)
+	}
+}
+
+// tracing을 끄면 main.go처럼 만든 reconciler는 span 없이 reconcile합니다.
+func TestReconcileWithoutTracing(t *testing.T) {
+	cr := &demoappv2.Demo{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+		Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+	}
+	r, c := newPipelineTest(t, cr)
+	tp, shutdown, err := NewTracerProvider(context.Background(), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp != nil {
+		t.Fatalf("got TracerProvider %#v without an endpoint, want nil", tp)
+	}
+	r.Client = c
+	r.TracerProvider = tp
+
+	if err := reconcileDemo(t, r, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	demoappv2 "demo-operator/api/v2"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// specCheck은 객체를 만들기 전에 확인하는 spec 검사 하나입니다.
+// 실패하면 reason으로 Valid condition을 False로 기록합니다.
+type specCheck struct {
+	reason   string
+	validate func(d *demoappv2.Demo) error
+}
+
+// Reconcile과 Render가 같은 순서로 확인하는 spec 검사
+func (r *DemoReconciler) specChecks() []specCheck {
+	return []specCheck{
+		// 사용자 컨테이너 이름이 겹치면 pod를 만들 수 없으므로 먼저 확인합니다.
+		{reason: "InvalidContainers", validate: validateContainers},
+		{reason: "InvalidContent", validate: validateContent},
+		{reason: "InvalidSecurity", validate: validateSecurity},
+		{reason: "InvalidTLS", validate: validateTLS},
+		{reason: "InvalidMetadata", validate: validateMetadata},
+		{reason: "UnknownResourceProfile", validate: r.validateResourceProfile},
+	}
+}
+
+// validateSpec은 처음 실패한 검사의 reason과 에러를 반환합니다.
+func (r *DemoReconciler) validateSpec(d *demoappv2.Demo) (string, error) {
+	for _, check := range r.specChecks() {
+		if err := check.validate(d); err != nil {
+			return check.reason, err
+		}
+	}
+	return "", nil
+}
+
+// 잘못된 spec을 Valid condition에 기록합니다.
+func (s *reconcileState) setInvalidCondition(reason, message string) {
+	s.setCondition(metav1.Condition{
+		Type:    demoappv2.ConditionValid,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// spec을 적용할 수 있으면 이전에 기록한 Valid=False를 되돌립니다.
+// 한 번도 잘못된 적 없는 Demo에는 condition을 추가하지 않습니다.
+func (s *reconcileState) setValidCondition() {
+	if meta.FindStatusCondition(s.cr.Status.Conditions, demoappv2.ConditionValid) == nil {
+		return
+	}
+	s.setCondition(metav1.Condition{
+		Type:    demoappv2.ConditionValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Valid",
+		Message: "The spec is applied",
+	})
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	demoappv2 "demo-operator/api/v2"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+// 잘못된 spec은 Valid condition으로 알리고 다시 시도하지 않습니다. spec을 고치면 condition이 True가 됩니다.
+func TestReconcileInvalidSpec(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   func(d *demoappv2.Demo)
+		fix    func(d *demoappv2.Demo)
+		reason string
+	}{
+		{
+			name:   "tls port",
+			spec:   func(d *demoappv2.Demo) { d.Spec.TLS = &demoappv2.TLSSpec{Port: 80} },
+			fix:    func(d *demoappv2.Demo) { d.Spec.TLS.Port = 0 },
+			reason: "InvalidTLS",
+		},
+		{
+			name: "container name",
+			spec: func(d *demoappv2.Demo) {
+				d.Spec.Pod.Containers = []corev1.Container{{Name: "nginx", Image: "busybox"}}
+			},
+			fix:    func(d *demoappv2.Demo) { d.Spec.Pod.Containers[0].Name = "helper" },
+			reason: "InvalidContainers",
+		},
+		{
+			name: "privileged",
+			spec: func(d *demoappv2.Demo) {
+				d.Spec.Pod.SecurityContext = &corev1.SecurityContext{Privileged: pointer.Bool(true)}
+			},
+			fix:    func(d *demoappv2.Demo) { d.Spec.Pod.SecurityContext = nil },
+			reason: "InvalidSecurity",
+		},
+		{
+			name:   "resource profile",
+			spec:   func(d *demoappv2.Demo) { d.Spec.Pod.ResourceProfile = "huge" },
+			fix:    func(d *demoappv2.Demo) { d.Spec.Pod.ResourceProfile = "" },
+			reason: "UnknownResourceProfile",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &demoappv2.Demo{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "demo-uid"},
+				Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+			}
+			tt.spec(cr)
+			r, c := newPipelineTest(t, cr)
+			ctx := context.Background()
+			key := types.NamespacedName{Namespace: "default", Name: "web"}
+
+			result, err := r.Reconcile(ctx, reconcileRequest("web"))
+			if err != nil || result.Requeue || result.RequeueAfter != 0 {
+				t.Fatalf("got result %+v, error %v, want no retry", result, err)
+			}
+			got := &demoappv2.Demo{}
+			if err := c.Get(ctx, key, got); err != nil {
+				t.Fatal(err)
+			}
+			cond := meta.FindStatusCondition(got.Status.Conditions, demoappv2.ConditionValid)
+			if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != tt.reason {
+				t.Errorf("got condition %+v, want Valid False %s", cond, tt.reason)
+			}
+			if err := c.Get(ctx, key, &appsv1.Deployment{}); !apierrors.IsNotFound(err) {
+				t.Errorf("got %v, want no Deployment for an invalid spec", err)
+			}
+
+			tt.fix(got)
+			if err := c.Update(ctx, got); err != nil {
+				t.Fatal(err)
+			}
+			if err := reconcileDemo(t, r, "web"); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Get(ctx, key, got); err != nil {
+				t.Fatal(err)
+			}
+			if cond := meta.FindStatusCondition(got.Status.Conditions, demoappv2.ConditionValid); cond == nil || cond.Status != metav1.ConditionTrue {
+				t.Errorf("got condition %+v after fixing the spec, want Valid True", cond)
+			}
+		})
+	}
+}
@@ -17,88 +17,365 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
+	// 스케줄의 time zone을 tzdata가 없는 distroless 이미지에서도 해석할 수 있도록 포함합니다.
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"demo-operator/activator"
+	configv1alpha1 "demo-operator/api/config/v1alpha1"
 	demoappv1 "demo-operator/api/v1"
+	demoappv2 "demo-operator/api/v2"
 	"demo-operator/controllers"
+	"demo-operator/render"
+	"demo-operator/scope"
 	//+kubebuilder:scaffold:imports
 )
 
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
+
+	// config/manager/manager.yaml의 operator pod label. Demo의 NetworkPolicy가 이 label로 액티베이터를 허용합니다.
+	operatorPodLabels = map[string]string{"control-plane": "controller-manager"}
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(demoappv1.AddToScheme(scheme))
+	utilruntime.Must(demoappv2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	// manager render는 클러스터 없이 Demo가 만들 객체를 출력합니다.
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(render.Main(os.Args[2:], scheme, os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	var activatorAddr string
+	var activatorTimeout time.Duration
+	var migrateStorageVersion bool
+	var watchNamespaces string
+	var namespaceSelector string
+	var defaultImage string
+	var maxConcurrentReconciles int
+	var dryRun bool
+	var dryRunPlanAnnotation bool
+	var tracingEndpoint string
+	var tracingInsecure bool
+	flag.StringVar(&configFile, "config", "",
+		"The DemoOperatorConfig file to load. Flags set on the command line override its values.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", configv1alpha1.DefaultMetricsBindAddress, "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", configv1alpha1.DefaultHealthProbeBindAddress, "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
-	opts := zap.Options{
-		Development: true,
-	}
+	flag.StringVar(&activatorAddr, "activator-bind-address", configv1alpha1.DefaultActivatorBindAddress,
+		"The address the activator for idle Demos binds to. Requires the POD_IP environment variable.")
+	flag.DurationVar(&activatorTimeout, "activator-timeout", configv1alpha1.DefaultActivatorTimeout,
+		"How long the activator holds a request while an idle Demo wakes up.")
+	flag.BoolVar(&migrateStorageVersion, "migrate-storage-version", true,
+		"Rewrite Demos stored in an older API version to the storage version at startup. "+
+			"Same as the StorageVersionMigration feature gate.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma-separated namespaces to watch. All namespaces are watched when empty.")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "",
+		"Label selector of the namespaces to watch, e.g. demo-operator=enabled. "+
+			"The operator restarts its caches and controllers when the matching namespaces change.")
+	flag.StringVar(&defaultImage, "default-image", configv1alpha1.DefaultImage,
+		"The nginx image of Demos that do not set spec.image.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of Demos reconciled in parallel.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Log the changes the operator would make to each Demo instead of writing them. "+
+			"Use it to preview a new version side by side with the running operator.")
+	flag.BoolVar(&dryRunPlanAnnotation, "dry-run-plan-annotation", false,
+		"Write the planned changes of each Demo to its "+demoappv2.PlanAnnotation+" annotation. Requires --dry-run.")
+	flag.StringVar(&tracingEndpoint, "tracing-endpoint", "",
+		"The OTLP/HTTP collector (host:port) receiving a trace of each reconcile. Tracing is disabled when empty.")
+	flag.BoolVar(&tracingInsecure, "tracing-insecure", false, "Send traces over plain HTTP instead of HTTPS.")
+	// 기본은 JSON log입니다. 개발할 때는 --zap-devel로 사람이 읽기 쉬운 log를 씁니다.
+	opts := zap.Options{}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "a3788769.my.domain",
+	// 설정 파일을 읽고, 명령줄에서 직접 지정한 flag로 덮어씁니다.
+	operatorConfig, err := configv1alpha1.Load(configFile)
+	if err != nil {
+		setupLog.Error(err, "unable to load the config file", "file", configFile)
+		os.Exit(1)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "metrics-bind-address":
+			operatorConfig.Metrics.BindAddress = metricsAddr
+		case "health-probe-bind-address":
+			operatorConfig.Health.HealthProbeBindAddress = probeAddr
+		case "leader-elect":
+			operatorConfig.LeaderElection.LeaderElect = &enableLeaderElection
+		case "activator-bind-address":
+			operatorConfig.Activator.BindAddress = activatorAddr
+		case "activator-timeout":
+			operatorConfig.Activator.Timeout = metav1.Duration{Duration: activatorTimeout}
+		case "migrate-storage-version":
+			if operatorConfig.FeatureGates == nil {
+				operatorConfig.FeatureGates = map[string]bool{}
+			}
+			operatorConfig.FeatureGates[configv1alpha1.StorageVersionMigration] = migrateStorageVersion
+		case "watch-namespaces":
+			operatorConfig.WatchNamespaces = strings.Split(watchNamespaces, ",")
+			operatorConfig.NamespaceSelector = ""
+		case "namespace-selector":
+			operatorConfig.NamespaceSelector = namespaceSelector
+			operatorConfig.WatchNamespaces = nil
+		case "default-image":
+			operatorConfig.DefaultImage = defaultImage
+		case "max-concurrent-reconciles":
+			operatorConfig.MaxConcurrentReconciles = maxConcurrentReconciles
+		case "dry-run":
+			operatorConfig.DryRun.Enabled = dryRun
+		case "dry-run-plan-annotation":
+			operatorConfig.DryRun.PlanAnnotation = dryRunPlanAnnotation
+		case "tracing-endpoint":
+			operatorConfig.Tracing.Endpoint = tracingEndpoint
+		case "tracing-insecure":
+			operatorConfig.Tracing.Insecure = tracingInsecure
+		}
 	})
+	if err := operatorConfig.Validate(); err != nil {
+		setupLog.Error(err, "invalid config", "file", configFile)
+		os.Exit(1)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	cfg := ctrl.GetConfigOrDie()
+
+	// 감시할 namespace 범위를 정하고, 그 범위에 맞는 RBAC가 있는지 먼저 확인합니다.
+	watchScope, err := scope.New(strings.Join(operatorConfig.WatchNamespaces, ","), operatorConfig.NamespaceSelector)
+	if err != nil {
+		setupLog.Error(err, "invalid watch scope")
+		os.Exit(1)
+	}
+	setupClient, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+
+	// tracing을 켜면 reconcile마다 trace를 남깁니다.
+	tracerProvider, shutdownTracing, err := controllers.NewTracerProvider(ctx,
+		operatorConfig.Tracing.Endpoint, operatorConfig.Tracing.Insecure)
 	if err != nil {
-		setupLog.Error(err, "unable to start manager")
+		setupLog.Error(err, "unable to set up tracing", "endpoint", operatorConfig.Tracing.Endpoint)
 		os.Exit(1)
 	}
+	if tracerProvider != nil {
+		setupLog.Info("exporting traces", "endpoint", operatorConfig.Tracing.Endpoint)
+	}
+
+	// selector에 맞는 namespace가 바뀌면 process를 끝내지 않고, 새 namespace 목록으로 manager를 다시 만들어
+	// cache와 controller를 다시 시작합니다.
+	exitCode := 0
+	for {
+		err := startManager(ctx, cfg, operatorConfig, watchScope, setupClient, tracerProvider)
+		if errors.Is(err, scope.ErrNamespacesChanged) {
+			setupLog.Info("restarting the manager", "reason", err.Error())
+			continue
+		}
+		if err != nil {
+			setupLog.Error(err, "problem running manager")
+			exitCode = 1
+		}
+		break
+	}
+
+	// 종료하기 전에 남은 span을 보냅니다.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		setupLog.Error(err, "unable to flush traces")
+	}
+	cancel()
+	os.Exit(exitCode)
+}
+
+// startManager는 watchScope에서 지금 감시할 namespace를 정하고, 그 namespace만 감시하는 manager를
+// ctx가 끝날 때까지 실행합니다. selector에 맞는 namespace가 바뀌면 scope.ErrNamespacesChanged를 반환합니다.
+// 실패해도 process를 끝내지 않고 에러를 반환하므로 main은 남은 span을 보낸 뒤에 종료합니다.
+func startManager(ctx context.Context, cfg *rest.Config, operatorConfig *configv1alpha1.DemoOperatorConfig,
+	watchScope *scope.Scope, setupClient client.Client, tracerProvider trace.TracerProvider) error {
+	namespaces, err := watchScope.Resolve(ctx, setupClient)
+	if err != nil {
+		return fmt.Errorf("unable to resolve watched namespaces of %s: %w", watchScope, err)
+	}
+	if watchScope.Mode() == scope.Selector && len(namespaces) == 0 {
+		return fmt.Errorf("no namespaces match the namespace selector %s", watchScope)
+	}
+	if err := scope.CheckAccess(ctx, setupClient, namespaces, controllers.RequiredAccess); err != nil {
+		return fmt.Errorf("RBAC does not match the watch scope %s (%s). "+
+			"Deploy config/namespaced for --watch-namespaces, config/namespace-selector for --namespace-selector, "+
+			"or the default ClusterRole otherwise: %w", watchScope, watchScope.Mode(), err)
+	}
+	setupLog.Info("watching Demos", "scope", watchScope.String(), "namespaces", namespaces)
+
+	options, err := ctrl.Options{Scheme: scheme}.AndFrom(operatorConfig)
+	if err != nil {
+		return fmt.Errorf("unable to apply the config: %w", err)
+	}
+	scope.ApplyToOptions(&options, namespaces)
+	options.NewCache = controllers.NewCache(options.NewCache)
+	// dry-run operator는 실행 중인 operator와 leader를 나눠 갖지 않습니다.
+	dryRun := operatorConfig.DryRun.Enabled
+	if dryRun {
+		options.LeaderElectionID += "-dry-run"
+	}
+	// namespace가 바뀌어 manager를 다시 만들 때 새 manager가 lease가 끝나기를 기다리지 않도록,
+	// 모든 controller가 멈춘 뒤 lease를 놓습니다.
+	options.LeaderElectionReleaseOnCancel = true
+
+	mgr, err := ctrl.NewManager(cfg, options)
+	if err != nil {
+		return fmt.Errorf("unable to start manager: %w", err)
+	}
+
+	// idle 상태인 Demo로 온 요청을 받는 액티베이터
+	// IdleScaling feature gate가 꺼져 있으면 POD_IP 없이 만들어 idle scale-to-zero를 끕니다.
+	podIP := os.Getenv("POD_IP")
+	if !operatorConfig.Enabled(configv1alpha1.IdleScaling) {
+		podIP = ""
+	}
+	act, err := activator.New(mgr.GetClient(), activator.Options{
+		BindAddress:  operatorConfig.Activator.BindAddress,
+		PodIP:        podIP,
+		PodNamespace: os.Getenv("POD_NAMESPACE"),
+		PodLabels:    operatorPodLabels,
+		Timeout:      operatorConfig.Activator.Timeout.Duration,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create activator: %w", err)
+	}
+	if _, _, ok := act.Address(); !ok {
+		setupLog.Info("POD_IP is not set or the IdleScaling feature gate is off, idle scale-to-zero is disabled")
+	}
+	if err := mgr.Add(act); err != nil {
+		return fmt.Errorf("unable to add activator: %w", err)
+	}
+
+	// 모든 worker의 쓰기 요청을 하나의 token bucket으로 제한합니다.
+	writeClient := controllers.NewWriteLimitedClient(mgr.GetClient(),
+		rate.NewLimiter(rate.Limit(operatorConfig.RateLimits.WriteQPS), operatorConfig.RateLimits.WriteBurst))
+
+	// API 요청의 span에는 쓰기 제한으로 기다린 시간도 들어갑니다.
+	reconcilerClient := writeClient
+	if tracerProvider != nil {
+		reconcilerClient = controllers.NewTracingClient(writeClient, tracerProvider)
+	}
+
+	// dry-run에서는 쓰기를 log로만 남기고, 이벤트도 남기지 않습니다.
+	recorder := mgr.GetEventRecorderFor("demo-controller")
+	var planWriter client.Writer
+	if dryRun {
+		setupLog.Info("running in dry-run mode, changes are logged and not applied")
+		reconcilerClient = controllers.NewDryRunClient(reconcilerClient)
+		recorder = nil
+		if operatorConfig.DryRun.PlanAnnotation {
+			planWriter = writeClient
+		}
+	}
 
+	nameTemplate, err := configv1alpha1.ParseNameTemplate(operatorConfig.NameTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid name template: %w", err)
+	}
 	if err = (&controllers.DemoReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  reconcilerClient,
+		APIReader:               mgr.GetAPIReader(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Demo"),
+		Scheme:                  mgr.GetScheme(),
+		Activator:               act,
+		Recorder:                recorder,
+		DryRun:                  dryRun,
+		PlanWriter:              planWriter,
+		TracerProvider:          tracerProvider,
+		DefaultImage:            operatorConfig.DefaultImage,
+		ResourceProfiles:        operatorConfig.ResourceProfiles,
+		DefaultResourceProfile:  operatorConfig.DefaultResourceProfile,
+		NameTemplate:            nameTemplate,
+		MaxConcurrentReconciles: operatorConfig.MaxConcurrentReconciles,
+		RateLimiter: controllers.NewRateLimiter(
+			operatorConfig.RateLimits.BaseDelay.Duration, operatorConfig.RateLimits.MaxDelay.Duration),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Demo")
-		os.Exit(1)
+		return fmt.Errorf("unable to create controller Demo: %w", err)
+	}
+	// v1과 v2 Demo를 변환하는 conversion webhook. 로컬에서 webhook 없이 실행할 때는 ENABLE_WEBHOOKS=false로 끕니다.
+	// CRD는 실행 중인 operator의 webhook을 사용하므로 dry-run에서는 띄우지 않습니다.
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" && !dryRun {
+		if err = (&demoappv2.Demo{}).SetupWebhookWithManager(mgr); err != nil {
+			return fmt.Errorf("unable to create webhook Demo: %w", err)
+		}
 	}
 	//+kubebuilder:scaffold:builder
 
+	// selector에 맞는 namespace가 바뀌면 manager를 멈추고 새 namespace 목록으로 다시 만듭니다.
+	if watchScope.Mode() == scope.Selector {
+		if err := mgr.Add(&scope.NamespaceWatcher{
+			Scope:   watchScope,
+			Reader:  mgr.GetAPIReader(),
+			Current: namespaces,
+		}); err != nil {
+			return fmt.Errorf("unable to add namespace watcher: %w", err)
+		}
+	}
+
+	// v1으로 저장된 Demo를 v2로 다시 써서 CRD의 storedVersions에서 v1을 제거합니다.
+	// 모든 namespace의 Demo를 다시 써야 하므로 클러스터 전체를 감시할 때만 실행하고, dry-run에서는 실행하지 않습니다.
+	migrateStorageVersion := operatorConfig.Enabled(configv1alpha1.StorageVersionMigration) && !dryRun
+	if migrateStorageVersion && watchScope.Mode() != scope.Cluster {
+		setupLog.Info("storage version migration needs the cluster scope, skipping", "scope", watchScope.String())
+	} else if migrateStorageVersion {
+		if err := mgr.Add(&controllers.StorageVersionMigrator{
+			Client: writeClient,
+			Reader: mgr.GetAPIReader(),
+		}); err != nil {
+			return fmt.Errorf("unable to add storage version migration: %w", err)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
+		return fmt.Errorf("unable to set up health check: %w", err)
 	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
+		return fmt.Errorf("unable to set up ready check: %w", err)
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
-		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
-	}
+	return mgr.Start(ctx)
 }
@@ -0,0 +1,354 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/yaml"
+
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+)
+
+// Source는 rendered 객체와 비교할 현재 객체를 찾습니다.
+type Source interface {
+	// Get은 obj와 group, kind, namespace, 이름이 같은 현재 객체를 돌려줍니다. 없으면 nil입니다.
+	Get(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error)
+	// Owned는 d가 controller인 현재 객체를 돌려줍니다. rendered에 없는 객체는 operator가 지울 객체입니다.
+	Owned(ctx context.Context, d *demoappv2.Demo) ([]*unstructured.Unstructured, error)
+}
+
+// FileSource는 `kubectl get -o yaml`이나 render로 저장한 manifest입니다.
+type FileSource struct {
+	objs []*unstructured.Unstructured
+}
+
+// NewFileSource는 path의 manifest를 읽습니다. namespace가 없는 객체는 namespace에 있다고 봅니다.
+func NewFileSource(path, namespace string) (*FileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	objs, err := readObjects(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, u := range objs {
+		if u.GetNamespace() == "" {
+			u.SetNamespace(namespace)
+		}
+	}
+	return &FileSource{objs: objs}, nil
+}
+
+func (s *FileSource) Get(_ context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	for _, u := range s.objs {
+		if sameObject(u, obj) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *FileSource) Owned(_ context.Context, d *demoappv2.Demo) ([]*unstructured.Unstructured, error) {
+	var owned []*unstructured.Unstructured
+	for _, u := range s.objs {
+		if controlledBy(u, d) {
+			owned = append(owned, u)
+		}
+	}
+	return owned, nil
+}
+
+// LiveSource는 클러스터에 있는 현재 객체입니다.
+type LiveSource struct {
+	Reader client.Reader
+}
+
+// NewLiveSource는 KUBECONFIG나 ~/.kube/config의 현재 context로 클러스터에 연결합니다.
+func NewLiveSource(scheme *runtime.Scheme) (*LiveSource, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	return &LiveSource{Reader: c}, nil
+}
+
+func (s *LiveSource) Get(ctx context.Context, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(obj.GroupVersionKind())
+	err := s.Reader.Get(ctx, client.ObjectKeyFromObject(obj), u)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return u, err
+}
+
+// Render가 만드는 kind. operator가 발급하는 TLS Secret과 idle Demo의 Endpoints는 render하지 않으므로
+// 찾지 않습니다. 찾으면 항상 지워질 객체로 보입니다.
+var ownedKinds = []schema.GroupVersionKind{
+	{Version: "v1", Kind: "ServiceAccount"},
+	{Version: "v1", Kind: "Service"},
+	{Version: "v1", Kind: "ConfigMap"},
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+	{Group: "networking.k8s.io", Version: "v1", Kind: "NetworkPolicy"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role"},
+	{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "RoleBinding"},
+}
+
+// Owned는 Demo의 label이 붙은 객체 중 d가 controller인 객체를 kind마다 찾습니다.
+// label을 마이그레이션하기 전의 객체는 찾지 못합니다.
+func (s *LiveSource) Owned(ctx context.Context, d *demoappv2.Demo) ([]*unstructured.Unstructured, error) {
+	var owned []*unstructured.Unstructured
+	for _, gvk := range ownedKinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		err := s.Reader.List(ctx, list, client.InNamespace(d.Namespace), client.MatchingLabels(controllers.PodSelector(d)))
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", gvk.Kind, err)
+		}
+		for i := range list.Items {
+			if u := &list.Items[i]; controlledBy(u, d) {
+				owned = append(owned, u)
+			}
+		}
+	}
+	return owned, nil
+}
+
+// LoadStatus는 클러스터에 있는 Demo의 UID와 status를 d에 채웁니다.
+// status의 content revision과 인증서 만료 시각이 pod template에 들어가므로 현재 객체와 같은 값으로 render합니다.
+func (s *LiveSource) LoadStatus(ctx context.Context, d *demoappv2.Demo) error {
+	live := &demoappv2.Demo{}
+	err := s.Reader.Get(ctx, client.ObjectKeyFromObject(d), live)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.UID = live.UID
+	d.Status = live.Status
+	return nil
+}
+
+// Change는 현재 객체에서 rendered 객체로 바뀌는 내용입니다.
+type Change struct {
+	Kind, Namespace, Name string
+	// Diff는 unified diff입니다.
+	Diff string
+}
+
+func (c Change) String() string {
+	return c.Kind + "/" + c.Namespace + "/" + c.Name
+}
+
+// Changes는 현재 객체와 rendered 객체가 다른 객체를 찾습니다.
+// API 서버가 채우는 필드와 기본값 때문에 생기는 차이를 없애도록 현재 객체는 rendered 객체에 있는 필드만 비교합니다.
+func Changes(ctx context.Context, rendered []*unstructured.Unstructured, current Source, demos []*demoappv2.Demo) ([]Change, error) {
+	var changes []Change
+	add := func(cur, want *unstructured.Unstructured) error {
+		d, err := diffObject(cur, want)
+		if err != nil || d == "" {
+			return err
+		}
+		obj := want
+		if obj == nil {
+			obj = cur
+		}
+		changes = append(changes, Change{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName(), Diff: d})
+		return nil
+	}
+
+	for _, want := range rendered {
+		cur, err := current.Get(ctx, want)
+		if err != nil {
+			return nil, err
+		}
+		if err := add(cur, want); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range demos {
+		owned, err := current.Owned(ctx, d)
+		if err != nil {
+			return nil, err
+		}
+		for _, cur := range owned {
+			if !containsObject(rendered, cur) {
+				if err := add(cur, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return changes, nil
+}
+
+// Diff는 Changes의 unified diff를 출력하고, 차이가 있으면 true를 돌려줍니다.
+func Diff(ctx context.Context, w io.Writer, rendered []*unstructured.Unstructured, current Source, demos []*demoappv2.Demo) (bool, error) {
+	changes, err := Changes(ctx, rendered, current, demos)
+	if err != nil {
+		return false, err
+	}
+	for _, c := range changes {
+		if _, err := io.WriteString(w, c.Diff); err != nil {
+			return false, err
+		}
+	}
+	return len(changes) > 0, nil
+}
+
+// diffObject는 cur에서 want로 바뀌는 unified diff를 만듭니다. 둘 중 하나는 nil일 수 있습니다.
+func diffObject(cur, want *unstructured.Unstructured) (string, error) {
+	var a, b string
+	obj := want
+	if want != nil {
+		want = want.DeepCopy()
+		stripServerFields(want)
+		data, err := yaml.Marshal(want.Object)
+		if err != nil {
+			return "", err
+		}
+		b = string(data)
+	}
+	if cur != nil {
+		obj = cur
+		cur = cur.DeepCopy()
+		stripServerFields(cur)
+		if want != nil {
+			cur.Object = prune(cur.Object, want.Object).(map[string]interface{})
+		}
+		data, err := yaml.Marshal(cur.Object)
+		if err != nil {
+			return "", err
+		}
+		a = string(data)
+	}
+	if a == b {
+		return "", nil
+	}
+
+	name := fmt.Sprintf("%s/%s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: "current/" + name,
+		ToFile:   "rendered/" + name,
+		Context:  3,
+	})
+}
+
+// stripServerFields는 API 서버가 관리하는 필드를 지웁니다.
+// owner reference의 uid는 Demo를 파일에서 읽으면 비어 있으므로 비교하지 않습니다.
+func stripServerFields(u *unstructured.Unstructured) {
+	for _, f := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink"} {
+		unstructured.RemoveNestedField(u.Object, "metadata", f)
+	}
+	unstructured.RemoveNestedField(u.Object, "metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration")
+	if len(u.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(u.Object, "metadata", "annotations")
+	}
+	unstructured.RemoveNestedField(u.Object, "status")
+
+	refs, found, _ := unstructured.NestedSlice(u.Object, "metadata", "ownerReferences")
+	if !found {
+		return
+	}
+	for _, ref := range refs {
+		if m, ok := ref.(map[string]interface{}); ok {
+			delete(m, "uid")
+		}
+	}
+	_ = unstructured.SetNestedSlice(u.Object, refs, "metadata", "ownerReferences")
+}
+
+// prune은 cur에서 want에 없는 map key를 지웁니다. 길이가 같은 list는 항목마다 같은 방법으로 지웁니다.
+func prune(cur, want interface{}) interface{} {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		c, ok := cur.(map[string]interface{})
+		if !ok {
+			return cur
+		}
+		out := make(map[string]interface{}, len(w))
+		for k, v := range c {
+			if wv, ok := w[k]; ok {
+				out[k] = prune(v, wv)
+			}
+		}
+		return out
+	case []interface{}:
+		c, ok := cur.([]interface{})
+		if !ok || len(c) != len(w) {
+			return cur
+		}
+		out := make([]interface{}, len(c))
+		for i := range c {
+			out[i] = prune(c[i], w[i])
+		}
+		return out
+	default:
+		return cur
+	}
+}
+
+// sameObject는 API version을 빼고 group, kind, namespace, 이름이 같은지 확인합니다.
+func sameObject(a, b *unstructured.Unstructured) bool {
+	return a.GroupVersionKind().GroupKind() == b.GroupVersionKind().GroupKind() &&
+		a.GetNamespace() == b.GetNamespace() && a.GetName() == b.GetName()
+}
+
+func containsObject(objs []*unstructured.Unstructured, obj *unstructured.Unstructured) bool {
+	for _, u := range objs {
+		if sameObject(u, obj) {
+			return true
+		}
+	}
+	return false
+}
+
+func controlledBy(u *unstructured.Unstructured, d *demoappv2.Demo) bool {
+	if u.GetNamespace() != d.Namespace {
+		return false
+	}
+	for _, ref := range u.GetOwnerReferences() {
+		if ref.Controller != nil && *ref.Controller && ref.Kind == demoGroupKind.Kind && ref.Name == d.Name &&
+			strings.HasPrefix(ref.APIVersion, demoGroupKind.Group+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render는 `manager render` 명령을 구현합니다.
+// 클러스터 없이 Demo manifest를 읽어 operator가 만들 객체를 YAML로 출력하고,
+// --diff로 저장된 manifest나 클러스터의 현재 객체와 비교합니다.
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	configv1alpha1 "demo-operator/api/config/v1alpha1"
+	demoappv1 "demo-operator/api/v1"
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+)
+
+// Main의 종료 코드입니다. diff와 같이 차이가 있으면 1, 실패하면 2를 돌려줍니다.
+const (
+	ExitOK          = 0
+	ExitDifferences = 1
+	ExitError       = 2
+)
+
+// Live는 --diff에 주면 파일 대신 클러스터의 현재 객체와 비교하는 값입니다.
+const Live = "live"
+
+var demoGroupKind = schema.GroupKind{Group: demoappv2.GroupVersion.Group, Kind: "Demo"}
+
+// Main은 `manager render`의 인자를 읽고 실행합니다. scheme에는 v1과 v2 Demo가 등록되어 있어야 합니다.
+func Main(args []string, scheme *runtime.Scheme, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var file, namespace, configFile, defaultImage, diff string
+	fs.StringVar(&file, "f", "", "The Demo manifest to render, or - for stdin. Objects other than Demos are skipped.")
+	fs.StringVar(&namespace, "namespace", "default", "The namespace of Demos that do not set metadata.namespace.")
+	fs.StringVar(&configFile, "config", "", "The DemoOperatorConfig file with the default image and resource profiles.")
+	fs.StringVar(&defaultImage, "default-image", "", "The nginx image of Demos that do not set spec.image. Overrides the config file.")
+	fs.StringVar(&diff, "diff", "",
+		"Compare the rendered objects with a saved manifest file, or with the cluster when set to \""+Live+"\". "+
+			"Exits with 1 when they differ.")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: manager render -f demo.yaml [--diff FILE|live]")
+		fmt.Fprintln(stderr, "Prints the objects the operator creates for the Demos in the manifest without a cluster.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitError
+	}
+	if file == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return ExitError
+	}
+
+	operatorConfig, err := configv1alpha1.Load(configFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+	if defaultImage != "" {
+		operatorConfig.DefaultImage = defaultImage
+	}
+	nameTemplate, err := configv1alpha1.ParseNameTemplate(operatorConfig.NameTemplate)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+	r := &controllers.DemoReconciler{
+		Scheme:                 scheme,
+		DefaultImage:           operatorConfig.DefaultImage,
+		ResourceProfiles:       operatorConfig.ResourceProfiles,
+		DefaultResourceProfile: operatorConfig.DefaultResourceProfile,
+		NameTemplate:           nameTemplate,
+	}
+
+	in, err := open(file, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+	demos, err := ReadDemos(in, scheme, namespace)
+	in.Close()
+	if err != nil {
+		fmt.Fprintf(stderr, "reading %s: %v\n", file, err)
+		return ExitError
+	}
+
+	ctx := context.Background()
+	var current Source
+	switch diff {
+	case "":
+	case Live:
+		live, err := NewLiveSource(scheme)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitError
+		}
+		for _, d := range demos {
+			if err := live.LoadStatus(ctx, d); err != nil {
+				fmt.Fprintf(stderr, "reading Demo %s/%s: %v\n", d.Namespace, d.Name, err)
+				return ExitError
+			}
+		}
+		current = live
+	default:
+		current, err = NewFileSource(diff, namespace)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitError
+		}
+	}
+
+	rendered, err := Objects(r, demos...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+
+	if current == nil {
+		if err := Print(stdout, rendered); err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitError
+		}
+		return ExitOK
+	}
+	changed, err := Diff(ctx, stdout, rendered, current, demos)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+	if changed {
+		return ExitDifferences
+	}
+	return ExitOK
+}
+
+func open(file string, stdin io.Reader) (io.ReadCloser, error) {
+	if file == "-" {
+		return io.NopCloser(stdin), nil
+	}
+	return os.Open(file)
+}
+
+// ReadDemos는 YAML 또는 JSON manifest에서 Demo를 읽어 v2로 변환합니다.
+// Demo가 아닌 객체는 건너뛰고, namespace가 없는 Demo는 namespace에 둡니다.
+// 클러스터가 거부할 manifest를 놓치지 않도록 Demo의 알 수 없는 필드는 에러로 처리합니다.
+func ReadDemos(r io.Reader, scheme *runtime.Scheme, namespace string) ([]*demoappv2.Demo, error) {
+	objs, err := readObjects(r)
+	if err != nil {
+		return nil, err
+	}
+	decoder := serializer.NewCodecFactory(scheme, serializer.EnableStrict).UniversalDeserializer()
+
+	var demos []*demoappv2.Demo
+	for _, u := range objs {
+		if u.GroupVersionKind().GroupKind() != demoGroupKind {
+			continue
+		}
+		data, err := u.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		obj, _, err := decoder.Decode(data, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Demo %s: %w", u.GetName(), err)
+		}
+
+		var d *demoappv2.Demo
+		switch src := obj.(type) {
+		case *demoappv2.Demo:
+			d = src
+		case *demoappv1.Demo:
+			d = &demoappv2.Demo{}
+			if err := src.ConvertTo(d); err != nil {
+				return nil, fmt.Errorf("Demo %s: %w", u.GetName(), err)
+			}
+		default:
+			return nil, fmt.Errorf("Demo %s: unsupported version %s", u.GetName(), u.GetAPIVersion())
+		}
+		if d.Namespace == "" {
+			d.Namespace = namespace
+		}
+		demos = append(demos, d)
+	}
+	if len(demos) == 0 {
+		return nil, errors.New("no Demo found")
+	}
+	return demos, nil
+}
+
+// readObjects는 여러 문서로 된 YAML이나 JSON을 읽습니다. `kubectl get -o yaml`이 출력하는 List는 항목으로 풉니다.
+func readObjects(r io.Reader) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		u := &unstructured.Unstructured{}
+		if err := decoder.Decode(&u.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objs, nil
+			}
+			return nil, err
+		}
+		if len(u.Object) == 0 { // 빈 문서
+			continue
+		}
+		if u.IsList() {
+			err := u.EachListItem(func(item runtime.Object) error {
+				objs = append(objs, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		objs = append(objs, u)
+	}
+}
+
+// Objects는 Demo마다 Render가 만든 객체를 출력하고 비교할 수 있는 형태로 돌려줍니다.
+func Objects(r *controllers.DemoReconciler, demos ...*demoappv2.Demo) ([]*unstructured.Unstructured, error) {
+	var rendered []*unstructured.Unstructured
+	for _, d := range demos {
+		objs, err := r.Render(d)
+		if err != nil {
+			return nil, fmt.Errorf("rendering Demo %s/%s: %w", d.Namespace, d.Name, err)
+		}
+		for _, obj := range objs {
+			u, err := toUnstructured(obj)
+			if err != nil {
+				return nil, err
+			}
+			rendered = append(rendered, u)
+		}
+	}
+	return rendered, nil
+}
+
+// toUnstructured는 Render가 만든 객체를 출력하고 비교할 수 있는 형태로 바꿉니다.
+// API 서버가 채우는 creationTimestamp와 빈 status는 뺍니다.
+func toUnstructured(obj client.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "status")
+	return u, nil
+}
+
+// Print는 객체를 `---`로 구분한 YAML로 출력합니다.
+func Print(w io.Writer, objs []*unstructured.Unstructured) error {
+	var buf bytes.Buffer
+	for _, u := range objs {
+		data, err := yaml.Marshal(u.Object)
+		if err != nil {
+			return err
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	demoappv1 "demo-operator/api/v1"
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+)
+
+const demos = `apiVersion: demoapp.my.domain/v1
+kind: Demo
+metadata:
+  name: old
+spec:
+  size: 3
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: skipped
+---
+apiVersion: demoapp.my.domain/v2
+kind: Demo
+metadata:
+  name: new
+  namespace: web
+spec:
+  scaling:
+    replicas: 2
+`
+
+func newScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(demoappv1.AddToScheme(scheme))
+	utilruntime.Must(demoappv2.AddToScheme(scheme))
+	return scheme
+}
+
+func TestReadDemos(t *testing.T) {
+	got, err := ReadDemos(strings.NewReader(demos), newScheme(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d Demos, want 2", len(got))
+	}
+	if got[0].Namespace != "default" || got[0].Spec.Scaling.Replicas != 3 {
+		t.Errorf("got v1 Demo %s/%s with %d replicas, want it converted into default with 3",
+			got[0].Namespace, got[0].Name, got[0].Spec.Scaling.Replicas)
+	}
+	if got[1].Namespace != "web" || got[1].Spec.Scaling.Replicas != 2 {
+		t.Errorf("got v2 Demo %s/%s with %d replicas, want web/new with 2",
+			got[1].Namespace, got[1].Name, got[1].Spec.Scaling.Replicas)
+	}
+
+	// 알 수 없는 필드는 클러스터가 거부하므로 에러입니다.
+	_, err = ReadDemos(strings.NewReader("apiVersion: demoapp.my.domain/v2\nkind: Demo\nmetadata:\n  name: typo\nspec:\n  replica: 2\n"),
+		newScheme(), "default")
+	if err == nil {
+		t.Error("got no error for an unknown field")
+	}
+	if _, err := ReadDemos(strings.NewReader("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n"), newScheme(), "default"); err == nil {
+		t.Error("got no error for a manifest without Demos")
+	}
+}
+
+// render 결과를 저장해 두고 --diff로 비교합니다.
+func TestMainDiff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := Main(args, newScheme(), strings.NewReader(""), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	demo := write("demo.yaml", "apiVersion: demoapp.my.domain/v2\nkind: Demo\nmetadata:\n  name: web\n"+
+		"spec:\n  scaling:\n    replicas: 2\n  networkPolicy: {}\n")
+	code, saved, stderr := run("-f", demo)
+	if code != ExitOK {
+		t.Fatalf("render exited with %d: %s", code, stderr)
+	}
+	for _, kind := range []string{"kind: Service", "kind: ConfigMap", "kind: Deployment", "kind: NetworkPolicy"} {
+		if !strings.Contains(saved, kind) {
+			t.Errorf("rendered objects have no %q:\n%s", kind, saved)
+		}
+	}
+
+	// API 서버가 채운 필드는 차이로 보지 않습니다.
+	live := strings.Replace(saved, "  namespace: default\n",
+		"  namespace: default\n  uid: 0b1c\n  resourceVersion: \"42\"\n  creationTimestamp: \"2022-05-01T00:00:00Z\"\n", -1)
+	live = strings.Replace(live, "  type: ClusterIP\n", "  type: ClusterIP\n  clusterIP: 10.0.0.10\n", 1)
+	savedPath := write("saved.yaml", live)
+	if code, out, stderr := run("-f", demo, "--diff", savedPath); code != ExitOK {
+		t.Errorf("got exit code %d for the same objects, want %d:\n%s%s", code, ExitOK, out, stderr)
+	}
+
+	// replicas를 바꾸고 NetworkPolicy를 없애면 Deployment는 바뀌고 NetworkPolicy는 지워집니다.
+	changed := write("changed.yaml", "apiVersion: demoapp.my.domain/v2\nkind: Demo\nmetadata:\n  name: web\n"+
+		"spec:\n  scaling:\n    replicas: 3\n")
+	code, out, stderr := run("-f", changed, "--diff", savedPath)
+	if code != ExitDifferences {
+		t.Fatalf("got exit code %d, want %d: %s", code, ExitDifferences, stderr)
+	}
+	for _, want := range []string{
+		"+++ rendered/Deployment/default/web", "-  replicas: 2", "+  replicas: 3",
+		"--- current/NetworkPolicy/default/web", "-kind: NetworkPolicy",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("diff has no %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Service/default/web") {
+		t.Errorf("diff has the unchanged Service:\n%s", out)
+	}
+
+	if code, _, _ := run("-f", filepath.Join(dir, "missing.yaml")); code != ExitError {
+		t.Errorf("got exit code %d for a missing file, want %d", code, ExitError)
+	}
+}
+
+// LiveSource.Owned는 Demo의 label이 있고 Demo가 controller인 객체만 찾습니다.
+func TestLiveSourceOwned(t *testing.T) {
+	d := &demoappv2.Demo{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web-uid"}}
+	other := &demoappv2.Demo{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", UID: "other-uid"}}
+	meta := func(name string, owner *demoappv2.Demo) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name: name, Namespace: "default", Labels: controllers.PodSelector(owner),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: demoappv2.GroupVersion.String(), Kind: "Demo", Name: owner.Name, UID: owner.UID, Controller: pointer.Bool(true),
+			}},
+		}
+	}
+	unlabeled := meta("web-unlabeled", d)
+	unlabeled.Labels = nil
+
+	c := fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(
+		&corev1.ConfigMap{ObjectMeta: meta("web-nginx", d)},
+		&appsv1.Deployment{ObjectMeta: meta("web", d)},
+		&corev1.ConfigMap{ObjectMeta: meta("other-nginx", other)},
+		&corev1.ConfigMap{ObjectMeta: unlabeled},
+		// operator가 발급한 TLS Secret은 render하지 않으므로 지울 객체로 보지 않습니다.
+		&corev1.Secret{ObjectMeta: meta("web-tls", d)},
+	).Build()
+
+	owned, err := (&LiveSource{Reader: c}).Owned(context.Background(), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, u := range owned {
+		got = append(got, u.GetKind()+"/"+u.GetName())
+	}
+	sort.Strings(got)
+	if want := []string{"ConfigMap/web-nginx", "Deployment/web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scope는 operator가 감시하는 namespace 범위를 정합니다.
+// 클러스터 전체, namespace 목록, 또는 label selector에 맞는 namespace만 감시할 수 있고,
+// 시작할 때 선택한 범위에 맞는 RBAC 권한이 있는지 확인합니다.
+package scope
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// Mode는 감시 범위의 종류입니다.
+type Mode string
+
+const (
+	// Cluster는 모든 namespace를 감시합니다. ClusterRole이 필요합니다.
+	Cluster Mode = "cluster"
+	// Namespaces는 지정한 namespace만 감시합니다. namespace마다 Role이면 충분합니다.
+	Namespaces Mode = "namespaces"
+	// Selector는 label selector에 맞는 namespace만 감시합니다.
+	// namespace 목록을 조회하는 cluster 권한과 맞는 namespace마다 권한이 필요합니다.
+	Selector Mode = "selector"
+)
+
+// Scope는 operator가 감시하는 namespace 범위입니다.
+type Scope struct {
+	namespaces []string
+	selector   labels.Selector
+}
+
+// New는 쉼표로 구분한 namespace 목록 또는 namespace label selector로 Scope를 만듭니다.
+// 둘 다 비어 있으면 클러스터 전체를 감시합니다.
+func New(namespaces, selector string) (*Scope, error) {
+	s := &Scope{}
+	for _, ns := range strings.Split(namespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			s.namespaces = append(s.namespaces, ns)
+		}
+	}
+	sort.Strings(s.namespaces)
+
+	if selector != "" {
+		if len(s.namespaces) > 0 {
+			return nil, fmt.Errorf("watch namespaces and a namespace selector cannot be used together")
+		}
+		sel, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector %q: %w", selector, err)
+		}
+		if sel.Empty() {
+			return nil, fmt.Errorf("namespace selector %q matches every namespace, leave it empty to watch the cluster", selector)
+		}
+		s.selector = sel
+	}
+	return s, nil
+}
+
+// Mode는 감시 범위의 종류를 반환합니다.
+func (s *Scope) Mode() Mode {
+	switch {
+	case s.selector != nil:
+		return Selector
+	case len(s.namespaces) > 0:
+		return Namespaces
+	}
+	return Cluster
+}
+
+func (s *Scope) String() string {
+	switch s.Mode() {
+	case Selector:
+		return fmt.Sprintf("namespaces matching %q", s.selector.String())
+	case Namespaces:
+		return fmt.Sprintf("namespaces %s", strings.Join(s.namespaces, ","))
+	}
+	return "all namespaces"
+}
+
+// Resolve는 지금 감시할 namespace 목록을 이름순으로 반환합니다. 클러스터 전체이면 nil입니다.
+func (s *Scope) Resolve(ctx context.Context, c client.Reader) ([]string, error) {
+	if s.Mode() != Selector {
+		return s.namespaces, nil
+	}
+
+	nsList := &corev1.NamespaceList{}
+	if err := c.List(ctx, nsList, client.MatchingLabelsSelector{Selector: s.selector}); err != nil {
+		return nil, fmt.Errorf("listing namespaces matching %q: %w", s.selector.String(), err)
+	}
+	namespaces := []string{}
+	for _, ns := range nsList.Items {
+		namespaces = append(namespaces, ns.Name)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// ApplyToOptions는 manager의 cache가 namespaces만 감시하도록 설정합니다.
+// namespaces가 비어 있으면 클러스터 전체를 감시합니다.
+func ApplyToOptions(opts *ctrl.Options, namespaces []string) {
+	switch len(namespaces) {
+	case 0:
+	case 1:
+		opts.Namespace = namespaces[0]
+	default:
+		opts.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+}
+
+// Rule은 operator가 필요로 하는 권한입니다.
+type Rule struct {
+	Group    string
+	Resource string
+	Verbs    []string
+}
+
+// CheckAccess는 SelfSubjectAccessReview로 rules의 권한이 namespaces마다 있는지 확인합니다.
+// namespaces가 nil이면 클러스터 전체 권한을 확인합니다. 없는 권한을 모두 모아 에러로 반환합니다.
+func CheckAccess(ctx context.Context, c client.Client, namespaces []string, rules []Rule) error {
+	targets := namespaces
+	if targets == nil {
+		targets = []string{""}
+	}
+
+	var missing []string
+	for _, ns := range targets {
+		for _, rule := range rules {
+			for _, verb := range rule.Verbs {
+				review := &authorizationv1.SelfSubjectAccessReview{
+					Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+						ResourceAttributes: &authorizationv1.ResourceAttributes{
+							Namespace: ns,
+							Verb:      verb,
+							Group:     rule.Group,
+							Resource:  rule.Resource,
+						},
+					},
+				}
+				if err := c.Create(ctx, review); err != nil {
+					return fmt.Errorf("checking RBAC: %w", err)
+				}
+				if !review.Status.Allowed {
+					missing = append(missing, describe(verb, rule, ns))
+				}
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("RBAC does not allow: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+func describe(verb string, rule Rule, ns string) string {
+	resource := rule.Resource
+	if rule.Group != "" {
+		resource += "." + rule.Group
+	}
+	if ns == "" {
+		return fmt.Sprintf("%s %s cluster-wide", verb, resource)
+	}
+	return fmt.Sprintf("%s %s in namespace %s", verb, resource, ns)
+}
+
+// ErrNamespacesChanged는 selector에 맞는 namespace가 바뀌어 manager를 다시 만들어야 함을 나타냅니다.
+var ErrNamespacesChanged = errors.New("watched namespaces changed")
+
+// NamespaceWatcher는 selector에 맞는 namespace가 바뀌면 ErrNamespacesChanged를 반환해 manager를 멈춥니다.
+// manager의 cache는 시작할 때 정한 namespace만 감시하므로, 호출한 쪽에서 새 목록으로 manager를 다시 만듭니다.
+type NamespaceWatcher struct {
+	Scope    *Scope
+	Reader   client.Reader
+	Current  []string
+	Interval time.Duration
+}
+
+// 모든 replica가 각자 manager를 다시 만들어야 하므로 leader election과 관계없이 실행합니다.
+func (w *NamespaceWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start는 Interval마다 namespace 목록을 다시 확인합니다.
+func (w *NamespaceWatcher) Start(ctx context.Context) error {
+	logger := ctrl.Log.WithName("scope")
+
+	interval := w.Interval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		namespaces, err := w.Scope.Resolve(ctx, w.Reader)
+		if err != nil {
+			logger.Error(err, "Failed to list watched namespaces")
+			continue
+		}
+		if !reflect.DeepEqual(namespaces, w.Current) {
+			return fmt.Errorf("%w: %s changed from [%s] to [%s]",
+				ErrNamespacesChanged, w.Scope, strings.Join(w.Current, ","), strings.Join(namespaces, ","))
+		}
+	}
+}
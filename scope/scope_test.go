@@ -0,0 +1,174 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scope
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces string
+		selector   string
+		mode       Mode
+		wantErr    bool
+	}{
+		{name: "cluster", mode: Cluster},
+		{name: "namespaces", namespaces: "b, a,,", mode: Namespaces},
+		{name: "selector", selector: "demo=enabled", mode: Selector},
+		{name: "both", namespaces: "a", selector: "demo=enabled", wantErr: true},
+		{name: "invalid selector", selector: "demo in (", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.namespaces, tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && s.Mode() != tt.mode {
+				t.Errorf("got mode %s, want %s", s.Mode(), tt.mode)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"demo": "enabled"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"demo": "enabled"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+	).Build()
+	ctx := context.Background()
+
+	s, _ := New("", "demo=enabled")
+	got, err := s.Resolve(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"team-a", "team-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	s, _ = New("x,y", "")
+	if got, _ := s.Resolve(ctx, c); !reflect.DeepEqual(got, []string{"x", "y"}) {
+		t.Errorf("got %v, want [x y]", got)
+	}
+
+	s, _ = New("", "")
+	if got, _ := s.Resolve(ctx, c); got != nil {
+		t.Errorf("got %v, want nil for the whole cluster", got)
+	}
+}
+
+func TestApplyToOptions(t *testing.T) {
+	opts := ctrl.Options{}
+	ApplyToOptions(&opts, nil)
+	if opts.Namespace != "" || opts.NewCache != nil {
+		t.Errorf("cluster scope changed options %+v", opts)
+	}
+
+	opts = ctrl.Options{}
+	ApplyToOptions(&opts, []string{"a"})
+	if opts.Namespace != "a" || opts.NewCache != nil {
+		t.Errorf("got namespace %q, want a", opts.Namespace)
+	}
+
+	opts = ctrl.Options{}
+	ApplyToOptions(&opts, []string{"a", "b"})
+	if opts.Namespace != "" || opts.NewCache == nil {
+		t.Errorf("multiple namespaces should use a multi-namespace cache")
+	}
+}
+
+// reviewClient는 allowed namespace의 SelfSubjectAccessReview만 허용합니다.
+type reviewClient struct {
+	client.Client
+	allowed string
+}
+
+func (c *reviewClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	review := obj.(*authorizationv1.SelfSubjectAccessReview)
+	review.Status.Allowed = review.Spec.ResourceAttributes.Namespace == c.allowed
+	return nil
+}
+
+func TestCheckAccess(t *testing.T) {
+	c := &reviewClient{allowed: "team-a"}
+	rules := []Rule{{Group: "apps", Resource: "deployments", Verbs: []string{"get", "create"}}}
+	ctx := context.Background()
+
+	if err := CheckAccess(ctx, c, []string{"team-a"}, rules); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	err := CheckAccess(ctx, c, []string{"team-a", "team-b"}, rules)
+	if err == nil {
+		t.Fatal("expected missing permissions in team-b")
+	}
+	for _, want := range []string{"get deployments.apps in namespace team-b", "create deployments.apps in namespace team-b"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	err = CheckAccess(ctx, c, nil, rules)
+	if err == nil || !strings.Contains(err.Error(), "cluster-wide") {
+		t.Errorf("got %v, want missing cluster-wide permissions", err)
+	}
+}
+
+func TestNamespaceWatcher(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"demo": "enabled"}}},
+	).Build()
+	s, _ := New("", "demo=enabled")
+	w := &NamespaceWatcher{Scope: s, Reader: c, Current: []string{"team-a"}, Interval: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- w.Start(ctx) }()
+
+	// 목록이 그대로이면 계속 실행됩니다.
+	select {
+	case err := <-done:
+		t.Fatalf("watcher stopped with %v before namespaces changed", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 새 namespace에 label이 붙으면 manager를 다시 만들도록 ErrNamespacesChanged를 반환합니다.
+	if err := c.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"demo": "enabled"}}}); err != nil {
+		t.Fatal(err)
+	}
+	err := <-done
+	if !errors.Is(err, ErrNamespacesChanged) || !strings.Contains(err.Error(), "team-a,team-b") {
+		t.Errorf("got %v, want ErrNamespacesChanged naming the new namespaces", err)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package load는 envtest에 많은 Demo를 만들고 controller가 모두 수렴할 때까지 걸리는 시간을 잽니다.
+// 수렴하는 동안 API 쓰기가 설정한 속도를 넘지 않는지와 workqueue 대기 시간도 확인합니다.
+// 오래 걸리므로 DEMO_LOAD_TEST=1일 때만 실행합니다. (make load-test)
+package load
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/time/rate"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	demoappv2 "demo-operator/api/v2"
+	"demo-operator/controllers"
+)
+
+// 환경 변수로 정하는 부하 조건
+func envInt(t *testing.T, name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		t.Fatalf("invalid %s=%q: %v", name, v, err)
+	}
+	return n
+}
+
+// gather는 controller-runtime registry에서 name metric 중 labels가 모두 맞는 것을 찾습니다.
+func gather(t *testing.T, name string, labels map[string]string) []*dto.Metric {
+	t.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []*dto.Metric
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range family.GetMetric() {
+			for _, pair := range m.GetLabel() {
+				if want, ok := labels[pair.GetName()]; ok && want != pair.GetValue() {
+					continue next
+				}
+			}
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+// writes는 controller가 보낸 API 쓰기 수입니다.
+func writes(t *testing.T) float64 {
+	var total float64
+	for _, m := range gather(t, "demo_operator_api_writes_total", nil) {
+		total += m.GetCounter().GetValue()
+	}
+	return total
+}
+
+// queueWait는 Demo workqueue에서 item이 기다린 시간의 평균과 가장 긴 구간의 상한입니다.
+func queueWait(t *testing.T) (mean time.Duration, max float64) {
+	found := gather(t, "workqueue_queue_duration_seconds", map[string]string{"name": "demo"})
+	if len(found) == 0 {
+		t.Fatal("no workqueue_queue_duration_seconds metric for the demo controller")
+	}
+	h := found[0].GetHistogram()
+	if h.GetSampleCount() == 0 {
+		t.Fatal("the demo workqueue observed no items")
+	}
+	// 모든 item이 들어간 bucket 중 가장 작은 bucket의 상한
+	max = -1
+	for _, b := range h.GetBucket() {
+		if b.GetCumulativeCount() == h.GetSampleCount() {
+			max = b.GetUpperBound()
+			break
+		}
+	}
+	return time.Duration(h.GetSampleSum() / float64(h.GetSampleCount()) * float64(time.Second)), max
+}
+
+func TestConvergence(t *testing.T) {
+	if os.Getenv("DEMO_LOAD_TEST") == "" {
+		t.Skip("set DEMO_LOAD_TEST=1 to run the load test")
+	}
+	demos := envInt(t, "DEMO_LOAD_COUNT", 300)
+	workers := envInt(t, "DEMO_LOAD_WORKERS", 8)
+	writeQPS := envInt(t, "DEMO_LOAD_WRITE_QPS", 50)
+	// workqueue에서 기다린 시간 평균의 상한 (초)
+	maxQueueWait := time.Duration(envInt(t, "DEMO_LOAD_MAX_QUEUE_WAIT", 30)) * time.Second
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = demoappv2.AddToScheme(scheme)
+
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = testEnv.Stop() }()
+	// 테스트 client가 병목이 되지 않도록 client 쪽 제한을 높입니다.
+	cfg.QPS, cfg.Burst = 500, 1000
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{Scheme: scheme, MetricsBindAddress: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&controllers.DemoReconciler{
+		Client:                  controllers.NewWriteLimitedClient(mgr.GetClient(), rate.NewLimiter(rate.Limit(writeQPS), writeQPS)),
+		Scheme:                  mgr.GetScheme(),
+		MaxConcurrentReconciles: workers,
+		RateLimiter:             controllers.NewRateLimiter(5*time.Millisecond, 10*time.Second),
+	}).SetupWithManager(mgr); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := mgr.Start(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "load-"}}
+	if err := c.Create(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+
+	writesBefore := writes(t)
+	start := time.Now()
+	for i := 0; i < demos; i++ {
+		d := &demoappv2.Demo{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("demo-%d", i), Namespace: ns.Name},
+			Spec:       demoappv2.DemoSpec{Scaling: demoappv2.ScalingSpec{Replicas: 1}},
+		}
+		if err := c.Create(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := time.Since(start)
+
+	// 모든 Demo의 Deployment와 Service가 만들어지면 수렴한 것으로 봅니다.
+	deadline := time.Now().Add(10 * time.Minute)
+	for {
+		deployments := &appsv1.DeploymentList{}
+		services := &corev1.ServiceList{}
+		if err := c.List(ctx, deployments, client.InNamespace(ns.Name)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.List(ctx, services, client.InNamespace(ns.Name)); err != nil {
+			t.Fatal(err)
+		}
+		if len(deployments.Items) >= demos && len(services.Items) >= demos {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d deployments and %d services of %d Demos after %s",
+				len(deployments.Items), len(services.Items), demos, time.Since(start))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	converged := time.Since(start)
+	sent := writes(t) - writesBefore
+	mean, max := queueWait(t)
+	t.Logf("%d Demos, %d workers, %d writes/s: created in %s, converged in %s (%.1f Demos/s), "+
+		"%.0f writes (%.1f/s), queue wait mean %s, all under %gs",
+		demos, workers, writeQPS, created, converged, float64(demos)/converged.Seconds(),
+		sent, sent/converged.Seconds(), mean, max)
+
+	// Demo마다 적어도 Service와 Deployment를 만들고, token bucket은 burst와 초당 writeQPS를 넘겨 보내지 않습니다.
+	// burst (writeQPS)만큼 1초, 마지막 List 뒤에 보낸 쓰기로 1초를 더 허용합니다.
+	if sent < float64(2*demos) {
+		t.Errorf("got %.0f writes, want at least %d for the Services and Deployments", sent, 2*demos)
+	}
+	if limit := float64(writeQPS) * (converged.Seconds() + 2); sent > limit {
+		t.Errorf("got %.0f writes in %s, want at most %.0f at %d writes/s", sent, converged, limit, writeQPS)
+	}
+	if mean > maxQueueWait {
+		t.Errorf("Demos waited %s in the workqueue on average, want at most %s", mean, maxQueueWait)
+	}
+}